@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// acrBackend lists charts from an Azure Container Registry using the
+// registry catalog and the ACR manifests API, authenticating with a service
+// principal or the registry admin user.
+type acrBackend struct {
+	config *Config
+	api    *registryAPI
+}
+
+type acrManifest struct {
+	Digest         string    `json:"digest"`
+	Tags           []string  `json:"tags"`
+	MediaType      string    `json:"mediaType"`
+	CreatedTime    time.Time `json:"createdTime"`
+	LastUpdateTime time.Time `json:"lastUpdateTime"`
+	ImageSize      int64     `json:"imageSize"`
+}
+
+func newACRBackend(config *Config) (*acrBackend, error) {
+	b := &acrBackend{config: config}
+	b.api = &registryAPI{host: config.ACRRegistry, credential: b.Credential}
+	return b, nil
+}
+
+func (b *acrBackend) Name() string {
+	return strings.TrimSuffix(b.config.ACRRegistry+"/"+b.config.Repository, "/")
+}
+
+func (b *acrBackend) Host() string {
+	return b.config.ACRRegistry
+}
+
+func (b *acrBackend) Credential(ctx context.Context) (string, string, error) {
+	return b.config.ACRUsername, b.config.ACRPassword, nil
+}
+
+func (b *acrBackend) Close() error {
+	return nil
+}
+
+func (b *acrBackend) List(ctx context.Context) ([]*Asset, error) {
+	repositories, err := b.repositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []*Asset
+	var skipped skippedEntries
+	for _, repository := range repositories {
+		next := fmt.Sprintf("/acr/v1/%s/_manifests", repository)
+		for next != "" {
+			var page struct {
+				Manifests []acrManifest `json:"manifests"`
+			}
+			next, err = b.api.getJSON(ctx, next, &page)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, manifest := range page.Manifests {
+				rawName := fmt.Sprintf("%s/%s@%s", b.Host(), repository, manifest.Digest)
+				_, sha, err := extractNameAndSha(rawName)
+				if err != nil {
+					skipped.skip(rawName, err)
+					continue
+				}
+
+				asset := &Asset{
+					Name:      chartName(repository, b.config.Repository),
+					SHA:       sha,
+					RawName:   rawName,
+					URI:       rawName,
+					MediaType: manifest.MediaType,
+					Updated:   manifest.LastUpdateTime.UTC(),
+					Uploaded:  manifest.CreatedTime.UTC(),
+					Size:      manifest.ImageSize,
+				}
+
+				asset.Tags = append(asset.Tags, manifest.Tags...)
+
+				assets = append(assets, asset)
+			}
+		}
+	}
+	return assets, skipped.err()
+}
+
+// repositories pages through the registry catalog, keeping the repositories
+// under the configured prefix.
+func (b *acrBackend) repositories(ctx context.Context) ([]string, error) {
+	var names []string
+	next := "/v2/_catalog?" + url.Values{"n": {"1000"}}.Encode()
+	for next != "" {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+
+		var err error
+		next, err = b.api.getJSON(ctx, next, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range page.Repositories {
+			if strings.HasPrefix(name, b.config.Repository) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
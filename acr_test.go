@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestACRList(t *testing.T) {
+	digest := func(c string) string { return "sha256:" + strings.Repeat(c, 64) }
+	tests := []struct {
+		name      string
+		responses map[string]string
+		want      []string
+		skipped   int
+		err       bool
+	}{
+		{
+			name: "paginated catalog and manifests",
+			responses: map[string]string{
+				"/v2/_catalog?n=1000":                    `{"repositories":["charts/nginx","images/app"]}` + "\n</v2/_catalog?last=images%2Fapp&n=1000>",
+				"/v2/_catalog?last=images/app&n=1000":    `{"repositories":["charts/redis"]}`,
+				"/acr/v1/charts/nginx/_manifests":        fmt.Sprintf(`{"manifests":[{"digest":%q,"tags":["1.0.0"],"imageSize":42,"createdTime":"2024-01-02T03:04:05Z"}]}`, digest("a")) + "\n</acr/v1/charts/nginx/_manifests?last=a>",
+				"/acr/v1/charts/nginx/_manifests?last=a": fmt.Sprintf(`{"manifests":[{"digest":%q,"tags":["1.1.0","latest"]}]}`, digest("b")),
+				"/acr/v1/charts/redis/_manifests":        fmt.Sprintf(`{"manifests":[{"digest":%q},{"digest":"sha256:short"}]}`, digest("c")),
+			},
+			want: []string{
+				"nginx@" + digest("a") + " 1.0.0 42 2024-01-02T03:04:05Z",
+				"nginx@" + digest("b") + " 1.1.0,latest 0 0001-01-01T00:00:00Z",
+				"redis@" + digest("c") + "  0 0001-01-01T00:00:00Z",
+			},
+			skipped: 1,
+		},
+		{
+			name: "a failing repository fails the listing",
+			responses: map[string]string{
+				"/v2/_catalog?n=1000": `{"repositories":["charts/nginx"]}`,
+			},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, password, _ := r.BasicAuth(); user != "sp" || password != "secret" {
+					w.Header().Set("WWW-Authenticate", `Basic realm="acr"`)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				target := r.URL.Path
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.Query().Encode()
+				}
+				body, ok := tt.responses[strings.ReplaceAll(target, "%2F", "/")]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				body, link, _ := strings.Cut(body, "\n")
+				if link != "" {
+					w.Header().Set("Link", link+`; rel="next"`)
+				}
+				w.Write([]byte(body))
+			}))
+			defer server.Close()
+
+			b, _ := newACRBackend(&Config{ACRRegistry: server.Listener.Addr().String(), ACRUsername: "sp", ACRPassword: "secret", Repository: "charts"})
+			b.api.client = server.Client()
+			assets, err := b.List(context.Background())
+			var skipped skippedEntries
+			if errors.As(err, &skipped) {
+				err = nil
+			}
+			if (err != nil) != tt.err || len(skipped) != tt.skipped {
+				t.Fatalf("List() = %v, %d skipped, want error %v, %d skipped", err, len(skipped), tt.err, tt.skipped)
+			}
+
+			var got []string
+			for _, asset := range assets {
+				got = append(got, fmt.Sprintf("%s@%s %s %d %s", asset.Name, asset.SHA, strings.Join(asset.Tags, ","), asset.Size, asset.Uploaded.Format("2006-01-02T15:04:05Z")))
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("List() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+const (
+	inTotoPayloadType    = "application/vnd.in-toto+json"
+	inTotoStatementType  = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType   = "https://slsa.dev/provenance/v1"
+	proxyBuilderID       = "https://github.com/fandujar/gcp-oci-proxy"
+	ociEmptyConfigType   = "application/vnd.oci.empty.v1+json"
+	ociImageManifestType = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// attestor records the artifact movements performed by the proxy as SLSA
+// provenance statements, signs them with a Cloud KMS key and attaches them
+// to the artifact the way `cosign attest` does, under the
+// `sha256-<hex>.att` tag.
+type attestor struct {
+	key    string
+	config *Config
+
+	mu      sync.Mutex
+	service *cloudkms.Service
+}
+
+// newAttestor returns nil when no signing key is configured.
+func newAttestor(config *Config) *attestor {
+	if config.AttestationKMSKey == "" {
+		return nil
+	}
+	return &attestor{key: config.AttestationKMSKey, config: config}
+}
+
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     interface{}     `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// attest attaches a signed provenance statement to the artifact ref (with
+// the given digest) on backend, recording that operation ("import",
+// "promote", ...) produced it from the given parameters between started and
+// now.
+func (a *attestor) attest(ctx context.Context, backend Backend, ref, digest, operation string, parameters map[string]string, started time.Time) error {
+	host, repository, _, err := splitReference(ref)
+	if err != nil {
+		return err
+	}
+
+	_, hex, _ := strings.Cut(digest, ":")
+	statement := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []inTotoSubject{{Name: host + "/" + repository, Digest: map[string]string{"sha256": hex}}},
+		PredicateType: slsaProvenanceType,
+		Predicate: map[string]interface{}{
+			"buildDefinition": map[string]interface{}{
+				"buildType":          proxyBuilderID + "/" + operation + "/v1",
+				"externalParameters": parameters,
+			},
+			"runDetails": map[string]interface{}{
+				"builder": map[string]string{"id": proxyBuilderID},
+				"metadata": map[string]string{
+					"startedOn":  started.UTC().Format(time.RFC3339),
+					"finishedOn": time.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := a.sign(ctx, payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign attestation. error: %w", err)
+	}
+
+	api := &registryAPI{host: host, credential: backend.Credential}
+	return attach(ctx, api, repository, cosignTag(digest, "att"), envelope)
+}
+
+// sign wraps payload in a DSSE envelope signed with the KMS key.
+func (a *attestor) sign(ctx context.Context, payload []byte) ([]byte, error) {
+	service, err := a.kms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(dssePAE(inTotoPayloadType, payload))
+	resp, err := service.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.AsymmetricSign(a.key, &cloudkms.AsymmetricSignRequest{
+		Digest: &cloudkms.Digest{Sha256: base64.StdEncoding.EncodeToString(sum[:])},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(dsseEnvelope{
+		PayloadType: inTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []dsseSignature{{KeyID: a.key, Sig: resp.Signature}},
+	})
+}
+
+func (a *attestor) kms(ctx context.Context) (*cloudkms.Service, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.service != nil {
+		return a.service, nil
+	}
+
+	opts, err := googleClientOptions(a.config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := cloudkms.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kms client. error: %w", err)
+	}
+	a.service = service
+	return service, nil
+}
+
+// dssePAE is the DSSE pre-authentication encoding of payload, which is what
+// actually gets signed.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// attach pushes envelope to repository as a single layer artifact tagged
+// tag.
+func attach(ctx context.Context, api *registryAPI, repository, tag string, envelope []byte) error {
+	config := []byte("{}")
+	configDigest, err := api.pushBlob(ctx, repository, config)
+	if err != nil {
+		return err
+	}
+
+	layerDigest, err := api.pushBlob(ctx, repository, envelope)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociImageManifestType,
+		"config":        ociDescriptor{MediaType: ociEmptyConfigType, Digest: configDigest, Size: int64(len(config))},
+		"layers": []ociDescriptor{{
+			MediaType:   dsseEnvelopeMediaType,
+			Digest:      layerDigest,
+			Size:        int64(len(envelope)),
+			Annotations: map[string]string{"predicateType": slsaProvenanceType},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	return api.pushManifest(ctx, repository, tag, ociImageManifestType, manifest)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	auditBuffer        = 1024
+	auditBatchSize     = 100
+	auditFlushInterval = 5 * time.Second
+)
+
+// auditEvent records one chart download: who pulled which chart, when, and
+// how it ended.
+type auditEvent struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Chart      string    `json:"chart"`
+	Version    string    `json:"version,omitempty"`
+	Digest     string    `json:"digest"`
+	Path       string    `json:"path"`
+	Bytes      int64     `json:"bytes"`
+	Status     int       `json:"status"`
+	Result     string    `json:"result"`
+}
+
+// auditSink is a destination for audit events.
+type auditSink interface {
+	// Name describes the sink in logs.
+	Name() string
+	// Write stores a batch of events.
+	Write(ctx context.Context, events []auditEvent) error
+	Close() error
+}
+
+// auditLog fans download events out to the configured sinks. Events are
+// buffered and written in batches off the request path; when the buffer is
+// full, events are dropped and counted rather than slowing downloads.
+type auditLog struct {
+	sinks   []auditSink
+	events  chan auditEvent
+	dropped atomic.Int64
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// newAuditLog opens the sinks described by config.AuditSinks. It returns
+// nil when none are configured; recording to a nil auditLog does nothing.
+func newAuditLog(ctx context.Context, config *Config) (*auditLog, error) {
+	if len(config.AuditSinks) == 0 {
+		return nil, nil
+	}
+
+	a := &auditLog{events: make(chan auditEvent, auditBuffer)}
+	for _, spec := range config.AuditSinks {
+		sink, err := newAuditSink(ctx, config, spec)
+		if err != nil {
+			a.close()
+			return nil, fmt.Errorf("failed to open audit sink %q: %w", spec, err)
+		}
+		a.sinks = append(a.sinks, sink)
+	}
+	return a, nil
+}
+
+// record queues event for the sinks.
+func (a *auditLog) record(event auditEvent) {
+	if a == nil {
+		return
+	}
+
+	select {
+	case a.events <- event:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// start writes queued events in the background until stop is called.
+func (a *auditLog) start() {
+	if a == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel, a.stopped = cancel, make(chan struct{})
+	go func() {
+		defer close(a.stopped)
+		a.run(ctx)
+	}()
+}
+
+// stop flushes the events still queued, closes the sinks and waits for
+// both. Call it once downloads have stopped.
+func (a *auditLog) stop() {
+	if a == nil {
+		return
+	}
+
+	a.cancel()
+	<-a.stopped
+}
+
+// run writes queued events in batches until ctx is done, then flushes what
+// is left and closes the sinks.
+func (a *auditLog) run(ctx context.Context) {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+
+	var batch []auditEvent
+	flush := func(ctx context.Context) {
+		if dropped := a.dropped.Swap(0); dropped > 0 {
+			log.Printf("dropped %d audit events, the sinks can't keep up", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		for _, sink := range a.sinks {
+			if err := sink.Write(ctx, batch); err != nil {
+				log.Printf("failed to write %d audit events to %s. error: %v", len(batch), sink.Name(), err)
+			}
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case event := <-a.events:
+			batch = append(batch, event)
+			if len(batch) >= auditBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			for len(a.events) > 0 {
+				batch = append(batch, <-a.events)
+			}
+
+			// ctx is done; give the final flush a context of its own.
+			shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			flush(shutdown)
+			cancel()
+			a.close()
+			return
+		}
+	}
+}
+
+func (a *auditLog) close() {
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("failed to close audit sink %s. error: %v", sink.Name(), err)
+		}
+	}
+}
+
+// auditResult names how a download ended from its response status.
+func auditResult(status int) string {
+	switch {
+	case status == http.StatusNotModified:
+		return "not_modified"
+	case status >= 300 && status < 400:
+		return "redirected"
+	case status == http.StatusForbidden:
+		return "denied"
+	case status >= 400:
+		return "error"
+	}
+	return "served"
+}
+
+// newAuditEvent describes the download of asset answered with status after
+// sending bytes.
+func newAuditEvent(r *http.Request, asset *Asset, status int, bytes int64) auditEvent {
+	identity := requestIdentity(r)
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return auditEvent{
+		Time:       time.Now().UTC(),
+		User:       identity.User,
+		RemoteAddr: identity.RemoteAddr,
+		UserAgent:  identity.UserAgent,
+		Chart:      asset.Name,
+		Version:    requestedVersion(r, asset),
+		Digest:     asset.SHA,
+		Path:       r.URL.Path,
+		Bytes:      bytes,
+		Status:     status,
+		Result:     auditResult(status),
+	}
+}
+
+// validateAuditSink checks the syntax of a sink spec without opening it.
+func validateAuditSink(spec string) error {
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "stdout":
+		return nil
+	case "file", "cloud-logging":
+		if target == "" {
+			return fmt.Errorf("audit sink %q needs a target, e.g. %s:<name>", spec, kind)
+		}
+		return nil
+	case "bigquery":
+		if parts := strings.Split(target, "."); len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("audit sink %q needs a table, e.g. bigquery:[project.]dataset.table", spec)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown audit sink %q, expected stdout, file:, cloud-logging: or bigquery:", spec)
+}
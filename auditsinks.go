@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// newAuditSink opens the sink described by spec: "stdout", "file:<path>",
+// "cloud-logging:<log id>" or "bigquery:[project.]dataset.table". Cloud
+// sinks default to the configured project.
+func newAuditSink(ctx context.Context, config *Config, spec string) (auditSink, error) {
+	if err := validateAuditSink(spec); err != nil {
+		return nil, err
+	}
+
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "stdout":
+		return &writerSink{name: "stdout", w: os.Stdout}, nil
+	case "file":
+		f, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return nil, err
+		}
+		return &writerSink{name: spec, w: f, closer: f}, nil
+	case "cloud-logging":
+		return newCloudLoggingSink(ctx, config, target)
+	default:
+		return newBigQuerySink(ctx, config, target)
+	}
+}
+
+// writerSink writes events as JSON lines.
+type writerSink struct {
+	name   string
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func (s *writerSink) Name() string {
+	return s.name
+}
+
+func (s *writerSink) Write(ctx context.Context, events []auditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encoder := json.NewEncoder(s.w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// cloudLoggingSink writes events as structured entries of a Cloud Logging
+// log.
+type cloudLoggingSink struct {
+	logName string
+	service *logging.Service
+}
+
+func newCloudLoggingSink(ctx context.Context, config *Config, logID string) (*cloudLoggingSink, error) {
+	logName := logID
+	if !strings.HasPrefix(logName, "projects/") {
+		if config.Project == "" {
+			return nil, fmt.Errorf("cloud logging sink %q needs --project or a full projects/<project>/logs/<id> name", logID)
+		}
+		logName = fmt.Sprintf("projects/%s/logs/%s", config.Project, logID)
+	}
+
+	opts, err := googleClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := logging.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud logging client. error: %w", err)
+	}
+	return &cloudLoggingSink{logName: logName, service: service}, nil
+}
+
+func (s *cloudLoggingSink) Name() string {
+	return "cloud-logging:" + s.logName
+}
+
+func (s *cloudLoggingSink) Write(ctx context.Context, events []auditEvent) error {
+	request := &logging.WriteLogEntriesRequest{
+		LogName:  s.logName,
+		Resource: &logging.MonitoredResource{Type: "global"},
+	}
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		severity := "INFO"
+		if event.Result == "denied" || event.Result == "error" {
+			severity = "WARNING"
+		}
+		request.Entries = append(request.Entries, &logging.LogEntry{
+			JsonPayload: payload,
+			Timestamp:   event.Time.UTC().Format(time.RFC3339Nano),
+			Severity:    severity,
+		})
+	}
+
+	_, err := s.service.Entries.Write(request).Context(ctx).Do()
+	return err
+}
+
+func (s *cloudLoggingSink) Close() error {
+	return nil
+}
+
+// bigQuerySink streams events into a BigQuery table whose columns are the
+// JSON fields of auditEvent, with time as a TIMESTAMP.
+type bigQuerySink struct {
+	project, dataset, table string
+	service                 *bigquery.Service
+}
+
+func newBigQuerySink(ctx context.Context, config *Config, target string) (*bigQuerySink, error) {
+	parts := strings.Split(target, ".")
+	if len(parts) == 2 {
+		if config.Project == "" {
+			return nil, fmt.Errorf("bigquery sink %q needs --project or a project.dataset.table name", target)
+		}
+		parts = append([]string{config.Project}, parts...)
+	}
+
+	opts, err := googleClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := bigquery.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bigquery client. error: %w", err)
+	}
+	return &bigQuerySink{project: parts[0], dataset: parts[1], table: parts[2], service: service}, nil
+}
+
+func (s *bigQuerySink) Name() string {
+	return fmt.Sprintf("bigquery:%s.%s.%s", s.project, s.dataset, s.table)
+}
+
+func (s *bigQuerySink) Write(ctx context.Context, events []auditEvent) error {
+	request := &bigquery.TableDataInsertAllRequest{}
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		var row map[string]bigquery.JsonValue
+		if err := json.Unmarshal(data, &row); err != nil {
+			return err
+		}
+		request.Rows = append(request.Rows, &bigquery.TableDataInsertAllRequestRows{Json: row})
+	}
+
+	resp, err := s.service.Tabledata.InsertAll(s.project, s.dataset, s.table, request).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		message := ""
+		if len(first.Errors) > 0 {
+			message = first.Errors[0].Message
+		}
+		return fmt.Errorf("%d rows rejected, first at index %d: %s", len(resp.InsertErrors), first.Index, message)
+	}
+	return nil
+}
+
+func (s *bigQuerySink) Close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Backend is a registry the catalog is loaded from and charts are pulled from.
+type Backend interface {
+	// Name describes the backend in logs, e.g. the repository address.
+	Name() string
+	// Host is the registry host to log in to before pulling an asset.
+	Host() string
+	// Credential returns the basic auth credential used to log in to Host.
+	Credential(ctx context.Context) (user, password string, err error)
+	// List returns every asset currently stored in the backend. Entries
+	// that can't be parsed or looked up are left out and reported in a
+	// skippedEntries error returned along with the other assets.
+	List(ctx context.Context) ([]*Asset, error)
+	Close() error
+}
+
+func newBackend(ctx context.Context, config *Config) (Backend, error) {
+	if len(config.Routes) > 0 {
+		return newCompositeBackend(ctx, config)
+	}
+
+	if config.usesGoogle() {
+		if _, _, err := getCredential(config); err != nil {
+			return nil, withExitCode(exitCredential, fmt.Errorf("failed to load credential: %w", err))
+		}
+	}
+
+	switch config.Backend {
+	case "gar":
+		return newGARBackend(ctx, config)
+	case "gcr":
+		return newGCRBackend(config)
+	case "ecr":
+		return newECRBackend(config)
+	case "acr":
+		return newACRBackend(config)
+	case "oci", "distribution":
+		return newOCIBackend(config)
+	case "ghcr":
+		return newGHCRBackend(config)
+	case "harbor":
+		return newHarborBackend(config)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", config.Backend)
+	}
+}
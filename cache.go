@@ -0,0 +1,208 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// cachedChart is a pulled chart archive along with the metadata needed to
+// serve it.
+type cachedChart struct {
+	Name    string
+	Version string
+	Data    []byte
+}
+
+// chartCache keeps pulled charts by digest so repeated and resumed
+// downloads don't go back to the registry.
+type chartCache interface {
+	get(digest string) (*cachedChart, bool)
+	put(digest string, chart *cachedChart)
+	stats() cacheStats
+
+	// list returns the charts held, remove drops the chart of digest,
+	// reporting whether it was held, and purge drops them all, returning
+	// how many.
+	list(ctx context.Context) ([]cacheEntry, error)
+	remove(ctx context.Context, digest string) (bool, error)
+	purge(ctx context.Context) (int, error)
+}
+
+// cacheEntry describes a chart held by a chartCache, in the tier it is
+// held in: memory, disk or bucket.
+type cacheEntry struct {
+	Digest  string    `json:"digest"`
+	Chart   string    `json:"chart"`
+	Version string    `json:"version"`
+	Tier    string    `json:"tier"`
+	Bytes   int64     `json:"bytes"`
+	Added   time.Time `json:"added"`
+	Used    time.Time `json:"used"`
+}
+
+// cacheStats counts the lookups of a chartCache and what it holds.
+type cacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+
+	// The Disk counts are those of --cache-dir.
+	DiskHits      int64 `json:"diskHits,omitempty"`
+	DiskMisses    int64 `json:"diskMisses,omitempty"`
+	DiskEvictions int64 `json:"diskEvictions,omitempty"`
+	DiskEntries   int   `json:"diskEntries,omitempty"`
+	DiskBytes     int64 `json:"diskBytes,omitempty"`
+
+	// SharedHits and SharedMisses count the local misses looked up in
+	// --cache-bucket.
+	SharedHits   int64 `json:"sharedHits,omitempty"`
+	SharedMisses int64 `json:"sharedMisses,omitempty"`
+}
+
+func newChartCache(ctx context.Context, config *Config) (chartCache, error) {
+	var local chartCache = noCache{}
+	if config.CacheMemoryBytes > 0 {
+		local = newMemoryCache(config.CacheMemoryBytes)
+	}
+	if config.CacheDir != "" {
+		disk, err := newDiskCache(config, local)
+		if err != nil {
+			return nil, err
+		}
+		local = disk
+	}
+	if config.CacheBucket == "" {
+		return local, nil
+	}
+	return newGCSCache(ctx, config, local)
+}
+
+// noCache is used when caching is disabled.
+type noCache struct{}
+
+func (noCache) get(string) (*cachedChart, bool) { return nil, false }
+func (noCache) put(string, *cachedChart)        {}
+func (noCache) stats() cacheStats               { return cacheStats{} }
+
+func (noCache) list(context.Context) ([]cacheEntry, error)   { return nil, nil }
+func (noCache) remove(context.Context, string) (bool, error) { return false, nil }
+func (noCache) purge(context.Context) (int, error)           { return 0, nil }
+
+// memoryCache is an in-memory chartCache evicting the least recently used
+// charts once their total size exceeds max bytes.
+type memoryCache struct {
+	mu      sync.Mutex
+	max     int64
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+
+	hits, misses, evictions int64
+}
+
+type memoryEntry struct {
+	digest      string
+	chart       *cachedChart
+	added, used time.Time
+}
+
+func newMemoryCache(max int64) *memoryCache {
+	return &memoryCache{max: max, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *memoryCache) get(digest string) (*cachedChart, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[digest]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	entry := element.Value.(*memoryEntry)
+	entry.used = time.Now()
+	return entry.chart, true
+}
+
+func (c *memoryCache) put(digest string, chart *cachedChart) {
+	size := int64(len(chart.Data))
+	if size > c.max {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[digest]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+
+	now := time.Now()
+	c.entries[digest] = c.order.PushFront(&memoryEntry{digest: digest, chart: chart, added: now, used: now})
+	c.size += size
+
+	for c.size > c.max {
+		c.drop(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *memoryCache) stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{Hits: c.hits, Misses: c.misses, Evictions: c.evictions, Entries: len(c.entries), Bytes: c.size}
+}
+
+func (c *memoryCache) list(context.Context) ([]cacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var entries []cacheEntry
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*memoryEntry)
+		entries = append(entries, cacheEntry{
+			Digest:  entry.digest,
+			Chart:   entry.chart.Name,
+			Version: entry.chart.Version,
+			Tier:    "memory",
+			Bytes:   int64(len(entry.chart.Data)),
+			Added:   entry.added,
+			Used:    entry.used,
+		})
+	}
+	return entries, nil
+}
+
+func (c *memoryCache) remove(_ context.Context, digest string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[digest]
+	if ok {
+		c.drop(element)
+	}
+	return ok, nil
+}
+
+func (c *memoryCache) purge(context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := len(c.entries)
+	for element := c.order.Front(); element != nil; element = c.order.Front() {
+		c.drop(element)
+	}
+	return removed, nil
+}
+
+// drop removes the chart of element. The caller holds c.mu.
+func (c *memoryCache) drop(element *list.Element) {
+	entry := element.Value.(*memoryEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.digest)
+	c.size -= int64(len(entry.chart.Data))
+}
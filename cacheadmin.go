@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// cacheListing answers GET /admin/cache.
+type cacheListing struct {
+	Stats   cacheStats        `json:"stats"`
+	Entries []cacheListedItem `json:"entries"`
+}
+
+type cacheListedItem struct {
+	cacheEntry
+	AgeSeconds float64 `json:"ageSeconds"`
+}
+
+// cacheAdmin lets operators inspect and evict the chart cache without
+// restarting.
+type cacheAdmin struct {
+	cache chartCache
+}
+
+// handleList lists the charts held by every tier of the cache.
+func (a *cacheAdmin) handleList(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.cache.list(r.Context())
+	if err != nil {
+		log.Printf("failed to list the chart cache. error: %v", err)
+		http.Error(w, "failed to list the chart cache", http.StatusBadGateway)
+		return
+	}
+
+	now := time.Now()
+	listing := cacheListing{Stats: a.cache.stats(), Entries: []cacheListedItem{}}
+	for _, entry := range entries {
+		item := cacheListedItem{cacheEntry: entry}
+		if !entry.Added.IsZero() {
+			item.AgeSeconds = now.Sub(entry.Added).Seconds()
+		}
+		listing.Entries = append(listing.Entries, item)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// handleRemove evicts a chart from every tier of the cache, so the next
+// download pulls it again.
+func (a *cacheAdmin) handleRemove(w http.ResponseWriter, r *http.Request) {
+	digest := chi.URLParam(r, "digest")
+	if err := validateDigest(digest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	removed, err := a.cache.remove(r.Context(), digest)
+	if err != nil {
+		log.Printf("failed to evict %s from the chart cache. error: %v", digest, err)
+		http.Error(w, "failed to evict chart", http.StatusBadGateway)
+		return
+	}
+	if !removed {
+		http.NotFound(w, r)
+		return
+	}
+	identity := requestIdentity(r)
+	who := identity.User
+	if who == "" {
+		who = identity.RemoteAddr
+	}
+	log.Printf("%s evicted %s from the chart cache", who, digest)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurge empties every tier of the cache.
+func (a *cacheAdmin) handlePurge(w http.ResponseWriter, r *http.Request) {
+	removed, err := a.cache.purge(r.Context())
+	if err != nil {
+		log.Printf("failed to purge the chart cache after %d charts. error: %v", removed, err)
+		http.Error(w, "failed to purge the chart cache", http.StatusBadGateway)
+		return
+	}
+	identity := requestIdentity(r)
+	who := identity.User
+	if who == "" {
+		who = identity.RemoteAddr
+	}
+	log.Printf("%s purged %d charts from the chart cache", who, removed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"removed": removed})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestCacheAdmin(t *testing.T) {
+	cache := newMemoryCache(1 << 20)
+	cache.put("sha256:"+strings.Repeat("1", 64), &cachedChart{Name: "nginx", Version: "1.2.3", Data: []byte("chart1")})
+	cache.put("sha256:"+strings.Repeat("2", 64), &cachedChart{Name: "nginx", Version: "1.3.0", Data: []byte("chart2")})
+	admin := &cacheAdmin{cache: cache}
+	router := chi.NewRouter()
+	router.Get("/admin/cache", admin.handleList)
+	router.Delete("/admin/cache", admin.handlePurge)
+	router.Delete("/admin/cache/{digest}", admin.handleRemove)
+
+	tests := []struct {
+		method  string
+		target  string
+		code    int
+		entries int
+	}{
+		{http.MethodGet, "/admin/cache", http.StatusOK, 2},
+		{http.MethodDelete, "/admin/cache/not-a-digest", http.StatusBadRequest, 2},
+		{http.MethodDelete, "/admin/cache/sha256:" + strings.Repeat("1", 64), http.StatusNoContent, 1},
+		{http.MethodDelete, "/admin/cache/sha256:" + strings.Repeat("1", 64), http.StatusNotFound, 1},
+		{http.MethodDelete, "/admin/cache", http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s %s = %d %s, want %d", tt.method, tt.target, w.Code, w.Body, tt.code)
+		}
+		if entries, _ := cache.list(context.Background()); len(entries) != tt.entries {
+			t.Errorf("after %s %s, the cache holds %d charts, want %d", tt.method, tt.target, len(entries), tt.entries)
+		}
+	}
+	if stats := cache.stats(); stats.Bytes != 0 {
+		t.Errorf("stats() after purging = %+v, want 0 bytes", stats)
+	}
+}
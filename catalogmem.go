@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"unsafe"
+)
+
+// stringPool interns the strings repeated across the assets of a catalog,
+// such as chart names, media types and tags like latest, so a catalog of
+// 100k digests holds a single copy of each.
+type stringPool map[string]string
+
+func (p stringPool) intern(s string) string {
+	if interned, ok := p[s]; ok {
+		return interned
+	}
+	p[s] = s
+	return s
+}
+
+// compactAssets interns the repeated strings of assets and trims their tag
+// slices, then returns an estimate of the bytes the catalog holds on to.
+func compactAssets(assets []*Asset) int64 {
+	pool := stringPool{}
+	size := int64(len(assets)) * int64(unsafe.Sizeof(&Asset{})+unsafe.Sizeof(Asset{}))
+	for _, asset := range assets {
+		asset.Name = pool.intern(asset.Name)
+		asset.MediaType = pool.intern(asset.MediaType)
+		for i, tag := range asset.Tags {
+			asset.Tags[i] = pool.intern(tag)
+		}
+		if cap(asset.Tags) > len(asset.Tags) {
+			asset.Tags = append([]string(nil), asset.Tags...)
+		}
+		// Most backends name assets by their URI.
+		if asset.RawName == asset.URI {
+			asset.RawName = asset.URI
+		} else {
+			size += int64(len(asset.RawName))
+		}
+
+		size += int64(len(asset.SHA) + len(asset.URI))
+		size += int64(len(asset.Tags)) * int64(unsafe.Sizeof(""))
+	}
+	for s := range pool {
+		size += int64(len(s))
+	}
+	return size
+}
+
+// overCatalogBudget reports whether the catalog outgrows
+// --catalog-memory-bytes, warning when there is no --catalog-path to keep it
+// in instead.
+func overCatalogBudget(config *Config, size int64, assets int) bool {
+	if config.CatalogMemoryBytes == 0 || size <= config.CatalogMemoryBytes {
+		return false
+	}
+	if config.CatalogPath == "" {
+		log.Printf("catalog of %d assets takes about %d bytes, over the budget of %d", assets, size, config.CatalogMemoryBytes)
+	}
+	return true
+}
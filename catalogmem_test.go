@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestCompactAssets(t *testing.T) {
+	// Strings decoded separately, as from registry responses.
+	copyOf := func(s string) string { return string([]byte(s)) }
+	tags := make([]string, 1, 8)
+	tags[0] = copyOf("latest")
+	assets := []*Asset{
+		{Name: copyOf("nginx"), SHA: "sha256:1", URI: "example.com/nginx@sha256:1", Tags: tags},
+		{Name: copyOf("nginx"), SHA: "sha256:2", URI: "example.com/nginx@sha256:2", Tags: []string{copyOf("latest")}},
+	}
+	assets[0].RawName = assets[0].URI
+
+	if size := compactAssets(assets); size <= 0 {
+		t.Errorf("compactAssets() = %d, want a positive estimate", size)
+	}
+	if unsafe.StringData(assets[0].Name) != unsafe.StringData(assets[1].Name) {
+		t.Error("chart names weren't interned")
+	}
+	if unsafe.StringData(assets[0].Tags[0]) != unsafe.StringData(assets[1].Tags[0]) {
+		t.Error("tags weren't interned")
+	}
+	if cap(assets[0].Tags) != 1 {
+		t.Errorf("tags capacity = %d, want 1", cap(assets[0].Tags))
+	}
+}
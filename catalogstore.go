@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync/atomic"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// assetsBucket holds the assets as JSON, keyed by name and digest.
+	assetsBucket = []byte("assets")
+	// tagsBucket maps name and tag to the digest the tag points at.
+	tagsBucket = []byte("tags")
+)
+
+// catalogStoreBatch is how many assets are written per transaction, which
+// bounds the memory a write holds.
+const catalogStoreBatch = 10000
+
+// catalogStore keeps a catalog on disk for repositories too large for
+// memory. Handlers query it through the Repository methods, which read
+// assets by key instead of scanning a slice. Every sync writes a new store;
+// the previous one is closed and removed once no catalog refers to it, as
+// resolution sessions may still hold on to it.
+type catalogStore struct {
+	db    *bolt.DB
+	count atomic.Int64
+}
+
+// catalogKey is the key of suffix, a digest or a tag, of the chart called
+// name. Names can't contain NUL, so the keys of a chart never share a
+// prefix with those of another whose name starts the same.
+func catalogKey(name, suffix string) []byte {
+	return []byte(name + "\x00" + suffix)
+}
+
+// writeCatalogStore writes assets to a new store in dir.
+func writeCatalogStore(dir string, assets []*Asset) (*catalogStore, error) {
+	file, err := os.CreateTemp(dir, "catalog-*.db")
+	if err != nil {
+		return nil, err
+	}
+	path := file.Name()
+	file.Close()
+
+	// The store is rebuilt by the next sync after a crash, so it is never
+	// synced to disk.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{NoSync: true, NoFreelistSync: true})
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	for start := 0; start == 0 || start < len(assets); start += catalogStoreBatch {
+		end := start + catalogStoreBatch
+		if end > len(assets) {
+			end = len(assets)
+		}
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			byDigest, err := tx.CreateBucketIfNotExists(assetsBucket)
+			if err != nil {
+				return err
+			}
+			byTag, err := tx.CreateBucketIfNotExists(tagsBucket)
+			if err != nil {
+				return err
+			}
+			for _, asset := range assets[start:end] {
+				if err := putAsset(byDigest, byTag, asset); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			os.Remove(path)
+			return nil, fmt.Errorf("failed to write catalog store: %w", err)
+		}
+	}
+
+	s := &catalogStore{db: db}
+	s.count.Store(int64(len(assets)))
+	runtime.SetFinalizer(s, (*catalogStore).remove)
+	return s, nil
+}
+
+func putAsset(byDigest, byTag *bolt.Bucket, asset *Asset) error {
+	data, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	if err := byDigest.Put(catalogKey(asset.Name, asset.SHA), data); err != nil {
+		return err
+	}
+	for _, tag := range asset.Tags {
+		if err := byTag.Put(catalogKey(asset.Name, tag), []byte(asset.SHA)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove closes the store and deletes its file.
+func (s *catalogStore) remove() {
+	runtime.SetFinalizer(s, nil)
+	path := s.db.Path()
+	if err := s.db.Close(); err != nil {
+		log.Printf("failed to close catalog store %s. error: %v", path, err)
+	}
+	os.Remove(path)
+}
+
+func decodeAsset(data []byte) *Asset {
+	asset := &Asset{}
+	if err := json.Unmarshal(data, asset); err != nil {
+		log.Printf("failed to decode catalog entry. error: %v", err)
+		return nil
+	}
+	return asset
+}
+
+// get returns the asset of the chart called name with the given digest.
+func (s *catalogStore) get(name, digest string) *Asset {
+	var asset *Asset
+	s.view(func(tx *bolt.Tx) {
+		if data := tx.Bucket(assetsBucket).Get(catalogKey(name, digest)); data != nil {
+			asset = decodeAsset(data)
+		}
+	})
+	return asset
+}
+
+// tagged returns the asset of the chart called name tagged tag.
+func (s *catalogStore) tagged(name, tag string) *Asset {
+	var asset *Asset
+	s.view(func(tx *bolt.Tx) {
+		digest := tx.Bucket(tagsBucket).Get(catalogKey(name, tag))
+		if digest == nil {
+			return
+		}
+		if data := tx.Bucket(assetsBucket).Get(catalogKey(name, string(digest))); data != nil {
+			asset = decodeAsset(data)
+		}
+	})
+	return asset
+}
+
+// scan calls fn with every asset whose key starts with prefix, in key
+// order, until fn returns false.
+func (s *catalogStore) scan(prefix string, fn func(*Asset) bool) {
+	s.view(func(tx *bolt.Tx) {
+		cursor := tx.Bucket(assetsBucket).Cursor()
+		for key, data := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, data = cursor.Next() {
+			if asset := decodeAsset(data); asset != nil && !fn(asset) {
+				return
+			}
+		}
+	})
+}
+
+func (s *catalogStore) view(fn func(tx *bolt.Tx)) {
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fn(tx)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to read catalog store. error: %v", err)
+	}
+}
+
+// without removes tag from asset in the store, or asset altogether when
+// tag is empty.
+func (s *catalogStore) without(asset *Asset, tag string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		byDigest, byTag := tx.Bucket(assetsBucket), tx.Bucket(tagsBucket)
+		data := byDigest.Get(catalogKey(asset.Name, asset.SHA))
+		if data == nil {
+			return nil
+		}
+		stored := decodeAsset(data)
+		if stored == nil {
+			return fmt.Errorf("invalid catalog entry %s@%s", asset.Name, asset.SHA)
+		}
+
+		var kept []string
+		for _, t := range stored.Tags {
+			if tag == "" || t == tag {
+				if err := byTag.Delete(catalogKey(asset.Name, t)); err != nil {
+					return err
+				}
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		if tag == "" {
+			s.count.Add(-1)
+			return byDigest.Delete(catalogKey(asset.Name, asset.SHA))
+		}
+		stored.Tags = kept
+		return putAsset(byDigest, byTag, stored)
+	})
+}
+
+// merge puts assets in the store in place of those of the same digests,
+// taking their tags off the digests they were on before being moved.
+func (s *catalogStore) merge(assets []*Asset) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		byDigest, byTag := tx.Bucket(assetsBucket), tx.Bucket(tagsBucket)
+		for _, asset := range assets {
+			for _, tag := range asset.Tags {
+				digest := string(byTag.Get(catalogKey(asset.Name, tag)))
+				if digest == "" || digest == asset.SHA {
+					continue
+				}
+				if err := s.untag(byDigest, byTag, asset.Name, digest, tag); err != nil {
+					return err
+				}
+			}
+
+			key := catalogKey(asset.Name, asset.SHA)
+			if data := byDigest.Get(key); data == nil {
+				s.count.Add(1)
+			} else if stored := decodeAsset(data); stored != nil {
+				// Tags moved off the digest since it was stored.
+				for _, tag := range stored.Tags {
+					if !hasTag(asset, tag) && string(byTag.Get(catalogKey(asset.Name, tag))) == asset.SHA {
+						if err := byTag.Delete(catalogKey(asset.Name, tag)); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			if err := putAsset(byDigest, byTag, asset); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// untag takes tag off the stored asset of the chart called name with the
+// given digest.
+func (s *catalogStore) untag(byDigest, byTag *bolt.Bucket, name, digest, tag string) error {
+	data := byDigest.Get(catalogKey(name, digest))
+	if data == nil {
+		return nil
+	}
+	stored := decodeAsset(data)
+	if stored == nil {
+		return fmt.Errorf("invalid catalog entry %s@%s", name, digest)
+	}
+
+	var kept []string
+	for _, t := range stored.Tags {
+		if t != tag {
+			kept = append(kept, t)
+		}
+	}
+	stored.Tags = kept
+	return putAsset(byDigest, byTag, stored)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestCatalogStore(t *testing.T) {
+	store, err := writeCatalogStore(t.TempDir(), []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0", "latest"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"0.9.0"}},
+		{Name: "nginx-ingress", SHA: "sha256:3", Tags: []string{"latest"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.remove()
+	r := &Repository{store: store}
+
+	if asset := r.findByTag("nginx", "latest"); asset == nil || asset.SHA != "sha256:1" {
+		t.Errorf("findByTag(nginx, latest) = %v, want sha256:1", asset)
+	}
+	if asset := r.findByDigest("nginx-ingress", "sha256:3"); asset == nil {
+		t.Error("findByDigest(nginx-ingress, sha256:3) = nil")
+	}
+	if matches := r.findByDigestPrefix("nginx", "sha256:"); len(matches) != 2 {
+		t.Errorf("findByDigestPrefix(nginx) = %d matches, want 2", len(matches))
+	}
+
+	r = r.without(&Asset{Name: "nginx", SHA: "sha256:1"}, "latest")
+	if asset := r.findByTag("nginx", "latest"); asset != nil {
+		t.Errorf("findByTag(nginx, latest) after untagging = %v, want nil", asset)
+	}
+	r = r.without(&Asset{Name: "nginx", SHA: "sha256:2"}, "")
+	if r.len() != 2 || r.findByTag("nginx", "0.9.0") != nil {
+		t.Errorf("len() after deleting = %d, want 2 without nginx 0.9.0", r.len())
+	}
+}
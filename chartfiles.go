@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// chartFile is a file of the chart archive served on its own.
+type chartFile struct {
+	// names are the file names it may have at the root of the chart.
+	names       []string
+	contentType string
+}
+
+var (
+	chartYAMLFile  = chartFile{names: []string{"Chart.yaml"}, contentType: "application/yaml"}
+	valuesYAMLFile = chartFile{names: []string{"values.yaml"}, contentType: "application/yaml"}
+	// Helm shows the first of these it finds with `helm show readme`.
+	readmeFile = chartFile{names: []string{"README.md", "README.txt", "README"}, contentType: "text/markdown; charset=utf-8"}
+)
+
+// openChart returns a reader of the files of a chart archive, gzipped or
+// not.
+func openChart(data []byte) (*tar.Reader, error) {
+	var archive io.Reader = bytes.NewReader(data)
+	if isGzip(data) {
+		decompressed, err := gzip.NewReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		archive = decompressed
+	}
+	return tar.NewReader(archive), nil
+}
+
+// extract returns the content of the file from a chart archive, or nil if
+// the chart doesn't have it. Files of subcharts are ignored.
+func (f chartFile) extract(data []byte) ([]byte, error) {
+	reader, err := openChart(data)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]byte)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Count(header.Name, "/") != 1 {
+			continue
+		}
+		_, file := path.Split(header.Name)
+		for _, name := range f.names {
+			if strings.EqualFold(file, name) {
+				if found[name], err = io.ReadAll(reader); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for _, name := range f.names {
+		if content, ok := found[name]; ok {
+			return content, nil
+		}
+	}
+	return nil, nil
+}
+
+// chartVersion pulls the archive of the chart version a file request is
+// for. variant tells the file apart in its ETag. It returns nil when it
+// answered the request itself: with an error, or 304 when the client holds
+// the file already.
+func (d *chartDownloader) chartVersion(w http.ResponseWriter, r *http.Request, variant string) (*Asset, *cachedChart) {
+	name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+	asset := findChartVersion(name, version)
+	if asset == nil {
+		config, _ := d.live.get()
+		chartNotFound(w, config, currentRepository(), name, version)
+		return nil, nil
+	}
+
+	allowed, reason, err := d.policy.authorize(r, asset)
+	if err != nil {
+		log.Printf("failed to evaluate download policy for %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to evaluate download policy", http.StatusServiceUnavailable)
+		return nil, nil
+	}
+	if !allowed {
+		http.Error(w, strings.TrimSpace("download denied by policy. "+reason), http.StatusForbidden)
+		return nil, nil
+	}
+
+	etag := strings.TrimSuffix(assetETag(asset), `"`) + "-" + variant + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", tagCacheControl)
+	if notModified(r, etag, asset.Updated) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil, nil
+	}
+
+	config, backend := d.live.get()
+	if oversized(config, asset) {
+		refuseOversized(w, config, asset)
+		return nil, nil
+	}
+	chart, err := pullAsset(r.Context(), d.client, d.logins, d.cache, d.stats, backend, asset)
+	if err != nil {
+		log.Printf("failed to pull %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to pull chart", http.StatusBadGateway)
+		return nil, nil
+	}
+	return asset, chart
+}
+
+// handleChartFile serves a file of a chart version, so UIs can show it
+// without downloading the archive.
+func (d *chartDownloader) handleChartFile(file chartFile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		asset, chart := d.chartVersion(w, r, strings.ToLower(file.names[0]))
+		if chart == nil {
+			return
+		}
+
+		content, err := file.extract(chart.Data)
+		if err != nil {
+			log.Printf("failed to read %s of %s. error: %v", file.names[0], asset.RawName, err)
+			http.Error(w, "failed to read chart archive", http.StatusBadGateway)
+			return
+		}
+		if content == nil {
+			http.Error(w, fmt.Sprintf("%s has no %s", asset.RawName, file.names[0]), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", file.contentType)
+		w.Write(content)
+	}
+}
+
+// chartFileTypes are the content types of the files charts usually hold.
+// Other files are served as application/octet-stream, never sniffed, so a
+// chart can't have HTML run on the proxy's origin.
+var chartFileTypes = map[string]string{
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".json": "application/json",
+	".md":   "text/markdown; charset=utf-8",
+	".tpl":  "text/plain; charset=utf-8",
+	".txt":  "text/plain; charset=utf-8",
+}
+
+// cleanChartPath validates the ?path of a file request, relative to the
+// root of the chart.
+func cleanChartPath(value string) (string, error) {
+	cleaned := path.Clean("/" + value)[1:]
+	if value == "" || cleaned == "" || cleaned != strings.TrimPrefix(value, "./") {
+		return "", fmt.Errorf("invalid path %q, expected a file relative to the chart root, like templates/deployment.yaml", value)
+	}
+	return cleaned, nil
+}
+
+// handleFile streams the file at ?path of a chart version out of its
+// archive, so code review bots don't download a chart for one file.
+func (d *chartDownloader) handleFile(w http.ResponseWriter, r *http.Request) {
+	name, err := cleanChartPath(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asset, chart := d.chartVersion(w, r, sha256Hex([]byte(name))[:16])
+	if chart == nil {
+		return
+	}
+
+	reader, err := openChart(chart.Data)
+	if err != nil {
+		log.Printf("failed to read %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to read chart archive", http.StatusBadGateway)
+		return
+	}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("failed to read %s. error: %v", asset.RawName, err)
+			http.Error(w, "failed to read chart archive", http.StatusBadGateway)
+			return
+		}
+
+		// Entries are stored under the chart's directory.
+		_, entry, _ := strings.Cut(header.Name, "/")
+		if entry != name || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		contentType, ok := chartFileTypes[path.Ext(name)]
+		if !ok {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Length", strconv.FormatInt(header.Size, 10))
+		io.Copy(w, reader)
+		return
+	}
+	http.Error(w, fmt.Sprintf("%s has no file %s", asset.RawName, name), http.StatusNotFound)
+}
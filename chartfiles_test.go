@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestChartFileExtract(t *testing.T) {
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"nginx/Chart.yaml":                "name: nginx\n",
+		"nginx/readme.md":                 "# nginx\n",
+		"nginx/charts/common/values.yaml": "subchart: true\n",
+	} {
+		writer.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))})
+		writer.Write([]byte(content))
+	}
+	writer.Close()
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(archive.Bytes())
+	gz.Close()
+
+	for _, data := range [][]byte{archive.Bytes(), compressed.Bytes()} {
+		tests := []struct {
+			file chartFile
+			want []byte
+		}{
+			{chartYAMLFile, []byte("name: nginx\n")},
+			{readmeFile, []byte("# nginx\n")},
+			// Only the values of a subchart, which aren't the chart's.
+			{valuesYAMLFile, nil},
+		}
+		for _, tt := range tests {
+			got, err := tt.file.extract(data)
+			if err != nil {
+				t.Fatalf("extract(%s) error = %v", tt.file.names[0], err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("extract(%s) = %q, want %q", tt.file.names[0], got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCleanChartPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"templates/deployment.yaml", "templates/deployment.yaml", true},
+		{"./Chart.yaml", "Chart.yaml", true},
+		{"charts/redis/values.yaml", "charts/redis/values.yaml", true},
+		{"", "", false},
+		{".", "", false},
+		{"../secret", "", false},
+		{"templates/../../secret", "", false},
+		{"/etc/passwd", "", false},
+	}
+	for _, tt := range tests {
+		got, err := cleanChartPath(tt.path)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("cleanChartPath(%q) = %q, %v, want %q, ok %v", tt.path, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientTools maps User-Agent product names to the tool they belong to.
+// Anything else is counted as "other" to keep the number of buckets small.
+var clientTools = map[string]string{
+	"helm":               "helm",
+	"flux":               "flux",
+	"source-controller":  "flux",
+	"helm-controller":    "flux",
+	"argocd":             "argocd",
+	"argocd-repo-server": "argocd",
+	"argo-cd":            "argocd",
+	"containerd":         "containerd",
+	"docker":             "docker",
+	"oras":               "oras",
+	"crane":              "crane",
+	"curl":               "curl",
+	"wget":               "wget",
+	"go-http-client":     "go",
+}
+
+var userAgentProduct = regexp.MustCompile(`^([A-Za-z][\w.-]*)/v?(\d+)(?:\.(\d+))?`)
+
+// parseUserAgent reduces a User-Agent to a tool name and a major.minor
+// version bucket, e.g. "Helm/3.14.2" to ("helm", "3.14").
+func parseUserAgent(userAgent string) (tool, version string) {
+	match := userAgentProduct.FindStringSubmatch(strings.TrimSpace(userAgent))
+	if match == nil {
+		return "other", "unknown"
+	}
+
+	tool, ok := clientTools[strings.ToLower(match[1])]
+	if !ok {
+		return "other", "unknown"
+	}
+
+	version = match[2]
+	if match[3] != "" {
+		version += "." + match[3]
+	}
+	return tool, version
+}
+
+// clientBucket counts the downloads of one tool version.
+type clientBucket struct {
+	Tool      string    `json:"tool"`
+	Version   string    `json:"version"`
+	Downloads int64     `json:"downloads"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// clientStats aggregates downloads by client tool and version.
+type clientStats struct {
+	mu      sync.Mutex
+	buckets map[string]*clientBucket
+}
+
+func newClientStats() *clientStats {
+	return &clientStats{buckets: map[string]*clientBucket{}}
+}
+
+func (s *clientStats) record(userAgent string) {
+	tool, version := parseUserAgent(userAgent)
+	clientRequests.WithLabelValues(tool, version).Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := tool + "/" + version
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &clientBucket{Tool: tool, Version: version}
+		s.buckets[key] = bucket
+	}
+	bucket.Downloads++
+	bucket.LastSeen = time.Now()
+}
+
+// handleClients lists the client versions seen since start, most used
+// first.
+func (s *clientStats) handleClients(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	buckets := make([]clientBucket, 0, len(s.buckets))
+	for _, bucket := range s.buckets {
+		buckets = append(buckets, *bucket)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Downloads != buckets[j].Downloads {
+			return buckets[i].Downloads > buckets[j].Downloads
+		}
+		return buckets[i].Tool+buckets[i].Version < buckets[j].Tool+buckets[j].Version
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buckets)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func FuzzParseUserAgent(f *testing.F) {
+	for _, seed := range []string{
+		"Helm/3.14.0",
+		"flux/v2.2.3",
+		"argocd-repo-server/v2.10.1 (linux/amd64)",
+		"containerd/v1.7.13",
+		"curl/8.4.0",
+		"Mozilla/5.0 (X11; Linux x86_64)",
+		"",
+		"/",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, userAgent string) {
+		tool, version := parseUserAgent(userAgent)
+		if tool == "" || version == "" {
+			t.Fatalf("parseUserAgent(%q) = %q, %q, want a bucket", userAgent, tool, version)
+		}
+		if tool == "other" && version != "unknown" {
+			t.Fatalf("parseUserAgent(%q) = %q, %q, want other clients unversioned", userAgent, tool, version)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func newRootCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "gcp-oci-proxy",
+		Short:        "Serve OCI Helm charts from Artifact Registry as a classic chart repository",
+		SilenceUsage: true,
+	}
+	cmd.AddCommand(newServeCommand(), newHelmDownloaderCommand())
+	return cmd
+}
+
+func newServeCommand() *cobra.Command {
+	var configFile string
+	bound := &Config{}
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Start the proxy HTTP server",
+		Long: `Start the proxy HTTP server.
+
+Settings are resolved in order from command line flags, their environment
+variables, the optional --config file and finally the flag defaults. Sending
+SIGHUP re-reads the environment and the config file and reloads the catalog
+without interrupting in-flight downloads.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			load := func() (*Config, error) {
+				config, err := resolveConfig(cmd.Flags(), bound, configFile)
+				if err != nil {
+					return nil, err
+				}
+				return config, config.validate()
+			}
+
+			config, err := load()
+			if err != nil {
+				return withExitCode(exitConfig, err)
+			}
+			return serve(config, load)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&configFile, "config", envOr("CONFIG", ""), "YAML file whose keys are the flag names below [CONFIG]")
+	bindFlags(flags, bound)
+
+	return cmd
+}
@@ -0,0 +1,242 @@
+// Command proxyctl lists, downloads and uploads charts through a
+// gcp-oci-proxy, so users don't have to craft URLs with digests.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// searchPage is how many results list asks for at a time.
+const searchPage = 500
+
+// client talks to the proxy's HTTP API.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+type asset struct {
+	Name    string    `json:"name"`
+	SHA     string    `json:"sha"`
+	Tags    []string  `json:"tags"`
+	Size    int64     `json:"size"`
+	Updated time.Time `json:"updated"`
+}
+
+type searchResults struct {
+	Total   int      `json:"total"`
+	Results []*asset `json:"results"`
+}
+
+func (c *client) do(method, target string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.server, "/")+target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "proxyctl")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, target, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// list returns the digests of the charts whose name contains filter.
+func (c *client) list(filter string) ([]*asset, error) {
+	var assets []*asset
+	for {
+		query := url.Values{"q": {filter}, "sort": {"name"}, "offset": {fmt.Sprint(len(assets))}, "limit": {fmt.Sprint(searchPage)}}
+		resp, err := c.do(http.MethodGet, "/api/search?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		var page searchResults
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid search results: %w", err)
+		}
+
+		assets = append(assets, page.Results...)
+		if len(page.Results) == 0 || len(assets) >= page.Total {
+			return assets, nil
+		}
+	}
+}
+
+// downloadPath returns the download route of a reference: chart:version,
+// chart@digest, or a bare chart for its latest version.
+func downloadPath(reference string) (string, error) {
+	if reference == "" || strings.HasPrefix(reference, "/") {
+		return "", fmt.Errorf("invalid chart reference %q, expected chart, chart:version or chart@digest", reference)
+	}
+	base := path.Base(reference)
+	if strings.Contains(base, ":") || strings.Contains(base, "@") {
+		return "/" + reference, nil
+	}
+	return "/" + reference + "/latest", nil
+}
+
+// get downloads the chart archive of reference into dir, and returns the
+// file it wrote.
+func (c *client) get(reference, dir string) (string, error) {
+	target, err := downloadPath(reference)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.do(http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	name := path.Base(strings.NewReplacer(":", "-", "@", "-").Replace(reference)) + ".tgz"
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = filepath.Base(params["filename"])
+	}
+	file := filepath.Join(dir, name)
+
+	out, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return "", err
+	}
+	return file, out.Close()
+}
+
+// push uploads the chart archive in file, under dir if set.
+func (c *client) push(file, dir string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	target := "/api/charts"
+	if dir != "" {
+		target += "?" + url.Values{"dir": {dir}}.Encode()
+	}
+
+	resp, err := c.do(http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var pushed map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&pushed); err != nil {
+		return nil, fmt.Errorf("invalid push result: %w", err)
+	}
+	return pushed, nil
+}
+
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+func newRootCommand() *cobra.Command {
+	c := &client{http: &http.Client{Timeout: 5 * time.Minute}}
+	cmd := &cobra.Command{
+		Use:          "proxyctl",
+		Short:        "List, download and upload charts through gcp-oci-proxy",
+		SilenceUsage: true,
+	}
+	cmd.PersistentFlags().StringVar(&c.server, "server", envOr("PROXYCTL_SERVER", "http://localhost:8080"), "URL of the proxy [PROXYCTL_SERVER]")
+	cmd.PersistentFlags().StringVar(&c.token, "token", envOr("PROXYCTL_TOKEN", ""), "bearer token, the admin token for push or an access token for the IAM check [PROXYCTL_TOKEN]")
+
+	var output string
+	list := &cobra.Command{
+		Use:   "list [FILTER]",
+		Short: "List the charts whose name contains FILTER",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter := ""
+			if len(args) > 0 {
+				filter = args[0]
+			}
+			assets, err := c.list(filter)
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				return json.NewEncoder(cmd.OutOrStdout()).Encode(assets)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSIONS\tDIGEST\tSIZE\tUPDATED")
+			for _, a := range assets {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", a.Name, strings.Join(a.Tags, ","), a.SHA, a.Size, a.Updated.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+	list.Flags().StringVarP(&output, "output", "o", "table", "table or json")
+
+	var dir string
+	get := &cobra.Command{
+		Use:   "get CHART[:VERSION|@DIGEST]",
+		Short: "Download a chart archive, the latest version if none is given",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file, err := c.get(args[0], dir)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), file)
+			return nil
+		},
+	}
+	get.Flags().StringVarP(&dir, "output", "o", ".", "directory to save the archive in")
+
+	var pushDir string
+	push := &cobra.Command{
+		Use:   "push CHART.tgz",
+		Short: "Upload a chart archive, with the admin token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pushed, err := c.push(args[0], pushDir)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "pushed %s:%s %s\n", pushed["name"], pushed["version"], pushed["digest"])
+			return nil
+		},
+	}
+	push.Flags().StringVar(&pushDir, "dir", "", "directory to store the chart under, e.g. team")
+
+	cmd.AddCommand(list, get, push)
+	return cmd
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
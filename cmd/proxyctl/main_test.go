@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadPath(t *testing.T) {
+	tests := []struct {
+		reference, want string
+		err             bool
+	}{
+		{"nginx:1.2.3", "/nginx:1.2.3", false},
+		{"nginx@sha256:abc", "/nginx@sha256:abc", false},
+		{"nginx", "/nginx/latest", false},
+		{"team/app:1.0.0", "/team/app:1.0.0", false},
+		{"team/app", "/team/app/latest", false},
+		{"", "", true},
+		{"/nginx", "", true},
+	}
+	for _, tt := range tests {
+		got, err := downloadPath(tt.reference)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("downloadPath(%q) = %q, %v, want %q", tt.reference, got, err, tt.want)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/search" && r.URL.Query().Get("offset") == "0":
+			io.WriteString(w, `{"total":2,"results":[{"name":"nginx","sha":"sha256:1","tags":["1.2.3"]}]}`)
+		case r.URL.Path == "/api/search":
+			io.WriteString(w, `{"total":2,"results":[{"name":"redis","sha":"sha256:2","tags":["7.0.0"]}]}`)
+		case r.URL.Path == "/nginx:1.2.3":
+			w.Header().Set("Content-Disposition", "attachment; filename=nginx-1.2.3.tgz")
+			io.WriteString(w, "archive")
+		case r.URL.Path == "/api/charts" && r.Header.Get("Authorization") == "Bearer admin":
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"name":"team/nginx","version":"1.2.3","digest":"sha256:1"}`)
+		default:
+			http.Error(w, "nope", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	c := &client{server: server.URL, token: "admin", http: server.Client()}
+
+	assets, err := c.list("")
+	if err != nil || len(assets) != 2 || assets[1].Name != "redis" {
+		t.Errorf("list() = %v, %v, want nginx and redis", assets, err)
+	}
+
+	dir := t.TempDir()
+	file, err := c.get("nginx:1.2.3", dir)
+	if data, _ := os.ReadFile(file); err != nil || file != filepath.Join(dir, "nginx-1.2.3.tgz") || string(data) != "archive" {
+		t.Errorf("get(nginx:1.2.3) = %s, %v, want the archive in nginx-1.2.3.tgz", file, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "chart.tgz"), []byte("chart"), 0o644)
+	pushed, err := c.push(filepath.Join(dir, "chart.tgz"), "team")
+	if err != nil || pushed["name"] != "team/nginx" {
+		t.Errorf("push() = %v, %v, want team/nginx", pushed, err)
+	}
+
+	c.token = ""
+	if _, err := c.push(filepath.Join(dir, "chart.tgz"), ""); err == nil {
+		t.Errorf("push() without the admin token succeeded")
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// healthAlpha weighs the latest pull in the error rate and latency
+	// averages of a backend.
+	healthAlpha = 0.3
+	// unhealthyErrorRate is the error rate above which a backend is only
+	// used when no healthier mirror holds the chart.
+	unhealthyErrorRate = 0.5
+	// probeInterval is how often an unhealthy backend gets a trial pull, so
+	// it can prove it recovered and take its traffic back.
+	probeInterval = 30 * time.Second
+)
+
+// The values of --route-collisions.
+const (
+	matchCollisions    = "match"
+	priorityCollisions = "priority"
+)
+
+// compositeBackend serves the catalog of several backends as one. Every
+// chart name belongs to the first route whose pattern matches it, or with
+// priority collisions to the first route holding it, so the merged catalog
+// never holds the same name from two backends. Routes may prefix the names
+// of their charts to keep them apart instead. Mirror routes add alternate
+// sources for the same digests, and pulls go to the healthiest source
+// holding them.
+type compositeBackend struct {
+	routes     []*routedBackend
+	collisions string
+}
+
+type routedBackend struct {
+	Route
+	backend Backend
+
+	mu          sync.Mutex
+	status      backendStatus
+	lastAttempt time.Time
+}
+
+// assetSource is a backend holding a copy of an asset, and the name of the
+// chart there, which lacks the prefix of the route.
+type assetSource struct {
+	route *routedBackend
+	name  string
+	uri   string
+}
+
+// backendStatus is the health of one backend as of its last catalog sync
+// and the pulls served from it since.
+type backendStatus struct {
+	Name      string    `json:"name"`
+	Backend   string    `json:"backend"`
+	Match     string    `json:"match"`
+	Prefix    string    `json:"prefix,omitempty"`
+	MirrorOf  string    `json:"mirror_of,omitempty"`
+	Healthy   bool      `json:"healthy"`
+	Assets    int       `json:"assets"`
+	Skipped   int       `json:"skipped"`
+	LastSync  time.Time `json:"last_sync"`
+	Error     string    `json:"error,omitempty"`
+	ErrorRate float64   `json:"error_rate"`
+	LatencyMS float64   `json:"latency_ms"`
+}
+
+func newCompositeBackend(ctx context.Context, config *Config) (*compositeBackend, error) {
+	c := &compositeBackend{collisions: config.RouteCollisions}
+	for _, route := range config.Routes {
+		backend, err := newBackend(ctx, route.Config)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("route %s: %w", route.Name, err)
+		}
+
+		c.routes = append(c.routes, &routedBackend{
+			Route:   route,
+			backend: backend,
+			status: backendStatus{
+				Name:     route.Name,
+				Backend:  backend.Name(),
+				Match:    route.Match,
+				Prefix:   route.Prefix,
+				MirrorOf: route.MirrorOf,
+			},
+		})
+	}
+	return c, nil
+}
+
+func (c *compositeBackend) Name() string {
+	var names []string
+	for _, r := range c.routes {
+		names = append(names, fmt.Sprintf("%s=%s", r.Match, r.backend.Name()))
+	}
+	return strings.Join(names, ", ")
+}
+
+// Host and Credential are never used directly: pullAsset goes through pull,
+// which picks the backend for every asset.
+func (c *compositeBackend) Host() string {
+	return ""
+}
+
+func (c *compositeBackend) Credential(ctx context.Context) (string, string, error) {
+	return "", "", fmt.Errorf("composite backend has no credential of its own")
+}
+
+func (c *compositeBackend) Close() error {
+	var errs []error
+	for _, r := range c.routes {
+		errs = append(errs, r.backend.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// List merges the catalogs of all routes. A failing backend is reported in
+// its status and skipped, so one unavailable registry doesn't take down the
+// charts served by the others; only when every backend fails is an error
+// returned. Entries skipped by the routes are merged into one
+// skippedEntries error.
+func (c *compositeBackend) List(ctx context.Context) ([]*Asset, error) {
+	listed := make([][]*Asset, len(c.routes))
+	var errs []error
+	var skipped skippedEntries
+	for i, r := range c.routes {
+		done := syncTraceFrom(ctx).phase("route " + r.Name)
+		assets, err := r.backend.List(ctx)
+		done()
+
+		var routeSkipped skippedEntries
+		if errors.As(err, &routeSkipped) {
+			for _, entry := range routeSkipped {
+				entry.Route = r.Name
+				skipped = append(skipped, entry)
+			}
+			err = nil
+		}
+
+		r.mu.Lock()
+		r.status.LastSync = time.Now()
+		r.status.Error = ""
+		if err != nil {
+			r.status.Error = err.Error()
+		} else {
+			r.status.Assets = len(assets)
+		}
+		r.status.Skipped = len(routeSkipped)
+		r.mu.Unlock()
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", r.Name, err))
+			continue
+		}
+		listed[i] = assets
+	}
+
+	if len(errs) == len(c.routes) {
+		return nil, errors.Join(errs...)
+	}
+
+	var assets []*Asset
+	byDigest := map[string]*Asset{}
+	owners := map[string]*routedBackend{}
+	for i, r := range c.routes {
+		if r.MirrorOf != "" {
+			continue
+		}
+
+		for _, asset := range listed[i] {
+			source := &assetSource{route: r, name: asset.Name, uri: asset.URI}
+			name := r.Prefix + asset.Name
+			if c.collisions == priorityCollisions {
+				if ok, _ := path.Match(r.Match, asset.Name); !ok {
+					continue
+				}
+				if owner, ok := owners[name]; ok && owner != r {
+					// A replica of a digest the owning route holds too.
+					if held, ok := byDigest[owner.Name+"\x00"+name+"@"+asset.SHA]; ok {
+						held.sources = append(held.sources, source)
+					}
+					continue
+				}
+				owners[name] = r
+			} else if c.route(name) != r {
+				continue
+			}
+
+			asset.Name = name
+			asset.sources = []*assetSource{source}
+			byDigest[r.Name+"\x00"+name+"@"+asset.SHA] = asset
+			assets = append(assets, asset)
+		}
+	}
+
+	for i, r := range c.routes {
+		if r.MirrorOf == "" {
+			continue
+		}
+
+		prefix := c.primary(r.MirrorOf).Prefix
+		for _, mirrored := range listed[i] {
+			if asset, ok := byDigest[r.MirrorOf+"\x00"+prefix+mirrored.Name+"@"+mirrored.SHA]; ok {
+				asset.sources = append(asset.sources, &assetSource{route: r, name: mirrored.Name, uri: mirrored.URI})
+			}
+		}
+	}
+	return assets, skipped.err()
+}
+
+// route returns the primary route serving the chart called name, or nil if
+// no route matches it. A route only matches names starting with its prefix,
+// and its pattern applies to the rest of the name.
+func (c *compositeBackend) route(name string) *routedBackend {
+	for _, r := range c.routes {
+		if r.MirrorOf != "" || !strings.HasPrefix(name, r.Prefix) {
+			continue
+		}
+
+		if ok, _ := path.Match(r.Match, strings.TrimPrefix(name, r.Prefix)); ok {
+			return r
+		}
+	}
+	return nil
+}
+
+// primary returns the primary route called name.
+func (c *compositeBackend) primary(name string) *routedBackend {
+	for _, r := range c.routes {
+		if r.Name == name && r.MirrorOf == "" {
+			return r
+		}
+	}
+	return nil
+}
+
+// pull fetches asset from its sources in order of preference, failing over
+// to the next source when a pull fails, and records the outcome of every
+// attempt in the health of the backend that served it.
+func (c *compositeBackend) pull(asset *Asset, pull func(backend Backend, uri string) error) error {
+	sources := rankSources(asset.sources, time.Now())
+	if len(sources) == 0 {
+		return fmt.Errorf("no route for chart %s", asset.Name)
+	}
+
+	var errs []error
+	for _, source := range sources {
+		start := time.Now()
+		err := pull(source.route.backend, source.uri)
+		source.route.record(time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("route %s: %w", source.route.Name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// rankSources orders sources by preference: healthy backends in route
+// order, then unhealthy ones by error rate. An unhealthy backend due for a
+// probe goes first, which is how a recovered primary gets its traffic back
+// once its error rate settles below the threshold.
+func rankSources(sources []*assetSource, now time.Time) []*assetSource {
+	type ranked struct {
+		source    *assetSource
+		index     int
+		healthy   bool
+		probe     bool
+		errorRate float64
+	}
+
+	var candidates []ranked
+	for i, source := range sources {
+		r := source.route
+		r.mu.Lock()
+		errorRate := r.status.ErrorRate
+		probe := errorRate >= unhealthyErrorRate && now.Sub(r.lastAttempt) >= probeInterval
+		r.mu.Unlock()
+
+		candidates = append(candidates, ranked{
+			source:    source,
+			index:     i,
+			healthy:   errorRate < unhealthyErrorRate,
+			probe:     probe,
+			errorRate: errorRate,
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.probe != b.probe {
+			return a.probe
+		}
+		if a.healthy != b.healthy {
+			return a.healthy
+		}
+		if !a.healthy && a.errorRate != b.errorRate {
+			return a.errorRate < b.errorRate
+		}
+		return a.index < b.index
+	})
+
+	ranking := make([]*assetSource, len(candidates))
+	for i, candidate := range candidates {
+		ranking[i] = candidate.source
+	}
+	return ranking
+}
+
+// record folds the outcome of a pull into the moving error rate and latency
+// of the backend.
+func (r *routedBackend) record(latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failure := 0.0
+	if err != nil {
+		failure = 1
+	}
+
+	r.lastAttempt = time.Now()
+	r.status.ErrorRate = healthAlpha*failure + (1-healthAlpha)*r.status.ErrorRate
+	r.status.LatencyMS = healthAlpha*float64(latency.Milliseconds()) + (1-healthAlpha)*r.status.LatencyMS
+}
+
+func (c *compositeBackend) statuses() []backendStatus {
+	var statuses []backendStatus
+	for _, r := range c.routes {
+		r.mu.Lock()
+		status := r.status
+		status.Healthy = status.Error == "" && status.ErrorRate < unhealthyErrorRate
+		r.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
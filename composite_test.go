@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompositeCollisions(t *testing.T) {
+	us := &listedBackend{[]*Asset{{Name: "nginx", SHA: "sha256:1"}, {Name: "redis", SHA: "sha256:2"}}}
+	eu := &listedBackend{[]*Asset{{Name: "nginx", SHA: "sha256:1"}, {Name: "postgres", SHA: "sha256:3"}}}
+
+	tests := []struct {
+		collisions string
+		prefix     string
+		want       string
+		sources    int
+	}{
+		{matchCollisions, "", "nginx,redis", 1},
+		{matchCollisions, "eu/", "eu/nginx,eu/postgres,nginx,redis", 1},
+		{priorityCollisions, "", "nginx,postgres,redis", 2},
+	}
+	for _, tt := range tests {
+		c := &compositeBackend{collisions: tt.collisions, routes: []*routedBackend{
+			{Route: Route{Name: "us", Match: "*"}, backend: us},
+			{Route: Route{Name: "eu", Match: "*", Prefix: tt.prefix}, backend: eu},
+		}}
+		assets, err := c.List(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, asset := range assets {
+			names = append(names, asset.Name)
+			if asset.Name == "nginx" && len(asset.sources) != tt.sources {
+				t.Errorf("%s %q: nginx has %d sources, want %d", tt.collisions, tt.prefix, len(asset.sources), tt.sources)
+			}
+			if asset.Name == "eu/nginx" && asset.sources[0].name != "nginx" {
+				t.Errorf("%s %q: eu/nginx is stored as %s, want nginx", tt.collisions, tt.prefix, asset.sources[0].name)
+			}
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("%s %q: List() = %s, want %s", tt.collisions, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// testRegistry is a fake OCI registry holding one chart version, 1.0.0, per
+// repository, which answers 503 while down.
+type testRegistry struct {
+	*httptest.Server
+	down     atomic.Bool
+	requests atomic.Int32
+}
+
+func newTestRegistry(t *testing.T, charts map[string]string) *testRegistry {
+	registry := &testRegistry{}
+	registry.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry.requests.Add(1)
+		if registry.down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		repository, reference, manifest := strings.Cut(path, "/manifests/")
+		switch {
+		case path == "_catalog":
+			var names []string
+			for name := range charts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			json.NewEncoder(w).Encode(map[string][]string{"repositories": names})
+			return
+		case strings.HasSuffix(path, "/tags/list") && charts[strings.TrimSuffix(path, "/tags/list")] != "":
+			fmt.Fprint(w, `{"tags":["1.0.0"]}`)
+			return
+		case manifest && charts[repository] != "" && (reference == "1.0.0" || reference == charts[repository]):
+			w.Header().Set("Docker-Content-Digest", charts[repository])
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(registry.Close)
+
+	previous := upstreamClient
+	upstreamClient = registry.Client()
+	t.Cleanup(func() { upstreamClient = previous })
+	return registry
+}
+
+func (r *testRegistry) config() *Config {
+	return &Config{Backend: "oci", OCIRegistry: r.Listener.Addr().String(), Anonymous: true}
+}
+
+func TestCompositeRouting(t *testing.T) {
+	digest := func(c string) string { return "sha256:" + strings.Repeat(c, 64) }
+	a := newTestRegistry(t, map[string]string{"nginx": digest("1"), "team/app": digest("2")})
+	b := newTestRegistry(t, map[string]string{"redis": digest("3"), "team/app": digest("4")})
+
+	tests := []struct {
+		name   string
+		routes []Route
+		down   bool
+		want   []string
+		err    bool
+	}{
+		{
+			name:   "first matching route",
+			routes: []Route{{Name: "team", Match: "team/*", Config: b.config()}, {Name: "default", Match: "*", Config: a.config()}},
+			want:   []string{"nginx@" + digest("1") + " default", "team/app@" + digest("4") + " team"},
+		},
+		{
+			name:   "patterns apply past the prefix",
+			routes: []Route{{Name: "default", Match: "*", Config: a.config()}, {Name: "b", Match: "*", Prefix: "b/", Config: b.config()}},
+			want:   []string{"b/redis@" + digest("3") + " b", "nginx@" + digest("1") + " default"},
+		},
+		{
+			name:   "a failing route leaves the others",
+			routes: []Route{{Name: "team", Match: "team/*", Config: b.config()}, {Name: "default", Match: "*", Config: a.config()}},
+			down:   true,
+			want:   []string{"nginx@" + digest("1") + " default"},
+		},
+		{
+			name:   "every route failing",
+			routes: []Route{{Name: "b", Match: "*", Config: b.config()}},
+			down:   true,
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.down.Store(tt.down)
+			backend, err := newBackend(context.Background(), &Config{Routes: tt.routes, RouteCollisions: matchCollisions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := backend.(*compositeBackend)
+			assets, err := c.List(context.Background())
+			if (err != nil) != tt.err {
+				t.Fatalf("List() = %v, want error %v", err, tt.err)
+			}
+
+			var got []string
+			for _, asset := range assets {
+				got = append(got, asset.Name+"@"+asset.SHA+" "+c.route(asset.Name).Name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("List() = %q, want %q", got, tt.want)
+			}
+			for _, status := range c.statuses() {
+				if failed := status.Error != ""; failed != (tt.down && status.Name != "default") {
+					t.Errorf("route %s status error %q", status.Name, status.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestCompositeFailback(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("1", 64)
+	primary := newTestRegistry(t, map[string]string{"nginx": digest})
+	mirror := newTestRegistry(t, map[string]string{"nginx": digest})
+	backend, err := newBackend(context.Background(), &Config{Routes: []Route{
+		{Name: "primary", Match: "*", Config: primary.config()},
+		{Name: "mirror", Match: "*", MirrorOf: "primary", Config: mirror.config()},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := backend.(*compositeBackend)
+	assets, err := c.List(context.Background())
+	if err != nil || len(assets) != 1 || len(assets[0].sources) != 2 {
+		t.Fatalf("List() = %v, %v, want nginx from both registries", assets, err)
+	}
+
+	// Pulls fetch the manifest from the registry of the source.
+	pull := func(backend Backend, uri string) error {
+		name, sha, _ := strings.Cut(uri, "@")
+		host, repository, _ := strings.Cut(name, "/")
+		resp, err := upstreamClient.Get(fmt.Sprintf("https://%s/v2/%s/manifests/%s", host, repository, sha))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", uri, resp.Status)
+		}
+		return nil
+	}
+
+	steps := []struct {
+		name     string
+		down     bool
+		probeDue bool
+		tried    bool
+		servedBy string
+		healthy  bool
+	}{
+		{"healthy primary", false, false, true, "primary", true},
+		{"first failure fails over", true, false, true, "mirror", true},
+		{"second failure marks it unhealthy", true, false, true, "mirror", false},
+		{"unhealthy primary is skipped", true, false, false, "mirror", false},
+		{"probe while still down", true, true, true, "mirror", false},
+		{"probe after recovery", false, true, true, "primary", true},
+		{"recovered primary takes traffic back", false, false, true, "primary", true},
+	}
+	for _, step := range steps {
+		primary.down.Store(step.down)
+		if step.probeDue {
+			c.routes[0].mu.Lock()
+			c.routes[0].lastAttempt = time.Now().Add(-probeInterval)
+			c.routes[0].mu.Unlock()
+		}
+		primaryRequests, mirrorRequests := primary.requests.Load(), mirror.requests.Load()
+
+		if err := c.pull(assets[0], pull); err != nil {
+			t.Fatalf("%s: pull() = %v", step.name, err)
+		}
+		tried := primary.requests.Load() > primaryRequests
+		servedBy := "primary"
+		if mirror.requests.Load() > mirrorRequests {
+			servedBy = "mirror"
+		}
+		healthy := c.statuses()[0].Healthy
+		if tried != step.tried || servedBy != step.servedBy || healthy != step.healthy {
+			t.Errorf("%s: primary tried %v, served by %s, primary healthy %v, want %v, %s, %v", step.name, tried, servedBy, healthy, step.tried, step.servedBy, step.healthy)
+		}
+	}
+}
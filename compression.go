@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipMagic starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// isGzip reports whether a chart layer is gzip compressed, as `helm
+// package` produces, rather than a plain tar some tools push.
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// chartContentType returns the Content-Type a chart layer is served with.
+// Layers are sent as stored, never with a Content-Encoding, so clients save
+// them byte for byte and the digest still matches.
+func chartContentType(data []byte) string {
+	if isGzip(data) {
+		return "application/gzip"
+	}
+	return "application/x-tar"
+}
+
+// compressMinBytes is the smallest response body worth compressing.
+const compressMinBytes = 1024
+
+// encoders are the content codings responses can be compressed with, by
+// preference.
+var encoders = []struct {
+	coding string
+	pool   *sync.Pool
+}{
+	{"gzip", &sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}},
+	{"deflate", &sync.Pool{New: func() interface{} { return zlib.NewWriter(io.Discard) }}},
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compressible reports whether responses of contentType are worth
+// compressing: JSON, YAML and text, but not event streams, which have to
+// reach clients as they are written, nor chart archives, already
+// compressed.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/json", strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/yaml", mediaType == "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+// compressResponses compresses the index, JSON and text responses of
+// clients accepting gzip or deflate. Chart archives are left alone, see
+// chartContentType.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, coding := range acceptedMediaTypes(r.Header.Get("Accept-Encoding")) {
+			for _, encoder := range encoders {
+				if coding == encoder.coding || coding == "*" {
+					cw := &compressWriter{ResponseWriter: w, header: w.Header().Clone(), coding: encoder.coding, pool: encoder.pool, status: http.StatusOK}
+					defer cw.close()
+					next.ServeHTTP(cw, r)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// compressWriter holds back the response until compressMinBytes are
+// written, then decides whether to compress it. Handlers see their own
+// header map, which only reaches the client once the decision is made, so
+// middlewares capturing responses never see the content coding.
+type compressWriter struct {
+	http.ResponseWriter
+	header  http.Header
+	coding  string
+	pool    *sync.Pool
+	status  int
+	buf     []byte
+	decided bool
+	encoder resetWriter
+}
+
+func (w *compressWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || status < http.StatusOK {
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide()
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < compressMinBytes {
+			return len(p), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide sends the headers, compressing the response when its type and
+// size are worth it, then what was held back.
+func (w *compressWriter) decide() error {
+	w.decided = true
+	header := w.ResponseWriter.Header()
+	for name := range header {
+		delete(header, name)
+	}
+	for name, values := range w.header {
+		header[name] = values
+	}
+
+	if compressible(header.Get("Content-Type")) {
+		header.Add("Vary", "Accept-Encoding")
+		if len(w.buf) >= compressMinBytes && w.status == http.StatusOK && header.Get("Content-Encoding") == "" {
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", w.coding)
+			// The compressed body differs byte for byte.
+			if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				header.Set("ETag", "W/"+etag)
+			}
+			w.encoder = w.pool.Get().(resetWriter)
+			w.encoder.Reset(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush keeps server-sent events streaming.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		w.pool.Put(w.encoder)
+	}
+}
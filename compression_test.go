@@ -0,0 +1,73 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressResponses(t *testing.T) {
+	index := strings.Repeat("apiVersion: v2\n", 100)
+	handler := compressResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.yaml":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("ETag", `"abc"`)
+			io.WriteString(w, index)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, "{}")
+		case "/nginx:1.2.3":
+			w.Header().Set("Content-Type", "application/gzip")
+			io.WriteString(w, index)
+		}
+	}))
+
+	tests := []struct {
+		path     string
+		accept   string
+		encoding string
+	}{
+		{"/index.yaml", "gzip, deflate", "gzip"},
+		{"/index.yaml", "deflate", "deflate"},
+		{"/index.yaml", "br;q=1, gzip;q=0.5", "gzip"},
+		{"/index.yaml", "gzip;q=0", ""},
+		{"/index.yaml", "", ""},
+		{"/small", "gzip", ""},
+		{"/nginx:1.2.3", "gzip", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("GET %s accepting %q encoding = %q, want %q", tt.path, tt.accept, got, tt.encoding)
+			continue
+		}
+
+		var body io.Reader = w.Body
+		switch tt.encoding {
+		case "gzip":
+			body, _ = gzip.NewReader(w.Body)
+		case "deflate":
+			body, _ = zlib.NewReader(w.Body)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("GET %s accepting %q: %v", tt.path, tt.accept, err)
+		}
+		if tt.path == "/index.yaml" && string(data) != index {
+			t.Errorf("GET %s accepting %q body = %q, want the index", tt.path, tt.accept, data)
+		}
+		if etag := w.Header().Get("ETag"); tt.path == "/index.yaml" && tt.encoding != "" && etag != `W/"abc"` {
+			t.Errorf("GET %s accepting %q ETag = %s, want it weak", tt.path, tt.accept, etag)
+		}
+	}
+}
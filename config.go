@@ -0,0 +1,1059 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+type Config struct {
+	Backend    string
+	Project    string
+	Repository string
+	Region     string
+	GCRHost    string
+	Port       string
+	BindHost   string
+	GRPCPort   string
+	Listen     string
+	Credential string
+
+	ExternalURL string
+
+	IAPAudience string
+
+	AllowCIDRs     []string
+	DenyCIDRs      []string
+	TrustedProxies []string
+	ProxyProtocol  bool
+
+	TLSCertFile string
+	TLSKeyFile  string
+	H2C         bool
+
+	CredentialSecret  string
+	CredentialJSON    string
+	CredentialRefresh time.Duration
+
+	Anonymous bool
+
+	ImpersonateServiceAccount string
+
+	DesiredState         string
+	DesiredStateInterval time.Duration
+	AttestationKMSKey    string
+
+	Redirect bool
+
+	ManifestScanner        string
+	ManifestScannerTimeout time.Duration
+
+	NotFoundSuggestions int
+
+	DisplayTimezone string
+
+	MaxArtifactBytes   int64
+	OversizedArtifacts string
+
+	ReadOnly bool
+
+	SecurityHeaders bool
+	HSTSMaxAge      time.Duration
+
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+	CORSMaxAge  time.Duration
+
+	AdminToken string
+
+	SignedURLKey string
+	SignedURLTTL time.Duration
+
+	TransportMaxIdleConnsPerHost int
+	TransportTLSHandshakeTimeout time.Duration
+	TransportHTTP2PingInterval   time.Duration
+	TransportHTTP2PingTimeout    time.Duration
+
+	PolicyURL     string
+	PolicyTimeout time.Duration
+
+	IAMCheck         bool
+	IAMCheckAudience string
+	IAMCheckTTL      time.Duration
+
+	AuditSinks []string
+
+	Webhooks           []string
+	WebhookSecret      string
+	WebhookRetries     int
+	WebhookConcurrency int
+	WebhookBatchSize   int
+	WebhookBatchWait   time.Duration
+
+	LeaderElection          bool
+	LeaderElectionNamespace string
+	LeaderElectionLease     string
+
+	SessionTTL time.Duration
+
+	IdempotencyTTL time.Duration
+
+	Upstream         string
+	UpstreamUsername string
+	UpstreamPassword string
+	UpstreamTagTTL   time.Duration
+
+	LazyCatalog    bool
+	LazyCatalogTTL time.Duration
+
+	SyncInterval     time.Duration
+	FullSyncInterval time.Duration
+
+	SyncPageSize       int
+	SyncPageRetries    int
+	CatalogMemoryBytes int64
+	CatalogPath        string
+	CatalogSnapshot    string
+
+	CacheMemoryBytes int64
+	CacheDir         string
+	CacheMaxBytes    int64
+	CacheTTL         time.Duration
+	CacheBucket      string
+	Prewarm          []string
+	PrewarmTimeout   time.Duration
+	ServeStaleAfter  time.Duration
+
+	MaxConcurrentPulls     int
+	DownloadBytesPerSecond int64
+
+	MaintenanceWindows string
+
+	RetentionKeepLast       int
+	RetentionUntaggedMaxAge time.Duration
+	RetentionInterval       time.Duration
+
+	CostStoragePerGBMonth float64
+	CostEgressPerGB       float64
+
+	AWSRegion    string
+	AWSAccountID string
+
+	ACRRegistry string
+	ACRUsername string
+	ACRPassword string
+
+	OCIRegistry     string
+	OCIRepositories []string
+	OCIUsername     string
+	OCIPassword     string
+	OCIToken        string
+
+	RouteCollisions string
+
+	// Routes splits the catalog across several backends, each configured
+	// with its own settings. Only available through the config file.
+	Routes []Route
+
+	// Headers are added to responses by route group. Only available
+	// through the config file.
+	Headers []HeaderRule
+
+	// Profiles are environment defaults packaged into downloaded charts on
+	// request. Only available through the config file.
+	Profiles []Profile
+}
+
+// Route sends chart names matching Match, a path.Match pattern, to the
+// backend described by Config, serving them with Prefix prepended. A route
+// with MirrorOf set instead provides alternate sources for the charts of
+// the named route.
+type Route struct {
+	Name     string
+	Match    string
+	Prefix   string
+	MirrorOf string
+	Config   *Config
+}
+
+// configEnv maps every serve flag to the environment variable it falls back to.
+var configEnv = map[string]string{
+	"backend":    "BACKEND",
+	"project":    "PROJECT",
+	"repository": "REPOSITORY",
+	"region":     "REGION",
+	"gcr-host":   "GCR_HOST",
+	"port":       "PORT",
+	"bind-host":  "BIND_HOST",
+	"grpc-port":  "GRPC_PORT",
+	"listen":     "LISTEN",
+	"credential": "GOOGLE_APPLICATION_CREDENTIALS",
+
+	"external-url": "EXTERNAL_URL",
+
+	"iap-audience": "IAP_AUDIENCE",
+
+	"allow-cidrs":     "ALLOW_CIDRS",
+	"deny-cidrs":      "DENY_CIDRS",
+	"trusted-proxies": "TRUSTED_PROXIES",
+	"proxy-protocol":  "PROXY_PROTOCOL",
+
+	"tls-cert-file": "TLS_CERT_FILE",
+	"tls-key-file":  "TLS_KEY_FILE",
+	"h2c":           "H2C",
+
+	"credential-secret":  "CREDENTIAL_SECRET",
+	"credential-json":    "GOOGLE_CREDENTIALS_JSON",
+	"credential-refresh": "CREDENTIAL_REFRESH",
+
+	"anonymous": "ANONYMOUS",
+
+	"impersonate-service-account": "IMPERSONATE_SERVICE_ACCOUNT",
+
+	"desired-state":          "DESIRED_STATE",
+	"desired-state-interval": "DESIRED_STATE_INTERVAL",
+	"attestation-kms-key":    "ATTESTATION_KMS_KEY",
+
+	"redirect": "REDIRECT",
+
+	"manifest-scanner":         "MANIFEST_SCANNER",
+	"manifest-scanner-timeout": "MANIFEST_SCANNER_TIMEOUT",
+
+	"not-found-suggestions": "NOT_FOUND_SUGGESTIONS",
+
+	"display-timezone": "DISPLAY_TIMEZONE",
+
+	"max-artifact-bytes":  "MAX_ARTIFACT_BYTES",
+	"oversized-artifacts": "OVERSIZED_ARTIFACTS",
+
+	"read-only": "READ_ONLY",
+
+	"security-headers": "SECURITY_HEADERS",
+	"hsts-max-age":     "HSTS_MAX_AGE",
+
+	"cors-origins": "CORS_ORIGINS",
+	"cors-methods": "CORS_METHODS",
+	"cors-headers": "CORS_HEADERS",
+	"cors-max-age": "CORS_MAX_AGE",
+
+	"admin-token": "ADMIN_TOKEN",
+
+	"signed-url-key": "SIGNED_URL_KEY",
+	"signed-url-ttl": "SIGNED_URL_TTL",
+
+	"webhooks":            "WEBHOOKS",
+	"webhook-secret":      "WEBHOOK_SECRET",
+	"webhook-retries":     "WEBHOOK_RETRIES",
+	"webhook-concurrency": "WEBHOOK_CONCURRENCY",
+	"webhook-batch-size":  "WEBHOOK_BATCH_SIZE",
+	"webhook-batch-wait":  "WEBHOOK_BATCH_WAIT",
+
+	"leader-election":           "LEADER_ELECTION",
+	"leader-election-namespace": "LEADER_ELECTION_NAMESPACE",
+	"leader-election-lease":     "LEADER_ELECTION_LEASE",
+
+	"transport-max-idle-conns-per-host": "TRANSPORT_MAX_IDLE_CONNS_PER_HOST",
+	"transport-tls-handshake-timeout":   "TRANSPORT_TLS_HANDSHAKE_TIMEOUT",
+	"transport-http2-ping-interval":     "TRANSPORT_HTTP2_PING_INTERVAL",
+	"transport-http2-ping-timeout":      "TRANSPORT_HTTP2_PING_TIMEOUT",
+
+	"policy-url":     "POLICY_URL",
+	"policy-timeout": "POLICY_TIMEOUT",
+
+	"iam-check":          "IAM_CHECK",
+	"iam-check-audience": "IAM_CHECK_AUDIENCE",
+	"iam-check-ttl":      "IAM_CHECK_TTL",
+
+	"audit-sinks": "AUDIT_SINKS",
+
+	"session-ttl": "SESSION_TTL",
+
+	"idempotency-ttl": "IDEMPOTENCY_TTL",
+
+	"upstream":          "UPSTREAM",
+	"upstream-username": "UPSTREAM_USERNAME",
+	"upstream-password": "UPSTREAM_PASSWORD",
+	"upstream-tag-ttl":  "UPSTREAM_TAG_TTL",
+
+	"lazy-catalog":     "LAZY_CATALOG",
+	"lazy-catalog-ttl": "LAZY_CATALOG_TTL",
+
+	"sync-interval":      "SYNC_INTERVAL",
+	"full-sync-interval": "FULL_SYNC_INTERVAL",
+
+	"sync-page-size":       "SYNC_PAGE_SIZE",
+	"sync-page-retries":    "SYNC_PAGE_RETRIES",
+	"catalog-memory-bytes": "CATALOG_MEMORY_BYTES",
+	"catalog-path":         "CATALOG_PATH",
+	"catalog-snapshot":     "CATALOG_SNAPSHOT",
+
+	"cache-memory-bytes": "CACHE_MEMORY_BYTES",
+	"cache-dir":          "CACHE_DIR",
+	"cache-max-bytes":    "CACHE_MAX_BYTES",
+	"cache-ttl":          "CACHE_TTL",
+	"cache-bucket":       "CACHE_BUCKET",
+	"prewarm":            "PREWARM",
+	"prewarm-timeout":    "PREWARM_TIMEOUT",
+	"serve-stale-after":  "SERVE_STALE_AFTER",
+
+	"max-concurrent-pulls":      "MAX_CONCURRENT_PULLS",
+	"download-bytes-per-second": "DOWNLOAD_BYTES_PER_SECOND",
+
+	"maintenance-windows": "MAINTENANCE_WINDOWS",
+
+	"retention-keep-last":        "RETENTION_KEEP_LAST",
+	"retention-untagged-max-age": "RETENTION_UNTAGGED_MAX_AGE",
+	"retention-interval":         "RETENTION_INTERVAL",
+
+	"cost-storage-per-gb-month": "COST_STORAGE_PER_GB_MONTH",
+	"cost-egress-per-gb":        "COST_EGRESS_PER_GB",
+
+	"aws-region":     "AWS_REGION",
+	"aws-account-id": "AWS_ACCOUNT_ID",
+
+	"acr-registry": "ACR_REGISTRY",
+	"acr-username": "ACR_USERNAME",
+	"acr-password": "ACR_PASSWORD",
+
+	"oci-registry":     "OCI_REGISTRY",
+	"oci-repositories": "OCI_REPOSITORIES",
+	"oci-username":     "OCI_USERNAME",
+	"oci-password":     "OCI_PASSWORD",
+	"oci-token":        "OCI_TOKEN",
+
+	"route-collisions": "ROUTE_COLLISIONS",
+}
+
+func bindFlags(flags *pflag.FlagSet, config *Config) {
+	flags.StringVar(&config.Backend, "backend", "gar", "registry backend: gar (Artifact Registry), gcr (Container Registry), ecr, acr, ghcr, harbor or oci (any distribution registry) [BACKEND]")
+	flags.StringVar(&config.Project, "project", "", "GCP project hosting the repository [PROJECT]")
+	flags.StringVar(&config.Repository, "repository", "", "Artifact Registry repository, or repository prefix for the other backends [REPOSITORY]")
+	flags.StringVar(&config.Region, "region", "us-central1", "Artifact Registry location, regional (us-central1) or multi-regional (us, europe, asia) [REGION]")
+	flags.StringVar(&config.GCRHost, "gcr-host", "gcr.io", "Container Registry host for the gcr backend [GCR_HOST]")
+	flags.StringVar(&config.Port, "port", ":8080", "port or address to listen on, e.g. 8080, :8080 or 0.0.0.0:8080 [PORT]")
+	flags.StringVar(&config.BindHost, "bind-host", "", "host to listen on when --port has none, e.g. 127.0.0.1; empty listens on every interface [BIND_HOST]")
+	flags.StringVar(&config.GRPCPort, "grpc-port", "", "port or address the gRPC API listens on, like --port; empty disables it [GRPC_PORT]")
+	flags.StringVar(&config.Listen, "listen", "", "comma-separated bind addresses overriding --port, e.g. tcp4://0.0.0.0:8080,tcp6://[::]:8080 [LISTEN]")
+	flags.StringVar(&config.Credential, "credential", "", "path to the service account JSON key [GOOGLE_APPLICATION_CREDENTIALS]")
+	flags.StringVar(&config.ExternalURL, "external-url", "", "URL clients reach the proxy at, used in generated client configs; empty derives it from each request [EXTERNAL_URL]")
+	flags.StringVar(&config.IAPAudience, "iap-audience", "", "audience of the IAP assertions every request must carry, e.g. /projects/123/global/backendServices/456; empty trusts the IAP headers unverified [IAP_AUDIENCE]")
+	flags.StringSliceVar(&config.AllowCIDRs, "allow-cidrs", nil, "client ranges allowed to reach the proxy, e.g. 10.0.0.0/8,192.168.1.7; empty allows every client not denied [ALLOW_CIDRS]")
+	flags.StringSliceVar(&config.DenyCIDRs, "deny-cidrs", nil, "client ranges refused, even when allowed [DENY_CIDRS]")
+	flags.StringSliceVar(&config.TrustedProxies, "trusted-proxies", nil, "ranges of the proxies and load balancers whose X-Forwarded-For is trusted to identify clients [TRUSTED_PROXIES]")
+	flags.BoolVar(&config.ProxyProtocol, "proxy-protocol", false, "read client addresses from the PROXY protocol header the L4 load balancers of --trusted-proxies must send [PROXY_PROTOCOL]")
+	flags.StringVar(&config.TLSCertFile, "tls-cert-file", "", "PEM certificate chain to serve HTTPS and HTTP/2 with, read at startup [TLS_CERT_FILE]")
+	flags.StringVar(&config.TLSKeyFile, "tls-key-file", "", "PEM private key of --tls-cert-file [TLS_KEY_FILE]")
+	flags.BoolVar(&config.H2C, "h2c", false, "serve HTTP/2 over plaintext to load balancers speaking it, only to --trusted-proxies when set [H2C]")
+	flags.StringVar(&config.CredentialSecret, "credential-secret", "", "Secret Manager version holding the JSON key, e.g. projects/x/secrets/y/versions/latest [CREDENTIAL_SECRET]")
+	flags.StringVar(&config.CredentialJSON, "credential-json", "", "service account JSON key given inline, as JSON or base64-encoded JSON [GOOGLE_CREDENTIALS_JSON]")
+	flags.DurationVar(&config.CredentialRefresh, "credential-refresh", 5*time.Minute, "how often to check the credential file or secret for rotations, 0 to disable [CREDENTIAL_REFRESH]")
+	flags.BoolVar(&config.Anonymous, "anonymous", false, "list and pull from a public gar or gcr repository without any credential [ANONYMOUS]")
+	flags.StringVar(&config.ImpersonateServiceAccount, "impersonate-service-account", "", "service account email to impersonate for Artifact Registry API calls and pulls [IMPERSONATE_SERVICE_ACCOUNT]")
+
+	flags.StringVar(&config.DesiredState, "desired-state", "", "manifest of chart versions to import into the gar repository when missing [DESIRED_STATE]")
+	flags.DurationVar(&config.DesiredStateInterval, "desired-state-interval", 10*time.Minute, "how often to reconcile --desired-state [DESIRED_STATE_INTERVAL]")
+	flags.StringVar(&config.AttestationKMSKey, "attestation-kms-key", "", "Cloud KMS key version signing SLSA provenance attached to imported and pushed charts, e.g. projects/x/locations/y/keyRings/z/cryptoKeys/k/cryptoKeyVersions/1 [ATTESTATION_KMS_KEY]")
+
+	flags.BoolVar(&config.Redirect, "redirect", false, "redirect chart downloads to short-lived upstream URLs instead of proxying them [REDIRECT]")
+	flags.StringVar(&config.ManifestScanner, "manifest-scanner", "", "command the rendered manifests of every chart version are piped to on its first pull, e.g. \"kubeconform -strict -summary -output json\" [MANIFEST_SCANNER]")
+	flags.DurationVar(&config.ManifestScannerTimeout, "manifest-scanner-timeout", time.Minute, "how long --manifest-scanner may run on a chart [MANIFEST_SCANNER_TIMEOUT]")
+	flags.IntVar(&config.NotFoundSuggestions, "not-found-suggestions", 5, "how many similar chart names or versions a 404 suggests, 0 disables suggestions [NOT_FOUND_SUGGESTIONS]")
+
+	flags.StringVar(&config.DisplayTimezone, "display-timezone", "UTC", "IANA timezone user interfaces display timestamps in, e.g. Europe/Berlin; APIs, index.yaml and logs always use UTC [DISPLAY_TIMEZONE]")
+	flags.Int64Var(&config.MaxArtifactBytes, "max-artifact-bytes", 0, "largest artifact served, by its registry size; 0 for no limit [MAX_ARTIFACT_BYTES]")
+	flags.StringVar(&config.OversizedArtifacts, "oversized-artifacts", rejectOversized, "what to do with requests for artifacts over --max-artifact-bytes: reject with 413, or redirect chart downloads upstream [OVERSIZED_ARTIFACTS]")
+	flags.BoolVar(&config.ReadOnly, "read-only", false, "refuse every mutating request and disable desired state sync and destructive operations [READ_ONLY]")
+	flags.BoolVar(&config.SecurityHeaders, "security-headers", false, "set HSTS, nosniff, frame and referrer policies, strip server identification and refuse directory-style paths [SECURITY_HEADERS]")
+	flags.DurationVar(&config.HSTSMaxAge, "hsts-max-age", 365*24*time.Hour, "max-age of the Strict-Transport-Security header of --security-headers, 0 to leave it out [HSTS_MAX_AGE]")
+	flags.StringSliceVar(&config.CORSOrigins, "cors-origins", nil, "origins of the browser UIs allowed to call /api/ across origins, e.g. https://ui.example.com,https://*.example.com or *; empty disables CORS [CORS_ORIGINS]")
+	flags.StringSliceVar(&config.CORSMethods, "cors-methods", []string{"GET", "HEAD", "POST"}, "methods cross-origin calls may use [CORS_METHODS]")
+	flags.StringSliceVar(&config.CORSHeaders, "cors-headers", []string{"Authorization", "Content-Type", "Idempotency-Key"}, "request headers cross-origin calls may send [CORS_HEADERS]")
+	flags.DurationVar(&config.CORSMaxAge, "cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses [CORS_MAX_AGE]")
+
+	flags.StringVar(&config.AdminToken, "admin-token", "", "bearer token required by admin endpoints such as chart deletion, which are disabled without it [ADMIN_TOKEN]")
+	flags.StringVar(&config.SignedURLKey, "signed-url-key", "", "secret signing download urls that need no credentials, which are disabled without it [SIGNED_URL_KEY]")
+	flags.DurationVar(&config.SignedURLTTL, "signed-url-ttl", time.Hour, "longest a signed download url is valid, and the default [SIGNED_URL_TTL]")
+	flags.IntVar(&config.TransportMaxIdleConnsPerHost, "transport-max-idle-conns-per-host", 16, "idle connections kept open to every registry host [TRANSPORT_MAX_IDLE_CONNS_PER_HOST]")
+	flags.DurationVar(&config.TransportTLSHandshakeTimeout, "transport-tls-handshake-timeout", 10*time.Second, "timeout of TLS handshakes with registries [TRANSPORT_TLS_HANDSHAKE_TIMEOUT]")
+	flags.DurationVar(&config.TransportHTTP2PingInterval, "transport-http2-ping-interval", 30*time.Second, "idle time after which HTTP/2 connections to registries are pinged, 0 disables pings [TRANSPORT_HTTP2_PING_INTERVAL]")
+	flags.DurationVar(&config.TransportHTTP2PingTimeout, "transport-http2-ping-timeout", 15*time.Second, "how long an HTTP/2 ping may go unanswered before the connection is closed [TRANSPORT_HTTP2_PING_TIMEOUT]")
+	flags.StringVar(&config.PolicyURL, "policy-url", "", "policy endpoint, such as an OPA data API rule, asked to allow every chart download, e.g. http://localhost:8181/v1/data/charts/allow [POLICY_URL]")
+	flags.DurationVar(&config.PolicyTimeout, "policy-timeout", 2*time.Second, "how long to wait for --policy-url before denying the download [POLICY_TIMEOUT]")
+
+	flags.BoolVar(&config.IAMCheck, "iam-check", false, "only serve a chart to callers holding artifactregistry.repositories.downloadArtifacts on its Artifact Registry repository [IAM_CHECK]")
+	flags.StringVar(&config.IAMCheckAudience, "iam-check-audience", "", "audience ID tokens and IAP assertions are verified against for --iam-check; empty accepts only OAuth access tokens [IAM_CHECK_AUDIENCE]")
+	flags.DurationVar(&config.IAMCheckTTL, "iam-check-ttl", 5*time.Minute, "how long an --iam-check decision is cached per caller and repository [IAM_CHECK_TTL]")
+
+	flags.StringSliceVar(&config.Webhooks, "webhooks", nil, "URLs notified with a JSON POST when a new chart version appears in the catalog [WEBHOOKS]")
+	flags.StringVar(&config.WebhookSecret, "webhook-secret", "", "key signing webhook payloads with HMAC-SHA256 in the X-Hub-Signature-256 header [WEBHOOK_SECRET]")
+	flags.IntVar(&config.WebhookRetries, "webhook-retries", 5, "how many times a failed webhook delivery is retried, with exponential backoff [WEBHOOK_RETRIES]")
+	flags.IntVar(&config.WebhookConcurrency, "webhook-concurrency", 4, "how many webhook deliveries may be in flight at once, read at startup [WEBHOOK_CONCURRENCY]")
+	flags.IntVar(&config.WebhookBatchSize, "webhook-batch-size", 1, "how many events a webhook payload may hold; above 1 payloads are {\"events\": [...]} [WEBHOOK_BATCH_SIZE]")
+	flags.DurationVar(&config.WebhookBatchWait, "webhook-batch-wait", time.Second, "how long a batch waits for more events before it is sent [WEBHOOK_BATCH_WAIT]")
+
+	flags.BoolVar(&config.LeaderElection, "leader-election", false, "elect a leader through a Kubernetes Lease so only one replica syncs the catalog and desired state, enforces retention and sends webhooks, followers loading --catalog-snapshot [LEADER_ELECTION]")
+	flags.StringVar(&config.LeaderElectionNamespace, "leader-election-namespace", "", "namespace of the leader election Lease, defaults to the pod namespace [LEADER_ELECTION_NAMESPACE]")
+	flags.StringVar(&config.LeaderElectionLease, "leader-election-lease", "gcp-oci-proxy", "name of the leader election Lease [LEADER_ELECTION_LEASE]")
+	flags.StringSliceVar(&config.AuditSinks, "audit-sinks", nil, "where to record chart downloads: stdout, file:<path>, cloud-logging:<log id>, bigquery:[project.]dataset.table [AUDIT_SINKS]")
+
+	flags.DurationVar(&config.SessionTTL, "session-ttl", time.Hour, "longest a resolution session pins tags for [SESSION_TTL]")
+
+	flags.DurationVar(&config.IdempotencyTTL, "idempotency-ttl", 24*time.Hour, "how long the response of a mutating request sent with an Idempotency-Key is replayed to retries reaching the same replica; responses are kept in memory and lost on restart [IDEMPOTENCY_TTL]")
+
+	flags.StringVar(&config.Upstream, "upstream", "", "OCI repository charts missing from the catalog are pulled through from, e.g. oci://registry-1.docker.io/bitnamicharts [UPSTREAM]")
+	flags.StringVar(&config.UpstreamUsername, "upstream-username", "", "username for --upstream, anonymous when empty [UPSTREAM_USERNAME]")
+	flags.StringVar(&config.UpstreamPassword, "upstream-password", "", "password or token for --upstream [UPSTREAM_PASSWORD]")
+	flags.DurationVar(&config.UpstreamTagTTL, "upstream-tag-ttl", 5*time.Minute, "how long a tag resolved against --upstream is reused [UPSTREAM_TAG_TTL]")
+
+	flags.BoolVar(&config.LazyCatalog, "lazy-catalog", false, "look charts up in artifact registry as they are requested instead of listing the repository up front [LAZY_CATALOG]")
+	flags.DurationVar(&config.LazyCatalogTTL, "lazy-catalog-ttl", 10*time.Minute, "how long a chart looked up with --lazy-catalog stays in the catalog [LAZY_CATALOG_TTL]")
+	flags.DurationVar(&config.SyncInterval, "sync-interval", 0, "how often the catalog is synced after startup, listing only the images updated since the last sync with artifact registry; 0 disables periodic syncs [SYNC_INTERVAL]")
+	flags.DurationVar(&config.FullSyncInterval, "full-sync-interval", 24*time.Hour, "how often a periodic sync lists the whole repository, to drop deleted images [FULL_SYNC_INTERVAL]")
+	flags.IntVar(&config.SyncPageSize, "sync-page-size", 1000, "images requested per page when listing artifact registry [SYNC_PAGE_SIZE]")
+	flags.IntVar(&config.SyncPageRetries, "sync-page-retries", 3, "how many times a page that failed to list is retried before the sync fails [SYNC_PAGE_RETRIES]")
+	flags.Int64Var(&config.CatalogMemoryBytes, "catalog-memory-bytes", 0, "memory budget of the in-memory catalog; syncs exceeding it are logged, or kept on disk with --catalog-path, 0 for no budget [CATALOG_MEMORY_BYTES]")
+	flags.StringVar(&config.CatalogPath, "catalog-path", "", "directory to keep the catalog on disk in, only when it exceeds --catalog-memory-bytes if set [CATALOG_PATH]")
+	flags.StringVar(&config.CatalogSnapshot, "catalog-snapshot", "", "file or gs://bucket/object the catalog is saved to after every sync and served from at startup while the first sync runs [CATALOG_SNAPSHOT]")
+
+	flags.Int64Var(&config.CacheMemoryBytes, "cache-memory-bytes", 256<<20, "memory budget for pulled charts kept to serve repeated and resumed downloads, 0 to disable [CACHE_MEMORY_BYTES]")
+	flags.StringVar(&config.CacheDir, "cache-dir", "", "directory pulled charts are kept in across restarts, behind the memory cache [CACHE_DIR]")
+	flags.Int64Var(&config.CacheMaxBytes, "cache-max-bytes", 10<<30, "disk budget of --cache-dir, 0 for no limit [CACHE_MAX_BYTES]")
+	flags.DurationVar(&config.CacheTTL, "cache-ttl", 0, "how long charts unused are kept in --cache-dir, 0 to keep them until evicted [CACHE_TTL]")
+	flags.StringVar(&config.CacheBucket, "cache-bucket", "", "gs://bucket or gs://bucket/prefix pulled charts are shared through by replicas, behind the memory cache [CACHE_BUCKET]")
+	flags.StringSliceVar(&config.Prewarm, "prewarm", nil, "charts pulled into the cache before serving, as name patterns with an optional tag, semver constraint or latest, e.g. nginx,team/*:~1.2 [PREWARM]")
+	flags.DurationVar(&config.PrewarmTimeout, "prewarm-timeout", 2*time.Minute, "how long serving waits on --prewarm [PREWARM_TIMEOUT]")
+	flags.IntVar(&config.MaxConcurrentPulls, "max-concurrent-pulls", 0, "how many charts may be pulled from registries at once, others waiting for a slot; 0 for no limit, read at startup [MAX_CONCURRENT_PULLS]")
+	flags.Int64Var(&config.DownloadBytesPerSecond, "download-bytes-per-second", 0, "bandwidth of each chart download through the proxy; 0 for no limit [DOWNLOAD_BYTES_PER_SECOND]")
+	flags.DurationVar(&config.ServeStaleAfter, "serve-stale-after", 0, "serve the cached archive a tag was last downloaded with, marked stale, when pulling the one it points to now fails or takes longer than this; 0 to disable [SERVE_STALE_AFTER]")
+
+	flags.StringVar(&config.MaintenanceWindows, "maintenance-windows", "", "semicolon separated windows for destructive operations, each a cron expression and a duration, e.g. \"0 2 * * SAT 4h\"; empty allows them any time [MAINTENANCE_WINDOWS]")
+
+	flags.IntVar(&config.RetentionKeepLast, "retention-keep-last", 0, "retention: keep only the newest versions of each chart, 0 keeps all [RETENTION_KEEP_LAST]")
+	flags.DurationVar(&config.RetentionUntaggedMaxAge, "retention-untagged-max-age", 0, "retention: remove untagged digests older than this, 0 keeps them [RETENTION_UNTAGGED_MAX_AGE]")
+	flags.DurationVar(&config.RetentionInterval, "retention-interval", 0, "retention: how often the policy is enforced by deleting from artifact registry, 0 only reports the plan [RETENTION_INTERVAL]")
+
+	flags.Float64Var(&config.CostStoragePerGBMonth, "cost-storage-per-gb-month", 0.10, "storage price in USD per GB-month used by /api/v1/costs [COST_STORAGE_PER_GB_MONTH]")
+	flags.Float64Var(&config.CostEgressPerGB, "cost-egress-per-gb", 0.12, "registry egress price in USD per GB used by /api/v1/costs [COST_EGRESS_PER_GB]")
+
+	flags.StringVar(&config.AWSRegion, "aws-region", "", "AWS region of the ecr backend [AWS_REGION]")
+	flags.StringVar(&config.AWSAccountID, "aws-account-id", "", "AWS account owning the ecr registry [AWS_ACCOUNT_ID]")
+
+	flags.StringVar(&config.ACRRegistry, "acr-registry", "", "login server of the acr backend, e.g. example.azurecr.io [ACR_REGISTRY]")
+	flags.StringVar(&config.ACRUsername, "acr-username", "", "service principal ID or admin user for the acr backend [ACR_USERNAME]")
+	flags.StringVar(&config.ACRPassword, "acr-password", "", "service principal secret or admin password for the acr backend [ACR_PASSWORD]")
+
+	flags.StringVar(&config.OCIRegistry, "oci-registry", "", "registry host of the oci and harbor backends, e.g. harbor.example.com [OCI_REGISTRY]")
+	flags.StringSliceVar(&config.OCIRepositories, "oci-repositories", nil, "repositories to serve, for registries without a catalog API [OCI_REPOSITORIES]")
+	flags.StringVar(&config.OCIUsername, "oci-username", "", "username for the oci backend [OCI_USERNAME]")
+	flags.StringVar(&config.OCIPassword, "oci-password", "", "password for the oci backend [OCI_PASSWORD]")
+	flags.StringVar(&config.OCIToken, "oci-token", "", "bearer token for the oci backend, instead of a password [OCI_TOKEN]")
+
+	flags.StringVar(&config.RouteCollisions, "route-collisions", matchCollisions, "which route serves a chart name more than one route matches: match (the first route whose pattern matches) or priority (the first route holding the chart, later ones holding the same digest becoming alternate sources) [ROUTE_COLLISIONS]")
+}
+
+// resolveConfig fills every flag that wasn't given on the command line from
+// its environment variable, then from the config file at path, then from its
+// default, and returns a copy of the result. It can be called again to pick
+// up changes to the environment or the file.
+func resolveConfig(flags *pflag.FlagSet, bound *Config, path string) (*Config, error) {
+	file := map[string]interface{}{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	var errs []error
+	for key := range file {
+		if _, ok := configEnv[key]; !ok && key != "routes" && key != "headers" && key != "profiles" {
+			errs = append(errs, fmt.Errorf("unknown setting %q in config file %s", key, path))
+		}
+	}
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		env, ok := configEnv[f.Name]
+		if !ok || f.Changed {
+			return
+		}
+
+		value := f.DefValue
+		if v, ok := file[f.Name]; ok {
+			value = fileValue(v)
+		}
+		value = envOr(env, value)
+
+		if err := setFlag(f, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", f.Name, err))
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	config := *bound
+	if routes, ok := file["routes"]; ok {
+		var err error
+		config.Routes, err = resolveRoutes(flags, routes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid routes in config file %s: %w", path, err)
+		}
+	}
+	if headers, ok := file["headers"]; ok {
+		var err error
+		config.Headers, err = resolveHeaders(headers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid headers in config file %s: %w", path, err)
+		}
+	}
+	if profiles, ok := file["profiles"]; ok {
+		var err error
+		config.Profiles, err = resolveProfiles(profiles)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profiles in config file %s: %w", path, err)
+		}
+	}
+	return &config, nil
+}
+
+// resolveRoutes builds the config of every route in the config file. Routes
+// inherit the resolved top-level settings and override them with their own
+// keys, which use the same names as the flags.
+func resolveRoutes(flags *pflag.FlagSet, value interface{}) ([]Route, error) {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a list")
+	}
+
+	var routes []Route
+	var errs []error
+	for i, value := range entries {
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			errs = append(errs, fmt.Errorf("route %d: expected a mapping", i))
+			continue
+		}
+
+		name, _ := entry["name"].(string)
+		route := Route{
+			Name:   name,
+			Match:  "*",
+			Config: &Config{},
+		}
+		if match, ok := entry["match"].(string); ok {
+			route.Match = match
+		}
+		route.Prefix, _ = entry["prefix"].(string)
+		route.MirrorOf, _ = entry["mirror-of"].(string)
+
+		for key := range entry {
+			if _, ok := configEnv[key]; !ok && key != "name" && key != "match" && key != "prefix" && key != "mirror-of" {
+				errs = append(errs, fmt.Errorf("route %s: unknown setting %q", route.Name, key))
+			}
+		}
+
+		routeFlags := pflag.NewFlagSet(route.Name, pflag.ContinueOnError)
+		bindFlags(routeFlags, route.Config)
+		flags.VisitAll(func(f *pflag.Flag) {
+			if _, ok := configEnv[f.Name]; !ok {
+				return
+			}
+
+			value := f.Value.String()
+			if v, ok := entry[f.Name]; ok {
+				value = fileValue(v)
+			}
+
+			if err := setFlag(routeFlags.Lookup(f.Name), value); err != nil {
+				errs = append(errs, fmt.Errorf("route %s: invalid %s: %w", route.Name, f.Name, err))
+			}
+		})
+
+		routes = append(routes, route)
+	}
+	return routes, errors.Join(errs...)
+}
+
+// fileValue renders a config file value the way it would be written on the
+// command line, joining lists with commas.
+func fileValue(v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Sprint(v)
+	}
+
+	items := make([]string, len(list))
+	for i, item := range list {
+		items[i] = fmt.Sprint(item)
+	}
+	return strings.Join(items, ",")
+}
+
+// setFlag sets f without marking it as changed. List flags are replaced
+// rather than appended to, so resolving twice doesn't duplicate entries.
+func setFlag(f *pflag.Flag, value string) error {
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+		var items []string
+		if value != "" {
+			items = strings.Split(value, ",")
+		}
+		return slice.Replace(items)
+	}
+	return f.Value.Set(value)
+}
+
+// validate reports every missing or invalid setting at once so operators
+// don't have to fix their deployment one variable at a time.
+func (c *Config) validate() error {
+	var errs []error
+
+	if len(c.Routes) == 0 {
+		errs = append(errs, c.validateBackend())
+	}
+
+	names := map[string]bool{}
+	for _, route := range c.Routes {
+		if route.Name == "" {
+			errs = append(errs, fmt.Errorf("route matching %q has no name", route.Match))
+		} else if names[route.Name] {
+			errs = append(errs, fmt.Errorf("duplicate route %q", route.Name))
+		}
+		names[route.Name] = true
+
+		if route.MirrorOf != "" && !isPrimaryRoute(c.Routes, route.MirrorOf) {
+			errs = append(errs, fmt.Errorf("route %s: mirror-of %q is not a route or is a mirror itself", route.Name, route.MirrorOf))
+		}
+		if route.Prefix != "" && route.MirrorOf != "" {
+			errs = append(errs, fmt.Errorf("route %s: a mirror serves the names of %s and can't have a prefix", route.Name, route.MirrorOf))
+		}
+		if strings.HasPrefix(route.Prefix, "/") {
+			errs = append(errs, fmt.Errorf("route %s: invalid prefix %q, chart names can't start with /", route.Name, route.Prefix))
+		}
+
+		if _, err := path.Match(route.Match, ""); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: invalid match %q: %w", route.Name, route.Match, err))
+		}
+
+		if err := route.Config.validateBackend(); err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", route.Name, err))
+		}
+	}
+
+	if c.Listen != "" {
+		if _, err := parseListen(c.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("%w (--listen or LISTEN)", err))
+		}
+	} else if c.Port == "" {
+		errs = append(errs, fmt.Errorf("missing port (--port or PORT)"))
+	} else if _, err := portAddress(c.Port, c.BindHost); err != nil {
+		errs = append(errs, fmt.Errorf("%w (--port or PORT)", err))
+	}
+
+	if c.GRPCPort != "" {
+		if _, err := portAddress(c.GRPCPort, c.BindHost); err != nil {
+			errs = append(errs, fmt.Errorf("invalid grpc %w (--grpc-port or GRPC_PORT)", err))
+		}
+	}
+
+	if c.ExternalURL != "" {
+		if u, err := url.Parse(c.ExternalURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid external url %q (--external-url or EXTERNAL_URL)", c.ExternalURL))
+		}
+	}
+
+	for _, cidrs := range []struct {
+		values []string
+		source string
+	}{
+		{c.AllowCIDRs, "--allow-cidrs or ALLOW_CIDRS"},
+		{c.DenyCIDRs, "--deny-cidrs or DENY_CIDRS"},
+		{c.TrustedProxies, "--trusted-proxies or TRUSTED_PROXIES"},
+	} {
+		if _, err := parsePrefixes(cidrs.values); err != nil {
+			errs = append(errs, fmt.Errorf("%w (%s)", err, cidrs.source))
+		}
+	}
+	if c.IAPAudience != "" && !strings.HasPrefix(c.IAPAudience, "/projects/") {
+		errs = append(errs, fmt.Errorf("invalid iap audience %q (--iap-audience or IAP_AUDIENCE), expected /projects/<number>/global/backendServices/<id> or /projects/<number>/apps/<project>", c.IAPAudience))
+	}
+
+	if c.DesiredState != "" && c.Backend != "gar" {
+		errs = append(errs, fmt.Errorf("--desired-state requires the gar backend"))
+	}
+
+	if c.DesiredState != "" && c.DesiredStateInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid desired state interval %s (--desired-state-interval or DESIRED_STATE_INTERVAL)", c.DesiredStateInterval))
+	}
+
+	if c.AttestationKMSKey != "" && !strings.Contains(c.AttestationKMSKey, "/cryptoKeyVersions/") {
+		errs = append(errs, fmt.Errorf("invalid attestation key %q, expected a crypto key version (--attestation-kms-key or ATTESTATION_KMS_KEY)", c.AttestationKMSKey))
+	}
+
+	if c.TransportMaxIdleConnsPerHost < 0 {
+		errs = append(errs, fmt.Errorf("invalid max idle connections per host %d (--transport-max-idle-conns-per-host or TRANSPORT_MAX_IDLE_CONNS_PER_HOST)", c.TransportMaxIdleConnsPerHost))
+	}
+
+	if c.TransportTLSHandshakeTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid tls handshake timeout %s (--transport-tls-handshake-timeout or TRANSPORT_TLS_HANDSHAKE_TIMEOUT)", c.TransportTLSHandshakeTimeout))
+	}
+
+	if c.TransportHTTP2PingInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid http/2 ping interval %s (--transport-http2-ping-interval or TRANSPORT_HTTP2_PING_INTERVAL)", c.TransportHTTP2PingInterval))
+	}
+
+	if c.TransportHTTP2PingTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid http/2 ping timeout %s (--transport-http2-ping-timeout or TRANSPORT_HTTP2_PING_TIMEOUT)", c.TransportHTTP2PingTimeout))
+	}
+
+	if c.PolicyURL != "" {
+		if u, err := url.Parse(c.PolicyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid policy url %q (--policy-url or POLICY_URL)", c.PolicyURL))
+		}
+	}
+
+	if c.IAMCheck && c.Backend != "gar" && len(c.Routes) == 0 {
+		errs = append(errs, fmt.Errorf("the iam check (--iam-check or IAM_CHECK) requires the gar backend"))
+	}
+	if c.IAMCheckTTL <= 0 {
+		errs = append(errs, fmt.Errorf("invalid iam check ttl %s (--iam-check-ttl or IAM_CHECK_TTL)", c.IAMCheckTTL))
+	}
+
+	if c.PolicyTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid policy timeout %s (--policy-timeout or POLICY_TIMEOUT)", c.PolicyTimeout))
+	}
+
+	for _, webhook := range c.Webhooks {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid webhook url %q (--webhooks or WEBHOOKS)", webhook))
+		}
+	}
+
+	if c.WebhookRetries < 0 {
+		errs = append(errs, fmt.Errorf("invalid webhook retries %d (--webhook-retries or WEBHOOK_RETRIES)", c.WebhookRetries))
+	}
+
+	if c.WebhookConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("invalid webhook concurrency %d (--webhook-concurrency or WEBHOOK_CONCURRENCY)", c.WebhookConcurrency))
+	}
+
+	if c.WebhookBatchSize < 1 {
+		errs = append(errs, fmt.Errorf("invalid webhook batch size %d (--webhook-batch-size or WEBHOOK_BATCH_SIZE)", c.WebhookBatchSize))
+	}
+
+	if c.WebhookBatchWait < 0 {
+		errs = append(errs, fmt.Errorf("invalid webhook batch wait %s (--webhook-batch-wait or WEBHOOK_BATCH_WAIT)", c.WebhookBatchWait))
+	}
+
+	if c.LeaderElection && c.CatalogSnapshot == "" && !c.LazyCatalog {
+		errs = append(errs, fmt.Errorf("leader election needs a catalog snapshot shared by the replicas (--catalog-snapshot or CATALOG_SNAPSHOT)"))
+	}
+	if c.LeaderElection && c.LeaderElectionLease == "" {
+		errs = append(errs, fmt.Errorf("invalid leader election lease %q (--leader-election-lease or LEADER_ELECTION_LEASE)", c.LeaderElectionLease))
+	}
+
+	for _, sink := range c.AuditSinks {
+		if err := validateAuditSink(sink); err != nil {
+			errs = append(errs, fmt.Errorf("%w (--audit-sinks or AUDIT_SINKS)", err))
+		}
+	}
+
+	if c.Upstream != "" {
+		if _, _, err := parseUpstream(c.Upstream); err != nil {
+			errs = append(errs, fmt.Errorf("%w (--upstream or UPSTREAM)", err))
+		}
+	}
+
+	if c.UpstreamTagTTL < 0 {
+		errs = append(errs, fmt.Errorf("invalid upstream tag ttl %s (--upstream-tag-ttl or UPSTREAM_TAG_TTL)", c.UpstreamTagTTL))
+	}
+
+	if c.SessionTTL <= 0 {
+		errs = append(errs, fmt.Errorf("invalid session ttl %s (--session-ttl or SESSION_TTL)", c.SessionTTL))
+	}
+
+	if c.IdempotencyTTL <= 0 {
+		errs = append(errs, fmt.Errorf("invalid idempotency ttl %s (--idempotency-ttl or IDEMPOTENCY_TTL)", c.IdempotencyTTL))
+	}
+
+	if c.LazyCatalog {
+		if c.Backend != "gar" || len(c.Routes) > 0 {
+			errs = append(errs, fmt.Errorf("--lazy-catalog requires the gar backend"))
+		}
+		if c.CatalogPath != "" || c.RetentionInterval > 0 || c.DesiredState != "" || c.SyncInterval > 0 {
+			errs = append(errs, fmt.Errorf("--lazy-catalog can't be used with --catalog-path, --retention-interval, --desired-state or --sync-interval, which need the full catalog"))
+		}
+		if c.LazyCatalogTTL <= 0 {
+			errs = append(errs, fmt.Errorf("invalid lazy catalog ttl %s (--lazy-catalog-ttl or LAZY_CATALOG_TTL)", c.LazyCatalogTTL))
+		}
+	}
+
+	if c.SyncInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid sync interval %s (--sync-interval or SYNC_INTERVAL)", c.SyncInterval))
+	}
+	if c.SyncInterval > 0 && c.FullSyncInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid full sync interval %s (--full-sync-interval or FULL_SYNC_INTERVAL)", c.FullSyncInterval))
+	}
+
+	if c.SyncPageSize < 1 {
+		errs = append(errs, fmt.Errorf("invalid sync page size %d (--sync-page-size or SYNC_PAGE_SIZE)", c.SyncPageSize))
+	}
+
+	if c.SyncPageRetries < 0 {
+		errs = append(errs, fmt.Errorf("invalid sync page retries %d (--sync-page-retries or SYNC_PAGE_RETRIES)", c.SyncPageRetries))
+	}
+
+	if c.ManifestScanner != "" && c.ManifestScannerTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid manifest scanner timeout %s (--manifest-scanner-timeout or MANIFEST_SCANNER_TIMEOUT)", c.ManifestScannerTimeout))
+	}
+
+	if _, err := time.LoadLocation(c.DisplayTimezone); err != nil {
+		errs = append(errs, fmt.Errorf("invalid display timezone %q (--display-timezone or DISPLAY_TIMEZONE): %w", c.DisplayTimezone, err))
+	}
+
+	if c.NotFoundSuggestions < 0 {
+		errs = append(errs, fmt.Errorf("invalid not found suggestions %d (--not-found-suggestions or NOT_FOUND_SUGGESTIONS)", c.NotFoundSuggestions))
+	}
+
+	if c.MaxArtifactBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid artifact size limit %d (--max-artifact-bytes or MAX_ARTIFACT_BYTES)", c.MaxArtifactBytes))
+	}
+	if c.RouteCollisions != matchCollisions && c.RouteCollisions != priorityCollisions {
+		errs = append(errs, fmt.Errorf("invalid route collisions %q (--route-collisions or ROUTE_COLLISIONS), expected match or priority", c.RouteCollisions))
+	}
+
+	if c.OversizedArtifacts != rejectOversized && c.OversizedArtifacts != redirectOversized {
+		errs = append(errs, fmt.Errorf("invalid oversized artifacts action %q (--oversized-artifacts or OVERSIZED_ARTIFACTS), expected reject or redirect", c.OversizedArtifacts))
+	}
+
+	if c.CatalogMemoryBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid catalog memory budget %d (--catalog-memory-bytes or CATALOG_MEMORY_BYTES)", c.CatalogMemoryBytes))
+	}
+
+	if c.CatalogPath != "" {
+		if info, err := os.Stat(c.CatalogPath); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("invalid catalog path %q, expected a directory (--catalog-path or CATALOG_PATH)", c.CatalogPath))
+		}
+		if len(c.Routes) > 0 {
+			errs = append(errs, fmt.Errorf("--catalog-path can't be used with routes"))
+		}
+	}
+
+	if c.CatalogSnapshot != "" {
+		if strings.HasPrefix(c.CatalogSnapshot, "gs://") {
+			if _, _, err := parseGCSObject(c.CatalogSnapshot); err != nil {
+				errs = append(errs, fmt.Errorf("invalid catalog snapshot %q, expected a file or gs://<bucket>/<object> (--catalog-snapshot or CATALOG_SNAPSHOT)", c.CatalogSnapshot))
+			}
+		}
+		if len(c.Routes) > 0 || c.LazyCatalog {
+			errs = append(errs, fmt.Errorf("--catalog-snapshot can't be used with routes or --lazy-catalog"))
+		}
+	}
+
+	if c.CacheMemoryBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid cache memory budget %d (--cache-memory-bytes or CACHE_MEMORY_BYTES)", c.CacheMemoryBytes))
+	}
+	if c.CacheMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid cache disk budget %d (--cache-max-bytes or CACHE_MAX_BYTES)", c.CacheMaxBytes))
+	}
+	if c.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("invalid cache ttl %s (--cache-ttl or CACHE_TTL)", c.CacheTTL))
+	}
+	for _, pattern := range c.Prewarm {
+		name, _, _ := strings.Cut(pattern, ":")
+		if _, err := path.Match(name, ""); err != nil || name == "" {
+			errs = append(errs, fmt.Errorf("invalid prewarm pattern %q, expected <name pattern>[:<version>] (--prewarm or PREWARM)", pattern))
+		}
+	}
+	if len(c.Prewarm) > 0 && c.CacheMemoryBytes == 0 && c.CacheDir == "" && c.CacheBucket == "" {
+		errs = append(errs, fmt.Errorf("--prewarm needs a chart cache (--cache-memory-bytes, --cache-dir or --cache-bucket)"))
+	}
+	if c.PrewarmTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("invalid prewarm timeout %s (--prewarm-timeout or PREWARM_TIMEOUT)", c.PrewarmTimeout))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, fmt.Errorf("tls needs both a certificate and a key (--tls-cert-file and --tls-key-file or TLS_CERT_FILE and TLS_KEY_FILE)"))
+	}
+	if c.H2C && c.TLSCertFile != "" {
+		errs = append(errs, fmt.Errorf("h2c only applies to plaintext, HTTP/2 is already served over tls (--h2c or H2C)"))
+	}
+	if c.ProxyProtocol && len(c.TrustedProxies) == 0 {
+		errs = append(errs, fmt.Errorf("the proxy protocol needs the load balancers sending it (--trusted-proxies or TRUSTED_PROXIES)"))
+	}
+	if c.HSTSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("invalid hsts max age %s (--hsts-max-age or HSTS_MAX_AGE)", c.HSTSMaxAge))
+	}
+	for _, origin := range c.CORSOrigins {
+		if err := validateCORSOrigin(origin); err != nil {
+			errs = append(errs, fmt.Errorf("%w (--cors-origins or CORS_ORIGINS)", err))
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("invalid cors max age %s (--cors-max-age or CORS_MAX_AGE)", c.CORSMaxAge))
+	}
+	if c.SignedURLKey != "" && len(c.SignedURLKey) < 32 {
+		errs = append(errs, fmt.Errorf("signed url key is too short, expected at least 32 bytes (--signed-url-key or SIGNED_URL_KEY)"))
+	}
+	if c.SignedURLTTL <= 0 {
+		errs = append(errs, fmt.Errorf("invalid signed url ttl %s (--signed-url-ttl or SIGNED_URL_TTL)", c.SignedURLTTL))
+	}
+	if c.MaxConcurrentPulls < 0 {
+		errs = append(errs, fmt.Errorf("invalid concurrent pull limit %d (--max-concurrent-pulls or MAX_CONCURRENT_PULLS)", c.MaxConcurrentPulls))
+	}
+	if c.DownloadBytesPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("invalid download bandwidth %d (--download-bytes-per-second or DOWNLOAD_BYTES_PER_SECOND)", c.DownloadBytesPerSecond))
+	}
+	if c.CacheBucket != "" {
+		if _, _, err := parseGCSBucket(c.CacheBucket); err != nil {
+			errs = append(errs, fmt.Errorf("invalid cache bucket %q, expected gs://<bucket> or gs://<bucket>/<prefix> (--cache-bucket or CACHE_BUCKET)", c.CacheBucket))
+		}
+	}
+	if c.ServeStaleAfter < 0 {
+		errs = append(errs, fmt.Errorf("invalid stale serving delay %s (--serve-stale-after or SERVE_STALE_AFTER)", c.ServeStaleAfter))
+	} else if c.ServeStaleAfter > 0 && c.CacheMemoryBytes == 0 && c.CacheDir == "" && c.CacheBucket == "" {
+		errs = append(errs, fmt.Errorf("--serve-stale-after needs a chart cache (--cache-memory-bytes, --cache-dir or --cache-bucket)"))
+	}
+
+	if c.RetentionKeepLast < 0 {
+		errs = append(errs, fmt.Errorf("invalid retention keep last %d (--retention-keep-last or RETENTION_KEEP_LAST)", c.RetentionKeepLast))
+	}
+
+	if c.RetentionInterval < 0 {
+		errs = append(errs, fmt.Errorf("invalid retention interval %s (--retention-interval or RETENTION_INTERVAL)", c.RetentionInterval))
+	}
+
+	if c.RetentionInterval > 0 && c.Backend != "gar" && len(c.Routes) == 0 {
+		errs = append(errs, fmt.Errorf("--retention-interval requires the gar backend"))
+	}
+
+	if c.RetentionUntaggedMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("invalid retention untagged max age %s (--retention-untagged-max-age or RETENTION_UNTAGGED_MAX_AGE)", c.RetentionUntaggedMaxAge))
+	}
+
+	if c.CostStoragePerGBMonth < 0 || c.CostEgressPerGB < 0 {
+		errs = append(errs, fmt.Errorf("invalid negative price (--cost-storage-per-gb-month, --cost-egress-per-gb)"))
+	}
+
+	if _, err := parseMaintenanceWindows(c.MaintenanceWindows); err != nil {
+		errs = append(errs, fmt.Errorf("%w (--maintenance-windows or MAINTENANCE_WINDOWS)", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func isPrimaryRoute(routes []Route, name string) bool {
+	for _, route := range routes {
+		if route.Name == name {
+			return route.MirrorOf == ""
+		}
+	}
+	return false
+}
+
+// validateBackend checks the settings of the configured backend.
+func (c *Config) validateBackend() error {
+	var errs []error
+
+	switch c.Backend {
+	case "gar":
+		if c.Repository == "" {
+			errs = append(errs, fmt.Errorf("missing repository (--repository or REPOSITORY)"))
+		}
+
+		if c.Region == "" {
+			errs = append(errs, fmt.Errorf("missing region (--region or REGION)"))
+		}
+	case "gcr":
+		if c.GCRHost == "" {
+			errs = append(errs, fmt.Errorf("missing gcr host (--gcr-host or GCR_HOST)"))
+		}
+	case "ecr":
+		if c.AWSRegion == "" {
+			errs = append(errs, fmt.Errorf("missing aws region (--aws-region or AWS_REGION)"))
+		}
+
+		if c.AWSAccountID == "" {
+			errs = append(errs, fmt.Errorf("missing aws account id (--aws-account-id or AWS_ACCOUNT_ID)"))
+		}
+	case "acr":
+		if c.ACRRegistry == "" {
+			errs = append(errs, fmt.Errorf("missing acr registry (--acr-registry or ACR_REGISTRY)"))
+		}
+
+		if c.ACRUsername == "" || c.ACRPassword == "" {
+			errs = append(errs, fmt.Errorf("missing acr credential (--acr-username/--acr-password or ACR_USERNAME/ACR_PASSWORD)"))
+		}
+	case "oci", "distribution", "harbor":
+		if c.OCIRegistry == "" {
+			errs = append(errs, fmt.Errorf("missing oci registry (--oci-registry or OCI_REGISTRY)"))
+		}
+
+		if c.OCIPassword != "" && c.OCIToken != "" {
+			errs = append(errs, fmt.Errorf("conflicting oci credentials, set either --oci-password or --oci-token"))
+		}
+	case "ghcr":
+		if c.OCIToken == "" {
+			errs = append(errs, fmt.Errorf("missing github token with read:packages (--oci-token or OCI_TOKEN)"))
+		}
+
+		if c.Repository == "" && len(c.OCIRepositories) == 0 {
+			errs = append(errs, fmt.Errorf("missing github owner (--repository or REPOSITORY), or --oci-repositories"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid backend %q (--backend or BACKEND), expected gar, gcr, ecr, acr, ghcr, harbor or oci", c.Backend))
+	}
+
+	if c.usesGoogle() {
+		if c.Project == "" {
+			errs = append(errs, fmt.Errorf("missing project (--project or PROJECT)"))
+		}
+
+		if c.Anonymous {
+			if c.Credential != "" || c.CredentialSecret != "" || c.CredentialJSON != "" || c.ImpersonateServiceAccount != "" {
+				errs = append(errs, fmt.Errorf("anonymous access (--anonymous or ANONYMOUS) can't be combined with a credential"))
+			}
+			if c.DesiredState != "" {
+				errs = append(errs, fmt.Errorf("the desired state (--desired-state or DESIRED_STATE) can't be imported with anonymous access"))
+			}
+		} else if c.Credential == "" && c.CredentialSecret == "" && c.CredentialJSON == "" && c.ImpersonateServiceAccount == "" {
+			errs = append(errs, fmt.Errorf("missing credential (--credential, --credential-secret, --credential-json, --impersonate-service-account or their environment variables)"))
+		}
+		if c.CredentialJSON != "" {
+			if _, err := inlineCredential(c.CredentialJSON); err != nil {
+				errs = append(errs, fmt.Errorf("%w (--credential-json or GOOGLE_CREDENTIALS_JSON)", err))
+			}
+		}
+	}
+
+	if c.Anonymous && !c.usesGoogle() {
+		errs = append(errs, fmt.Errorf("anonymous access (--anonymous or ANONYMOUS) only applies to gar and gcr, other backends pull anonymously when no credential is set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// usesGoogle reports whether the configured backend is hosted on Google Cloud.
+func (c *Config) usesGoogle() bool {
+	return c.Backend == "gar" || c.Backend == "gcr"
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func FuzzResolveConfig(f *testing.F) {
+	for _, seed := range []string{
+		"backend: gar\nproject: p\nrepository: charts\n",
+		"backend: gar\nproject: p\nrepository: charts\nanonymous: true\n",
+		"backend: oci\noci-registry: ghcr.io\noci-repositories: [a, b]\ncache-memory-bytes: 1024\n",
+		"maintenance-windows: \"0 2 * * SAT 4h; 30 1 * * * 1h\"\npolicy-url: http://localhost:8181/v1/data/charts/allow\n",
+		"audit-sinks: [stdout, \"bigquery:dataset.table\"]\n",
+		"routes:\n- name: a\n  match: \"team-*\"\n  backend: gcr\n  project: p\n- name: b\n  mirror-of: a\n  backend: ecr\n",
+		"headers:\n- group: downloads\n  set:\n    X-Backend: \"{{ .Backend }}\"\n",
+		"unknown: 1\n",
+		"routes: 3\n",
+		"headers: [1, 2]\n",
+		"{{",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		flags := pflag.NewFlagSet("fuzz", pflag.ContinueOnError)
+		bound := &Config{}
+		bindFlags(flags, bound)
+
+		config, err := resolveConfig(flags, bound, path)
+		if err != nil {
+			return
+		}
+		config.validate()
+	})
+}
+
+func FuzzParseMaintenanceWindows(f *testing.F) {
+	for _, seed := range []string{
+		"0 2 * * SAT 4h",
+		"0 2 * * SAT 4h; */15 * * * * 5m",
+		"@daily 1h",
+		"0 2 * * SAT",
+		"0 2 * * SAT -1h",
+		";;",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		windows, err := parseMaintenanceWindows(value)
+		if err != nil {
+			return
+		}
+
+		for _, window := range windows {
+			if window.Duration <= 0 {
+				t.Fatalf("parseMaintenanceWindows(%q) accepted duration %s", value, window.Duration)
+			}
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// corsOriginAllowed reports whether origin matches one of patterns, each
+// an origin, * or an origin with wildcards, e.g. https://*.example.com.
+func corsOriginAllowed(patterns []string, origin string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCORSOrigin checks an entry of --cors-origins.
+func validateCORSOrigin(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid cors origin %q: %w", pattern, err)
+	}
+	u, err := url.Parse(strings.ReplaceAll(pattern, "*", "x"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("invalid cors origin %q, expected * or <scheme>://<host>[:<port>]", pattern)
+	}
+	return nil
+}
+
+// cors lets the browser UIs of --cors-origins call the JSON API under
+// /api/ across origins, answering their preflight requests before IAP and
+// admin tokens are checked, as browsers send preflights without
+// credentials.
+func cors(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, _ := live.get()
+			origin := r.Header.Get("Origin")
+			if len(config.CORSOrigins) == 0 || origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Add("Vary", "Origin")
+			allowed := corsOriginAllowed(config.CORSOrigins, origin)
+			if allowed {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+
+			requested := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || requested == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Refused preflights get no CORS headers, which the browser
+			// takes as a refusal.
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			if allowed && containsFold(config.CORSMethods, requested) {
+				header.Set("Access-Control-Allow-Methods", strings.Join(config.CORSMethods, ", "))
+				header.Set("Access-Control-Allow-Headers", strings.Join(config.CORSHeaders, ", "))
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(config.CORSMaxAge.Seconds())))
+			} else {
+				header.Del("Access-Control-Allow-Origin")
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	live := &liveConfig{config: &Config{
+		CORSOrigins: []string{"https://ui.example.com", "https://*.apps.example.com"},
+		CORSMethods: []string{"GET", "POST"},
+		CORSHeaders: []string{"Content-Type"},
+		CORSMaxAge:  10 * time.Minute,
+	}}
+	handler := cors(live)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	tests := []struct {
+		method    string
+		path      string
+		origin    string
+		preflight string
+		code      int
+		allow     string
+		methods   string
+	}{
+		{http.MethodGet, "/api/search", "https://ui.example.com", "", http.StatusOK, "https://ui.example.com", ""},
+		{http.MethodGet, "/api/search", "https://team.apps.example.com", "", http.StatusOK, "https://team.apps.example.com", ""},
+		{http.MethodGet, "/api/search", "https://evil.example.com", "", http.StatusOK, "", ""},
+		{http.MethodGet, "/index.yaml", "https://ui.example.com", "", http.StatusOK, "", ""},
+		{http.MethodOptions, "/api/charts/nginx/1.2.3/render", "https://ui.example.com", "POST", http.StatusNoContent, "https://ui.example.com", "GET, POST"},
+		{http.MethodOptions, "/api/charts/nginx/1.2.3", "https://ui.example.com", "DELETE", http.StatusNoContent, "", ""},
+		{http.MethodOptions, "/api/search", "https://evil.example.com", "GET", http.StatusNoContent, "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.preflight != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.preflight)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %s from %s = %d, want %d", tt.method, tt.path, tt.origin, w.Code, tt.code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("%s %s from %s allowed origin %q, want %q", tt.method, tt.path, tt.origin, got, tt.allow)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.methods {
+			t.Errorf("%s %s from %s allowed methods %q, want %q", tt.method, tt.path, tt.origin, got, tt.methods)
+		}
+	}
+}
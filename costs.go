@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	bytesPerGB  = 1 << 30
+	monthLength = 30 * 24 * time.Hour
+)
+
+// costEstimate projects the monthly registry bill attributable to the
+// charts served by the proxy from the catalog sizes and the traffic seen
+// since start.
+type costEstimate struct {
+	Since   time.Time   `json:"since"`
+	Storage storageCost `json:"storage"`
+	Egress  egressCost  `json:"egress"`
+	Total   float64     `json:"monthly_total_usd"`
+}
+
+type storageCost struct {
+	Bytes      int64   `json:"bytes"`
+	PricePerGB float64 `json:"price_per_gb_month_usd"`
+	Monthly    float64 `json:"monthly_usd"`
+}
+
+// egressCost splits traffic into what was pulled from the registry, which
+// is billed, and what was served to clients, the difference being what the
+// chart cache saved.
+type egressCost struct {
+	Downloads         int64   `json:"downloads"`
+	UpstreamBytes     int64   `json:"upstream_bytes"`
+	ServedBytes       int64   `json:"served_bytes"`
+	MonthlyUpstreamGB float64 `json:"projected_monthly_upstream_gb"`
+	PricePerGB        float64 `json:"price_per_gb_usd"`
+	Monthly           float64 `json:"monthly_usd"`
+	MonthlySavings    float64 `json:"monthly_cache_savings_usd"`
+}
+
+func estimateCosts(config *Config, repository *Repository, stats *downloadStats, now time.Time) *costEstimate {
+	started, upstream, served, downloads := stats.totals()
+
+	estimate := &costEstimate{Since: started}
+	repository.each(func(asset *Asset) {
+		estimate.Storage.Bytes += asset.Size
+	})
+	estimate.Storage.PricePerGB = config.CostStoragePerGBMonth
+	estimate.Storage.Monthly = float64(estimate.Storage.Bytes) / bytesPerGB * config.CostStoragePerGBMonth
+
+	// Traffic is extrapolated linearly from the time the proxy has been up.
+	scale := 0.0
+	if elapsed := now.Sub(started); elapsed > 0 {
+		scale = float64(monthLength) / float64(elapsed)
+	}
+
+	estimate.Egress = egressCost{
+		Downloads:         downloads,
+		UpstreamBytes:     upstream,
+		ServedBytes:       served,
+		MonthlyUpstreamGB: float64(upstream) / bytesPerGB * scale,
+		PricePerGB:        config.CostEgressPerGB,
+	}
+	estimate.Egress.Monthly = estimate.Egress.MonthlyUpstreamGB * config.CostEgressPerGB
+	if served > upstream {
+		estimate.Egress.MonthlySavings = float64(served-upstream) / bytesPerGB * scale * config.CostEgressPerGB
+	}
+
+	estimate.Total = estimate.Storage.Monthly + estimate.Egress.Monthly
+	return estimate
+}
+
+func handleCosts(w http.ResponseWriter, r *http.Request, live *liveConfig, stats *downloadStats) {
+	config, _ := live.get()
+	estimate := estimateCosts(config, currentRepository(), stats, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(estimate)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileCredential keeps the contents of a key file in memory and re-reads it
+// whenever its modification time or size changes, so key rotations through
+// a mounted secret are picked up without touching disk on every download.
+type fileCredential struct {
+	path string
+
+	mu      sync.RWMutex
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+var (
+	fileCredentials   = map[string]*fileCredential{}
+	fileCredentialsMu sync.Mutex
+)
+
+// watchCredentialFile returns the credential stored at path, reading it on
+// first use and checking it for changes every refresh interval after that.
+func watchCredentialFile(path string, refresh time.Duration) (*fileCredential, error) {
+	fileCredentialsMu.Lock()
+	defer fileCredentialsMu.Unlock()
+
+	if file, ok := fileCredentials[path]; ok {
+		return file, nil
+	}
+
+	file := &fileCredential{path: path}
+	if err := file.refresh(); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go func() {
+			ticker := time.NewTicker(refresh)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := file.refresh(); err != nil {
+					log.Printf("failed to refresh credential file %s. error: %v", path, err)
+				}
+			}
+		}()
+	}
+
+	fileCredentials[path] = file
+	return file, nil
+}
+
+func (f *fileCredential) refresh() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.RLock()
+	unchanged := info.ModTime().Equal(f.modTime) && info.Size() == f.size
+	f.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.value != "" && f.value != string(data) {
+		log.Printf("credential file %s rotated", f.path)
+	}
+	f.value = string(data)
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+func (f *fileCredential) get() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.value
+}
+
+var (
+	inlineCredentials   = map[string]string{}
+	inlineCredentialsMu sync.Mutex
+)
+
+// inlineCredential returns the key given in --credential-json, either as
+// JSON or as base64-encoded JSON. Every value is decoded once, so a key
+// rotated through a config reload is decoded on its first use.
+func inlineCredential(value string) (string, error) {
+	inlineCredentialsMu.Lock()
+	defer inlineCredentialsMu.Unlock()
+
+	if key, ok := inlineCredentials[value]; ok {
+		return key, nil
+	}
+
+	key := strings.TrimSpace(value)
+	if !strings.HasPrefix(key, "{") {
+		data, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return "", fmt.Errorf("invalid credential json: neither json nor base64")
+		}
+		key = string(data)
+	}
+	if !json.Valid([]byte(key)) {
+		return "", fmt.Errorf("invalid credential json: not a json document")
+	}
+
+	inlineCredentials[value] = key
+	return key, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestInlineCredential(t *testing.T) {
+	key := `{"type": "service_account"}`
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{key, true},
+		{"  " + key + "\n", true},
+		{base64.StdEncoding.EncodeToString([]byte(key)), true},
+		{base64.StdEncoding.EncodeToString([]byte("not json")), false},
+		{"/var/run/secrets/key.json", false},
+	}
+	for _, tt := range tests {
+		got, err := inlineCredential(tt.value)
+		if (err == nil) != tt.ok || (tt.ok && got != key) {
+			t.Errorf("inlineCredential(%q) = %q, %v, want ok %v", tt.value, got, err, tt.ok)
+		}
+	}
+}
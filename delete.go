@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	artifactregistrypb "cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
+	"github.com/go-chi/chi"
+)
+
+// requireAdmin lets through requests bearing the --admin-token. Admin
+// endpoints are refused altogether while no token is configured.
+func requireAdmin(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, _ := live.get()
+			if config.AdminToken == "" {
+				http.Error(w, "admin endpoints are disabled, set --admin-token to enable them", http.StatusForbidden)
+				return
+			}
+
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gcp-oci-proxy"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// chartDeleter retracts chart versions from Artifact Registry.
+type chartDeleter struct {
+	live        *liveConfig
+	maintenance *maintenanceGate
+}
+
+// handleDelete removes a chart version, given as a tag or a digest, from
+// every Artifact Registry repository holding it and from the catalog. A tag
+// sharing its digest with other tags is untagged rather than deleting the
+// digest under them. The deletion waits for a maintenance window if one is
+// configured.
+func (d *chartDeleter) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+	asset := findChartVersion(name, version)
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, backend := d.live.get()
+	targets, err := garSources(backend, asset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	tag := ""
+	if asset.SHA != version && len(asset.Tags) > 1 {
+		tag = version
+	}
+
+	identity := requestIdentity(r)
+	who := identity.User
+	if who == "" {
+		who = identity.RemoteAddr
+	}
+	op := fmt.Sprintf("delete %s:%s", name, version)
+	queued, err := d.maintenance.submit(context.WithoutCancel(r.Context()), op, func(ctx context.Context) error {
+		if tag == "" {
+			if err := deleteEverywhere(ctx, backend, asset); err != nil {
+				return err
+			}
+		} else {
+			for _, target := range targets {
+				if err := target.gar.deleteVersion(ctx, target.name, asset.SHA, tag); err != nil {
+					return err
+				}
+			}
+			setRepository(currentRepository().without(asset, tag))
+		}
+		log.Printf("%s deleted %s:%s (%s)", who, name, version, asset.SHA)
+		return nil
+	})
+	if errors.Is(err, errReadOnly) {
+		http.Error(w, err.Error(), http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete %s:%s. error: %v", name, version, err)
+		http.Error(w, "failed to delete chart version", http.StatusBadGateway)
+		return
+	}
+
+	if queued {
+		http.Error(w, "deletion queued until the next maintenance window", http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// garTarget is an Artifact Registry backend holding a chart, and the name of
+// the chart there.
+type garTarget struct {
+	gar  *garBackend
+	name string
+}
+
+// garSources returns the Artifact Registry backends holding asset, or an
+// error if any copy of it is stored elsewhere.
+func garSources(backend Backend, asset *Asset) ([]garTarget, error) {
+	sources := []*assetSource{{name: asset.Name}}
+	if _, ok := backend.(*compositeBackend); ok {
+		sources = asset.sources
+	}
+
+	var targets []garTarget
+	for _, source := range sources {
+		held := backend
+		if source.route != nil {
+			held = source.route.backend
+		}
+		gar, ok := held.(*garBackend)
+		if !ok {
+			return nil, fmt.Errorf("%s is stored in %s, only artifact registry charts can be deleted", asset.RawName, held.Name())
+		}
+		targets = append(targets, garTarget{gar: gar, name: source.name})
+	}
+	return targets, nil
+}
+
+// deleteVersion deletes the image with the given digest of the chart called
+// name, with all its tags, or only tag when it is set.
+func (b *garBackend) deleteVersion(ctx context.Context, name, digest, tag string) error {
+	parent, err := formatPath(b.config)
+	if err != nil {
+		return err
+	}
+	pkg := fmt.Sprintf("%s/packages/%s", parent, url.PathEscape(name))
+
+	if tag != "" {
+		return b.client.DeleteTag(ctx, &artifactregistrypb.DeleteTagRequest{Name: pkg + "/tags/" + url.PathEscape(tag)})
+	}
+
+	op, err := b.client.DeleteVersion(ctx, &artifactregistrypb.DeleteVersionRequest{Name: pkg + "/versions/" + digest, Force: true})
+	if err != nil {
+		return err
+	}
+	return op.Wait(ctx)
+}
+
+// without returns a copy of the catalog with tag removed from asset, or
+// asset removed altogether when tag is empty.
+func (r *Repository) without(asset *Asset, tag string) *Repository {
+	if r.store != nil {
+		// The store is only ever replaced by a sync, so it is updated in
+		// place.
+		if err := r.store.without(asset, tag); err != nil {
+			log.Printf("failed to update catalog store. error: %v", err)
+		}
+		return &Repository{Errors: r.Errors, Conflicts: r.Conflicts, store: r.store}
+	}
+
+	copied := &Repository{Errors: r.Errors, Conflicts: r.Conflicts}
+	for _, a := range r.Assets {
+		if a.Name != asset.Name || a.SHA != asset.SHA {
+			copied.Assets = append(copied.Assets, a)
+			continue
+		}
+		if tag == "" {
+			continue
+		}
+
+		untagged := *a
+		untagged.Tags = nil
+		for _, t := range a.Tags {
+			if t != tag {
+				untagged.Tags = append(untagged.Tags, t)
+			}
+		}
+		copied.Assets = append(copied.Assets, &untagged)
+	}
+	return copied
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestDeleteAuthorization(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}})
+	defer setRepository(&Repository{})
+
+	gar := &garBackend{config: &Config{Project: "p", Region: "europe", Repository: "charts"}}
+	// A one minute window half an hour away keeps deletions queued rather
+	// than reaching Artifact Registry.
+	closed := fmt.Sprintf("%d * * * * 1m", (time.Now().Minute()+30)%60)
+
+	tests := []struct {
+		name    string
+		config  *Config
+		backend Backend
+		token   string
+		target  string
+		code    int
+	}{
+		{name: "admin disabled", config: &Config{}, backend: gar, token: "secret", target: "/api/charts/nginx/1.0.0", code: http.StatusForbidden},
+		{name: "no token", config: &Config{AdminToken: "secret"}, backend: gar, target: "/api/charts/nginx/1.0.0", code: http.StatusUnauthorized},
+		{name: "wrong token", config: &Config{AdminToken: "secret"}, backend: gar, token: "guess", target: "/api/charts/nginx/1.0.0", code: http.StatusUnauthorized},
+		{name: "unknown version", config: &Config{AdminToken: "secret"}, backend: gar, token: "secret", target: "/api/charts/nginx/2.0.0", code: http.StatusNotFound},
+		{name: "not gar", config: &Config{AdminToken: "secret"}, backend: &listedBackend{}, token: "secret", target: "/api/charts/nginx/1.0.0", code: http.StatusNotImplemented},
+		{name: "read-only", config: &Config{AdminToken: "secret", ReadOnly: true}, backend: gar, token: "secret", target: "/api/charts/nginx/1.0.0", code: http.StatusMethodNotAllowed},
+		{name: "queued", config: &Config{AdminToken: "secret", MaintenanceWindows: closed}, backend: gar, token: "secret", target: "/api/charts/nginx/1.0.0", code: http.StatusAccepted},
+		{name: "queued by digest", config: &Config{AdminToken: "secret", MaintenanceWindows: closed}, backend: gar, token: "secret", target: "/api/charts/nginx/sha256:1", code: http.StatusAccepted},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := &liveConfig{config: test.config, backend: test.backend}
+			deleter := &chartDeleter{live: live, maintenance: newMaintenanceGate(live)}
+			router := chi.NewRouter()
+			router.With(requireAdmin(live)).Delete("/api/charts/{name}/{version}", deleter.handleDelete)
+
+			r := httptest.NewRequest(http.MethodDelete, test.target, nil)
+			if test.token != "" {
+				r.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != test.code {
+				t.Errorf("DELETE %s = %d %q, want %d", test.target, w.Code, w.Body, test.code)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate challenge")
+			}
+			if len(deleter.maintenance.queue) > 0 && test.code != http.StatusAccepted {
+				t.Errorf("DELETE %s queued a deletion", test.target)
+			}
+		})
+	}
+}
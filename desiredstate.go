@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/registry"
+	"sigs.k8s.io/yaml"
+)
+
+// desiredState is the manifest of charts that must exist in the repository.
+type desiredState struct {
+	Charts []desiredChart `json:"charts"`
+}
+
+// desiredChart is a chart version and where to import it from: an OCI
+// repository ("oci://registry-1.docker.io/bitnamicharts/nginx") or the URL
+// of a chart archive.
+type desiredChart struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Source  string `json:"source"`
+}
+
+// driftEntry is the reconciliation state of one desired chart.
+type driftEntry struct {
+	desiredChart
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+type driftReport struct {
+	Manifest      string       `json:"manifest"`
+	LastReconcile time.Time    `json:"last_reconcile"`
+	Charts        []driftEntry `json:"charts"`
+}
+
+// desiredStateSyncer keeps the Artifact Registry repository in line with
+// the desired state manifest, importing missing chart versions.
+type desiredStateSyncer struct {
+	path   string
+	live   *liveConfig
+	client *registry.Client
+	logins *loginCache
+
+	// attestor, when set, attaches provenance to imported charts.
+	attestor *attestor
+	// leader tells whether this replica reconciles.
+	leader *leaderElection
+
+	mu     sync.RWMutex
+	report driftReport
+}
+
+func newDesiredStateSyncer(path string, live *liveConfig, client *registry.Client, logins *loginCache, attestor *attestor, leader *leaderElection) *desiredStateSyncer {
+	return &desiredStateSyncer{
+		path:     path,
+		live:     live,
+		client:   client,
+		logins:   logins,
+		attestor: attestor,
+		leader:   leader,
+		report:   driftReport{Manifest: path},
+	}
+}
+
+// run reconciles immediately and then every interval.
+func (s *desiredStateSyncer) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if !s.leader.isLeader() {
+			// Another replica reconciles.
+		} else if err := s.reconcile(ctx); err != nil {
+			log.Printf("failed to reconcile desired state. error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *desiredStateSyncer) reconcile(ctx context.Context) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var state desiredState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid desired state %s: %w", s.path, err)
+	}
+
+	config, backend := s.live.get()
+	gar, ok := backend.(*garBackend)
+	if !ok {
+		return fmt.Errorf("desired state sync requires the gar backend")
+	}
+
+	imported := 0
+	repository := currentRepository()
+	entries := make([]driftEntry, 0, len(state.Charts))
+	for _, chart := range state.Charts {
+		entry := driftEntry{desiredChart: chart, State: "present"}
+		if repository.findByTag(chart.Name, chart.Version) == nil {
+			if err := s.importChart(ctx, gar, chart); err != nil {
+				entry.State = "missing"
+				entry.Error = err.Error()
+			} else {
+				entry.State = "imported"
+				imported++
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	if imported > 0 {
+		if err := initDB(ctx, config, backend); err != nil {
+			return fmt.Errorf("failed to reload catalog after import: %w", err)
+		}
+		log.Printf("imported %d charts into %s", imported, repositoryURI(config))
+	}
+
+	s.mu.Lock()
+	s.report = driftReport{Manifest: s.path, LastReconcile: time.Now(), Charts: entries}
+	s.mu.Unlock()
+	return nil
+}
+
+// importChart fetches the chart from its source and pushes it to the
+// repository.
+func (s *desiredStateSyncer) importChart(ctx context.Context, gar *garBackend, chart desiredChart) error {
+	started := time.Now()
+	data, err := s.fetch(ctx, chart)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", chart.Source, err)
+	}
+
+	user, credential, err := gar.Credential(ctx)
+	if err != nil {
+		return err
+	}
+
+	client, err := s.logins.client(gar, user, credential)
+	if err != nil {
+		return err
+	}
+
+	ref := fmt.Sprintf("%s/%s:%s", repositoryURI(gar.config), chart.Name, chart.Version)
+	result, err := client.Push(data, ref)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %w", ref, err)
+	}
+
+	// The chart is in place at this point; a missing attestation is logged
+	// rather than reported as a failed import.
+	if s.attestor != nil {
+		parameters := map[string]string{"source": chart.Source, "destination": ref}
+		if err := s.attestor.attest(ctx, gar, ref, result.Manifest.Digest, "import", parameters, started); err != nil {
+			log.Printf("failed to attest import of %s. error: %v", ref, err)
+		}
+	}
+	return nil
+}
+
+func (s *desiredStateSyncer) fetch(ctx context.Context, chart desiredChart) ([]byte, error) {
+	if strings.HasPrefix(chart.Source, "oci://") {
+		ref := fmt.Sprintf("%s:%s", strings.TrimPrefix(chart.Source, "oci://"), chart.Version)
+		result, err := s.client.Pull(ref)
+		if err != nil {
+			return nil, err
+		}
+		return result.Chart.Data, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, chart.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *desiredStateSyncer) handleReport(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	report := s.report
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDesiredStateReconcile(t *testing.T) {
+	sources := httptest.NewServer(http.NotFoundHandler())
+	defer sources.Close()
+
+	setRepository(&Repository{Assets: []*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}})
+	defer setRepository(&Repository{})
+
+	gar := &garBackend{config: &Config{Project: "p", Region: "europe", Repository: "charts"}}
+	tests := []struct {
+		name     string
+		manifest string
+		backend  Backend
+		err      string
+		want     map[string]driftEntry
+	}{
+		{
+			name:     "present",
+			manifest: "charts:\n- name: nginx\n  version: 1.0.0\n  source: oci://registry-1.docker.io/bitnamicharts/nginx\n",
+			backend:  gar,
+			want:     map[string]driftEntry{"nginx": {State: "present"}},
+		},
+		{
+			name:     "source not found",
+			manifest: "charts:\n- name: redis\n  version: 7.0.0\n  source: " + sources.URL + "/redis-7.0.0.tgz\n",
+			backend:  gar,
+			want:     map[string]driftEntry{"redis": {State: "missing", Error: "failed to fetch " + sources.URL + "/redis-7.0.0.tgz: unexpected status 404 Not Found"}},
+		},
+		{
+			name:     "mixed",
+			manifest: "charts:\n- name: nginx\n  version: 1.0.0\n- name: nginx\n  version: 2.0.0\n  source: " + sources.URL + "/nginx-2.0.0.tgz\n",
+			backend:  gar,
+			want: map[string]driftEntry{
+				"nginx:1.0.0": {State: "present"},
+				"nginx:2.0.0": {State: "missing", Error: "failed to fetch " + sources.URL + "/nginx-2.0.0.tgz: unexpected status 404 Not Found"},
+			},
+		},
+		{
+			name:     "invalid manifest",
+			manifest: "charts: {",
+			backend:  gar,
+			err:      "invalid desired state",
+		},
+		{
+			name:     "not gar",
+			manifest: "charts: []\n",
+			backend:  &listedBackend{},
+			err:      "requires the gar backend",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "desired.yaml")
+			if err := os.WriteFile(path, []byte(test.manifest), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			s := newDesiredStateSyncer(path, &liveConfig{config: gar.config, backend: test.backend}, nil, nil, nil, nil)
+
+			err := s.reconcile(context.Background())
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("reconcile() = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reconcile() = %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			s.handleReport(w, httptest.NewRequest(http.MethodGet, "/api/v1/desired-state", nil))
+			var report driftReport
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Manifest != path || report.LastReconcile.IsZero() || len(report.Charts) != len(test.want) {
+				t.Fatalf("report = %+v, want %d charts of %s", report, len(test.want), path)
+			}
+			for _, entry := range report.Charts {
+				want, ok := test.want[entry.Name]
+				if !ok {
+					want = test.want[entry.Name+":"+entry.Version]
+				}
+				if entry.State != want.State || entry.Error != want.Error {
+					t.Errorf("%s:%s = %s %q, want %s %q", entry.Name, entry.Version, entry.State, entry.Error, want.State, want.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskMeta is written next to each chart in the disk cache. SHA256 is the
+// digest of the archive itself, the cache key being the manifest's.
+type diskMeta struct {
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	SHA256  string    `json:"sha256"`
+	Added   time.Time `json:"added"`
+}
+
+// diskCache is a chartCache keeping charts in --cache-dir, behind a local
+// one, so they survive restarts. Charts unused for ttl are removed, then
+// the least recently used ones once their total size exceeds max bytes.
+type diskCache struct {
+	local chartCache
+	dir   string
+	max   int64
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+
+	hits, misses, evictions int64
+}
+
+type diskEntry struct {
+	digest string
+	size   int64
+	used   time.Time
+}
+
+// newDiskCache opens the cache in config.CacheDir, removing the charts
+// whose archive no longer matches its recorded digest.
+func newDiskCache(config *Config, local chartCache) (*diskCache, error) {
+	c := &diskCache{local: local, dir: config.CacheDir, max: config.CacheMaxBytes, ttl: config.CacheTTL, order: list.New(), entries: map[string]*list.Element{}}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	// Only files laid out like the cache's own, <algorithm>/<hex> and its
+	// .json and .tmp siblings, are ever removed, so pointing --cache-dir at
+	// a shared directory leaves everything else alone.
+	var entries []*diskEntry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != c.dir && (filepath.Dir(path) != c.dir || digestLengths[d.Name()] == 0) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		name := strings.TrimSuffix(d.Name(), ".tmp")
+		digest := filepath.Base(filepath.Dir(path)) + ":" + strings.TrimSuffix(name, ".json")
+		if filepath.Dir(filepath.Dir(path)) != c.dir || !d.Type().IsRegular() || validateDigest(digest) != nil {
+			return nil
+		}
+
+		// Leftovers of interrupted writes.
+		if name != d.Name() {
+			return os.Remove(path)
+		}
+		if !strings.HasSuffix(name, ".json") {
+			if _, err := os.Stat(path + ".json"); os.IsNotExist(err) {
+				return os.Remove(path)
+			}
+			return nil
+		}
+		if _, err := c.read(digest); err != nil {
+			log.Printf("removing %s from the disk cache. error: %v", digest, err)
+			c.removeFiles(digest)
+			return nil
+		}
+		info, err := os.Stat(c.path(digest))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &diskEntry{digest: digest, size: info.Size(), used: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache dir: %w", err)
+	}
+
+	// Oldest first, so the most recently used charts end up in front.
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range entries {
+		c.entries[entry.digest] = c.order.PushFront(entry)
+		c.size += entry.size
+	}
+	c.expire(time.Now())
+	log.Printf("disk cache holds %d charts, %d bytes", len(c.entries), c.size)
+	return c, nil
+}
+
+// path returns the file holding the archive of digest, sha256/<hex>.
+func (c *diskCache) path(digest string) string {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return filepath.Join(c.dir, algorithm, encoded)
+}
+
+// read loads the chart stored under digest and checks its archive.
+func (c *diskCache) read(digest string) (*cachedChart, error) {
+	raw, err := os.ReadFile(c.path(digest) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var meta diskMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	data, err := os.ReadFile(c.path(digest))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != meta.SHA256 {
+		return nil, fmt.Errorf("archive doesn't match its digest sha256:%s", meta.SHA256)
+	}
+	return &cachedChart{Name: meta.Name, Version: meta.Version, Data: data}, nil
+}
+
+// write stores chart under digest, through temporary files renamed into
+// place so a crash never leaves a truncated archive behind.
+func (c *diskCache) write(digest string, chart *cachedChart) error {
+	path := c.path(digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(chart.Data)
+	meta, err := json.Marshal(diskMeta{Name: chart.Name, Version: chart.Version, SHA256: hex.EncodeToString(sum[:]), Added: time.Now()})
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{path, chart.Data}, {path + ".json", meta}} {
+		temp := file.path + ".tmp"
+		if err := os.WriteFile(temp, file.data, 0o644); err != nil {
+			os.Remove(temp)
+			return err
+		}
+		if err := os.Rename(temp, file.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *diskCache) removeFiles(digest string) {
+	os.Remove(c.path(digest) + ".json")
+	os.Remove(c.path(digest))
+}
+
+// get looks the chart up locally, then on disk. Charts found on disk are
+// kept locally too. Local hits count as uses of the copy on disk, so hot
+// charts aren't expired from it.
+func (c *diskCache) get(digest string) (*cachedChart, bool) {
+	now := time.Now()
+	if chart, ok := c.local.get(digest); ok {
+		c.mu.Lock()
+		if element, ok := c.entries[digest]; ok {
+			element.Value.(*diskEntry).used = now
+			c.order.MoveToFront(element)
+		}
+		c.mu.Unlock()
+		return chart, true
+	}
+
+	c.mu.Lock()
+	element, ok := c.entries[digest]
+	if ok && c.ttl > 0 && now.Sub(element.Value.(*diskEntry).used) > c.ttl {
+		c.drop(element)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	element.Value.(*diskEntry).used = now
+	c.order.MoveToFront(element)
+	c.mu.Unlock()
+
+	chart, err := c.read(digest)
+	if err != nil {
+		log.Printf("failed to read %s from the disk cache. error: %v", digest, err)
+		c.mu.Lock()
+		c.misses++
+		if element, ok := c.entries[digest]; ok {
+			c.drop(element)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	// The modification time records the last use across restarts.
+	os.Chtimes(c.path(digest), now, now)
+
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+	c.local.put(digest, chart)
+	return chart, true
+}
+
+func (c *diskCache) put(digest string, chart *cachedChart) {
+	c.local.put(digest, chart)
+
+	size := int64(len(chart.Data))
+	if validateDigest(digest) != nil || (c.max > 0 && size > c.max) {
+		return
+	}
+	c.mu.Lock()
+	_, ok := c.entries[digest]
+	c.mu.Unlock()
+	if ok {
+		return
+	}
+
+	if err := c.write(digest, chart); err != nil {
+		log.Printf("failed to write %s to the disk cache. error: %v", digest, err)
+		c.removeFiles(digest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[digest]; ok {
+		return
+	}
+	c.entries[digest] = c.order.PushFront(&diskEntry{digest: digest, size: size, used: time.Now()})
+	c.size += size
+	c.expire(time.Now())
+}
+
+// expire removes the charts unused for the ttl, then the least recently
+// used ones while over budget. The caller holds c.mu.
+func (c *diskCache) expire(now time.Time) {
+	for element := c.order.Back(); element != nil; {
+		entry := element.Value.(*diskEntry)
+		previous := element.Prev()
+		expired := c.ttl > 0 && now.Sub(entry.used) > c.ttl
+		if !expired && (c.max <= 0 || c.size <= c.max) {
+			return
+		}
+		c.drop(element)
+		c.evictions++
+		element = previous
+	}
+}
+
+// drop removes the chart of element. The caller holds c.mu.
+func (c *diskCache) drop(element *list.Element) {
+	entry := element.Value.(*diskEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.digest)
+	c.size -= entry.size
+	c.removeFiles(entry.digest)
+}
+
+func (c *diskCache) list(ctx context.Context) ([]cacheEntry, error) {
+	entries, err := c.local.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	var held []diskEntry
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		held = append(held, *element.Value.(*diskEntry))
+	}
+	c.mu.Unlock()
+
+	for _, entry := range held {
+		var meta diskMeta
+		if raw, err := os.ReadFile(c.path(entry.digest) + ".json"); err == nil {
+			json.Unmarshal(raw, &meta)
+		}
+		entries = append(entries, cacheEntry{
+			Digest:  entry.digest,
+			Chart:   meta.Name,
+			Version: meta.Version,
+			Tier:    "disk",
+			Bytes:   entry.size,
+			Added:   meta.Added,
+			Used:    entry.used,
+		})
+	}
+	return entries, nil
+}
+
+func (c *diskCache) remove(ctx context.Context, digest string) (bool, error) {
+	removed, err := c.local.remove(ctx, digest)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[digest]; ok {
+		c.drop(element)
+		removed = true
+	}
+	return removed, nil
+}
+
+func (c *diskCache) purge(ctx context.Context) (int, error) {
+	removed, err := c.local.purge(ctx)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed += len(c.entries)
+	for element := c.order.Front(); element != nil; element = c.order.Front() {
+		c.drop(element)
+	}
+	return removed, nil
+}
+
+func (c *diskCache) stats() cacheStats {
+	stats := c.local.stats()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats.DiskHits, stats.DiskMisses, stats.DiskEvictions = c.hits, c.misses, c.evictions
+	stats.DiskEntries, stats.DiskBytes = len(c.entries), c.size
+	return stats
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{CacheDir: dir, CacheMaxBytes: 12}
+	digest := func(n string) string { return "sha256:" + strings.Repeat(n, 64) }
+	chart := func(version string) *cachedChart {
+		return &cachedChart{Name: "nginx", Version: version, Data: []byte("chart" + version)}
+	}
+
+	cache, err := newDiskCache(config, noCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.put(digest("1"), chart("1"))
+	cache.put(digest("2"), chart("2"))
+	if _, ok := cache.get(digest("1")); !ok {
+		t.Errorf("get(%s) missed a chart just stored", digest("1"))
+	}
+
+	// Reopened, the cache keeps what was stored but the least recently used
+	// chart, evicted over the budget, and the one whose archive was
+	// corrupted.
+	cache.put(digest("3"), chart("3"))
+	os.WriteFile(filepath.Join(dir, "sha256", strings.Repeat("3", 64)), []byte("corrupted"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sha256", strings.Repeat("4", 64)+".tmp"), []byte("chart4"), 0o644)
+	cache, err = newDiskCache(config, noCache{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range map[string]bool{"1": true, "2": false, "3": false} {
+		got, ok := cache.get(digest(n))
+		if ok != want {
+			t.Errorf("get(%s) after reopening = %v, want %v", digest(n), ok, want)
+		} else if ok && !bytes.Equal(got.Data, chart("1").Data) {
+			t.Errorf("get(%s) = %q, want %q", digest(n), got.Data, chart("1").Data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sha256", strings.Repeat("4", 64)+".tmp")); !os.IsNotExist(err) {
+		t.Errorf("reopening kept an interrupted write")
+	}
+
+	// Files the cache didn't lay out are left alone.
+	foreign := []string{"notes.txt", "sha256/README", "sha256/" + strings.Repeat("5", 63), "other/" + strings.Repeat("6", 64), "deep/sha256/" + strings.Repeat("7", 64)}
+	for _, name := range foreign {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		os.WriteFile(filepath.Join(dir, name), []byte("keep"), 0o644)
+	}
+	os.WriteFile(filepath.Join(dir, "sha256", strings.Repeat("8", 64)), []byte("orphan"), 0o644)
+	if _, err := newDiskCache(config, noCache{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("reopening removed %s, which isn't the cache's", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sha256", strings.Repeat("8", 64))); !os.IsNotExist(err) {
+		t.Errorf("reopening kept an archive without metadata")
+	}
+
+	config.CacheTTL = time.Minute
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "sha256", strings.Repeat("1", 64)), old, old)
+	if cache, err = newDiskCache(config, noCache{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.get(digest("1")); ok {
+		t.Errorf("get(%s) found a chart unused for longer than the ttl", digest("1"))
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+const (
+	// immutableCacheControl is sent for downloads addressed by digest, whose
+	// content can never change.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+
+	// tagCacheControl is sent for downloads addressed by tag. Tags can be
+	// moved, so caches must revalidate, which the ETag makes cheap.
+	tagCacheControl = "public, no-cache"
+)
+
+// chartDownloader serves chart archives, answering conditional requests
+// from the catalog before pulling anything upstream and range requests from
+// the chart cache.
+type chartDownloader struct {
+	live     *liveConfig
+	client   *registry.Client
+	logins   *loginCache
+	cache    chartCache
+	stats    *downloadStats
+	clients  *clientStats
+	policy   *downloadPolicy
+	iam      *iamChecker
+	audit    *auditLog
+	upstream *pullThrough
+	lazy     *lazyCatalog
+	stale    *staleCharts
+
+	// aborted counts downloads the client went away from before the
+	// archive was sent.
+	aborted atomic.Int64
+}
+
+// routes registers the chart download routes on router. HEAD answers from
+// the catalog without pulling the chart, letting deployment tooling check a
+// version exists before installing it.
+func (d *chartDownloader) routes(router chi.Router) {
+	registerDownloadsAPI(router, d, nil)
+}
+
+func (d *chartDownloader) handleDownloadByDigest(w http.ResponseWriter, r *http.Request) {
+	var assetName = chi.URLParam(r, "assetName")
+	var assetSHA = chi.URLParam(r, "assetSHA")
+	log.Println(assetName, assetSHA)
+	if asset := repositoryFor(r).findByDigest(assetName, assetSHA); asset != nil {
+		d.serve(w, r, asset, true)
+		return
+	}
+	if validateDigest(assetSHA) == nil {
+		d.serveUpstream(w, r, assetName, assetSHA, true)
+		return
+	}
+	d.serveDigestPrefix(w, r, assetName, assetSHA)
+}
+
+func (d *chartDownloader) handleDownloadByTag(w http.ResponseWriter, r *http.Request) {
+	var assetName = chi.URLParam(r, "assetName")
+	var assetTag = chi.URLParam(r, "assetTag")
+	if asset := repositoryFor(r).findByTag(assetName, assetTag); asset != nil {
+		d.serve(w, r, asset, false)
+		return
+	}
+
+	// helm --verify fetches the chart URL from the index with .prov
+	// appended.
+	if tag, ok := strings.CutSuffix(assetTag, ".prov"); ok {
+		if asset := repositoryFor(r).findByTag(assetName, tag); asset != nil {
+			d.serveProvenance(w, r, asset)
+			return
+		}
+	}
+	d.serveUpstream(w, r, assetName, assetTag, false)
+}
+
+// handleDownloadNested dispatches the download routes of nested chart
+// names, such as team/app@<digest> or team/app:<tag>, which the single
+// segment patterns can't match, filling in the URL parameters those
+// handlers read.
+func (d *chartDownloader) handleDownloadNested(w http.ResponseWriter, r *http.Request) {
+	path := chi.URLParam(r, "*")
+	params := &chi.RouteContext(r.Context()).URLParams
+	dir, base := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir, base = path[:i+1], path[i+1:]
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".tgz.prov"):
+		params.Add("file", path)
+		d.handleProvenanceFile(w, r)
+	case strings.HasSuffix(path, ".tgz"):
+		params.Add("archive", path)
+		d.handleDownloadArchive(w, r)
+	case strings.Contains(base, "@"):
+		name, digest, _ := strings.Cut(base, "@")
+		params.Add("assetName", dir+name)
+		params.Add("assetSHA", digest)
+		d.handleDownloadByDigest(w, r)
+	case strings.Contains(base, ":"):
+		name, tag, _ := strings.Cut(base, ":")
+		params.Add("assetName", dir+name)
+		params.Add("assetTag", tag)
+		d.handleDownloadByTag(w, r)
+	case base == "latest" && dir != "":
+		params.Add("assetName", strings.TrimSuffix(dir, "/"))
+		d.handleDownloadLatest(w, r)
+	default:
+		params.Add("assetName", path)
+		d.handleDownloadByRange(w, r)
+	}
+}
+
+// serve writes the chart archive of asset. byDigest tells whether the
+// request addressed the asset by digest rather than by a mutable tag. HEAD
+// requests aren't downloads: they are neither audited nor counted.
+func (d *chartDownloader) serve(w http.ResponseWriter, r *http.Request, asset *Asset, byDigest bool) {
+	counter := &countingWriter{ResponseWriter: w}
+	if r.Method != http.MethodHead {
+		defer func() {
+			d.audit.record(newAuditEvent(r, asset, counter.status, counter.written))
+		}()
+	}
+	w = counter
+
+	config, backend := d.live.get()
+	if config.DownloadBytesPerSecond > 0 {
+		w = newThrottledWriter(w, r.Context(), config.DownloadBytesPerSecond)
+	}
+
+	// Signed URLs were checked against both when they were issued.
+	if !signedDownload(r) && !d.authorize(w, r, backend, asset) {
+		return
+	}
+
+	var profile *Profile
+	if name := r.URL.Query().Get("profile"); name != "" {
+		if profile = config.profile(name); profile == nil {
+			http.Error(w, fmt.Sprintf("unknown profile %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Every representation is served from the same URL.
+	w.Header().Add("Vary", "Accept")
+	want, err := negotiate(r)
+	if errors.Is(err, errNotAcceptable) {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if want.format != tgzFormat && profile != nil {
+		http.Error(w, "profiles only apply to chart archives", http.StatusBadRequest)
+		return
+	}
+
+	if want.format == jsonFormat {
+		serveMetadata(w, r, asset)
+		return
+	}
+
+	if r.Method != http.MethodHead {
+		d.clients.record(r.UserAgent())
+	}
+
+	etag := assetETag(asset)
+	if profile != nil {
+		etag = profile.etag(etag)
+	}
+	if want.format != tgzFormat {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + want.format + `"`
+	}
+	w.Header().Set("ETag", etag)
+	if !asset.Updated.IsZero() {
+		w.Header().Set("Last-Modified", asset.Updated.UTC().Format(http.TimeFormat))
+	}
+	if byDigest {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", tagCacheControl)
+	}
+
+	if notModified(r, etag, asset.Updated) {
+		if r.Method != http.MethodHead {
+			d.recordDownload(r, asset, 0)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		redirectable := config.OversizedArtifacts == redirectOversized && want.format == tgzFormat && profile == nil
+		if oversized(config, asset) && !redirectable {
+			refuseOversized(w, config, asset)
+			return
+		}
+		d.serveHead(w, asset, want, profile)
+		return
+	}
+
+	if oversized(config, asset) {
+		// Oversized charts never go through the proxy, so profiles can't
+		// be applied to them.
+		if config.OversizedArtifacts == redirectOversized && want.format == tgzFormat && profile == nil && redirectAsset(w, r, backend, asset) {
+			oversizedArtifacts.WithLabelValues("redirected").Inc()
+			d.recordDownload(r, asset, 0)
+			d.stats.recordPull(asset.Size)
+			return
+		}
+		refuseOversized(w, config, asset)
+		return
+	}
+
+	if want.format == ociLayoutFormat {
+		if want.mediaType != "" {
+			w.Header().Set("Content-Type", want.mediaType)
+		}
+		d.serveOCILayout(w, r, backend, asset, counter)
+		return
+	}
+
+	// Profiles are applied to the archive, which has to go through the
+	// proxy for that.
+	if config.Redirect && profile == nil && redirectAsset(w, r, backend, asset) {
+		// The client downloads straight from the registry, which still
+		// bills the egress.
+		d.recordDownload(r, asset, 0)
+		d.stats.recordPull(asset.Size)
+		return
+	}
+
+	chart, stale, err := d.pull(r.Context(), config, backend, asset, byDigest)
+	if err != nil {
+		log.Printf("failed to pull %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to pull chart", http.StatusBadGateway)
+		return
+	}
+	modified := asset.Updated
+	if stale != "" {
+		// The archive isn't the one the tag points to now, so it must not
+		// be stored or resumed against the current version.
+		etag = assetETag(&Asset{SHA: stale})
+		if profile != nil {
+			etag = profile.etag(etag)
+		}
+		modified = time.Time{}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Warning", staleWarning)
+		w.Header().Del("Last-Modified")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.tgz", chart.Name, chart.Version))
+
+	data := chart.Data
+	if profile != nil {
+		if data, err = profile.apply(chart.Data); err != nil {
+			log.Printf("failed to apply profile %s to %s. error: %v", profile.Name, asset.RawName, err)
+			http.Error(w, "failed to apply profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// ServeContent answers Range and If-Range requests, letting clients
+	// resume interrupted downloads.
+	contentType := chartContentType(data)
+	if want.mediaType != "" && isGzip(data) {
+		contentType = want.mediaType
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(data))
+	if r.Context().Err() != nil {
+		d.aborted.Add(1)
+	}
+	d.recordDownload(r, asset, counter.written)
+}
+
+// authorize runs the IAM check and the download policy on a download of
+// asset, answering the request when it is denied.
+func (d *chartDownloader) authorize(w http.ResponseWriter, r *http.Request, backend Backend, asset *Asset) bool {
+	allowed, reason, err := d.iam.authorize(r, backend, asset)
+	if errors.Is(err, errNoCaller) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gcp-oci-proxy"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		log.Printf("failed to check iam permissions for %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to check iam permissions", http.StatusServiceUnavailable)
+		return false
+	}
+	if !allowed {
+		log.Printf("iam check denied download of %s: %s", asset.RawName, reason)
+		http.Error(w, "download denied: "+reason, http.StatusForbidden)
+		return false
+	}
+
+	allowed, reason, err = d.policy.authorize(r, asset)
+	if err != nil {
+		log.Printf("failed to evaluate download policy for %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to evaluate download policy", http.StatusServiceUnavailable)
+		return false
+	}
+	if !allowed {
+		log.Printf("policy denied download of %s: %s", asset.RawName, reason)
+		http.Error(w, strings.TrimSpace("download denied by policy. "+reason), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// serveHead writes the headers a download of asset would get, without
+// pulling it. The length is exact once the chart is cached, and otherwise
+// the size the registry reports; archives with a profile applied have
+// none.
+func (d *chartDownloader) serveHead(w http.ResponseWriter, asset *Asset, want representation, profile *Profile) {
+	w.Header().Set("Docker-Content-Digest", asset.SHA)
+	switch {
+	case want.format == ociLayoutFormat:
+		w.Header().Set("Content-Type", ociLayoutMediaType)
+	case profile != nil:
+		w.Header().Set("Content-Type", "application/gzip")
+	default:
+		contentType, size := "application/gzip", asset.Size
+		if chart, ok := d.cache.get(asset.SHA); ok {
+			contentType, size = chartContentType(chart.Data), int64(len(chart.Data))
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.tgz", chart.Name, chart.Version))
+		}
+		if want.mediaType != "" && contentType == "application/gzip" {
+			contentType = want.mediaType
+		}
+		w.Header().Set("Content-Type", contentType)
+		if size > 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveUpstream serves a chart missing from the catalog after looking it
+// up in the repository with the lazy catalog, or from the pull-through
+// upstream, if any.
+func (d *chartDownloader) serveUpstream(w http.ResponseWriter, r *http.Request, name, reference string, byDigest bool) {
+	asset, err := d.lazy.resolve(r.Context(), name, reference)
+	if err != nil {
+		log.Printf("failed to look up %s:%s. error: %v", name, reference, err)
+		http.Error(w, "failed to look up chart", http.StatusBadGateway)
+		return
+	}
+	if asset != nil {
+		d.serve(w, r, asset, byDigest)
+		return
+	}
+
+	config, _ := d.live.get()
+	if d.upstream == nil {
+		chartNotFound(w, config, repositoryFor(r), name, reference)
+		return
+	}
+
+	asset, err = d.upstream.resolve(r.Context(), name, reference)
+	if err != nil {
+		log.Printf("failed to resolve %s:%s upstream. error: %v", name, reference, err)
+		http.Error(w, "failed to resolve chart upstream", http.StatusBadGateway)
+		return
+	}
+	if asset == nil {
+		chartNotFound(w, config, repositoryFor(r), name, reference)
+		return
+	}
+	d.serve(w, r, asset, byDigest)
+}
+
+// serveDigestPrefix serves the chart called name whose digest is the only
+// one starting with prefix, like docker accepts abbreviated image IDs. The
+// match may become ambiguous as charts are pushed, so it isn't cached as
+// immutable.
+func (d *chartDownloader) serveDigestPrefix(w http.ResponseWriter, r *http.Request, name, prefix string) {
+	encoded := prefix
+	if algorithm, rest, ok := strings.Cut(prefix, ":"); ok {
+		if !digestAlgorithm.MatchString(algorithm) {
+			http.NotFound(w, r)
+			return
+		}
+		encoded = rest
+	}
+	if !hexDigits.MatchString(encoded) {
+		http.NotFound(w, r)
+		return
+	}
+
+	matches := repositoryFor(r).findByDigestPrefix(name, prefix)
+	switch len(matches) {
+	case 0:
+		config, _ := d.live.get()
+		chartNotFound(w, config, repositoryFor(r), name, prefix)
+	case 1:
+		d.serve(w, r, matches[0], false)
+	default:
+		digests := make([]string, 0, len(matches))
+		for _, asset := range matches {
+			digests = append(digests, asset.SHA)
+		}
+		http.Error(w, fmt.Sprintf("ambiguous digest prefix %q matches %s", prefix, strings.Join(digests, ", ")), http.StatusConflict)
+	}
+}
+
+// recordDownload counts a download of asset that sent bytes to the client.
+func (d *chartDownloader) recordDownload(r *http.Request, asset *Asset, bytes int64) {
+	d.stats.record(asset.SHA, bytes)
+	chartDownloads.WithLabelValues(asset.Name, requestedVersion(r, asset)).Inc()
+}
+
+// countingWriter counts the body bytes written through it and remembers
+// the response status.
+type countingWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// assetETag returns the strong entity tag of asset, derived from its digest.
+func assetETag(asset *Asset) string {
+	_, hex, _ := strings.Cut(asset.SHA, ":")
+	return `"` + hex + `"`
+}
+
+// notModified reports whether the client already holds the current version
+// of a resource with the given entity tag and modification time. As in RFC
+// 9110, If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
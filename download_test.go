@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// FuzzDownloadRoutes sends arbitrary paths through the download routes
+// with an empty catalog: nothing may be served and nothing may panic.
+func FuzzDownloadRoutes(f *testing.F) {
+	for _, seed := range []string{
+		"/nginx@sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+		"/nginx:1.2.3",
+		"/nginx:1.2.3.prov",
+		"/nginx-1.2.3.tgz.prov",
+		"/my-chart-1.0.0-rc.1.tgz.prov",
+		"/nginx?version=~1.2",
+		"/nginx?version=%3E%3D",
+		"/nginx/latest",
+		"/a@b@c:d",
+		"/%2e%2e/%2F",
+		"/:",
+		"/@",
+	} {
+		f.Add(seed)
+	}
+
+	d := &chartDownloader{live: &liveConfig{config: &Config{NotFoundSuggestions: 5}}}
+	router := chi.NewRouter()
+	d.routes(router)
+
+	f.Fuzz(func(t *testing.T, target string) {
+		if len(target) == 0 || target[0] != '/' {
+			return
+		}
+
+		req, err := http.NewRequest(http.MethodGet, "http://proxy"+target, nil)
+		if err != nil {
+			return
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code < 400 {
+			t.Fatalf("GET %s answered %d from an empty catalog", target, w.Code)
+		}
+	})
+}
+
+// TestPullThroughByteIdentical pulls a chart through the proxy from an
+// upstream registry and checks the client gets the layer exactly as stored.
+// The layer is gzipped with unusual settings, so recompressing it anywhere
+// on the way would change its bytes.
+func TestPullThroughByteIdentical(t *testing.T) {
+	var tgz bytes.Buffer
+	compressed, _ := gzip.NewWriterLevel(&tgz, gzip.BestSpeed)
+	compressed.Name = "nginx-1.0.0.tgz"
+	compressed.ModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	archive := tar.NewWriter(compressed)
+	chartYAML := []byte("apiVersion: v2\nname: nginx\nversion: 1.0.0\n")
+	archive.WriteHeader(&tar.Header{Name: "nginx/Chart.yaml", Mode: 0o644, Size: int64(len(chartYAML))})
+	archive.Write(chartYAML)
+	archive.Close()
+	compressed.Close()
+
+	const manifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	layer := tgz.Bytes()
+	config := []byte(`{"apiVersion":"v2","name":"nginx","version":"1.0.0"}`)
+	blobs := map[string][]byte{
+		"sha256:" + sha256Hex(layer):  layer,
+		"sha256:" + sha256Hex(config): config,
+	}
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     manifestMediaType,
+		"config":        map[string]interface{}{"mediaType": registry.ConfigMediaType, "digest": "sha256:" + sha256Hex(config), "size": len(config)},
+		"layers":        []interface{}{map[string]interface{}{"mediaType": registry.ChartLayerMediaType, "digest": "sha256:" + sha256Hex(layer), "size": len(layer)}},
+	})
+	manifestDigest := "sha256:" + sha256Hex(manifest)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/charts/nginx/manifests/1.0.0" || r.URL.Path == "/v2/charts/nginx/manifests/"+manifestDigest:
+			w.Header().Set("Content-Type", manifestMediaType)
+			w.Header().Set("Docker-Content-Digest", manifestDigest)
+			w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+			if r.Method != http.MethodHead {
+				w.Write(manifest)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v2/charts/nginx/blobs/"):
+			blob, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/charts/nginx/blobs/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+			w.Write(blob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// Helm talks plain HTTP to registries on localhost, so the registry is
+	// reached as example.com, which the test certificate is valid for.
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	previous := upstreamClient
+	upstreamClient = &http.Client{Transport: transport}
+	defer func() { upstreamClient = previous }()
+
+	client, err := registry.NewClient(registry.ClientOptHTTPClient(upstreamClient), registry.ClientOptWriter(io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := &liveConfig{config: &Config{
+		Upstream:       "oci://example.com/charts",
+		UpstreamTagTTL: time.Minute,
+	}}
+	d := &chartDownloader{
+		live:     live,
+		client:   client,
+		logins:   newLoginCache(),
+		cache:    newMemoryCache(1 << 20),
+		stats:    newDownloadStats(),
+		clients:  newClientStats(),
+		policy:   newDownloadPolicy(live),
+		iam:      newIAMChecker(live),
+		upstream: newPullThrough(live),
+	}
+	router := chi.NewRouter()
+	d.routes(router)
+
+	// The second download is served from the chart cache.
+	for _, target := range []string{"/nginx:1.0.0", "/nginx@" + manifestDigest} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", target, w.Code, w.Body)
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+			t.Errorf("GET %s has Content-Encoding %q", target, encoding)
+		}
+		if !bytes.Equal(w.Body.Bytes(), layer) {
+			t.Errorf("GET %s returned %d bytes with digest sha256:%s, want the %d byte layer sha256:%s", target, w.Body.Len(), sha256Hex(w.Body.Bytes()), len(layer), sha256Hex(layer))
+		}
+	}
+}
+
+// listedBackend is a backend listing a fixed set of assets.
+type listedBackend struct {
+	assets []*Asset
+}
+
+func (b *listedBackend) Name() string { return "listed" }
+func (b *listedBackend) Host() string { return "" }
+func (b *listedBackend) Credential(ctx context.Context) (string, string, error) {
+	return "", "", nil
+}
+func (b *listedBackend) Close() error { return nil }
+func (b *listedBackend) List(ctx context.Context) ([]*Asset, error) {
+	assets := make([]*Asset, len(b.assets))
+	for i, asset := range b.assets {
+		copied := *asset
+		assets[i] = &copied
+	}
+	return assets, nil
+}
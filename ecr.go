@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ecrBackend lists charts from the repositories of an AWS Elastic Container
+// Registry through the ECR API and logs in with short-lived authorization
+// tokens.
+type ecrBackend struct {
+	config *Config
+	client *http.Client
+
+	mu       sync.Mutex
+	user     string
+	password string
+	expires  time.Time
+}
+
+type ecrRepository struct {
+	RepositoryName string `json:"repositoryName"`
+}
+
+type ecrImageDetail struct {
+	ImageDigest            string   `json:"imageDigest"`
+	ImageTags              []string `json:"imageTags"`
+	ImageManifestMediaType string   `json:"imageManifestMediaType"`
+	ImagePushedAt          float64  `json:"imagePushedAt"`
+	ImageSizeInBytes       int64    `json:"imageSizeInBytes"`
+}
+
+func newECRBackend(config *Config) (*ecrBackend, error) {
+	return &ecrBackend{config: config, client: upstreamClient}, nil
+}
+
+func (b *ecrBackend) Name() string {
+	return strings.TrimSuffix(b.Host()+"/"+b.config.Repository, "/")
+}
+
+func (b *ecrBackend) Host() string {
+	return fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", b.config.AWSAccountID, b.config.AWSRegion)
+}
+
+func (b *ecrBackend) Close() error {
+	return nil
+}
+
+// Credential exchanges the AWS credentials for a registry password that is
+// valid for twelve hours, reusing it until shortly before it expires.
+func (b *ecrBackend) Credential(ctx context.Context) (string, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Until(b.expires) > 5*time.Minute {
+		return b.user, b.password, nil
+	}
+
+	var resp struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+
+	req := map[string]interface{}{"registryIds": []string{b.config.AWSAccountID}}
+	if err := b.call(ctx, "GetAuthorizationToken", req, &resp); err != nil {
+		return "", "", err
+	}
+
+	if len(resp.AuthorizationData) == 0 {
+		return "", "", fmt.Errorf("ecr returned no authorization data")
+	}
+	data := resp.AuthorizationData[0]
+
+	token, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	user, password, ok := strings.Cut(string(token), ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid ecr authorization token")
+	}
+
+	b.user, b.password = user, password
+	b.expires = time.Unix(int64(data.ExpiresAt), 0)
+	return user, password, nil
+}
+
+func (b *ecrBackend) List(ctx context.Context) ([]*Asset, error) {
+	repositories, err := b.repositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var assets []*Asset
+	var skipped skippedEntries
+	for _, repository := range repositories {
+		images, err := b.images(ctx, repository)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range images {
+			rawName := fmt.Sprintf("%s/%s@%s", b.Host(), repository, image.ImageDigest)
+			_, sha, err := extractNameAndSha(rawName)
+			if err != nil {
+				skipped.skip(rawName, err)
+				continue
+			}
+
+			asset := &Asset{
+				Name:      chartName(repository, b.config.Repository),
+				SHA:       sha,
+				RawName:   rawName,
+				URI:       rawName,
+				MediaType: image.ImageManifestMediaType,
+				Size:      image.ImageSizeInBytes,
+			}
+			if image.ImagePushedAt > 0 {
+				asset.Updated = time.Unix(0, int64(image.ImagePushedAt*float64(time.Second)))
+				asset.Uploaded = asset.Updated
+			}
+
+			asset.Tags = append(asset.Tags, image.ImageTags...)
+
+			assets = append(assets, asset)
+		}
+	}
+	return assets, skipped.err()
+}
+
+// repositories returns the names of all repositories under the configured
+// prefix.
+func (b *ecrBackend) repositories(ctx context.Context) ([]string, error) {
+	var names []string
+	nextToken := ""
+	for {
+		req := map[string]interface{}{"registryId": b.config.AWSAccountID}
+		if nextToken != "" {
+			req["nextToken"] = nextToken
+		}
+
+		var resp struct {
+			Repositories []ecrRepository `json:"repositories"`
+			NextToken    string          `json:"nextToken"`
+		}
+		if err := b.call(ctx, "DescribeRepositories", req, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, repository := range resp.Repositories {
+			if strings.HasPrefix(repository.RepositoryName, b.config.Repository) {
+				names = append(names, repository.RepositoryName)
+			}
+		}
+
+		if resp.NextToken == "" {
+			return names, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+func (b *ecrBackend) images(ctx context.Context, repository string) ([]ecrImageDetail, error) {
+	var images []ecrImageDetail
+	nextToken := ""
+	for {
+		req := map[string]interface{}{
+			"registryId":     b.config.AWSAccountID,
+			"repositoryName": repository,
+		}
+		if nextToken != "" {
+			req["nextToken"] = nextToken
+		}
+
+		var resp struct {
+			ImageDetails []ecrImageDetail `json:"imageDetails"`
+			NextToken    string           `json:"nextToken"`
+		}
+		if err := b.call(ctx, "DescribeImages", req, &resp); err != nil {
+			return nil, err
+		}
+
+		images = append(images, resp.ImageDetails...)
+		if resp.NextToken == "" {
+			return images, nil
+		}
+		nextToken = resp.NextToken
+	}
+}
+
+// call invokes an ECR API operation using the AWS JSON 1.1 protocol.
+func (b *ecrBackend) call(ctx context.Context, operation string, in, out interface{}) error {
+	creds, err := awsCredentialsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://api.ecr.%s.amazonaws.com/", b.config.AWSRegion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921."+operation)
+	signV4(req, body, creds, b.config.AWSRegion, "ecr", time.Now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("ecr %s: %s %s %s", operation, resp.Status, apiErr.Type, apiErr.Message)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
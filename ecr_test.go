@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awsTestClient sends every request to server, whatever the AWS endpoint.
+func awsTestClient(server *httptest.Server) *http.Client {
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: transport.TLSClientConfig.RootCAs, ServerName: "example.com"}
+	return &http.Client{Transport: transport}
+}
+
+func TestECRList(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	digest := func(c string) string { return "sha256:" + strings.Repeat(c, 64) }
+
+	tests := []struct {
+		name      string
+		responses map[string][]string
+		want      []string
+		skipped   int
+		err       bool
+	}{
+		{
+			name: "paginated repositories and images",
+			responses: map[string][]string{
+				"DescribeRepositories": {
+					`{"repositories":[{"repositoryName":"charts/nginx"},{"repositoryName":"images/app"}],"nextToken":"2"}`,
+					`{"repositories":[{"repositoryName":"charts/redis"}]}`,
+				},
+				"DescribeImages charts/nginx": {
+					fmt.Sprintf(`{"imageDetails":[{"imageDigest":%q,"imageTags":["1.0.0"],"imagePushedAt":1600000000.5,"imageSizeInBytes":42}],"nextToken":"2"}`, digest("a")),
+					fmt.Sprintf(`{"imageDetails":[{"imageDigest":%q,"imageTags":["1.1.0","latest"]}]}`, digest("b")),
+				},
+				"DescribeImages charts/redis": {
+					fmt.Sprintf(`{"imageDetails":[{"imageDigest":%q},{"imageDigest":"sha256:short"}]}`, digest("c")),
+				},
+			},
+			want: []string{
+				"nginx@" + digest("a") + " 1.0.0 42 2020-09-13T12:26:40Z",
+				"nginx@" + digest("b") + " 1.1.0,latest 0 0001-01-01T00:00:00Z",
+				"redis@" + digest("c") + "  0 0001-01-01T00:00:00Z",
+			},
+			skipped: 1,
+		},
+		{
+			name:      "api errors fail the listing",
+			responses: map[string][]string{},
+			err:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := map[string]int{}
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || r.Host != "api.ecr.us-east-1.amazonaws.com" {
+					http.Error(w, `{"__type":"UnrecognizedClientException"}`, http.StatusForbidden)
+					return
+				}
+				var in struct {
+					RepositoryName string `json:"repositoryName"`
+					NextToken      string `json:"nextToken"`
+				}
+				json.NewDecoder(r.Body).Decode(&in)
+				key := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonEC2ContainerRegistry_V20150921.") + " " + in.RepositoryName)
+				pages := tt.responses[key]
+				page := calls[key]
+				if page >= len(pages) || (page > 0) != (in.NextToken != "") {
+					http.Error(w, `{"__type":"InvalidParameterException"}`, http.StatusBadRequest)
+					return
+				}
+				calls[key]++
+				w.Write([]byte(pages[page]))
+			}))
+			defer server.Close()
+
+			b, _ := newECRBackend(&Config{AWSAccountID: "123456789012", AWSRegion: "us-east-1", Repository: "charts"})
+			b.client = awsTestClient(server)
+			assets, err := b.List(context.Background())
+			var skipped skippedEntries
+			if errors.As(err, &skipped) {
+				err = nil
+			}
+			if (err != nil) != tt.err || len(skipped) != tt.skipped {
+				t.Fatalf("List() = %v, %d skipped, want error %v, %d skipped", err, len(skipped), tt.err, tt.skipped)
+			}
+
+			var got []string
+			for _, asset := range assets {
+				got = append(got, fmt.Sprintf("%s@%s %s %d %s", asset.Name, asset.SHA, strings.Join(asset.Tags, ","), asset.Size, asset.Uploaded.UTC().Format(time.RFC3339)))
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("List() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestECRCredential(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	tests := []struct {
+		name    string
+		token   string
+		expires time.Time
+		calls   int
+		err     bool
+	}{
+		{"reused until it expires", base64.StdEncoding.EncodeToString([]byte("AWS:password")), time.Now().Add(12 * time.Hour), 1, false},
+		{"renewed when about to expire", base64.StdEncoding.EncodeToString([]byte("AWS:password")), time.Now().Add(time.Minute), 2, false},
+		{"invalid token", base64.StdEncoding.EncodeToString([]byte("password")), time.Now().Add(12 * time.Hour), 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d}]}`, tt.token, tt.expires.Unix())
+			}))
+			defer server.Close()
+
+			b, _ := newECRBackend(&Config{AWSAccountID: "123456789012", AWSRegion: "us-east-1"})
+			b.client = awsTestClient(server)
+			for i := 0; i < 2; i++ {
+				user, password, err := b.Credential(context.Background())
+				if (err != nil) != tt.err || (!tt.err && (user != "AWS" || password != "password")) {
+					t.Fatalf("Credential() = %q, %q, %v", user, password, err)
+				}
+			}
+			if calls != tt.calls {
+				t.Errorf("Credential() twice called GetAuthorizationToken %d times, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// eventHistory is how many events /api/events keeps for clients
+	// resuming with Last-Event-ID.
+	eventHistory = 256
+	// eventKeepAlive is how often an idle stream gets a comment, so load
+	// balancers don't close it.
+	eventKeepAlive = 30 * time.Second
+	// eventSubscriberBuffer is how many events a client may lag behind
+	// before it is disconnected to resume from the history.
+	eventSubscriberBuffer = 64
+)
+
+// catalogEvent is a chartEvent numbered for Last-Event-ID.
+type catalogEvent struct {
+	id uint64
+	chartEvent
+}
+
+// eventStream streams catalog changes to /api/events clients as
+// Server-Sent Events: a chart.version.published event for every version a
+// sync adds, and a chart.version.removed event for every version it no
+// longer finds.
+type eventStream struct {
+	mu          sync.Mutex
+	next        uint64
+	history     []catalogEvent
+	subscribers map[chan catalogEvent]bool
+	closed      bool
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{next: 1, subscribers: map[chan catalogEvent]bool{}}
+}
+
+// catalogChanged publishes the versions added and removed between previous
+// and current. The first load of the catalog isn't announced.
+func (s *eventStream) catalogChanged(previous, current *Repository) {
+	if previous.Revision == 0 {
+		return
+	}
+
+	now := time.Now()
+	events := newVersions(previous, current, now)
+	for _, event := range newVersions(current, previous, now) {
+		event.Event = "chart.version.removed"
+		events = append(events, event)
+	}
+	for _, event := range events {
+		s.publish(event)
+	}
+}
+
+func (s *eventStream) publish(event chartEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	numbered := catalogEvent{id: s.next, chartEvent: event}
+	s.next++
+	s.history = append(s.history, numbered)
+	if len(s.history) > eventHistory {
+		s.history = s.history[len(s.history)-eventHistory:]
+	}
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- numbered:
+		default:
+			// The client falls behind; it reconnects and resumes from
+			// the history.
+			delete(s.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// subscribe returns the events after lastID still in the history and a
+// channel receiving the following ones.
+func (s *eventStream) subscribe(lastID uint64) ([]catalogEvent, chan catalogEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missed []catalogEvent
+	for _, event := range s.history {
+		if event.id > lastID {
+			missed = append(missed, event)
+		}
+	}
+
+	subscriber := make(chan catalogEvent, eventSubscriberBuffer)
+	if s.closed {
+		close(subscriber)
+		return missed, subscriber
+	}
+	s.subscribers[subscriber] = true
+	return missed, subscriber
+}
+
+func (s *eventStream) unsubscribe(subscriber chan catalogEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[subscriber] {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// close ends every stream once it sent the events already queued, so they
+// don't hold up shutdown.
+func (s *eventStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for subscriber := range s.subscribers {
+		delete(s.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// handleEvents streams catalog changes, optionally only those of ?chart.
+// Clients reconnecting with Last-Event-ID first get the events they
+// missed, as far back as the history goes.
+func (s *eventStream) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", header), http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+	chart := r.URL.Query().Get("chart")
+
+	missed, subscriber := s.subscribe(lastID)
+	defer s.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	send := func(event catalogEvent) error {
+		if chart != "" && event.Chart != chart {
+			return nil
+		}
+		data, err := json.Marshal(event.chartEvent)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.Event, data)
+		return err
+	}
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-subscriber:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventStream(t *testing.T) {
+	stream := newEventStream()
+	previous := &Repository{Revision: 1, Assets: []*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}}
+	current := &Repository{Revision: 2, Assets: []*Asset{{Name: "redis", SHA: "sha256:2", Tags: []string{"2.0.0"}}}}
+	stream.catalogChanged(&Repository{}, previous)
+	stream.catalogChanged(previous, current)
+
+	tests := []struct {
+		lastID uint64
+		want   []string
+	}{
+		{0, []string{"chart.version.published redis", "chart.version.removed nginx"}},
+		{1, []string{"chart.version.removed nginx"}},
+		{2, nil},
+	}
+	for _, tt := range tests {
+		missed, subscriber := stream.subscribe(tt.lastID)
+		stream.unsubscribe(subscriber)
+
+		var got []string
+		for _, event := range missed {
+			got = append(got, event.Event+" "+event.Chart)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("events after %d = %v, want %v", tt.lastID, got, tt.want)
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(stream.handleEvents))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "?chart=nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	stream.catalogChanged(current, previous)
+	stream.close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "id: 2\nevent: chart.version.removed\n") || !strings.Contains(string(body), "id: 3\nevent: chart.version.published\n") || strings.Contains(string(body), "redis") {
+		t.Errorf("stream of nginx = %q, want its removal and publication only", body)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Exit codes of the serve command, so supervisors and runbooks can tell a
+// bad configuration from rejected credentials or a listener that failed.
+const (
+	exitFailure    = 1
+	exitConfig     = 2
+	exitCredential = 3
+	exitListener   = 4
+)
+
+// exitError is an error that ends the process with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for err. Registries refusing the
+// credentials count as credential errors wherever they happened.
+func exitCode(err error) int {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		return exit.code
+	}
+
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return exitCredential
+	}
+	if errors.Is(err, errUnauthorized) {
+		return exitCredential
+	}
+	return exitFailure
+}
+
+// shutdownReport summarizes a serve run, logged when it stops.
+type shutdownReport struct {
+	Started          time.Time  `json:"started"`
+	Uptime           string     `json:"uptime"`
+	Requests         int64      `json:"requests"`
+	AbortedTransfers int64      `json:"abortedTransfers"`
+	Cache            cacheStats `json:"cache"`
+	Error            string     `json:"error,omitempty"`
+	ExitCode         int        `json:"exitCode"`
+
+	requests atomic.Int64
+}
+
+func newShutdownReport() *shutdownReport {
+	return &shutdownReport{Started: time.Now()}
+}
+
+// middleware counts the requests served.
+func (s *shutdownReport) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// log writes the report of a run that ended with err, nil on a clean stop.
+func (s *shutdownReport) log(downloads *chartDownloader, err error) {
+	s.Uptime = time.Since(s.Started).Round(time.Second).String()
+	s.Requests = s.requests.Load()
+	s.AbortedTransfers = downloads.aborted.Load()
+	s.Cache = downloads.cache.stats()
+	if err != nil {
+		s.Error = err.Error()
+		s.ExitCode = exitCode(err)
+	}
+
+	report, _ := json.Marshal(s)
+	log.Printf("shutdown report: %s", report)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	artifactregistry "cloud.google.com/go/artifactregistry/apiv1"
+	artifactregistrypb "cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
+	containeranalysis "google.golang.org/api/containeranalysis/v1"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// garBackend lists Docker images from an Artifact Registry repository.
+type garBackend struct {
+	config *Config
+	client *artifactregistry.Client
+
+	analysisMu sync.Mutex
+	analysis   *containeranalysis.Service
+}
+
+func newGARBackend(ctx context.Context, config *Config) (*garBackend, error) {
+	opts, err := googleClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Anonymous {
+		opts = []option.ClientOption{option.WithoutAuthentication()}
+	}
+
+	client, err := artifactregistry.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateLocation(ctx, config, client); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &garBackend{config: config, client: client}, nil
+}
+
+func (b *garBackend) Name() string {
+	return repositoryURI(b.config)
+}
+
+func (b *garBackend) Host() string {
+	return registryHost(b.config.Region)
+}
+
+func (b *garBackend) Credential(ctx context.Context) (string, string, error) {
+	return getCredential(b.config)
+}
+
+func (b *garBackend) Close() error {
+	return b.client.Close()
+}
+
+// containerAnalysis returns the Container Analysis client for the
+// repository's project, creating it on first use.
+func (b *garBackend) containerAnalysis(ctx context.Context) (*containeranalysis.Service, error) {
+	b.analysisMu.Lock()
+	defer b.analysisMu.Unlock()
+
+	if b.analysis != nil {
+		return b.analysis, nil
+	}
+
+	opts, err := googleClientOptions(b.config)
+	if err != nil {
+		return nil, err
+	}
+
+	service, err := containeranalysis.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container analysis client. error: %w", err)
+	}
+	b.analysis = service
+	return service, nil
+}
+
+func formatPath(config *Config) (string, error) {
+	return fmt.Sprintf(
+		"projects/%s/locations/%s/repositories/%s",
+		config.Project, config.Region, config.Repository,
+	), nil
+}
+
+func (b *garBackend) List(ctx context.Context) ([]*Asset, error) {
+	return b.list(ctx, time.Time{})
+}
+
+// listUpdatedSince lists the images updated at or after since. Images are
+// listed newest first, so the listing stops at the first older one.
+func (b *garBackend) listUpdatedSince(ctx context.Context, since time.Time) ([]*Asset, error) {
+	return b.list(ctx, since)
+}
+
+func (b *garBackend) list(ctx context.Context, since time.Time) ([]*Asset, error) {
+	formattedPath, err := formatPath(b.config)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &artifactregistrypb.ListDockerImagesRequest{
+		Parent: formattedPath,
+	}
+	if !since.IsZero() {
+		req.OrderBy = "update_time desc"
+	}
+
+	var assets []*Asset
+	var skipped skippedEntries
+	trace := syncTraceFrom(ctx)
+	token, attempt := "", 0
+	pager := iterator.NewPager(b.client.ListDockerImages(ctx, req), b.config.SyncPageSize, token)
+	for {
+		var page []*artifactregistrypb.DockerImage
+		start := time.Now()
+		next, err := pager.NextPage(&page)
+		if err != nil {
+			if !retryablePageError(err) || attempt >= b.config.SyncPageRetries {
+				return nil, fmt.Errorf("failed to list page %d of images. error: %w", trace.pages()+1, err)
+			}
+			attempt++
+			trace.retry()
+			log.Printf("failed to list images, retrying the page (%d/%d). error: %v", attempt, b.config.SyncPageRetries, err)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(jitter(time.Second << (attempt - 1))):
+			}
+
+			// The iterator gives up after its first error, so the listing
+			// resumes from the failed page with a new one.
+			pager = iterator.NewPager(b.client.ListDockerImages(ctx, req), b.config.SyncPageSize, token)
+			continue
+		}
+		attempt = 0
+		trace.page(len(page), time.Since(start))
+
+		for _, resp := range page {
+			if !since.IsZero() && resp.UpdateTime != nil && resp.UpdateTime.AsTime().Before(since) {
+				return assets, skipped.err()
+			}
+			if asset := garAsset(resp, &skipped); asset != nil {
+				assets = append(assets, asset)
+			}
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	return assets, skipped.err()
+}
+
+// lookup returns the image of the chart called name at reference, a tag
+// or a digest, or nil when the repository doesn't have it.
+func (b *garBackend) lookup(ctx context.Context, name, reference string) (*Asset, error) {
+	parent, err := formatPath(b.config)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := reference
+	if validateDigest(reference) != nil {
+		tag, err := b.client.GetTag(ctx, &artifactregistrypb.GetTagRequest{
+			Name: fmt.Sprintf("%s/packages/%s/tags/%s", parent, url.PathEscape(name), url.PathEscape(reference)),
+		})
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		digest = path.Base(tag.Version)
+	}
+
+	image, err := b.client.GetDockerImage(ctx, &artifactregistrypb.GetDockerImageRequest{
+		Name: fmt.Sprintf("%s/dockerImages/%s@%s", parent, url.PathEscape(name), digest),
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped skippedEntries
+	if asset := garAsset(image, &skipped); asset != nil {
+		return asset, nil
+	}
+	return nil, skipped.err()
+}
+
+// retryablePageError reports whether a failed page may list on a retry.
+func retryablePageError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// garAsset converts a listed image to an asset, or records it as skipped
+// and returns nil.
+func garAsset(resp *artifactregistrypb.DockerImage, skipped *skippedEntries) *Asset {
+	name, sha, err := extractNameAndSha(resp.Name)
+	if err != nil {
+		skipped.skip(resp.Name, err)
+		return nil
+	}
+
+	var asset *Asset = &Asset{
+		Name:      name,
+		SHA:       sha,
+		RawName:   resp.Name,
+		URI:       resp.Uri,
+		MediaType: resp.MediaType,
+		Size:      resp.ImageSizeBytes,
+	}
+	if resp.UpdateTime != nil {
+		asset.Updated = resp.UpdateTime.AsTime()
+	}
+	if resp.UploadTime != nil {
+		asset.Uploaded = resp.UploadTime.AsTime()
+	}
+	if resp.BuildTime != nil {
+		asset.Built = resp.BuildTime.AsTime()
+	}
+
+	asset.Tags = append(asset.Tags, resp.Tags...)
+	return asset
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryablePageError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{status.Error(codes.PermissionDenied, "denied"), false},
+		{status.Error(codes.InvalidArgument, "bad page token"), false},
+	}
+	for _, tt := range tests {
+		if got := retryablePageError(tt.err); got != tt.want {
+			t.Errorf("retryablePageError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// retentionPolicy decides which chart versions garbage collection removes.
+// A zero field disables its rule.
+type retentionPolicy struct {
+	// KeepLast keeps the newest KeepLast tagged versions of every chart.
+	KeepLast int
+	// UntaggedMaxAge removes untagged digests older than this.
+	UntaggedMaxAge time.Duration
+}
+
+func retentionPolicyFrom(config *Config) retentionPolicy {
+	return retentionPolicy{KeepLast: config.RetentionKeepLast, UntaggedMaxAge: config.RetentionUntaggedMaxAge}
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.KeepLast > 0 || p.UntaggedMaxAge > 0
+}
+
+// gcCandidate is an asset the retention policy would delete, and why.
+type gcCandidate struct {
+	Name         string     `json:"name"`
+	Versions     []string   `json:"versions"`
+	Digest       string     `json:"digest"`
+	SizeBytes    int64      `json:"size_bytes"`
+	Updated      *time.Time `json:"updated,omitempty"`
+	LastDownload *time.Time `json:"last_download,omitempty"`
+	Rule         string     `json:"rule"`
+
+	asset *Asset
+}
+
+// gcPlan lists everything a garbage collection run would delete.
+type gcPlan struct {
+	Generated  time.Time     `json:"generated"`
+	KeepLast   int           `json:"keep_last,omitempty"`
+	MaxAge     string        `json:"untagged_max_age,omitempty"`
+	TotalBytes int64         `json:"total_bytes"`
+	Candidates []gcCandidate `json:"candidates"`
+}
+
+// planRetention applies policy to the catalog as of now.
+func planRetention(repository *Repository, policy retentionPolicy, stats *downloadStats, now time.Time) *gcPlan {
+	plan := &gcPlan{Generated: now, KeepLast: policy.KeepLast, Candidates: []gcCandidate{}}
+	if policy.UntaggedMaxAge > 0 {
+		plan.MaxAge = policy.UntaggedMaxAge.String()
+	}
+
+	add := func(asset *Asset, rule string) {
+		candidate := gcCandidate{
+			Name:      asset.Name,
+			Versions:  []string{},
+			Digest:    asset.SHA,
+			SizeBytes: asset.Size,
+			Rule:      rule,
+			asset:     asset,
+		}
+		for _, tag := range asset.Tags {
+			candidate.Versions = append(candidate.Versions, tag)
+		}
+		if !asset.Updated.IsZero() {
+			updated := asset.Updated
+			candidate.Updated = &updated
+		}
+		if last := stats.get(asset.SHA).Last; !last.IsZero() {
+			candidate.LastDownload = &last
+		}
+
+		plan.Candidates = append(plan.Candidates, candidate)
+		plan.TotalBytes += asset.Size
+	}
+
+	tagged := map[string][]*Asset{}
+	var names []string
+	repository.each(func(asset *Asset) {
+		if len(asset.Tags) == 0 {
+			if policy.UntaggedMaxAge > 0 && !asset.Updated.IsZero() && now.Sub(asset.Updated) > policy.UntaggedMaxAge {
+				add(asset, fmt.Sprintf("untagged for more than %s", policy.UntaggedMaxAge))
+			}
+			return
+		}
+
+		if _, ok := tagged[asset.Name]; !ok {
+			names = append(names, asset.Name)
+		}
+		tagged[asset.Name] = append(tagged[asset.Name], asset)
+	})
+
+	if policy.KeepLast > 0 {
+		sort.Strings(names)
+		for _, name := range names {
+			assets := tagged[name]
+			sort.SliceStable(assets, func(i, j int) bool { return newerAsset(assets[i], assets[j]) })
+			for _, asset := range assets[min(policy.KeepLast, len(assets)):] {
+				add(asset, fmt.Sprintf("beyond the last %d versions", policy.KeepLast))
+			}
+		}
+	}
+	return plan
+}
+
+// newerAsset orders the versions of a chart newest first: by the highest
+// semantic version among their tags, then by update time.
+func newerAsset(a, b *Asset) bool {
+	va, vb := highestVersion(a), highestVersion(b)
+	switch {
+	case va != nil && vb != nil && !va.Equal(vb):
+		return va.GreaterThan(vb)
+	case va != nil && vb == nil:
+		return true
+	case va == nil && vb != nil:
+		return false
+	}
+	return a.Updated.After(b.Updated)
+}
+
+func highestVersion(asset *Asset) *semver.Version {
+	var highest *semver.Version
+	for _, tag := range asset.Tags {
+		version, err := semver.NewVersion(tag)
+		if err == nil && (highest == nil || version.GreaterThan(highest)) {
+			highest = version
+		}
+	}
+	return highest
+}
+
+// handleGCPlan reports what garbage collection would delete under the
+// configured retention policy, as JSON or, with ?format=csv, as a
+// spreadsheet for approval.
+func handleGCPlan(w http.ResponseWriter, r *http.Request, live *liveConfig, stats *downloadStats) {
+	config, _ := live.get()
+	if config.LazyCatalog {
+		http.Error(w, "garbage collection needs the full catalog, which --lazy-catalog doesn't load", http.StatusConflict)
+		return
+	}
+	plan := planRetention(currentRepository(), retentionPolicyFrom(config), stats, time.Now())
+
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plan)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=gc-plan-%s.csv", plan.Generated.Format("20060102-150405")))
+	out := csv.NewWriter(w)
+	out.Write([]string{"name", "versions", "digest", "size_bytes", "updated", "last_download", "rule"})
+	for _, c := range plan.Candidates {
+		out.Write([]string{c.Name, strings.Join(c.Versions, " "), c.Digest, strconv.FormatInt(c.SizeBytes, 10), formatOptionalTime(c.Updated), formatOptionalTime(c.LastDownload), c.Rule})
+	}
+	out.Flush()
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
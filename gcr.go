@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+// gcrBackend lists charts from a legacy Container Registry (gcr.io) project
+// through the registry tags API, walking child repositories recursively.
+type gcrBackend struct {
+	config *Config
+	api    *registryAPI
+}
+
+// gcrTagsList is the gcr.io flavour of the tags list response, which also
+// reports child repositories and the tags attached to every manifest.
+type gcrTagsList struct {
+	Name     string                 `json:"name"`
+	Child    []string               `json:"child"`
+	Manifest map[string]gcrManifest `json:"manifest"`
+}
+
+type gcrManifest struct {
+	MediaType string   `json:"mediaType"`
+	Tag       []string `json:"tag"`
+}
+
+func newGCRBackend(config *Config) (*gcrBackend, error) {
+	b := &gcrBackend{config: config}
+	b.api = &registryAPI{host: config.GCRHost, credential: b.Credential}
+	return b, nil
+}
+
+func (b *gcrBackend) Name() string {
+	return path.Join(b.config.GCRHost, projectPath(b.config.Project), b.config.Repository)
+}
+
+func (b *gcrBackend) Host() string {
+	return b.config.GCRHost
+}
+
+func (b *gcrBackend) Credential(ctx context.Context) (string, string, error) {
+	return getCredential(b.config)
+}
+
+func (b *gcrBackend) Close() error {
+	return nil
+}
+
+func (b *gcrBackend) List(ctx context.Context) ([]*Asset, error) {
+	var skipped skippedEntries
+	assets, err := b.list(ctx, path.Join(projectPath(b.config.Project), b.config.Repository), &skipped)
+	if err != nil {
+		return nil, err
+	}
+	return assets, skipped.err()
+}
+
+func (b *gcrBackend) list(ctx context.Context, repository string, skipped *skippedEntries) ([]*Asset, error) {
+	var tags gcrTagsList
+	if _, err := b.api.getJSON(ctx, fmt.Sprintf("/v2/%s/tags/list", repository), &tags); err != nil {
+		return nil, err
+	}
+
+	var assets []*Asset
+	for digest, manifest := range tags.Manifest {
+		rawName := fmt.Sprintf("%s/%s@%s", b.config.GCRHost, repository, digest)
+		_, sha, err := extractNameAndSha(rawName)
+		if err != nil {
+			skipped.skip(rawName, err)
+			continue
+		}
+
+		asset := &Asset{
+			Name:      chartName(repository, path.Join(projectPath(b.config.Project), b.config.Repository)),
+			SHA:       sha,
+			RawName:   rawName,
+			URI:       rawName,
+			MediaType: manifest.MediaType,
+		}
+
+		asset.Tags = append(asset.Tags, manifest.Tag...)
+
+		assets = append(assets, asset)
+	}
+
+	for _, child := range tags.Child {
+		childAssets, err := b.list(ctx, path.Join(repository, child), skipped)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, childAssets...)
+	}
+
+	return assets, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGCRList(t *testing.T) {
+	digest := func(c string) string { return "sha256:" + strings.Repeat(c, 64) }
+	tests := []struct {
+		name      string
+		responses map[string]string
+		want      []string
+		skipped   int
+		err       bool
+	}{
+		{
+			name: "charts in child repositories",
+			responses: map[string]string{
+				"/v2/my-project/charts/tags/list":          `{"child":["nginx","team"],"manifest":{}}`,
+				"/v2/my-project/charts/nginx/tags/list":    fmt.Sprintf(`{"manifest":{%q:{"mediaType":"application/vnd.oci.image.manifest.v1+json","tag":["1.0.0","latest"]}}}`, digest("a")),
+				"/v2/my-project/charts/team/tags/list":     `{"child":["app"]}`,
+				"/v2/my-project/charts/team/app/tags/list": fmt.Sprintf(`{"manifest":{%q:{"tag":["2.0.0"]},%q:{}}}`, digest("b"), digest("c")),
+			},
+			want: []string{
+				"nginx@" + digest("a") + " 1.0.0,latest",
+				"team/app@" + digest("b") + " 2.0.0",
+				"team/app@" + digest("c") + " ",
+			},
+		},
+		{
+			name: "invalid digests are skipped",
+			responses: map[string]string{
+				"/v2/my-project/charts/tags/list": fmt.Sprintf(`{"manifest":{"sha256:short":{"tag":["1.0.0"]},%q:{"tag":["1.1.0"]}}}`, digest("d")),
+			},
+			want:    []string{"charts@" + digest("d") + " 1.1.0"},
+			skipped: 1,
+		},
+		{
+			name: "a missing child fails the listing",
+			responses: map[string]string{
+				"/v2/my-project/charts/tags/list": `{"child":["gone"]}`,
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, ok := tt.responses[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(body))
+			}))
+			defer server.Close()
+
+			b, _ := newGCRBackend(&Config{GCRHost: server.Listener.Addr().String(), Project: "my-project", Repository: "charts", Anonymous: true})
+			b.api.client = server.Client()
+			assets, err := b.List(context.Background())
+			var skipped skippedEntries
+			if errors.As(err, &skipped) {
+				err = nil
+			}
+			if (err != nil) != tt.err || len(skipped) != tt.skipped {
+				t.Fatalf("List() = %v, %d skipped, want error %v, %d skipped", err, len(skipped), tt.err, tt.skipped)
+			}
+
+			var got []string
+			for _, asset := range assets {
+				got = append(got, asset.Name+"@"+asset.SHA+" "+strings.Join(asset.Tags, ","))
+			}
+			sort.Strings(got)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("List() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
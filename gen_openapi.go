@@ -0,0 +1,171 @@
+//go:build ignore
+
+// gen_openapi.go generates openapi_gen.go from openapi.json: an interface
+// per tag with a handler method per operation, and a function registering
+// the operations of the tag on a chi router.
+//
+//	go generate
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Tags        []string              `json:"tags"`
+	Summary     string                `json:"summary"`
+	Security    []map[string][]string `json:"security"`
+	ChiPattern  string                `json:"x-chi-pattern"`
+	Parameters  []struct {
+		Name string `json:"name"`
+		In   string `json:"in"`
+	} `json:"parameters"`
+}
+
+type spec struct {
+	Tags []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	Paths map[string]map[string]*operation `json:"paths"`
+}
+
+// route is an operation as the generated code registers it.
+type route struct {
+	method, path, pattern string
+	op                    *operation
+	// handler is the operation the route is served by: a HEAD route is
+	// served by the GET handler of its path.
+	handler *operation
+}
+
+// initialisms are the words of operation ids Go spells in capitals.
+var initialisms = map[string]string{"ui": "UI", "gc": "GC", "openapi": "OpenAPI"}
+
+// handlerName returns the name of the method serving the operation id.
+func handlerName(id string) string {
+	var name strings.Builder
+	name.WriteString("handle")
+	start := 0
+	for i, r := range id + "X" {
+		if i == len(id) || (i > 0 && unicode.IsUpper(r)) {
+			word := id[start:i]
+			if initialism, ok := initialisms[word]; ok {
+				word = initialism
+			}
+			name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+			start = i
+		}
+	}
+	return name.String()
+}
+
+func main() {
+	data, err := os.ReadFile("openapi.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	var s spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		log.Fatal(err)
+	}
+
+	byTag := map[string][]route{}
+	for path, methods := range s.Paths {
+		for method, op := range methods {
+			pattern := path
+			if op.ChiPattern != "" {
+				pattern = op.ChiPattern
+			}
+			handler := op
+			if method == "head" && methods["get"] != nil {
+				handler = methods["get"]
+			}
+			if len(op.Tags) != 1 {
+				log.Fatalf("%s %s: expected one tag, got %v", method, path, op.Tags)
+			}
+			byTag[op.Tags[0]] = append(byTag[op.Tags[0]], route{method: method, path: path, pattern: pattern, op: op, handler: handler})
+		}
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintln(&out, "// Code generated by gen_openapi.go from openapi.json. DO NOT EDIT.")
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, "package main")
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, `import (`)
+	fmt.Fprintln(&out, `	"net/http"`)
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, `	"github.com/go-chi/chi"`)
+	fmt.Fprintln(&out, `)`)
+
+	for _, tag := range s.Tags {
+		routes := byTag[tag.Name]
+		// chi matches a URL parameter up to the character following it in
+		// the first pattern registered, so /{assetName}@{assetSHA} has to
+		// come before /{assetName}: paths are registered in reverse order.
+		sort.Slice(routes, func(i, j int) bool {
+			if routes[i].path != routes[j].path {
+				return routes[i].path > routes[j].path
+			}
+			return routes[i].method > routes[j].method
+		})
+		name := tag.Name + "API"
+
+		fmt.Fprintf(&out, "\n// %s serves the operations tagged %s.\n", name, tag.Name)
+		fmt.Fprintf(&out, "type %s interface {\n", name)
+		for _, route := range routes {
+			if route.handler != route.op {
+				continue
+			}
+			fmt.Fprintf(&out, "\t// %s serves %s %s: %s\n", handlerName(route.op.OperationID), strings.ToUpper(route.method), route.path, route.op.Summary)
+			fmt.Fprintf(&out, "\t%s(w http.ResponseWriter, r *http.Request)\n", handlerName(route.op.OperationID))
+		}
+		fmt.Fprintln(&out, "}")
+
+		register := "register" + strings.ToUpper(tag.Name[:1]) + tag.Name[1:] + "API"
+		fmt.Fprintf(&out, "\n// %s registers the operations tagged %s on router, each wrapped\n// with the middleware wrap returns for it.\n", register, tag.Name)
+		fmt.Fprintf(&out, "func %s(router chi.Router, server %s, wrap openAPIMiddleware) {\n", register, name)
+		for _, route := range routes {
+			admin := len(route.op.Security) > 0
+			idempotent := false
+			for _, parameter := range route.op.Parameters {
+				if parameter.In == "header" && parameter.Name == "Idempotency-Key" {
+					idempotent = true
+				}
+			}
+			fields := fmt.Sprintf("id: %q", route.op.OperationID)
+			if admin {
+				fields += ", admin: true"
+			}
+			if idempotent {
+				fields += ", idempotent: true"
+			}
+			pattern := strconv.Quote(route.pattern)
+			if strings.Contains(route.pattern, `\`) {
+				pattern = "`" + route.pattern + "`"
+			}
+			method := strings.ToUpper(route.method[:1]) + route.method[1:]
+			fmt.Fprintf(&out, "\topenAPIRouter(router, wrap, openAPIOperation{%s}).%s(%s, server.%s)\n",
+				fields, method, pattern, handlerName(route.handler.OperationID))
+		}
+		fmt.Fprintln(&out, "}")
+	}
+
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("openapi_gen.go", source, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
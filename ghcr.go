@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const ghcrHost = "ghcr.io"
+
+// newGHCRBackend returns an oci backend for the GitHub Container Registry,
+// which has no catalog API: the container packages of the owner named by
+// the first segment of --repository are listed through the GitHub REST API
+// instead, with the same token used to pull.
+func newGHCRBackend(config *Config) (*ociBackend, error) {
+	c := *config
+	if c.OCIRegistry == "" {
+		c.OCIRegistry = ghcrHost
+	}
+
+	b, err := newOCIBackend(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	github := &registryAPI{host: "api.github.com", staticToken: c.OCIToken}
+	b.discover = func(ctx context.Context) ([]string, error) {
+		return ghcrRepositories(ctx, github, c.Repository)
+	}
+	return b, nil
+}
+
+// ghcrRepositories lists the container packages of the owner of prefix, an
+// owner optionally followed by a package name prefix, as repositories.
+func ghcrRepositories(ctx context.Context, github *registryAPI, prefix string) ([]string, error) {
+	owner, _, _ := strings.Cut(prefix, "/")
+	query := url.Values{"package_type": {"container"}, "per_page": {"100"}}.Encode()
+
+	// The owner is either an organization or a user.
+	names, err := ghcrPackages(ctx, github, fmt.Sprintf("/orgs/%s/packages?%s", url.PathEscape(owner), query))
+	if errors.Is(err, errNotFound) {
+		names, err = ghcrPackages(ctx, github, fmt.Sprintf("/users/%s/packages?%s", url.PathEscape(owner), query))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list container packages of %s: %w", owner, err)
+	}
+
+	var repositories []string
+	for _, name := range names {
+		if repository := owner + "/" + name; strings.HasPrefix(repository, prefix) {
+			repositories = append(repositories, repository)
+		}
+	}
+	return repositories, nil
+}
+
+func ghcrPackages(ctx context.Context, github *registryAPI, next string) ([]string, error) {
+	var names []string
+	for next != "" {
+		var page []struct {
+			Name string `json:"name"`
+		}
+
+		var err error
+		next, err = github.getJSON(ctx, next, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, pkg := range page {
+			names = append(names, pkg.Name)
+		}
+	}
+	return names, nil
+}
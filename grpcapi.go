@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// grpcService is the full name of the gRPC service, described for
+	// clients in proto/catalog.proto.
+	grpcService = "gcpociproxy.v1.Catalog"
+	// grpcChunkSize is how many bytes of an archive each Pull message
+	// carries.
+	grpcChunkSize = 256 << 10
+)
+
+// catalogProto describes the messages of proto/catalog.proto. There is no
+// protoc in the build, so the descriptors are built here and the messages
+// are dynamic; TestCatalogProto checks they match the .proto file.
+var catalogProto = mustCatalogProto()
+
+func protoField(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    label.Enum(),
+		Type:     kind.Enum(),
+	}
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+func mustCatalogProto() protoreflect.FileDescriptor {
+	const (
+		str   = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		i64   = descriptorpb.FieldDescriptorProto_TYPE_INT64
+		u64   = descriptorpb.FieldDescriptorProto_TYPE_UINT64
+		bytes = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		msg   = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	method := func(name, input, output string, stream bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".gcpociproxy.v1." + input),
+			OutputType:      proto.String(".gcpociproxy.v1." + output),
+			ServerStreaming: proto.Bool(stream),
+		}
+	}
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("gcpociproxy/v1/catalog.proto"),
+		Package:    proto.String("gcpociproxy.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("ChartVersion",
+				protoField("name", 1, str, "", false),
+				protoField("version", 2, str, "", false),
+				protoField("digest", 3, str, "", false),
+				protoField("tags", 4, str, "", true),
+				protoField("size", 5, i64, "", false),
+				protoField("uri", 6, str, "", false),
+				protoField("created", 7, msg, ".google.protobuf.Timestamp", false)),
+			message("ListRequest", protoField("chart", 1, str, "", false)),
+			message("ListResponse",
+				protoField("revision", 1, u64, "", false),
+				protoField("versions", 2, msg, ".gcpociproxy.v1.ChartVersion", true)),
+			message("ResolveRequest", protoField("chart", 1, str, "", false), protoField("version", 2, str, "", false)),
+			message("PullRequest", protoField("chart", 1, str, "", false), protoField("version", 2, str, "", false)),
+			message("PullResponse",
+				protoField("version", 1, msg, ".gcpociproxy.v1.ChartVersion", false),
+				protoField("data", 2, bytes, "", false)),
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Catalog"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("List", "ListRequest", "ListResponse", false),
+				method("Resolve", "ResolveRequest", "ChartVersion", false),
+				method("Pull", "PullRequest", "PullResponse", true),
+			},
+		}},
+	}
+
+	descriptor, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("invalid catalog proto: %v", err))
+	}
+	// Registered for server reflection, so grpcurl works without the
+	// .proto file.
+	if err := protoregistry.GlobalFiles.RegisterFile(descriptor); err != nil {
+		panic(fmt.Sprintf("failed to register catalog proto: %v", err))
+	}
+	return descriptor
+}
+
+// newCatalogMessage returns an empty message of catalogProto.
+func newCatalogMessage(name protoreflect.Name) *dynamicpb.Message {
+	return dynamicpb.NewMessage(catalogProto.Messages().ByName(name))
+}
+
+func setField(m *dynamicpb.Message, name protoreflect.Name, value protoreflect.Value) {
+	m.Set(m.Descriptor().Fields().ByName(name), value)
+}
+
+func stringField(m *dynamicpb.Message, name protoreflect.Name) string {
+	return m.Get(m.Descriptor().Fields().ByName(name)).String()
+}
+
+// chartVersionMessage describes asset, resolved by version.
+func chartVersionMessage(asset *Asset, version string) *dynamicpb.Message {
+	m := newCatalogMessage("ChartVersion")
+	setField(m, "name", protoreflect.ValueOfString(asset.Name))
+	setField(m, "version", protoreflect.ValueOfString(version))
+	setField(m, "digest", protoreflect.ValueOfString(asset.SHA))
+	tags := m.Mutable(m.Descriptor().Fields().ByName("tags")).List()
+	for _, tag := range asset.Tags {
+		tags.Append(protoreflect.ValueOfString(tag))
+	}
+	setField(m, "size", protoreflect.ValueOfInt64(asset.Size))
+	setField(m, "uri", protoreflect.ValueOfString(asset.URI))
+	setField(m, "created", protoreflect.ValueOfMessage(timestamppb.New(chartCreated(asset)).ProtoReflect()))
+	return m
+}
+
+// grpcCatalog serves the catalog and chart downloads over gRPC for
+// controllers that would rather not scrape the REST API. Pulls go through
+// the same IAM check, download policy, audit log and statistics as HTTP
+// downloads.
+type grpcCatalog struct {
+	downloads *chartDownloader
+}
+
+// newGRPCServer returns a server of the Catalog service and of server
+// reflection.
+func newGRPCServer(downloads *chartDownloader) *grpc.Server {
+	catalog := &grpcCatalog{downloads: downloads}
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: grpcService,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "List", Handler: catalog.unary("List", "ListRequest", catalog.list)},
+			{MethodName: "Resolve", Handler: catalog.unary("Resolve", "ResolveRequest", catalog.resolveVersion)},
+		},
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Pull", Handler: catalog.pull, ServerStreams: true},
+		},
+		Metadata: "gcpociproxy/v1/catalog.proto",
+	}, catalog)
+	reflection.Register(server)
+	return server
+}
+
+func (g *grpcCatalog) unary(method string, request protoreflect.Name, handle func(*http.Request, *dynamicpb.Message) (*dynamicpb.Message, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		defer g.downloads.live.acquire()()
+		in := newCatalogMessage(request)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		r, err := g.request(ctx, method)
+		if err != nil {
+			return nil, err
+		}
+		return handle(r, in)
+	}
+}
+
+// request describes a call as the HTTP request the download checks, audit
+// log and statistics take, with the metadata as headers. The client address
+// and, with --iap-audience set, the IAP assertion are checked like filterIPs
+// and verifyIAP do.
+func (g *grpcCatalog) request(ctx context.Context, method string) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/"+grpcService+"/"+method, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+
+	config, _ := g.downloads.live.get()
+	if len(config.AllowCIDRs) > 0 || len(config.DenyCIDRs) > 0 || len(config.TrustedProxies) > 0 {
+		addr, err := checkClientAddr(config, r)
+		if err != nil {
+			log.Printf("rejected grpc call from %s. error: %v", r.RemoteAddr, err)
+			return nil, status.Error(codes.PermissionDenied, "forbidden")
+		}
+		r = r.WithContext(context.WithValue(ctx, clientAddrKey{}, addr))
+		ctx = r.Context()
+	}
+	if config.IAPAudience == "" {
+		return r, nil
+	}
+	email, err := verifyIAPAssertion(ctx, r.Header.Get("X-Goog-Iap-Jwt-Assertion"), config.IAPAudience)
+	if err != nil {
+		log.Printf("rejected grpc call from %s. error: %v", r.RemoteAddr, err)
+		return nil, status.Error(codes.Unauthenticated, "invalid iap assertion")
+	}
+	return r.WithContext(context.WithValue(ctx, iapIdentityKey{}, email)), nil
+}
+
+func (g *grpcCatalog) list(r *http.Request, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+	repository := currentRepository()
+	out := newCatalogMessage("ListResponse")
+	setField(out, "revision", protoreflect.ValueOfUint64(repository.Revision))
+	versions := out.Mutable(out.Descriptor().Fields().ByName("versions")).List()
+
+	add := func(asset *Asset) {
+		versions.Append(protoreflect.ValueOfMessage(chartVersionMessage(asset, requestedVersion(r, asset))))
+	}
+	if chart := stringField(in, "chart"); chart != "" {
+		repository.eachNamed(chart, add)
+	} else {
+		repository.each(add)
+	}
+	return out, nil
+}
+
+// resolve finds the asset of chart at version, a tag, a digest, a semver
+// range or empty for the latest version, and the tag it was found by.
+func (g *grpcCatalog) resolve(chart, version string) (*Asset, string, error) {
+	if chart == "" {
+		return nil, "", status.Error(codes.InvalidArgument, "missing chart")
+	}
+
+	repository := currentRepository()
+	var asset *Asset
+	tag := version
+	switch {
+	case version == "":
+		asset, tag = repository.latestVersion(chart)
+	case strings.Contains(version, ":"):
+		asset, tag = repository.findByDigest(chart, version), ""
+	default:
+		if asset = repository.findByTag(chart, version); asset == nil {
+			var err error
+			if asset, tag, err = repository.resolveVersion(chart, version); err != nil {
+				return nil, "", status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+	if asset == nil {
+		return nil, "", status.Errorf(codes.NotFound, "no version of %s matches %q", chart, version)
+	}
+	return asset, tag, nil
+}
+
+func (g *grpcCatalog) resolveVersion(r *http.Request, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+	asset, tag, err := g.resolve(stringField(in, "chart"), stringField(in, "version"))
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		tag = requestedVersion(r, asset)
+	}
+	return chartVersionMessage(asset, tag), nil
+}
+
+// pull streams the archive of a chart version, after checking the caller
+// may download it.
+func (g *grpcCatalog) pull(_ interface{}, stream grpc.ServerStream) error {
+	defer g.downloads.live.acquire()()
+	in := newCatalogMessage("PullRequest")
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	r, err := g.request(stream.Context(), "Pull")
+	if err != nil {
+		return err
+	}
+	asset, tag, err := g.resolve(stringField(in, "chart"), stringField(in, "version"))
+	if err != nil {
+		return err
+	}
+	if tag != "" {
+		// Read back by requestedVersion, like a pull by tag over HTTP.
+		route := chi.NewRouteContext()
+		route.URLParams.Add("assetTag", tag)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, route))
+	}
+
+	var sent int64
+	code := codes.OK
+	defer func() {
+		g.downloads.audit.record(newAuditEvent(r, asset, grpcHTTPStatus(code), sent))
+	}()
+
+	data, err := g.archive(r, asset)
+	if err != nil {
+		code = status.Code(err)
+		return err
+	}
+
+	first := newCatalogMessage("PullResponse")
+	setField(first, "version", protoreflect.ValueOfMessage(chartVersionMessage(asset, requestedVersion(r, asset))))
+	if err := stream.SendMsg(first); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := min(len(data), grpcChunkSize)
+		chunk := newCatalogMessage("PullResponse")
+		setField(chunk, "data", protoreflect.ValueOfBytes(data[:n]))
+		if err := stream.SendMsg(chunk); err != nil {
+			g.downloads.aborted.Add(1)
+			return err
+		}
+		sent += int64(n)
+		data = data[n:]
+	}
+	g.downloads.recordDownload(r, asset, sent)
+	return nil
+}
+
+// archive checks the caller of r may download asset and returns its
+// archive.
+func (g *grpcCatalog) archive(r *http.Request, asset *Asset) ([]byte, error) {
+	d := g.downloads
+	config, backend := d.live.get()
+
+	allowed, reason, err := d.iam.authorize(r, backend, asset)
+	if errors.Is(err, errNoCaller) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		log.Printf("failed to check iam permissions for %s. error: %v", asset.RawName, err)
+		return nil, status.Error(codes.Unavailable, "failed to check iam permissions")
+	}
+	if !allowed {
+		log.Printf("iam check denied download of %s: %s", asset.RawName, reason)
+		return nil, status.Error(codes.PermissionDenied, "download denied: "+reason)
+	}
+
+	allowed, reason, err = d.policy.authorize(r, asset)
+	if err != nil {
+		log.Printf("failed to evaluate download policy for %s. error: %v", asset.RawName, err)
+		return nil, status.Error(codes.Unavailable, "failed to evaluate download policy")
+	}
+	if !allowed {
+		log.Printf("policy denied download of %s: %s", asset.RawName, reason)
+		return nil, status.Error(codes.PermissionDenied, strings.TrimSpace("download denied by policy. "+reason))
+	}
+
+	if oversized(config, asset) {
+		log.Printf("refused %s of %d bytes, over the limit of %d", asset.RawName, asset.Size, config.MaxArtifactBytes)
+		oversizedArtifacts.WithLabelValues("rejected").Inc()
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %d bytes, over this proxy's limit of %d bytes. Pull it from %s directly.", asset.Name, asset.Size, config.MaxArtifactBytes, asset.URI)
+	}
+
+	d.clients.record(r.UserAgent())
+	chart, err := pullAsset(r.Context(), d.client, d.logins, d.cache, d.stats, backend, asset)
+	if err != nil {
+		log.Printf("failed to pull %s. error: %v", asset.RawName, err)
+		return nil, status.Error(codes.Unavailable, "failed to pull chart")
+	}
+	return chart.Data, nil
+}
+
+// grpcHTTPStatus maps the outcome of a call to the HTTP status the audit
+// log records for downloads. Like over HTTP, a pull the client went away
+// from is recorded as 200 with the bytes sent.
+func grpcHTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.FailedPrecondition:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadGateway
+	}
+}
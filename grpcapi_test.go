@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var protoTokens = regexp.MustCompile(`//[^\n]*|"[^"]*"|[A-Za-z_][\w.]*|\d+|[{}();=<>,]`)
+
+// parseProto parses the subset of proto3 proto/catalog.proto uses: scalar
+// and message fields, possibly repeated, and unary or server streaming
+// methods.
+func parseProto(source string) (*descriptorpb.FileDescriptorProto, error) {
+	var tokens []string
+	for _, token := range protoTokens.FindAllString(source, -1) {
+		if token[0] != '/' {
+			tokens = append(tokens, token)
+		}
+	}
+	next := func() string {
+		if len(tokens) == 0 {
+			return ""
+		}
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token
+	}
+	expect := func(want string) error {
+		if got := next(); got != want {
+			return fmt.Errorf("expected %q, got %q", want, got)
+		}
+		return nil
+	}
+	scalars := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+		"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+		"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	}
+
+	file := &descriptorpb.FileDescriptorProto{}
+	qualify := func(name string) string {
+		if strings.Contains(name, ".") {
+			return "." + name
+		}
+		return "." + file.GetPackage() + "." + name
+	}
+	for len(tokens) > 0 {
+		switch keyword := next(); keyword {
+		case "syntax":
+			if err := expect("="); err != nil {
+				return nil, err
+			}
+			syntax, _ := strconv.Unquote(next())
+			file.Syntax = proto.String(syntax)
+		case "package":
+			file.Package = proto.String(next())
+		case "import":
+			dependency, _ := strconv.Unquote(next())
+			file.Dependency = append(file.Dependency, dependency)
+		case "message":
+			message := &descriptorpb.DescriptorProto{Name: proto.String(next())}
+			if err := expect("{"); err != nil {
+				return nil, err
+			}
+			for len(tokens) > 0 && tokens[0] != "}" {
+				repeated := tokens[0] == "repeated"
+				if repeated {
+					next()
+				}
+				kind, name := next(), next()
+				if err := expect("="); err != nil {
+					return nil, err
+				}
+				number, err := strconv.Atoi(next())
+				if err != nil {
+					return nil, err
+				}
+				typeName := ""
+				scalar, ok := scalars[kind]
+				if !ok {
+					scalar, typeName = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, qualify(kind)
+				}
+				message.Field = append(message.Field, protoField(name, int32(number), scalar, typeName, repeated))
+				if err := expect(";"); err != nil {
+					return nil, err
+				}
+			}
+			next()
+			file.MessageType = append(file.MessageType, message)
+		case "service":
+			service := &descriptorpb.ServiceDescriptorProto{Name: proto.String(next())}
+			if err := expect("{"); err != nil {
+				return nil, err
+			}
+			for len(tokens) > 0 && tokens[0] != "}" {
+				if err := expect("rpc"); err != nil {
+					return nil, err
+				}
+				method := &descriptorpb.MethodDescriptorProto{Name: proto.String(next())}
+				next()
+				method.InputType = proto.String(qualify(next()))
+				next()
+				if err := expect("returns"); err != nil {
+					return nil, err
+				}
+				next()
+				stream := tokens[0] == "stream"
+				if stream {
+					next()
+				}
+				method.OutputType = proto.String(qualify(next()))
+				if stream {
+					method.ServerStreaming = proto.Bool(true)
+				}
+				next()
+				if err := expect(";"); err != nil {
+					return nil, err
+				}
+				service.Method = append(service.Method, method)
+			}
+			next()
+			file.Service = append(file.Service, service)
+		default:
+			return nil, fmt.Errorf("unexpected %q", keyword)
+		}
+		if len(tokens) > 0 && tokens[0] == ";" {
+			next()
+		}
+	}
+	return file, nil
+}
+
+func TestCatalogProto(t *testing.T) {
+	source, err := os.ReadFile("proto/catalog.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseProto(string(source))
+	if err != nil {
+		t.Fatalf("parseProto() = %v", err)
+	}
+	// Served under its import path rather than its place in the repository.
+	parsed.Name = proto.String(catalogProto.Path())
+
+	built := protodesc.ToFileDescriptorProto(catalogProto)
+	if !proto.Equal(parsed, built) {
+		t.Errorf("proto/catalog.proto and grpcapi.go differ:\n%s\nwant:\n%s", prototext.Format(parsed), prototext.Format(built))
+	}
+}
+
+func TestGRPCCatalog(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.3"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.3.0"}},
+		{Name: "redis", SHA: "sha256:3", Tags: []string{"7.0.0"}},
+	}})
+	defer setRepository(&Repository{})
+
+	archive := bytes.Repeat([]byte("chart"), grpcChunkSize/2)
+	live := &liveConfig{config: &Config{}}
+	d := &chartDownloader{
+		live:    live,
+		cache:   newMemoryCache(1 << 22),
+		stats:   newDownloadStats(),
+		clients: newClientStats(),
+		policy:  newDownloadPolicy(live),
+		iam:     newIAMChecker(live),
+	}
+	d.cache.put("sha256:2", &cachedChart{Name: "nginx", Version: "1.3.0", Data: archive})
+
+	listener := bufconn.Listen(1 << 20)
+	server := newGRPCServer(d)
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.Dial("bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	list := newCatalogMessage("ListResponse")
+	listRequest := newCatalogMessage("ListRequest")
+	setField(listRequest, "chart", protoreflect.ValueOfString("nginx"))
+	if err := conn.Invoke(ctx, "/"+grpcService+"/List", listRequest, list); err != nil {
+		t.Fatal(err)
+	}
+	if versions := list.Get(list.Descriptor().Fields().ByName("versions")).List(); versions.Len() != 2 {
+		t.Errorf("List(nginx) = %d versions, want 2", versions.Len())
+	}
+
+	tests := []struct {
+		version string
+		digest  string
+		code    codes.Code
+	}{
+		{"", "sha256:2", codes.OK},
+		{"1.2.3", "sha256:1", codes.OK},
+		{"~1.2", "sha256:1", codes.OK},
+		{"sha256:2", "sha256:2", codes.OK},
+		{"2.0.0", "", codes.NotFound},
+		{"not a range", "", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		in, out := newCatalogMessage("ResolveRequest"), newCatalogMessage("ChartVersion")
+		setField(in, "chart", protoreflect.ValueOfString("nginx"))
+		setField(in, "version", protoreflect.ValueOfString(tt.version))
+		err := conn.Invoke(ctx, "/"+grpcService+"/Resolve", in, out)
+		if status.Code(err) != tt.code {
+			t.Errorf("Resolve(nginx, %q) = %v, want %v", tt.version, err, tt.code)
+			continue
+		}
+		if digest := stringField(out, "digest"); err == nil && digest != tt.digest {
+			t.Errorf("Resolve(nginx, %q) = %s, want %s", tt.version, digest, tt.digest)
+		}
+	}
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+grpcService+"/Pull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := newCatalogMessage("PullRequest")
+	setField(in, "chart", protoreflect.ValueOfString("nginx"))
+	stream.SendMsg(in)
+	stream.CloseSend()
+
+	var got []byte
+	var version string
+	for {
+		out := newCatalogMessage("PullResponse")
+		if err := stream.RecvMsg(out); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if field := out.Descriptor().Fields().ByName("version"); out.Has(field) {
+			version = stringField(out.Get(field).Message().Interface().(*dynamicpb.Message), "version")
+		}
+		got = append(got, out.Get(out.Descriptor().Fields().ByName("data")).Bytes()...)
+	}
+	if version != "1.3.0" || !bytes.Equal(got, archive) {
+		t.Errorf("Pull(nginx) = %s with %d bytes, want 1.3.0 with %d bytes", version, len(got), len(archive))
+	}
+}
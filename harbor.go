@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// newHarborBackend returns an oci backend for Harbor, whose catalog
+// endpoint is reserved to system administrators: repositories are listed
+// per project through the Harbor API instead, so a robot account with pull
+// access is enough.
+func newHarborBackend(config *Config) (*ociBackend, error) {
+	b, err := newOCIBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	harbor := &registryAPI{host: config.OCIRegistry, credential: b.Credential, basic: true}
+	b.discover = func(ctx context.Context) ([]string, error) {
+		return harborRepositories(ctx, harbor, config.Repository)
+	}
+	return b, nil
+}
+
+// harborRepositories lists the repositories starting with prefix. A prefix
+// naming a project, e.g. "charts/", only lists that project.
+func harborRepositories(ctx context.Context, harbor *registryAPI, prefix string) ([]string, error) {
+	var projects []string
+	if project, _, ok := strings.Cut(prefix, "/"); ok {
+		projects = []string{project}
+	} else {
+		var err error
+		projects, err = harborNames(ctx, harbor, "/api/v2.0/projects?page_size=100")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list harbor projects: %w", err)
+		}
+	}
+
+	var repositories []string
+	for _, project := range projects {
+		names, err := harborNames(ctx, harbor, fmt.Sprintf("/api/v2.0/projects/%s/repositories?page_size=100", url.PathEscape(project)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repositories of harbor project %s: %w", project, err)
+		}
+
+		// Repository names include their project, e.g. "charts/nginx".
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				repositories = append(repositories, name)
+			}
+		}
+	}
+	return repositories, nil
+}
+
+// harborNames pages through a Harbor list endpoint, returning the name of
+// every item.
+func harborNames(ctx context.Context, harbor *registryAPI, next string) ([]string, error) {
+	var names []string
+	for next != "" {
+		var page []struct {
+			Name string `json:"name"`
+		}
+
+		var err error
+		next, err = harbor.getJSON(ctx, next, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page {
+			names = append(names, item.Name)
+		}
+	}
+	return names, nil
+}
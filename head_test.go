@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestHead(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.3"}, Size: 1234},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.3.0"}, Size: 9999},
+		{Name: "redis", SHA: "sha256:3", Tags: []string{"7.0.0"}, Size: 1 << 30},
+	}})
+	defer setRepository(&Repository{})
+
+	archive := []byte{0x1f, 0x8b, 0, 0, 0}
+	live := &liveConfig{config: &Config{MaxArtifactBytes: 1 << 20}}
+	d := &chartDownloader{
+		live:    live,
+		cache:   newMemoryCache(1 << 20),
+		stats:   newDownloadStats(),
+		clients: newClientStats(),
+		policy:  newDownloadPolicy(live),
+		iam:     newIAMChecker(live),
+		audit:   &auditLog{events: make(chan auditEvent, 10)},
+	}
+	d.cache.put("sha256:2", &cachedChart{Name: "nginx", Version: "1.3.0", Data: archive})
+	router := chi.NewRouter()
+	d.routes(router)
+
+	tests := []struct {
+		target string
+		code   int
+		length string
+		digest string
+	}{
+		{"/nginx:1.2.3", http.StatusOK, "1234", "sha256:1"},
+		{"/nginx@sha256:1", http.StatusOK, "1234", "sha256:1"},
+		{"/nginx:1.3.0", http.StatusOK, "5", "sha256:2"},
+		{"/nginx-1.3.0.tgz", http.StatusOK, "5", "sha256:2"},
+		{"/nginx:2.0.0", http.StatusNotFound, "", ""},
+		{"/redis:7.0.0", http.StatusRequestEntityTooLarge, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, tt.target, nil))
+		if w.Code != tt.code {
+			t.Errorf("HEAD %s = %d, want %d", tt.target, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Length"); got != tt.length {
+			t.Errorf("HEAD %s Content-Length = %q, want %q", tt.target, got, tt.length)
+		}
+		if got := w.Header().Get("Docker-Content-Digest"); got != tt.digest {
+			t.Errorf("HEAD %s Docker-Content-Digest = %q, want %q", tt.target, got, tt.digest)
+		}
+		if got := w.Header().Get("Content-Type"); got != "application/gzip" {
+			t.Errorf("HEAD %s Content-Type = %q, want application/gzip", tt.target, got)
+		}
+	}
+
+	// Existence checks aren't downloads.
+	if len(d.clients.buckets) != 0 {
+		t.Errorf("HEAD counted clients %v, want none", d.clients.buckets)
+	}
+	if len(d.audit.events) != 0 {
+		t.Errorf("HEAD audited %d events, want none", len(d.audit.events))
+	}
+}
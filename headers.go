@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// headerGroups are the route groups response headers can be added to.
+var headerGroups = map[string]bool{
+	"all":       true,
+	"downloads": true,
+	"index":     true,
+	"api":       true,
+	"health":    true,
+	"admin":     true,
+}
+
+// HeaderRule adds the headers in Set to every response of the route group
+// Group. Values are Go templates executed with a headerData.
+type HeaderRule struct {
+	Group string            `json:"group"`
+	Set   map[string]string `json:"set"`
+
+	templates map[string]*template.Template
+}
+
+// headerData is what header templates can refer to, e.g.
+// `{{ .Backend }}` or `{{ .Path }}`.
+type headerData struct {
+	Group   string
+	Method  string
+	Path    string
+	Host    string
+	Backend string
+}
+
+// resolveHeaders parses the headers section of the config file and compiles
+// its templates.
+func resolveHeaders(value interface{}) ([]HeaderRule, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []HeaderRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("expected a list of {group, set}: %w", err)
+	}
+
+	var errs []error
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Group == "" {
+			rule.Group = "all"
+		}
+		if !headerGroups[rule.Group] {
+			errs = append(errs, fmt.Errorf("header rule %d: unknown group %q", i, rule.Group))
+		}
+
+		rule.templates = map[string]*template.Template{}
+		for name, value := range rule.Set {
+			tmpl, err := template.New(name).Option("missingkey=error").Parse(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("header rule %d: invalid %s: %w", i, name, err))
+				continue
+			}
+			rule.templates[http.CanonicalHeaderKey(name)] = tmpl
+		}
+	}
+	return rules, errors.Join(errs...)
+}
+
+// routeGroup returns the header group of a request path.
+func routeGroup(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/api/"):
+		return "api"
+	case strings.HasPrefix(path, "/admin/"):
+		return "admin"
+	case path == "/health" || strings.HasPrefix(path, "/health/") || path == "/metrics":
+		return "health"
+	case path == "/index.yaml":
+		return "index"
+	}
+	return "downloads"
+}
+
+// injectHeaders adds the configured headers to every response. Rules are
+// read from the live config so they follow reloads.
+func injectHeaders(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, backend := live.get()
+			if len(config.Headers) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			data := headerData{
+				Group:   routeGroup(r.URL.Path),
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Host:    r.Host,
+				Backend: backend.Name(),
+			}
+
+			for _, rule := range config.Headers {
+				if rule.Group != "all" && rule.Group != data.Group {
+					continue
+				}
+
+				for name, tmpl := range rule.templates {
+					var value bytes.Buffer
+					if err := tmpl.Execute(&value, data); err != nil {
+						log.Printf("failed to render header %s. error: %v", name, err)
+						continue
+					}
+					w.Header().Set(name, value.String())
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
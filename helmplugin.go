@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/idtoken"
+)
+
+// helmDownloadTimeout bounds a download made for Helm.
+const helmDownloadTimeout = 5 * time.Minute
+
+// downloaderSchemes maps the URL schemes the Helm downloader plugin
+// registers to the scheme the proxy is reached with.
+var downloaderSchemes = map[string]string{
+	"gcpoci":      "https",
+	"gcpoci+http": "http",
+}
+
+// downloaderURL turns a gcpoci:// URL Helm hands the plugin into the URL of
+// the proxy: gcpoci://proxy.example.com/nginx-1.2.3.tgz is fetched from
+// https://proxy.example.com/nginx-1.2.3.tgz, and gcpoci+http:// over plain
+// HTTP.
+func downloaderURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", raw, err)
+	}
+	scheme, ok := downloaderSchemes[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported scheme %q, expected gcpoci or gcpoci+http", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid url %q: missing host", raw)
+	}
+	u.Scheme = scheme
+	return u.String(), nil
+}
+
+// downloaderTokens returns where the plugin gets the token it presents to
+// the proxy: an ID token for audience, as IAP and --iam-check-audience
+// take, or else an access token of the application default credentials.
+func downloaderTokens(ctx context.Context, audience string) (oauth2.TokenSource, error) {
+	if audience != "" {
+		return idtoken.NewTokenSource(ctx, audience)
+	}
+	return google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloud-platform")
+}
+
+// downloaderClient returns an HTTP client presenting the TLS client
+// certificate and trusting the CA Helm passes for the repository, if any.
+func downloaderClient(certFile, keyFile, caFile string) (*http.Client, error) {
+	config := &tls.Config{}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in ca file %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// helmDownload writes to out what the proxy serves for the gcpoci:// URL
+// raw, authenticated with a token of tokens, or anonymously when tokens is
+// nil.
+func helmDownload(ctx context.Context, client *http.Client, tokens oauth2.TokenSource, raw string, out io.Writer) error {
+	target, err := downloaderURL(raw)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "gcp-oci-proxy-helm-downloader")
+	if tokens != nil {
+		token, err := tokens.Token()
+		if err != nil {
+			return fmt.Errorf("failed to get a google token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GET %s: %s: %s", target, resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func newHelmDownloaderCommand() *cobra.Command {
+	var anonymous bool
+	var audience string
+
+	cmd := &cobra.Command{
+		Use:   "helm-downloader CERT_FILE KEY_FILE CA_FILE URL",
+		Short: "Fetch a gcpoci:// URL for Helm, as a downloader plugin",
+		Long: `Fetch a gcpoci:// URL for Helm, as a downloader plugin.
+
+Helm runs this command for the gcpoci:// and gcpoci+http:// URLs of the
+plugin in helm-plugin/, with the repository's TLS files and the URL, and reads
+the chart or index from stdout. The request carries a token of the
+application default credentials, so the proxy's IAM check and IAP can tell
+who is downloading.`,
+		Args: cobra.ExactArgs(4),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), helmDownloadTimeout)
+			defer cancel()
+
+			client, err := downloaderClient(args[0], args[1], args[2])
+			if err != nil {
+				return err
+			}
+			var tokens oauth2.TokenSource
+			if !anonymous {
+				if tokens, err = downloaderTokens(ctx, audience); err != nil {
+					return fmt.Errorf("failed to find google credentials: %w", err)
+				}
+			}
+			return helmDownload(ctx, client, tokens, args[3], cmd.OutOrStdout())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&anonymous, "anonymous", envOr("GCPOCI_ANONYMOUS", "") == "true", "send no token [GCPOCI_ANONYMOUS]")
+	flags.StringVar(&audience, "audience", envOr("GCPOCI_AUDIENCE", ""), "send an ID token for this audience instead of an access token, e.g. the IAP client ID [GCPOCI_AUDIENCE]")
+	return cmd
+}
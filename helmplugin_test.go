@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"golang.org/x/oauth2"
+)
+
+func TestDownloaderURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+		err       bool
+	}{
+		{"gcpoci://proxy.example.com/nginx-1.2.3.tgz", "https://proxy.example.com/nginx-1.2.3.tgz", false},
+		{"gcpoci+http://proxy:8080/index.yaml", "http://proxy:8080/index.yaml", false},
+		{"gcpoci://proxy.example.com/team/app:1.0.0?profile=prod", "https://proxy.example.com/team/app:1.0.0?profile=prod", false},
+		{"https://proxy.example.com/index.yaml", "", true},
+		{"gcpoci:///index.yaml", "", true},
+	}
+	for _, tt := range tests {
+		got, err := downloaderURL(tt.raw)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("downloaderURL(%q) = %q, %v, want %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestHelmDownload(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "cert-manager", SHA: "sha256:1", Tags: []string{"1.2.3-rc.1"}},
+	}})
+	defer setRepository(&Repository{})
+
+	live := &liveConfig{config: &Config{}}
+	d := &chartDownloader{
+		live:    live,
+		cache:   newMemoryCache(1 << 20),
+		stats:   newDownloadStats(),
+		clients: newClientStats(),
+		policy:  newDownloadPolicy(live),
+		iam:     newIAMChecker(live),
+	}
+	d.cache.put("sha256:1", &cachedChart{Name: "cert-manager", Version: "1.2.3-rc.1", Data: []byte("archive")})
+	router := chi.NewRouter()
+	d.routes(router)
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		router.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	tokens := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+
+	var out bytes.Buffer
+	if err := helmDownload(context.Background(), server.Client(), tokens, "gcpoci+http://"+host+"/cert-manager-1.2.3-rc.1.tgz", &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "archive" || authorization != "Bearer token" {
+		t.Errorf("download = %q with Authorization %q, want the archive with the token", out.String(), authorization)
+	}
+
+	if err := helmDownload(context.Background(), server.Client(), nil, "gcpoci+http://"+host+"/cert-manager-9.9.9.tgz", io.Discard); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("download of a missing version = %v, want a 404 error", err)
+	}
+}
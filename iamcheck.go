@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	artifactregistryapi "google.golang.org/api/artifactregistry/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
+	"google.golang.org/api/policytroubleshooter/v1"
+)
+
+// downloadPermission is the permission roles/artifactregistry.reader grants
+// to download from a repository.
+const downloadPermission = "artifactregistry.repositories.downloadArtifacts"
+
+// errNoCaller means a request carries no credential the IAM check can
+// identify its caller with.
+var errNoCaller = errors.New("no verifiable caller credential")
+
+// iamChecker verifies, with --iam-check, that the caller of a download holds
+// downloadPermission on the Artifact Registry repository the chart is pulled
+// from, so the proxy's own service account doesn't open the repository to
+// everyone who can reach the proxy. Decisions are cached per caller and
+// repository for --iam-check-ttl.
+type iamChecker struct {
+	live *liveConfig
+
+	mu        sync.Mutex
+	decisions map[string]iamDecision
+}
+
+type iamDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// iamCaller is who a download is checked for: the bearer of an OAuth access
+// token, checked with TestIamPermissions as the caller, or the verified
+// email of an ID token or IAP assertion, checked with the Policy
+// Troubleshooter as the proxy.
+type iamCaller struct {
+	key   string
+	name  string
+	email string
+	token string
+}
+
+func newIAMChecker(live *liveConfig) *iamChecker {
+	return &iamChecker{live: live, decisions: map[string]iamDecision{}}
+}
+
+// authorize reports whether the caller of r may download asset and why
+// not. Charts held outside Artifact Registry are not checked. It returns
+// errNoCaller when r carries no usable credential.
+func (c *iamChecker) authorize(r *http.Request, backend Backend, asset *Asset) (bool, string, error) {
+	config, _ := c.live.get()
+	if !config.IAMCheck {
+		return true, "", nil
+	}
+
+	source, _ := sourceFor(backend, asset)
+	gar, ok := source.(*garBackend)
+	if !ok {
+		return true, "", nil
+	}
+	resource, err := formatPath(gar.config)
+	if err != nil {
+		return false, "", err
+	}
+
+	caller, err := iamCallerFrom(r, config.IAMCheckAudience)
+	if err != nil {
+		iamChecks.WithLabelValues("unauthenticated").Inc()
+		return false, "", err
+	}
+
+	key := caller.key + "\x00" + resource
+	c.mu.Lock()
+	decision, ok := c.decisions[key]
+	c.mu.Unlock()
+
+	if !ok || time.Now().After(decision.expires) {
+		var allowed bool
+		if caller.email != "" {
+			allowed, err = troubleshootAccess(r.Context(), config, caller.email, resource)
+		} else {
+			allowed, err = testAccess(r.Context(), caller.token, resource)
+		}
+		if err != nil {
+			iamChecks.WithLabelValues("error").Inc()
+			return false, "", err
+		}
+
+		decision = iamDecision{allowed: allowed, expires: time.Now().Add(config.IAMCheckTTL)}
+		c.mu.Lock()
+		c.expire(time.Now())
+		c.decisions[key] = decision
+		c.mu.Unlock()
+	}
+
+	if !decision.allowed {
+		iamChecks.WithLabelValues("denied").Inc()
+		return false, fmt.Sprintf("%s lacks %s on %s", caller.name, downloadPermission, resource), nil
+	}
+	iamChecks.WithLabelValues("allowed").Inc()
+	return true, "", nil
+}
+
+// expire drops the decisions past their TTL. The caller holds c.mu.
+func (c *iamChecker) expire(now time.Time) {
+	for key, decision := range c.decisions {
+		if now.After(decision.expires) {
+			delete(c.decisions, key)
+		}
+	}
+}
+
+// iamCallerFrom identifies the caller of r from an IAP assertion or an ID
+// token, both verified against audience, or an OAuth access token, sent as
+// a bearer token or as the password of oauth2accesstoken like Artifact
+// Registry takes it. ID tokens and IAP assertions are only accepted when
+// audience is set, unless --iap-audience already verified the assertion.
+func iamCallerFrom(r *http.Request, audience string) (*iamCaller, error) {
+	if email, ok := iapIdentity(r.Context()); ok {
+		return &iamCaller{key: "email:" + email, name: email, email: email}, nil
+	}
+	if assertion := r.Header.Get("X-Goog-Iap-Jwt-Assertion"); assertion != "" && audience != "" {
+		return verifiedCaller(r.Context(), assertion, audience)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if user, password, basic := r.BasicAuth(); basic && user == "oauth2accesstoken" {
+		token, ok = password, true
+	}
+	if !ok || token == "" {
+		return nil, errNoCaller
+	}
+	if audience != "" && strings.Count(token, ".") == 2 {
+		return verifiedCaller(r.Context(), token, audience)
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	return &iamCaller{key: "token:" + hex.EncodeToString(sum[:]), name: "the access token", token: token}, nil
+}
+
+func verifiedCaller(ctx context.Context, token, audience string) (*iamCaller, error) {
+	payload, err := idtoken.Validate(ctx, token, audience)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoCaller, err)
+	}
+	email, _ := payload.Claims["email"].(string)
+	if email == "" {
+		return nil, fmt.Errorf("%w: token has no email claim", errNoCaller)
+	}
+	return &iamCaller{key: "email:" + email, name: email, email: email}, nil
+}
+
+// testAccess asks, as the bearer of token, whether it holds
+// downloadPermission on resource.
+func testAccess(ctx context.Context, token, resource string) (bool, error) {
+	service, err := artifactregistryapi.NewService(ctx, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := service.Projects.Locations.Repositories.TestIamPermissions(resource, &artifactregistryapi.TestIamPermissionsRequest{
+		Permissions: []string{downloadPermission},
+	}).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+		return false, fmt.Errorf("%w: %v", errNoCaller, err)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to test permissions on %s: %w", resource, err)
+	}
+	return len(resp.Permissions) > 0, nil
+}
+
+// troubleshootAccess asks the Policy Troubleshooter whether email holds
+// downloadPermission on resource, which accounts for group memberships and
+// grants inherited from the project.
+func troubleshootAccess(ctx context.Context, config *Config, email, resource string) (bool, error) {
+	opts, err := googleClientOptions(config)
+	if err != nil {
+		return false, err
+	}
+	service, err := policytroubleshooter.NewService(ctx, opts...)
+	if err != nil {
+		return false, fmt.Errorf("failed to create policy troubleshooter client. error: %w", err)
+	}
+
+	resp, err := service.Iam.Troubleshoot(&policytroubleshooter.GoogleCloudPolicytroubleshooterV1TroubleshootIamPolicyRequest{
+		AccessTuple: &policytroubleshooter.GoogleCloudPolicytroubleshooterV1AccessTuple{
+			Principal:        email,
+			FullResourceName: "//artifactregistry.googleapis.com/" + resource,
+			Permission:       downloadPermission,
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("failed to troubleshoot access of %s to %s: %w", email, resource, err)
+	}
+	return resp.Access == "GRANTED", nil
+}
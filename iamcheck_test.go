@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIAMCheck(t *testing.T) {
+	live := &liveConfig{config: &Config{IAMCheck: true, IAMCheckTTL: time.Minute}}
+	c := newIAMChecker(live)
+	gar := &garBackend{config: &Config{Project: "p", Region: "europe", Repository: "charts"}}
+	asset := &Asset{Name: "nginx", SHA: "sha256:1"}
+
+	allowed := httptest.NewRequest(http.MethodGet, "/nginx:1.0.0", nil)
+	allowed.SetBasicAuth("oauth2accesstoken", "allowed-token")
+	denied := httptest.NewRequest(http.MethodGet, "/nginx:1.0.0", nil)
+	denied.Header.Set("Authorization", "Bearer denied-token")
+	for r, ok := range map[*http.Request]bool{allowed: true, denied: false} {
+		caller, err := iamCallerFrom(r, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.decisions[caller.key+"\x00projects/p/locations/europe/repositories/charts"] = iamDecision{allowed: ok, expires: time.Now().Add(time.Minute)}
+	}
+
+	tests := []struct {
+		r       *http.Request
+		allowed bool
+		err     error
+	}{
+		{allowed, true, nil},
+		{denied, false, nil},
+		{httptest.NewRequest(http.MethodGet, "/nginx:1.0.0", nil), false, errNoCaller},
+	}
+	for _, tt := range tests {
+		ok, reason, err := c.authorize(tt.r, gar, asset)
+		if ok != tt.allowed || !errors.Is(err, tt.err) {
+			t.Errorf("authorize(%v) = %v, %q, %v, want %v, %v", tt.r.Header, ok, reason, err, tt.allowed, tt.err)
+		}
+	}
+
+	if ok, _, err := c.authorize(tests[2].r, &listedBackend{}, asset); !ok || err != nil {
+		t.Errorf("authorize() of a chart outside artifact registry = %v, %v, want it allowed", ok, err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVerifyIAP(t *testing.T) {
+	handler := verifyIAP(&liveConfig{config: &Config{IAPAudience: "/projects/1/global/backendServices/2"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path, assertion string
+		code            int
+	}{
+		{"/health", "", http.StatusOK},
+		{"/metrics", "", http.StatusOK},
+		{"/index.yaml", "", http.StatusUnauthorized},
+		{"/index.yaml", "not.a.jwt", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("X-Goog-Authenticated-User-Email", "accounts.google.com:mallory@example.com")
+		if tt.assertion != "" {
+			req.Header.Set("X-Goog-Iap-Jwt-Assertion", tt.assertion)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/index.yaml", nil)
+	req.SetBasicAuth("bob", "secret")
+	req = req.WithContext(context.WithValue(req.Context(), iapIdentityKey{}, "alice@example.com"))
+	if user := requestIdentity(req).User; user != "alice@example.com" {
+		t.Errorf("requestIdentity().User = %q, want the verified alice@example.com", user)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyKeyHeader names a mutating request, so a retry of it is
+	// answered with the first response instead of running again.
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader is set on responses replayed for a retry.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKey = 255
+
+	// idempotencyPendingTimeout bounds how long a request keeps its key
+	// before it completes, so a key whose request never finished can be
+	// used again.
+	idempotencyPendingTimeout = 10 * time.Minute
+)
+
+// idempotentResult is the outcome of a request made with an idempotency
+// key. It is pending until the request completes, and expires
+// --idempotency-ttl later.
+type idempotentResult struct {
+	fingerprint string
+	expires     time.Time
+
+	done   bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+// idempotencyStore remembers the responses of mutating requests sent with
+// an Idempotency-Key for --idempotency-ttl, so CI retry loops don't delete
+// or open things twice. Keys are scoped to the Authorization header they
+// were sent with. Results are kept in memory only: they don't survive a
+// restart, and replicas don't share them, so a retry landing on another
+// replica runs again.
+type idempotencyStore struct {
+	live *liveConfig
+
+	mu      sync.Mutex
+	results map[string]*idempotentResult
+}
+
+func newIdempotencyStore(live *liveConfig) *idempotencyStore {
+	return &idempotencyStore{live: live, results: map[string]*idempotentResult{}}
+}
+
+// middleware replays the stored response of a request whose key was seen
+// before. Reusing a key for a different request is refused with 422, and a
+// retry arriving while the first attempt still runs with 409. Server errors
+// aren't stored, so the request can be retried, and neither is anything
+// when the handler panics.
+func (s *idempotencyStore) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+
+		// The body is read whole to fingerprint it, so it is held to the
+		// largest upload any of these endpoints accepts, a chart push.
+		config, _ := s.live.get()
+		limit := pushLimit(config)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("request body over %d bytes", limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := sha256Hex([]byte(r.Header.Get("Authorization") + "\x00" + key))
+		fingerprint := sha256Hex([]byte(r.Method + "\x00" + r.URL.RequestURI() + "\x00" + string(body)))
+
+		now := time.Now()
+		s.mu.Lock()
+		for scope, result := range s.results {
+			if now.After(result.expires) {
+				delete(s.results, scope)
+			}
+		}
+		result, ok := s.results[scope]
+		if !ok {
+			result = &idempotentResult{fingerprint: fingerprint, expires: now.Add(idempotencyPendingTimeout)}
+			s.results[scope] = result
+		}
+		// The first request fills in its result under the lock, so retries
+		// answer from a copy taken while holding it.
+		seen := *result
+		s.mu.Unlock()
+
+		if ok {
+			switch {
+			case seen.fingerprint != fingerprint:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case !seen.done:
+				http.Error(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+			default:
+				seen.replay(w)
+			}
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if !completed && s.results[scope] == result {
+				delete(s.results, scope)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+		completed = recorder.status < 500
+
+		if completed {
+			s.mu.Lock()
+			result.done = true
+			result.expires = time.Now().Add(config.IdempotencyTTL)
+			result.status = recorder.status
+			result.header = w.Header().Clone()
+			result.body = recorder.body.Bytes()
+			s.mu.Unlock()
+		}
+	})
+}
+
+// replay writes the stored response again.
+func (r *idempotentResult) replay(w http.ResponseWriter) {
+	for name, values := range r.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(r.status)
+	w.Write(r.body)
+}
+
+// recordingWriter keeps a copy of the response it writes.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	s := newIdempotencyStore(&liveConfig{config: &Config{IdempotencyTTL: time.Minute}})
+
+	// The handler answers with the ?status it is asked for and counts its
+	// runs, blocking while ?block is set until release is closed.
+	var runs atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := s.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		run := runs.Add(1)
+		if r.URL.Query().Has("block") {
+			close(started)
+			<-release
+		}
+		status := http.StatusCreated
+		fmt.Sscan(r.URL.Query().Get("status"), &status)
+		w.Header().Set("X-Run", fmt.Sprint(run))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "run %d", run)
+	}))
+
+	serve := func(target, token, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if key != "" {
+			r.Header.Set(idempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		token    string
+		key      string
+		body     string
+		code     int
+		run      string
+		replayed bool
+	}{
+		{name: "first", target: "/api/v1/sessions", token: "a", key: "k1", body: "{}", code: http.StatusCreated, run: "1"},
+		{name: "retry", target: "/api/v1/sessions", token: "a", key: "k1", body: "{}", code: http.StatusCreated, run: "1", replayed: true},
+		{name: "other caller", target: "/api/v1/sessions", token: "b", key: "k1", body: "{}", code: http.StatusCreated, run: "2"},
+		{name: "other body", target: "/api/v1/sessions", token: "a", key: "k1", body: `{"ttl":"1h"}`, code: http.StatusUnprocessableEntity},
+		{name: "other target", target: "/api/v1/sessions?x=1", token: "a", key: "k1", body: "{}", code: http.StatusUnprocessableEntity},
+		{name: "no key", target: "/api/v1/sessions", token: "a", body: "{}", code: http.StatusCreated, run: "3"},
+		{name: "no key again", target: "/api/v1/sessions", token: "a", body: "{}", code: http.StatusCreated, run: "4"},
+		{name: "key too long", target: "/api/v1/sessions", token: "a", key: strings.Repeat("k", maxIdempotencyKey+1), code: http.StatusBadRequest},
+		{name: "client error", target: "/api/v1/sessions?status=404", token: "a", key: "k2", code: http.StatusNotFound, run: "5"},
+		{name: "client error retry", target: "/api/v1/sessions?status=404", token: "a", key: "k2", code: http.StatusNotFound, run: "5", replayed: true},
+		{name: "server error", target: "/api/v1/sessions?status=502", token: "a", key: "k3", code: http.StatusBadGateway, run: "6"},
+		{name: "server error retry", target: "/api/v1/sessions?status=502", token: "a", key: "k3", code: http.StatusBadGateway, run: "7"},
+	}
+	for _, test := range tests {
+		w := serve(test.target, test.token, test.key, test.body)
+		if w.Code != test.code {
+			t.Fatalf("%s: status = %d %q, want %d", test.name, w.Code, w.Body, test.code)
+		}
+		if got := w.Header().Get("X-Run"); got != test.run {
+			t.Errorf("%s: run = %q, want %q", test.name, got, test.run)
+		}
+		if got := w.Header().Get(idempotentReplayedHeader) == "true"; got != test.replayed {
+			t.Errorf("%s: replayed = %v, want %v", test.name, got, test.replayed)
+		}
+		if test.replayed && w.Body.String() != "run "+test.run {
+			t.Errorf("%s: body = %q, want the first response's", test.name, w.Body)
+		}
+	}
+
+	// A retry arriving while the first attempt runs is refused.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve("/api/v1/sessions?block=1", "a", "k4", "") }()
+	<-started
+	if w := serve("/api/v1/sessions?block=1", "a", "k4", ""); w.Code != http.StatusConflict {
+		t.Errorf("retry in progress = %d, want %d", w.Code, http.StatusConflict)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("first attempt = %d, want %d", w.Code, http.StatusCreated)
+	}
+
+	// Keys are forgotten once they expire.
+	s.mu.Lock()
+	for _, result := range s.results {
+		result.expires = time.Now().Add(-time.Second)
+	}
+	s.mu.Unlock()
+	if w := serve("/api/v1/sessions", "a", "k1", "{}"); w.Header().Get(idempotentReplayedHeader) != "" || w.Header().Get("X-Run") != "9" {
+		t.Errorf("retry after expiry replayed = %q, run %q, want a new run 9", w.Header().Get(idempotentReplayedHeader), w.Header().Get("X-Run"))
+	}
+}
+
+func TestIdempotencyConcurrentRetries(t *testing.T) {
+	s := newIdempotencyStore(&liveConfig{config: &Config{IdempotencyTTL: time.Minute}})
+	var runs atomic.Int32
+	handler := s.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs.Add(1)
+		time.Sleep(time.Millisecond)
+		w.Header().Set("X-Session", "s1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 500; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/sessions", strings.NewReader("{}"))
+			r.Header.Set(idempotencyKeyHeader, "k1")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusCreated && w.Code != http.StatusConflict {
+				t.Errorf("retry = %d, want %d or %d", w.Code, http.StatusCreated, http.StatusConflict)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want once", n)
+	}
+}
+
+func TestIdempotencyUnfinished(t *testing.T) {
+	s := newIdempotencyStore(&liveConfig{config: &Config{IdempotencyTTL: time.Minute}})
+	var runs atomic.Int32
+	handler := s.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if runs.Add(1) == 1 {
+			panic(http.ErrAbortHandler)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/sessions/s1", nil)
+		r.Header.Set(idempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// A handler that panics doesn't hold on to the key.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the handler's panic was swallowed")
+			}
+		}()
+		serve()
+	}()
+	if w := serve(); w.Code != http.StatusNoContent || runs.Load() != 2 {
+		t.Errorf("retry after a panic = %d after %d runs, want %d after 2", w.Code, runs.Load(), http.StatusNoContent)
+	}
+
+	// A request that never finished gives its key up after a while.
+	pending := &idempotentResult{
+		fingerprint: sha256Hex([]byte("DELETE\x00/api/v1/sessions/s1\x00")),
+		expires:     time.Now().Add(idempotencyPendingTimeout),
+	}
+	s.mu.Lock()
+	s.results = map[string]*idempotentResult{sha256Hex([]byte("\x00k1")): pending}
+	s.mu.Unlock()
+	if w := serve(); w.Code != http.StatusConflict {
+		t.Errorf("retry while pending = %d, want %d", w.Code, http.StatusConflict)
+	}
+	s.mu.Lock()
+	pending.expires = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	if w := serve(); w.Code != http.StatusNoContent || runs.Load() != 3 {
+		t.Errorf("retry after the pending request expired = %d after %d runs, want %d after 3", w.Code, runs.Load(), http.StatusNoContent)
+	}
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	s := newIdempotencyStore(&liveConfig{config: &Config{IdempotencyTTL: time.Minute, MaxArtifactBytes: 8}})
+	handler := s.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{"12345678", http.StatusCreated},
+		{"123456789", http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/charts", strings.NewReader(test.body))
+		r.Header.Set(idempotencyKeyHeader, test.body)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("POST of %d bytes = %d, want %d", len(test.body), w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+var (
+	impersonatedTokenSources   = map[string]oauth2.TokenSource{}
+	impersonatedTokenSourcesMu sync.Mutex
+)
+
+// impersonatedTokenSource returns a token source for the service account set
+// in config.ImpersonateServiceAccount, minted through the IAM Credentials
+// API with the proxy's own identity. Tokens are cached and refreshed shortly
+// before they expire.
+func impersonatedTokenSource(config *Config) (oauth2.TokenSource, error) {
+	key := config.ImpersonateServiceAccount + "\x00" + config.Credential + "\x00" + config.CredentialJSON
+
+	impersonatedTokenSourcesMu.Lock()
+	defer impersonatedTokenSourcesMu.Unlock()
+
+	if ts, ok := impersonatedTokenSources[key]; ok {
+		return ts, nil
+	}
+
+	var opts []option.ClientOption
+	if config.CredentialJSON != "" {
+		data, err := inlineCredential(config.CredentialJSON)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithCredentialsJSON([]byte(data)))
+	} else if config.Credential != "" {
+		opts = append(opts, option.WithCredentialsFile(config.Credential))
+	}
+
+	ts, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+		TargetPrincipal: config.ImpersonateServiceAccount,
+		Scopes:          []string{cloudPlatformScope},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	impersonatedTokenSources[key] = ts
+	return ts, nil
+}
+
+// googleClientOptions returns the options for Google API clients acting on
+// the registry, impersonating the configured service account if any, or
+// authenticating with the key given inline.
+func googleClientOptions(config *Config) ([]option.ClientOption, error) {
+	if config.ImpersonateServiceAccount == "" {
+		if config.CredentialJSON == "" {
+			return nil, nil
+		}
+
+		data, err := inlineCredential(config.CredentialJSON)
+		if err != nil {
+			return nil, err
+		}
+		return []option.ClientOption{option.WithCredentialsJSON([]byte(data))}, nil
+	}
+
+	ts, err := impersonatedTokenSource(config)
+	if err != nil {
+		return nil, err
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// incrementalSyncOverlap is how far before the previous sync started a
+// delta sync lists from, so clock skew with the registry doesn't lose
+// updates. Images listed twice are merged again harmlessly.
+const incrementalSyncOverlap = time.Minute
+
+// followerSyncInterval is how often followers refresh the catalog from the
+// leader's snapshot without --sync-interval.
+const followerSyncInterval = time.Minute
+
+// incrementalSyncer keeps the catalog up to date every --sync-interval.
+// With Artifact Registry it only lists the images updated since the last
+// successful sync and merges them into the catalog. Deleted images aren't
+// listed by a delta sync, so the catalog is rebuilt in full every
+// --full-sync-interval, and every sync of other backends is a full one.
+//
+// With leader election only the leader lists the registry, in full as soon
+// as it is elected, and followers load the snapshot it saves instead.
+type incrementalSyncer struct {
+	live   *liveConfig
+	leader *leaderElection
+
+	mu       sync.Mutex
+	since    time.Time
+	lastFull time.Time
+	// followed is when the snapshot last loaded by a follower was written.
+	followed time.Time
+}
+
+// newIncrementalSyncer returns a syncer picking up from the full sync done
+// at startup.
+func newIncrementalSyncer(live *liveConfig, leader *leaderElection) *incrementalSyncer {
+	now := time.Now()
+	return &incrementalSyncer{live: live, leader: leader, since: now, lastFull: now}
+}
+
+// run syncs every interval, which may be zero with leader election for
+// followers to refresh from the snapshot and the leader to sync only when
+// elected.
+func (s *incrementalSyncer) run(ctx context.Context, interval time.Duration) {
+	period := interval
+	if period == 0 {
+		period = followerSyncInterval
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.leader.elected():
+			s.mu.Lock()
+			s.lastFull = time.Time{}
+			s.mu.Unlock()
+			err = s.sync(ctx)
+		case <-ticker.C:
+			switch {
+			case !s.leader.isLeader():
+				err = s.follow(ctx)
+			case interval > 0:
+				err = s.sync(ctx)
+			}
+		}
+		if err != nil {
+			log.Printf("failed to sync catalog. error: %v", err)
+		}
+	}
+}
+
+// follow loads the catalog from the leader's snapshot when it was written
+// since the last time.
+func (s *incrementalSyncer) follow(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, backend := s.live.get()
+	modified, err := snapshotModified(ctx, config)
+	if err != nil || !modified.After(s.followed) {
+		return err
+	}
+	repository, err := loadSnapshot(ctx, config, backend.Name())
+	if err != nil || repository == nil {
+		return err
+	}
+	s.followed = modified
+	if repository.synced.After(currentRepository().synced) {
+		setRepository(repository)
+	}
+	return nil
+}
+
+// sync runs a delta sync, or a full one when it is due.
+func (s *incrementalSyncer) sync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, backend := s.live.get()
+	start := time.Now()
+	gar, ok := backend.(*garBackend)
+	if !ok || start.Sub(s.lastFull) >= config.FullSyncInterval {
+		repository, err := loadRepository(ctx, config, backend)
+		if err != nil {
+			return err
+		}
+		setRepository(repository)
+		s.since, s.lastFull = start, start
+		log.Printf("synced %d assets from %s", repository.len(), backend.Name())
+		return nil
+	}
+
+	ctx, trace := startSyncTrace(ctx, backend)
+	done := trace.phase("delta")
+	assets, err := gar.listUpdatedSince(ctx, s.since.Add(-incrementalSyncOverlap))
+	done()
+	var skipped skippedEntries
+	if errors.As(err, &skipped) {
+		log.Printf("skipped %d catalog entries that failed to sync", len(skipped))
+		err = nil
+	}
+	trace.finish(len(assets), err)
+	if err != nil {
+		return err
+	}
+
+	if len(assets) > 0 {
+		current := currentRepository()
+		previous := &Repository{Revision: current.Revision, Assets: current.holding(assets)}
+		swapRepository(current.merged(assets))
+		if catalogChanged != nil {
+			catalogChanged(previous, &Repository{Assets: assets})
+		}
+		saveSnapshot(ctx, config, backend.Name(), currentRepository())
+	}
+	s.since = start
+	log.Printf("merged %d updated assets from %s", len(assets), backend.Name())
+	return nil
+}
+
+// holding returns the assets of the catalog holding any tag of assets.
+func (r *Repository) holding(assets []*Asset) []*Asset {
+	var held []*Asset
+	if r.store != nil {
+		for _, asset := range assets {
+			for _, tag := range asset.Tags {
+				if stored := r.store.tagged(asset.Name, tag); stored != nil {
+					held = append(held, stored)
+				}
+			}
+		}
+		return held
+	}
+
+	tags := map[string]bool{}
+	for _, asset := range assets {
+		for _, tag := range asset.Tags {
+			tags[asset.Name+"\x00"+tag] = true
+		}
+	}
+	for _, asset := range r.Assets {
+		for _, tag := range asset.Tags {
+			if tags[asset.Name+"\x00"+tag] {
+				held = append(held, asset)
+				break
+			}
+		}
+	}
+	return held
+}
+
+// merged returns the catalog with updated in place of the assets of the
+// same digests, and their tags taken off the digests they were on before
+// being moved.
+func (r *Repository) merged(updated []*Asset) *Repository {
+	conflicts := withConflicts(r.Conflicts, resolveTagConflicts(updated))
+	if r.store != nil {
+		// The store is only ever replaced by a full sync, so it is
+		// updated in place.
+		if err := r.store.merge(updated); err != nil {
+			log.Printf("failed to update catalog store. error: %v", err)
+		}
+		return &Repository{Errors: r.Errors, Conflicts: conflicts, store: r.store}
+	}
+
+	replaced := map[string]bool{}
+	moved := map[string]bool{}
+	for _, asset := range updated {
+		replaced[asset.Name+"\x00"+asset.SHA] = true
+		for _, tag := range asset.Tags {
+			moved[asset.Name+"\x00"+tag] = true
+		}
+	}
+
+	assets := make([]*Asset, 0, len(r.Assets)+len(updated))
+	for _, asset := range r.Assets {
+		if replaced[asset.Name+"\x00"+asset.SHA] {
+			continue
+		}
+
+		var kept []string
+		for _, tag := range asset.Tags {
+			if !moved[asset.Name+"\x00"+tag] {
+				kept = append(kept, tag)
+			}
+		}
+		if len(kept) != len(asset.Tags) {
+			untagged := *asset
+			untagged.Tags = kept
+			asset = &untagged
+		}
+		assets = append(assets, asset)
+	}
+	assets = append(assets, updated...)
+	return &Repository{Assets: assets, Errors: r.Errors, Conflicts: conflicts}
+}
+
+func hasTag(asset *Asset, tag string) bool {
+	for _, t := range asset.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestMerged(t *testing.T) {
+	assets := []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0", "latest"}},
+		{Name: "redis", SHA: "sha256:3", Tags: []string{"latest"}},
+	}
+	updated := []*Asset{
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.1.0", "latest"}},
+		{Name: "redis", SHA: "sha256:3", Tags: []string{"latest", "7.0.0"}},
+	}
+
+	store, err := writeCatalogStore(t.TempDir(), assets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.remove()
+
+	for name, r := range map[string]*Repository{"memory": {Assets: assets}, "store": {store: store}} {
+		held := r.holding(updated)
+		merged := r.merged(updated)
+
+		if len(held) != 2 {
+			t.Errorf("%s: holding() = %d assets, want the 2 tagged latest", name, len(held))
+		}
+		if merged.len() != 3 {
+			t.Errorf("%s: merged catalog has %d assets, want 3", name, merged.len())
+		}
+		if asset := merged.findByTag("nginx", "latest"); asset == nil || asset.SHA != "sha256:2" {
+			t.Errorf("%s: findByTag(nginx, latest) = %v, want the moved tag on sha256:2", name, asset)
+		}
+		if asset := merged.findByDigest("nginx", "sha256:1"); asset == nil || len(asset.Tags) != 1 {
+			t.Errorf("%s: findByDigest(nginx, sha256:1) = %v, want only 1.0.0 left", name, asset)
+		}
+		if asset := merged.findByTag("redis", "7.0.0"); asset == nil {
+			t.Errorf("%s: findByTag(redis, 7.0.0) = nil", name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientAddrKey struct{}
+
+// parsePrefixes parses CIDR ranges, single addresses standing for
+// themselves.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client r comes from. When the peer
+// is one of the trusted proxies, X-Forwarded-For is walked from the right,
+// each trusted proxy vouching for the address before it, down to the first
+// address that isn't a trusted proxy.
+func clientAddr(r *http.Request, trusted []netip.Prefix) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	addr = addr.Unmap()
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && containsAddr(trusted, addr); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+	}
+	return addr, nil
+}
+
+// checkClientAddr resolves the client address of r and checks it against
+// --deny-cidrs, then --allow-cidrs when set. The address is returned even
+// when refused, for logging.
+func checkClientAddr(config *Config, r *http.Request) (netip.Addr, error) {
+	// Validated at startup.
+	trusted, _ := parsePrefixes(config.TrustedProxies)
+	allowed, _ := parsePrefixes(config.AllowCIDRs)
+	denied, _ := parsePrefixes(config.DenyCIDRs)
+
+	addr, err := clientAddr(r, trusted)
+	switch {
+	case err != nil:
+		return addr, err
+	case containsAddr(denied, addr):
+		return addr, fmt.Errorf("%s is denied", addr)
+	case len(allowed) > 0 && !containsAddr(allowed, addr):
+		return addr, fmt.Errorf("%s isn't allowed", addr)
+	}
+	return addr, nil
+}
+
+// filterIPs refuses requests from clients outside --allow-cidrs or inside
+// --deny-cidrs, and hands the client address resolved through
+// --trusted-proxies to audit logs and policies. Health checks are left
+// alone, as load balancers probe from their own ranges.
+func filterIPs(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, _ := live.get()
+			if (len(config.AllowCIDRs) == 0 && len(config.DenyCIDRs) == 0 && len(config.TrustedProxies) == 0) || strings.HasPrefix(r.URL.Path, "/health") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			addr, err := checkClientAddr(config, r)
+			if err != nil {
+				log.Printf("rejected request from %s. error: %v", r.RemoteAddr, err)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, addr)))
+		})
+	}
+}
+
+// forwardedClientAddr returns the client address filterIPs resolved for
+// the request ctx belongs to, if any.
+func forwardedClientAddr(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(clientAddrKey{}).(netip.Addr)
+	return addr, ok
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilterIPs(t *testing.T) {
+	live := &liveConfig{config: &Config{
+		AllowCIDRs:     []string{"10.0.0.0/8", "192.168.1.7"},
+		DenyCIDRs:      []string{"10.6.0.0/16"},
+		TrustedProxies: []string{"35.191.0.0/16"},
+	}}
+	handler := filterIPs(live)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, requestIdentity(r).RemoteAddr)
+	}))
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		path      string
+		code      int
+		client    string
+	}{
+		{"10.1.2.3:1234", "", "/nginx", http.StatusOK, "10.1.2.3"},
+		{"192.168.1.7:1234", "", "/nginx", http.StatusOK, "192.168.1.7"},
+		{"192.168.1.8:1234", "", "/nginx", http.StatusForbidden, ""},
+		{"10.6.0.1:1234", "", "/nginx", http.StatusForbidden, ""},
+		{"[::ffff:10.1.2.3]:1234", "", "/nginx", http.StatusOK, "10.1.2.3"},
+		// Only trusted proxies vouch for X-Forwarded-For.
+		{"10.1.2.3:1234", "192.168.1.8", "/nginx", http.StatusOK, "10.1.2.3"},
+		{"35.191.0.1:1234", "10.1.2.3", "/nginx", http.StatusOK, "10.1.2.3"},
+		{"35.191.0.1:1234", "203.0.113.1", "/nginx", http.StatusForbidden, ""},
+		{"35.191.0.1:1234", "10.1.2.3, 35.191.0.2", "/nginx", http.StatusOK, "10.1.2.3"},
+		{"35.191.0.1:1234", "10.1.2.3, 203.0.113.1", "/nginx", http.StatusForbidden, ""},
+		{"35.191.0.1:1234", "203.0.113.1, 10.1.2.3", "/nginx", http.StatusOK, "10.1.2.3"},
+		{"203.0.113.1:1234", "", "/health", http.StatusOK, "203.0.113.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s from %s forwarding %q = %d, want %d", tt.path, tt.remote, tt.forwarded, w.Code, tt.code)
+		}
+		if tt.code == http.StatusOK && w.Body.String() != tt.client {
+			t.Errorf("%s from %s forwarding %q identified %q, want %q", tt.path, tt.remote, tt.forwarded, w.Body, tt.client)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// lazyMissTTL is how long a chart found missing is remembered, so clients
+// retrying it don't each cost a lookup.
+const lazyMissTTL = time.Minute
+
+// lazyCatalog fills the catalog on demand when --lazy-catalog is set:
+// instead of listing the whole repository up front, a download of a chart
+// missing from the catalog looks it up in Artifact Registry and adds it.
+// Charts are dropped again --lazy-catalog-ttl after their lookup, so moved
+// tags are looked up anew.
+type lazyCatalog struct {
+	live *liveConfig
+
+	mu      sync.Mutex
+	added   map[string]time.Time
+	missing map[string]time.Time
+}
+
+func newLazyCatalog(live *liveConfig) *lazyCatalog {
+	return &lazyCatalog{live: live, added: map[string]time.Time{}, missing: map[string]time.Time{}}
+}
+
+// resolve looks up the chart called name at reference, a tag or a digest,
+// and adds it to the catalog. It returns nil when the lazy catalog is
+// disabled or the repository doesn't have the chart.
+func (l *lazyCatalog) resolve(ctx context.Context, name, reference string) (*Asset, error) {
+	if l == nil {
+		return nil, nil
+	}
+	config, backend := l.live.get()
+	gar, ok := backend.(*garBackend)
+	if !config.LazyCatalog || !ok {
+		return nil, nil
+	}
+
+	key := name + "\x00" + reference
+	l.mu.Lock()
+	missed, ok := l.missing[key]
+	l.mu.Unlock()
+	if ok && time.Since(missed) < lazyMissTTL {
+		return nil, nil
+	}
+
+	asset, err := gar.lookup(ctx, name, reference)
+	if err != nil {
+		lazyLookups.WithLabelValues("error").Inc()
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if asset == nil {
+		lazyLookups.WithLabelValues("missing").Inc()
+		l.missing[key] = time.Now()
+		return nil, nil
+	}
+	lazyLookups.WithLabelValues("found").Inc()
+	log.Printf("looked up %s:%s, adding %s to the catalog", name, reference, asset.SHA)
+	l.add(asset)
+	return asset, nil
+}
+
+// add puts asset in the catalog. Lazily resolved charts aren't new, so
+// webhooks aren't notified.
+func (l *lazyCatalog) add(asset *Asset) {
+	l.added[asset.Name+"\x00"+asset.SHA] = time.Now()
+	swapRepository(currentRepository().merged([]*Asset{asset}))
+}
+
+// run drops expired lookups until ctx is done.
+func (l *lazyCatalog) run(ctx context.Context) {
+	ticker := time.NewTicker(lazyMissTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.expire(now)
+		}
+	}
+}
+
+// expire forgets the charts found missing over lazyMissTTL ago and drops
+// those added over --lazy-catalog-ttl ago from the catalog.
+func (l *lazyCatalog) expire(now time.Time) {
+	config, _ := l.live.get()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, missed := range l.missing {
+		if now.Sub(missed) >= lazyMissTTL {
+			delete(l.missing, key)
+		}
+	}
+
+	expired := map[string]bool{}
+	for key, added := range l.added {
+		if now.Sub(added) >= config.LazyCatalogTTL {
+			expired[key] = true
+			delete(l.added, key)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	current := currentRepository()
+	var kept []*Asset
+	for _, asset := range current.Assets {
+		if !expired[asset.Name+"\x00"+asset.SHA] {
+			kept = append(kept, asset)
+		}
+	}
+	if len(kept) != len(current.Assets) {
+		swapRepository(&Repository{Assets: kept, Errors: current.Errors, Conflicts: current.Conflicts})
+	}
+}
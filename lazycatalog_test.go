@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLazyCatalog(t *testing.T) {
+	previous := swapRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0", "latest"}},
+		{Name: "redis", SHA: "sha256:3", Tags: []string{"latest"}},
+	}})
+	defer swapRepository(previous)
+
+	l := newLazyCatalog(&liveConfig{config: &Config{LazyCatalogTTL: time.Minute}})
+	l.mu.Lock()
+	l.add(&Asset{Name: "nginx", SHA: "sha256:2", Tags: []string{"latest"}})
+	l.mu.Unlock()
+
+	r := currentRepository()
+	if asset := r.findByTag("nginx", "latest"); asset == nil || asset.SHA != "sha256:2" {
+		t.Errorf("findByTag(nginx, latest) = %v, want the moved tag on sha256:2", asset)
+	}
+	if asset := r.findByTag("nginx", "1.0.0"); asset == nil || asset.SHA != "sha256:1" {
+		t.Errorf("findByTag(nginx, 1.0.0) = %v, want sha256:1", asset)
+	}
+	if asset := r.findByTag("redis", "latest"); asset == nil {
+		t.Error("findByTag(redis, latest) = nil, tags of other charts were removed")
+	}
+
+	l.expire(time.Now().Add(time.Minute))
+	if r := currentRepository(); r.len() != 2 || r.findByDigest("nginx", "sha256:2") != nil {
+		t.Errorf("catalog after expiry has %d assets, want the 2 not looked up", r.len())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaderPollInterval is how often a follower starting without a catalog
+// looks for the leader's snapshot.
+const leaderPollInterval = 5 * time.Second
+
+// serviceAccountNamespace holds the namespace of the pod in Kubernetes.
+const serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// leaderElection tells replicas sharing a Kubernetes Lease which one of
+// them runs the background work: catalog sync, desired state sync,
+// retention enforcement and webhook notifications. A nil leaderElection,
+// used when election is disabled, is always the leader.
+type leaderElection struct {
+	identity string
+	leader   atomic.Bool
+
+	// won is signalled every time this replica becomes the leader.
+	won chan struct{}
+	// stopped is closed once the lease is released after ctx is done.
+	stopped chan struct{}
+}
+
+// newLeaderElection joins the election for config.LeaderElectionLease. It
+// returns nil when leader election is disabled.
+func newLeaderElection(ctx context.Context, config *Config) (*leaderElection, error) {
+	if !config.LeaderElection {
+		return nil, nil
+	}
+
+	namespace := config.LeaderElectionNamespace
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect the pod namespace, set --leader-election-namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	identity := os.Getenv("POD_NAME")
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		identity = hostname
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load in-cluster kubernetes config: %w", err)
+	}
+	client, err := coordinationv1.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &leaderElection{identity: identity, won: make(chan struct{}, 1), stopped: make(chan struct{})}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: config.LeaderElectionLease, Namespace: namespace},
+			Client:     client,
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				log.Printf("%s became the leader of %s/%s", identity, namespace, config.LeaderElectionLease)
+				e.leader.Store(true)
+				select {
+				case e.won <- struct{}{}:
+				default:
+				}
+			},
+			OnStoppedLeading: func() {
+				log.Printf("%s stopped leading %s/%s", identity, namespace, config.LeaderElectionLease)
+				e.leader.Store(false)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("%s/%s is led by %s", namespace, config.LeaderElectionLease, leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Run returns when the leadership is lost; stand again until ctx is
+	// done, when the lease is released for another replica to take over
+	// right away.
+	go func() {
+		defer close(e.stopped)
+		for ctx.Err() == nil {
+			elector.Run(ctx)
+		}
+	}()
+	return e, nil
+}
+
+// isLeader reports whether this replica should run the background work.
+func (e *leaderElection) isLeader() bool {
+	return e == nil || e.leader.Load()
+}
+
+// elected is signalled when this replica becomes the leader. It never is
+// without election.
+func (e *leaderElection) elected() <-chan struct{} {
+	if e == nil {
+		return nil
+	}
+	return e.won
+}
+
+// wait blocks until the lease is released after the context the election
+// was joined with is done, or until ctx is.
+func (e *leaderElection) wait(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	select {
+	case <-e.stopped:
+	case <-ctx.Done():
+	}
+}
+
+// awaitCatalog loads the first catalog when there was no snapshot to start
+// from. The leader lists it, followers wait for the snapshot it saves.
+func awaitCatalog(ctx context.Context, config *Config, backend Backend, leader *leaderElection) error {
+	for {
+		if leader.isLeader() {
+			// The catalog is synced right here, not again once elected.
+			select {
+			case <-leader.elected():
+			default:
+			}
+			return initDB(ctx, config, backend)
+		}
+
+		repository, err := loadSnapshot(ctx, config, backend.Name())
+		if err != nil {
+			log.Printf("failed to load the leader's catalog snapshot. error: %v", err)
+		}
+		if repository != nil {
+			setRepository(repository)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-leader.elected():
+		case <-time.After(leaderPollInterval):
+		}
+	}
+}
+
+// handleStatus reports which replica answered and whether it leads.
+func (e *leaderElection) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		Enabled  bool   `json:"enabled"`
+		Identity string `json:"identity,omitempty"`
+		Leader   bool   `json:"leader"`
+	}{Enabled: e != nil, Leader: e.isLeader()}
+	if e != nil {
+		status.Identity = e.identity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
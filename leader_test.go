@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLeaderCatalogSync(t *testing.T) {
+	defer setRepository(&Repository{})
+	snapshot := filepath.Join(t.TempDir(), "catalog.json.gz")
+	config := &Config{CatalogSnapshot: snapshot}
+	backend := &listedBackend{[]*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}}
+	follower := &leaderElection{won: make(chan struct{}, 1)}
+	ctx := context.Background()
+
+	// A follower waits for the leader's snapshot rather than listing.
+	saved := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		saved <- saveFileSnapshot(snapshot, backend.Name(), &Repository{Assets: []*Asset{{Name: "redis", SHA: "sha256:2", Tags: []string{"7.0.0"}}}})
+		follower.won <- struct{}{}
+	}()
+	setRepository(&Repository{})
+	if err := awaitCatalog(ctx, config, backend, follower); err != nil {
+		t.Fatalf("awaitCatalog() = %v", err)
+	}
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if currentRepository().findByTag("redis", "7.0.0") == nil || currentRepository().findByTag("nginx", "1.0.0") != nil {
+		t.Fatalf("follower catalog = %v, want the snapshot's", currentRepository().Assets)
+	}
+
+	// Followers reload the snapshot only when it changes.
+	syncer := newIncrementalSyncer(&liveConfig{config: config, backend: backend}, follower)
+	if err := syncer.follow(ctx); err != nil {
+		t.Fatal(err)
+	}
+	revision := currentRepository().Revision
+	if err := syncer.follow(ctx); err != nil || currentRepository().Revision != revision {
+		t.Errorf("follow() of an unchanged snapshot = %v, revision %d, want %d", err, currentRepository().Revision, revision)
+	}
+	if err := saveFileSnapshot(snapshot, backend.Name(), &Repository{Assets: backend.assets}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(snapshot, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.follow(ctx); err != nil || currentRepository().findByTag("nginx", "1.0.0") == nil {
+		t.Errorf("follow() of a new snapshot = %v, catalog %v, want nginx", err, currentRepository().Assets)
+	}
+
+	// Without election the replica lists the catalog itself.
+	setRepository(&Repository{})
+	if err := awaitCatalog(ctx, &Config{}, backend, nil); err != nil || currentRepository().findByTag("nginx", "1.0.0") == nil {
+		t.Errorf("awaitCatalog() without election = %v, catalog %v, want it listed", err, currentRepository().Assets)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// listenAddress is one socket the server listens on.
+type listenAddress struct {
+	network string
+	address string
+}
+
+func (a listenAddress) String() string {
+	return a.network + "://" + a.address
+}
+
+// parseListen parses a comma-separated list of network://host:port bind
+// addresses, where network is tcp (dual-stack where the host allows it), tcp4
+// or tcp6, e.g. "tcp4://0.0.0.0:8080,tcp6://[::]:8080".
+func parseListen(value string) ([]listenAddress, error) {
+	var addresses []listenAddress
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		network, address, ok := strings.Cut(spec, "://")
+		if !ok {
+			return nil, fmt.Errorf("invalid listen address %q, expected tcp://, tcp4:// or tcp6://<host>:<port>", spec)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", spec, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid listen address %q: bad port %q", spec, port)
+		}
+
+		ip := net.ParseIP(host)
+		switch network {
+		case "tcp":
+		case "tcp4":
+			if ip != nil && ip.To4() == nil {
+				return nil, fmt.Errorf("invalid listen address %q: %s is not an IPv4 address", spec, host)
+			}
+		case "tcp6":
+			if ip != nil && ip.To4() != nil {
+				return nil, fmt.Errorf("invalid listen address %q: %s is not an IPv6 address", spec, host)
+			}
+		default:
+			return nil, fmt.Errorf("invalid listen address %q: unknown network %q", spec, network)
+		}
+
+		addresses = append(addresses, listenAddress{network: network, address: address})
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no listen address in %q", value)
+	}
+	return addresses, nil
+}
+
+// portAddress turns --port into a bind address. The port may come alone,
+// as platforms like Cloud Run set it, with an empty host, or with a host,
+// e.g. 8080, :8080 or 0.0.0.0:8080. host, from --bind-host, is used when
+// the port comes without one.
+func portAddress(port, host string) (string, error) {
+	address := port
+	if _, _, err := net.SplitHostPort(port); err != nil {
+		address = net.JoinHostPort(host, port)
+	}
+
+	portHost, portNumber, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q: %w", port, err)
+	}
+	if n, err := strconv.Atoi(portNumber); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if portHost == "" {
+		portHost = host
+	}
+	return net.JoinHostPort(portHost, portNumber), nil
+}
+
+// listenAddresses returns where to listen: --listen if set, otherwise
+// --port on every address family.
+func (c *Config) listenAddresses() ([]listenAddress, error) {
+	if c.Listen == "" {
+		address, err := portAddress(c.Port, c.BindHost)
+		if err != nil {
+			return nil, err
+		}
+		return []listenAddress{{network: "tcp", address: address}}, nil
+	}
+	return parseListen(c.Listen)
+}
+
+// listen opens a listener for every address, closing them all if any fails.
+func listen(addresses []listenAddress) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		listener, err := net.Listen(address.network, address.address)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// serverTLS loads --tls-cert-file and --tls-key-file, or returns nil to
+// serve plaintext. net/http negotiates HTTP/2 over TLS by itself.
+func (c *Config) serverTLS() (*tls.Config, error) {
+	if c.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+type peerAddrKey struct{}
+
+// connContext records the address of the peer a connection was accepted
+// from, which the PROXY protocol hides behind the client's in RemoteAddr.
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	addr := conn.RemoteAddr()
+	if proxied, ok := conn.(*proxyProtocolConn); ok {
+		addr = proxied.Conn.RemoteAddr()
+	}
+	return context.WithValue(ctx, peerAddrKey{}, addr)
+}
+
+// peerAddr returns the address connContext recorded for the connection
+// ctx belongs to.
+func peerAddr(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(peerAddrKey{}).(net.Addr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	peer, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return peer.Addr().Unmap(), true
+}
+
+// h2cHandler serves HTTP/2 over plaintext, by prior knowledge or upgrade,
+// to the trusted proxies, or any client when trusted is empty. Others are
+// served HTTP/1.1 only. Proxies are recognized by the peer of the
+// connection, not the client address a PROXY protocol header gives.
+func h2cHandler(handler http.Handler, trusted []netip.Prefix) http.Handler {
+	upgraded := h2c.NewHandler(handler, &http2.Server{})
+	if len(trusted) == 0 {
+		return upgraded
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if peer, ok := peerAddr(r.Context()); ok && containsAddr(trusted, peer) {
+			upgraded.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestPortAddress(t *testing.T) {
+	tests := []struct {
+		port, host string
+		want       string
+		err        bool
+	}{
+		{"8080", "", ":8080", false},
+		{":8080", "", ":8080", false},
+		{"0.0.0.0:8080", "", "0.0.0.0:8080", false},
+		{"8080", "127.0.0.1", "127.0.0.1:8080", false},
+		{":8080", "::1", "[::1]:8080", false},
+		{"0.0.0.0:8080", "127.0.0.1", "0.0.0.0:8080", false},
+		{"http", "", "", true},
+		{"99999", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := portAddress(tt.port, tt.host)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("portAddress(%q, %q) = %q, %v, want %q", tt.port, tt.host, got, err, tt.want)
+		}
+	}
+}
+
+func TestH2C(t *testing.T) {
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	tests := []struct {
+		trusted []string
+		proxied bool
+		proto   string
+	}{
+		{nil, false, "HTTP/2.0"},
+		{[]string{"127.0.0.0/8"}, false, "HTTP/2.0"},
+		{[]string{"10.0.0.0/8"}, false, ""},
+		// The load balancer is trusted, whatever client it passes on.
+		{[]string{"127.0.0.0/8"}, true, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		trusted, _ := parsePrefixes(tt.trusted)
+		server := httptest.NewUnstartedServer(h2cHandler(proto, trusted))
+		server.Config.ConnContext = connContext
+		if tt.proxied {
+			server.Listener = &proxyProtocolListener{Listener: server.Listener, trusted: trusted}
+		}
+		server.Start()
+		client := client
+		if tt.proxied {
+			client = &http.Client{Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+					if err == nil {
+						_, err = io.WriteString(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 51234 80\r\n")
+					}
+					return conn, err
+				},
+			}}
+		}
+		resp, err := client.Get(server.URL)
+		var body []byte
+		if err == nil {
+			body, _ = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body = nil
+			}
+		}
+		if string(body) != tt.proto {
+			t.Errorf("h2c trusting %v served %q (%v), want %q", tt.trusted, body, err, tt.proto)
+		}
+
+		if tt.proxied {
+			server.Close()
+			continue
+		}
+		resp, err = http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/1.1" {
+			t.Errorf("h2c trusting %v served HTTP/1.1 clients %q", tt.trusted, body)
+		}
+		server.Close()
+	}
+}
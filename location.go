@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	artifactregistry "cloud.google.com/go/artifactregistry/apiv1"
+	"google.golang.org/api/iterator"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+)
+
+// registryHost returns the Docker-compatible host serving repositories in
+// location. Regional ("us-central1") and multi-regional ("us", "europe",
+// "asia") locations share the same naming scheme.
+func registryHost(location string) string {
+	return fmt.Sprintf("%s-docker.pkg.dev", location)
+}
+
+// projectPath returns the project as it appears in image paths. Domain-scoped
+// projects such as "example.com:my-project" are addressed as
+// "example.com/my-project" by the registry.
+func projectPath(project string) string {
+	return strings.Replace(project, ":", "/", 1)
+}
+
+// repositoryURI returns the registry address of the configured repository,
+// e.g. "us-docker.pkg.dev/my-project/my-repository".
+func repositoryURI(config *Config) string {
+	return fmt.Sprintf("%s/%s/%s", registryHost(config.Region), projectPath(config.Project), config.Repository)
+}
+
+// validateLocation checks that config.Region is a location Artifact Registry
+// serves for the project, so typos fail at startup instead of on first pull.
+func validateLocation(ctx context.Context, config *Config, client *artifactregistry.Client) error {
+	req := &locationpb.ListLocationsRequest{
+		Name: fmt.Sprintf("projects/%s", config.Project),
+	}
+
+	var known []string
+	it := client.ListLocations(ctx, req)
+	for {
+		location, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to list locations: %w", err)
+		}
+
+		if location.LocationId == config.Region {
+			return nil
+		}
+		known = append(known, location.LocationId)
+	}
+
+	sort.Strings(known)
+	return fmt.Errorf("unknown location %q, expected one of: %s", config.Region, strings.Join(known, ", "))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// loginTTL bounds how long a registry login is reused even if the credential
+// didn't change, in case the registry invalidated the session.
+const loginTTL = 30 * time.Minute
+
+// loginCache keeps a registry client per backend, logged in with the
+// credential of that backend, so repositories fronted together each pull
+// with their own service account, key or impersonation target even when
+// they share a registry host. A client only logs in again after its
+// credential rotated or the session aged out.
+type loginCache struct {
+	mu       sync.Mutex
+	options  []registry.ClientOption
+	dir      string
+	sessions map[string]*loginSession
+}
+
+type loginSession struct {
+	client   *registry.Client
+	user     string
+	password string
+	at       time.Time
+}
+
+// newLoginCache returns a cache creating its clients with options.
+func newLoginCache(options ...registry.ClientOption) *loginCache {
+	return &loginCache{options: options, sessions: map[string]*loginSession{}}
+}
+
+// client returns the registry client of backend, logged in to its host with
+// user and password. Every backend gets a client, and a credentials file,
+// of its own: the registry client remembers one credential per host.
+func (c *loginCache) client(backend Backend, user, password string) (*registry.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := backend.Name()
+	session, ok := c.sessions[key]
+	if ok && session.user == user && session.password == password && time.Since(session.at) < loginTTL {
+		return session.client, nil
+	}
+
+	if !ok {
+		if c.dir == "" {
+			dir, err := os.MkdirTemp("", "gcp-oci-proxy-logins-")
+			if err != nil {
+				return nil, err
+			}
+			c.dir = dir
+		}
+
+		path := filepath.Join(c.dir, fmt.Sprintf("%d.json", len(c.sessions)))
+		client, err := registry.NewClient(append(c.options, registry.ClientOptCredentialsFile(path))...)
+		if err != nil {
+			return nil, err
+		}
+		session = &loginSession{client: client}
+		c.sessions[key] = session
+	}
+
+	if err := session.client.Login(backend.Host(), registry.LoginOptBasicAuth(user, password)); err != nil {
+		session.at = time.Time{}
+		return nil, err
+	}
+
+	session.user, session.password, session.at = user, password, time.Now()
+	return session.client, nil
+}
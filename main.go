@@ -1,38 +1,101 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
 
 	"helm.sh/helm/v3/pkg/registry"
-
-	artifactregistry "cloud.google.com/go/artifactregistry/apiv1"
-	artifactregistrypb "cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
-	"google.golang.org/api/iterator"
 )
 
-type Config struct {
-	Project    string
-	Repository string
-	Region     string
-	Port       string
-	Credential string
-}
-
 type Repository struct {
 	Assets []*Asset `json:"assets"`
+
+	// Revision increases every time a new catalog is loaded.
+	Revision uint64 `json:"revision"`
+
+	// Errors lists the entries skipped when the catalog was loaded.
+	Errors []syncError `json:"errors,omitempty"`
+
+	// Conflicts lists the tags found on more than one digest of a chart.
+	Conflicts []tagConflict `json:"conflicts,omitempty"`
+
+	// store, when set, holds the assets on disk instead of Assets.
+	store *catalogStore
+
+	// synced is when the snapshot the catalog was loaded from was taken.
+	synced time.Time
+}
+
+// each calls fn with every asset of the catalog.
+func (r *Repository) each(fn func(*Asset)) {
+	if r.store != nil {
+		r.store.scan("", func(asset *Asset) bool {
+			fn(asset)
+			return true
+		})
+		return
+	}
+	for _, asset := range r.Assets {
+		fn(asset)
+	}
+}
+
+// eachNamed calls fn with every asset of the chart called name.
+func (r *Repository) eachNamed(name string, fn func(*Asset)) {
+	if r.store != nil {
+		r.store.scan(string(catalogKey(name, "")), func(asset *Asset) bool {
+			fn(asset)
+			return true
+		})
+		return
+	}
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			fn(asset)
+		}
+	}
+}
+
+// hasChart reports whether the catalog holds any version of the chart
+// called name.
+func (r *Repository) hasChart(name string) bool {
+	if r.store != nil {
+		found := false
+		r.store.scan(string(catalogKey(name, "")), func(*Asset) bool {
+			found = true
+			return false
+		})
+		return found
+	}
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// len returns how many assets the catalog holds.
+func (r *Repository) len() int {
+	if r.store != nil {
+		return int(r.store.count.Load())
+	}
+	return len(r.Assets)
 }
 
 type Asset struct {
@@ -41,29 +104,135 @@ type Asset struct {
 	RawName   string    `json:"raw_name"`
 	URI       string    `json:"uri"`
 	MediaType string    `json:"media_type"`
-	Tags      []*string `json:"tags"`
+	Tags      []string  `json:"tags"`
+	Updated   time.Time `json:"updated"`
+	Size      int64     `json:"size"`
+
+	// Uploaded and Built are when the image was pushed and built, as far
+	// as the backend reports them.
+	Uploaded time.Time `json:"uploaded"`
+	Built    time.Time `json:"built"`
+
+	// sources lists the backends holding the asset when it is served by a
+	// composite backend.
+	sources []*assetSource
+	// origin is the upstream an asset resolved in pull-through mode comes
+	// from, instead of the catalog backend.
+	origin Backend
+}
+
+// findByTag returns the asset of the chart called name tagged tag, or nil.
+func (r *Repository) findByTag(name, tag string) *Asset {
+	if r.store != nil {
+		return r.store.tagged(name, tag)
+	}
+	for _, asset := range r.Assets {
+		if asset.Name != name {
+			continue
+		}
+
+		for _, t := range asset.Tags {
+			if t == tag {
+				return asset
+			}
+		}
+	}
+	return nil
+}
+
+// findByDigest returns the asset of the chart called name with the given
+// digest, or nil.
+func (r *Repository) findByDigest(name, digest string) *Asset {
+	if r.store != nil {
+		return r.store.get(name, digest)
+	}
+	for _, asset := range r.Assets {
+		if asset.Name == name && asset.SHA == digest {
+			return asset
+		}
+	}
+	return nil
+}
+
+// findByDigestPrefix returns the assets of the chart called name whose
+// digest starts with prefix, with or without its algorithm, one per digest.
+func (r *Repository) findByDigestPrefix(name, prefix string) []*Asset {
+	var matches []*Asset
+	seen := map[string]bool{}
+	r.eachNamed(name, func(asset *Asset) {
+		if seen[asset.SHA] {
+			return
+		}
+
+		_, encoded, _ := strings.Cut(asset.SHA, ":")
+		if strings.HasPrefix(asset.SHA, prefix) || strings.HasPrefix(encoded, prefix) {
+			seen[asset.SHA] = true
+			matches = append(matches, asset)
+		}
+	})
+	return matches
 }
 
 var (
 	RepositoryDB *Repository = &Repository{}
+	repositoryMu sync.RWMutex
+
+	// chartPulled, when set, is told about every chart pulled from a
+	// registry rather than the cache.
+	chartPulled func(asset *Asset, chart *cachedChart)
+
+	// catalogChanged, when set, is told about every catalog replacement.
+	catalogChanged func(previous, current *Repository)
 )
 
-func newServer(router *chi.Mux) *http.Server {
-	listen := os.Getenv("PORT")
-	if listen == "" {
-		listen = ":8080"
+func currentRepository() *Repository {
+	repositoryMu.RLock()
+	defer repositoryMu.RUnlock()
+	return RepositoryDB
+}
+
+func setRepository(repository *Repository) {
+	previous := swapRepository(repository)
+	if catalogChanged != nil {
+		catalogChanged(previous, repository)
 	}
+}
 
+// swapRepository replaces the catalog with repository without notifying
+// anyone of the change, and returns the catalog it replaced.
+func swapRepository(repository *Repository) *Repository {
+	repositoryMu.Lock()
+	defer repositoryMu.Unlock()
+	previous := RepositoryDB
+	repository.Revision = previous.Revision + 1
+	RepositoryDB = repository
+	tagConflicts.Set(float64(len(repository.Conflicts)))
+	return previous
+}
+
+// newServer returns the server of handler. HTTP/2 is served over TLS with
+// --tls-cert-file set, and over plaintext with --h2c.
+func newServer(config *Config, handler http.Handler) (*http.Server, error) {
+	tlsConfig, err := config.serverTLS()
+	if err != nil {
+		return nil, err
+	}
+	if config.H2C {
+		trusted, _ := parsePrefixes(config.TrustedProxies)
+		handler = h2cHandler(handler, trusted)
+	}
 	return &http.Server{
-		Addr:        listen,
-		Handler:     router,
+		Handler:     handler,
 		ReadTimeout: 5 * time.Second,
-	}
+		TLSConfig:   tlsConfig,
+		ConnContext: connContext,
+	}, nil
 }
 
-func defaultRouter(healthCheck func(w http.ResponseWriter, r *http.Request)) *chi.Mux {
+func defaultRouter(healthCheck func(w http.ResponseWriter, r *http.Request), middlewares ...func(http.Handler) http.Handler) *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(middleware.Logger, middleware.Recoverer)
+	router.Use(middlewares...)
 	if healthCheck == nil {
 		healthCheck = defaultHealthCheck
 	}
@@ -75,252 +244,441 @@ func defaultHealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "ok")
 }
 
-func formatPath(config *Config) (string, error) {
-	return fmt.Sprintf(
-		"projects/%s/locations/%s/repositories/%s",
-		config.Project, config.Region, config.Repository,
-	), nil
-}
-
-func newConfig() (*Config, error) {
-	project := os.Getenv("PROJECT")
-	if project == "" {
-		return nil, fmt.Errorf("missing project")
+func loadRepository(ctx context.Context, config *Config, backend Backend) (*Repository, error) {
+	if config.LazyCatalog {
+		// Charts are looked up as they are requested instead.
+		return &Repository{}, nil
 	}
 
-	repository := os.Getenv("REPOSITORY")
-	if repository == "" {
-		return nil, fmt.Errorf("missing repository")
+	ctx, trace := startSyncTrace(ctx, backend)
+	done := trace.phase("list")
+	assets, err := backend.List(ctx)
+	done()
+	var skipped skippedEntries
+	if errors.As(err, &skipped) {
+		log.Printf("skipped %d catalog entries that failed to sync", len(skipped))
+		err = nil
 	}
-
-	region := os.Getenv("REGION")
-	if region == "" {
-		region = "us-central1"
+	if err != nil {
+		trace.finish(0, err)
+		return nil, err
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = ":8080"
+	repository, err := newCatalog(config, assets, skipped, trace)
+	if err != nil {
+		trace.finish(0, err)
+		return nil, err
 	}
+	trace.finish(len(assets), nil)
 
-	credential := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credential == "" {
-		return nil, fmt.Errorf("missing credential")
-	}
+	syncSkippedEntries.Set(float64(len(skipped)))
+	saveSnapshot(ctx, config, backend.Name(), repository)
+	return repository, nil
+}
 
-	return &Config{
-		Project:    project,
-		Repository: repository,
-		Region:     region,
-		Port:       port,
-		Credential: credential,
-	}, nil
+// newCatalog builds the catalog of assets, keeping it on disk when
+// --catalog-path asks for it.
+func newCatalog(config *Config, assets []*Asset, skipped skippedEntries, trace *syncTrace) (*Repository, error) {
+	conflicts := resolveTagConflicts(assets)
+
+	done := trace.phase("compact")
+	size := compactAssets(assets)
+	done()
+	over := overCatalogBudget(config, size, len(assets))
+
+	if config.CatalogPath != "" && (config.CatalogMemoryBytes == 0 || over) {
+		done = trace.phase("store")
+		store, err := writeCatalogStore(config.CatalogPath, assets)
+		done()
+		if err != nil {
+			return nil, err
+		}
+		catalogBytes.Set(0)
+		return &Repository{Errors: skipped, Conflicts: conflicts, store: store}, nil
+	}
+	catalogBytes.Set(float64(size))
+	return &Repository{Assets: assets, Errors: skipped, Conflicts: conflicts}, nil
 }
 
-func initDB(ctx context.Context, config *Config, client *artifactregistry.Client) error {
-	formattedPath, err := formatPath(config)
+func initDB(ctx context.Context, config *Config, backend Backend) error {
+	repository, err := loadRepository(ctx, config, backend)
 	if err != nil {
 		return err
 	}
 
-	req := &artifactregistrypb.ListDockerImagesRequest{
-		Parent: formattedPath,
-	}
+	setRepository(repository)
+	return nil
+}
 
-	it := client.ListDockerImages(ctx, req)
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
+func getCredential(config *Config) (string, string, error) {
+	// Without a credential pullFrom skips the login.
+	if config.Anonymous {
+		return "", "", nil
+	}
 
-		if err != nil && err != iterator.Done {
-			return err
+	if config.ImpersonateServiceAccount != "" {
+		ts, err := impersonatedTokenSource(config)
+		if err != nil {
+			return "", "", err
 		}
 
-		name, sha, err := extractNameAndSha(resp.Name)
+		token, err := ts.Token()
 		if err != nil {
-			return err
+			return "", "", err
 		}
+		return "oauth2accesstoken", token.AccessToken, nil
+	}
 
-		var asset *Asset = &Asset{
-			Name:      name,
-			SHA:       sha,
-			RawName:   resp.Name,
-			URI:       resp.Uri,
-			MediaType: resp.MediaType,
+	if config.CredentialSecret != "" {
+		secret, err := watchSecret(context.Background(), config.CredentialSecret, config.CredentialRefresh)
+		if err != nil {
+			return "", "", err
 		}
+		return "_json_key", secret.get(), nil
+	}
 
-		for _, tag := range resp.Tags {
-			asset.Tags = append(asset.Tags, &tag)
+	if config.CredentialJSON != "" {
+		key, err := inlineCredential(config.CredentialJSON)
+		if err != nil {
+			return "", "", err
 		}
+		return "_json_key", key, nil
+	}
 
-		RepositoryDB.Assets = append(RepositoryDB.Assets, asset)
+	file, err := watchCredentialFile(config.Credential, config.CredentialRefresh)
+	if err != nil {
+		return "", "", err
 	}
-	return nil
+	return "_json_key", file.get(), nil
 }
 
-func extractNameAndSha(input string) (name, sha string, err error) {
-	parts := strings.Split(input, "/")
+// pullGroup deduplicates concurrent pulls of the same digest.
+var pullGroup singleflight.Group
+
+// pullSlots, when set, bounds the pulls from registries in flight, as
+// --max-concurrent-pulls asks.
+var pullSlots chan struct{}
 
-	if len(parts) < 2 {
-		return "", "", fmt.Errorf("invalid input format")
+// pullAsset returns the chart stored in asset, from cache when possible.
+// Concurrent requests for the same digest share a single upstream pull; a
+// waiter giving up doesn't cancel it for the others.
+func pullAsset(ctx context.Context, client *registry.Client, logins *loginCache, cache chartCache, stats *downloadStats, backend Backend, asset *Asset) (*cachedChart, error) {
+	if chart, ok := cache.get(asset.SHA); ok {
+		return chart, nil
 	}
 
-	namePart := parts[len(parts)-1]
-	nameParts := strings.Split(namePart, "@")
+	ch := pullGroup.DoChan(asset.SHA, func() (interface{}, error) {
+		if pullSlots != nil {
+			upstreamPullsWaiting.Inc()
+			pullSlots <- struct{}{}
+			upstreamPullsWaiting.Dec()
+			defer func() { <-pullSlots }()
+		}
+
+		result, err := pullUpstream(context.Background(), client, logins, backend, asset)
+		if err != nil {
+			return nil, err
+		}
 
-	if len(nameParts) != 2 {
-		return "", "", fmt.Errorf("invalid name and SHA format")
+		chart := &cachedChart{
+			Name:    result.Chart.Meta.Name,
+			Version: result.Chart.Meta.Version,
+			Data:    result.Chart.Data,
+		}
+		stats.recordPull(int64(len(chart.Data)))
+		cache.put(asset.SHA, chart)
+		if chartPulled != nil {
+			chartPulled(asset, chart)
+		}
+		return chart, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*cachedChart), nil
 	}
+}
 
-	name = nameParts[0]
-	sha = nameParts[1]
+// pullUpstream logs in to the backend, reusing the previous session when the
+// credential hasn't changed, and pulls the chart stored in asset.
+func pullUpstream(ctx context.Context, client *registry.Client, logins *loginCache, backend Backend, asset *Asset) (*registry.PullResult, error) {
+	if asset.origin != nil {
+		return pullFrom(ctx, client, logins, asset.origin, asset.URI)
+	}
 
-	return name, sha, nil
+	composite, ok := backend.(*compositeBackend)
+	if !ok {
+		return pullFrom(ctx, client, logins, backend, asset.URI)
+	}
+
+	var result *registry.PullResult
+	err := composite.pull(asset, func(backend Backend, uri string) error {
+		var err error
+		result, err = pullFrom(ctx, client, logins, backend, uri)
+		return err
+	})
+	return result, err
 }
 
-func getCredential(config *Config) (string, string, error) {
-	credentialFile, err := os.Open(config.Credential)
+func pullFrom(ctx context.Context, client *registry.Client, logins *loginCache, backend Backend, uri string) (*registry.PullResult, error) {
+	user, credential, err := backend.Credential(ctx)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	defer credentialFile.Close()
 
-	credentialBytes, err := io.ReadAll(credentialFile)
-	if err != nil {
-		return "", "", err
+	// Public registries are pulled from anonymously.
+	if user != "" || credential != "" {
+		client, err = logins.client(backend, user, credential)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return "_json_key", string(credentialBytes), nil
+	return client.Pull(uri)
 }
 
 func main() {
+	useUTC()
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(exitCode(err))
+	}
+}
+
+func serve(config *Config, load func() (*Config, error)) error {
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
-	config, err := newConfig()
+	httpClient, err := newUpstreamClient(config)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	upstreamClient = httpClient
 
-	ctx := context.Background()
-	c, err := artifactregistry.NewClient(ctx)
+	addresses, err := config.listenAddresses()
 	if err != nil {
-		log.Fatal(err)
+		return withExitCode(exitConfig, err)
 	}
-	defer c.Close()
-
-	if err := initDB(ctx, config, c); err != nil {
-		log.Fatalf("failed to init db. error: %v", err)
+	listeners, err := listen(addresses)
+	if err != nil {
+		return withExitCode(exitListener, err)
+	}
+	if config.ProxyProtocol {
+		trusted, _ := parsePrefixes(config.TrustedProxies)
+		for i, listener := range listeners {
+			listeners[i] = &proxyProtocolListener{Listener: listener, trusted: trusted}
+		}
 	}
 
-	client, err := registry.NewClient(registry.ClientOptDebug(true))
+	// The port opens right away, answering the startup probe, while the
+	// backend is set up and the catalog synced.
+	startup := newStartupGate()
+	server, err := newServer(config, startup)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	router := defaultRouter(nil)
-
-	router.Get("/index.yaml", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "apiVersion: v2")
-		fmt.Fprintln(w, "entries:")
-		for _, asset := range RepositoryDB.Assets {
-			if len(asset.Tags) > 0 {
-				fmt.Fprintf(w, "  %s:\n", asset.Name)
-				fmt.Fprintf(w, "  - created: %s\n", time.Now().Format(time.RFC3339))
-				fmt.Fprintf(w, "    description: A Helm chart for Kubernetes\n")
-				fmt.Fprintf(w, "    digest: %s\n", strings.Split(asset.SHA, ":")[1])
-				fmt.Fprintf(w, "    name: %s\n", asset.Name)
-				fmt.Fprintf(w, "    type: application\n")
-				fmt.Fprintf(w, "    urls:\n")
-				fmt.Fprintf(w, "    - http://gcp-oci-proxy.gcp-oci-proxy.svc.cluster.local/%s:%s\n", asset.Name, *asset.Tags[0])
-				// fmt.Fprintf(w, "      - %s\n", asset.URI)
-				fmt.Fprintf(w, "    version: %s\n", *asset.Tags[0])
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		return withExitCode(exitConfig, err)
+	}
+	defer server.Close()
+	failed := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		log.Printf("listening on %s", addresses[i])
+		go func(listener net.Listener) {
+			serve := server.Serve
+			if server.TLSConfig != nil {
+				serve = func(listener net.Listener) error { return server.ServeTLS(listener, "", "") }
+			}
+			if err := serve(listener); err != nil && err != http.ErrServerClosed {
+				failed <- withExitCode(exitListener, fmt.Errorf("listen on %s: %w", listener.Addr(), err))
 			}
+		}(listener)
+	}
+
+	// Cancelled on shutdown, which stops the background work and releases
+	// the leader election lease.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	backend, err := newBackend(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// Only the leader lists the catalog, so the election is joined first.
+	leader, err := newLeaderElection(ctx, config)
+	if err != nil {
+		backend.Close()
+		return fmt.Errorf("failed to join leader election. error: %w", err)
+	}
+
+	// A snapshot lets traffic be served right away, while the first sync
+	// runs in the background.
+	snapshot, err := loadSnapshot(ctx, config, backend.Name())
+	if err != nil {
+		log.Printf("failed to load catalog snapshot, syncing instead. error: %v", err)
+	}
+	if snapshot != nil {
+		setRepository(snapshot)
+	} else {
+		if err := awaitCatalog(ctx, config, backend, leader); err != nil {
+			backend.Close()
+			return fmt.Errorf("failed to init db. error: %w", err)
 		}
-	})
+		log.Printf("loaded %d assets from %s", currentRepository().len(), backend.Name())
+	}
+
+	live := &liveConfig{config: config, backend: backend}
 
-	router.Get("/{assetName}@{assetSHA}", func(w http.ResponseWriter, r *http.Request) {
-		var assetName = chi.URLParam(r, "assetName")
-		var assetSHA = chi.URLParam(r, "assetSHA")
-		log.Println(assetName, assetSHA)
-		for _, asset := range RepositoryDB.Assets {
-			if asset.Name == assetName && asset.SHA == assetSHA {
-				user, credential, err := getCredential(config)
-				if err != nil {
-					log.Fatal(err)
-				}
-				err = client.Login(asset.URI, registry.LoginOptBasicAuth(
-					user,
-					credential,
-				))
-				if err != nil {
-					log.Fatal(err)
-				}
-				result, err := client.Pull(asset.URI)
-				if err != nil {
-					log.Fatal(err)
-				}
-				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.tgz", result.Chart.Meta.Name, result.Chart.Meta.Version))
-				w.WriteHeader(http.StatusOK)
-				reader := bytes.NewReader(result.Chart.Data)
-				io.Copy(w, reader)
+	webhooks := newWebhookNotifier(live, leader)
+	go webhooks.run(ctx)
+	events := newEventStream()
+	server.RegisterOnShutdown(events.close)
+	catalogChanged = func(previous, current *Repository) {
+		webhooks.catalogChanged(previous, current)
+		events.catalogChanged(previous, current)
+	}
+	if snapshot != nil && leader == nil {
+		// Versions published since the snapshot are announced like those
+		// of any sync. An elected leader syncs once it wins instead.
+		go func() {
+			if err := initDB(ctx, config, backend); err != nil {
+				log.Printf("failed to sync catalog, serving the snapshot. error: %v", err)
 				return
 			}
-		}
-	})
+			log.Printf("loaded %d assets from %s", currentRepository().len(), backend.Name())
+		}()
+	}
+	live.watchReload(ctx, load)
+	defer func() {
+		_, backend := live.get()
+		backend.Close()
+	}()
 
-	router.Get("/{assetName}:{assetTag}", func(w http.ResponseWriter, r *http.Request) {
-		var assetName = chi.URLParam(r, "assetName")
-		var assetTag = chi.URLParam(r, "assetTag")
-
-		for _, asset := range RepositoryDB.Assets {
-			if asset.Name == assetName {
-				for _, tag := range asset.Tags {
-					if *tag == assetTag {
-						user, credential, err := getCredential(config)
-						if err != nil {
-							log.Fatal(err)
-						}
-						err = client.Login(asset.URI, registry.LoginOptBasicAuth(
-							user,
-							credential,
-						))
-						if err != nil {
-							log.Fatal(err)
-						}
-						result, err := client.Pull(asset.URI)
-						if err != nil {
-							log.Fatal(err)
-						}
-						w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.tgz", result.Chart.Meta.Name, result.Chart.Meta.Version))
-						reader := bytes.NewReader(result.Chart.Data)
-						io.Copy(w, reader)
-						return
-					}
-				}
-			}
-		}
-	})
+	client, err := registry.NewClient(registry.ClientOptDebug(true), registry.ClientOptHTTPClient(upstreamClient))
+	if err != nil {
+		return err
+	}
+	logins := newLoginCache(registry.ClientOptDebug(true), registry.ClientOptHTTPClient(upstreamClient))
+	if config.MaxConcurrentPulls > 0 {
+		pullSlots = make(chan struct{}, config.MaxConcurrentPulls)
+	}
+
+	attestor := newAttestor(config)
+	var desiredState *desiredStateSyncer
+	if config.DesiredState != "" && config.ReadOnly {
+		log.Printf("read-only mode, not syncing desired state from %s", config.DesiredState)
+	} else if config.DesiredState != "" {
+		desiredState = newDesiredStateSyncer(config.DesiredState, live, client, logins, attestor, leader)
+		go desiredState.run(ctx, config.DesiredStateInterval)
+	}
+
+	scanner := newManifestScanner(live)
+	go scanner.run(ctx)
+	chartPulled = scanner.pulled
 
-	server := newServer(router)
+	maintenance := newMaintenanceGate(live)
+	go maintenance.run(ctx, time.Minute)
 
-	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+	stats := newDownloadStats()
+	retention := newRetentionWorker(live, stats, maintenance, leader)
+	if config.RetentionInterval > 0 && config.ReadOnly {
+		log.Printf("read-only mode, not enforcing retention")
+	} else if config.RetentionInterval > 0 {
+		go retention.run(ctx, config.RetentionInterval)
+	}
+	clients := newClientStats()
+
+	audit, err := newAuditLog(ctx, config)
+	if err != nil {
+		return err
+	}
+	audit.start()
+	defer audit.stop()
+
+	lazy := newLazyCatalog(live)
+	go lazy.run(ctx)
+	if config.SyncInterval > 0 || leader != nil {
+		go newIncrementalSyncer(live, leader).run(ctx, config.SyncInterval)
+	}
+
+	cache, err := newChartCache(ctx, config)
+	if err != nil {
+		return err
+	}
+	downloads := &chartDownloader{live: live, client: client, logins: logins, cache: cache, stats: stats, clients: clients, policy: newDownloadPolicy(live), iam: newIAMChecker(live), audit: audit, upstream: newPullThrough(live), lazy: lazy, stale: newStaleCharts()}
+
+	report := newShutdownReport()
+	router := defaultRouter(nil, report.middleware, holdBackend(live), securityHeaders(live), filterIPs(live), cors(live), verifySignedURL(live), verifyIAP(live), readOnly(live), injectHeaders(live), compressResponses)
+	api := &apiServices{
+		live:         live,
+		desiredState: desiredState,
+		scanner:      scanner,
+		maintenance:  maintenance,
+		stats:        stats,
+		retention:    retention,
+		leader:       leader,
+		webhooks:     webhooks,
+		clients:      clients,
+		events:       events,
+		startup:      startup,
+		downloads:    downloads,
+		cache:        cache,
+		logins:       logins,
+		attestor:     attestor,
+	}
+	api.routes(router)
+
+	var grpcServer *grpc.Server
+	if config.GRPCPort != "" {
+		address, _ := portAddress(config.GRPCPort, config.BindHost)
+		grpcListener, err := net.Listen("tcp", address)
+		if err != nil {
+			return withExitCode(exitListener, fmt.Errorf("listen on %s: %w", address, err))
 		}
-	}()
+		grpcServer = newGRPCServer(downloads)
+		defer grpcServer.Stop()
+		log.Printf("serving grpc on %s", address)
+		go func() {
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				failed <- withExitCode(exitListener, fmt.Errorf("serve grpc on %s: %w", address, err))
+			}
+		}()
+	}
+
+	downloads.prewarm(ctx)
+	startup.open(router)
+	log.Printf("started in %s", time.Since(startup.started).Round(time.Millisecond))
 
-	<-done
-	log.Println("stopping")
+	select {
+	case <-done:
+		log.Println("stopping")
+	case err = <-failed:
+	}
 
+	stop()
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
+	leader.wait(ctx)
 
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("couldn't stop server: %+v", err)
+	if shutdownErr := server.Shutdown(ctx); shutdownErr != nil && err == nil {
+		err = fmt.Errorf("couldn't stop server: %w", shutdownErr)
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
 	}
+	report.log(downloads, err)
+	return err
 }
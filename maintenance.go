@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maintenanceWindow is a recurring period during which destructive
+// operations may run. It opens on a cron schedule and stays open for
+// duration, e.g. "0 2 * * SAT 4h".
+type maintenanceWindow struct {
+	Spec     string        `json:"spec"`
+	Duration time.Duration `json:"duration"`
+
+	schedule cron.Schedule
+}
+
+// parseMaintenanceWindows parses a semicolon separated list of windows, each
+// a five-field cron expression followed by a duration.
+func parseMaintenanceWindows(value string) ([]maintenanceWindow, error) {
+	var windows []maintenanceWindow
+	for _, spec := range strings.Split(value, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		i := strings.LastIndex(spec, " ")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid maintenance window %q: expected a cron expression followed by a duration", spec)
+		}
+
+		duration, err := time.ParseDuration(spec[i+1:])
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid maintenance window %q: bad duration %q", spec, spec[i+1:])
+		}
+
+		schedule, err := cron.ParseStandard(strings.TrimSpace(spec[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+
+		windows = append(windows, maintenanceWindow{Spec: spec, Duration: duration, schedule: schedule})
+	}
+	return windows, nil
+}
+
+// open reports whether the window is open at t, that is whether it started
+// less than Duration before t.
+func (w maintenanceWindow) open(t time.Time) bool {
+	return !w.schedule.Next(t.Add(-w.Duration)).After(t)
+}
+
+// maintenanceOp is a destructive operation waiting for a window.
+type maintenanceOp struct {
+	Name      string    `json:"name"`
+	Requested time.Time `json:"requested"`
+
+	run func(ctx context.Context) error
+}
+
+// maintenanceGate holds back destructive operations, such as garbage
+// collection, deletions and retention enforcement, until a maintenance
+// window opens. Without configured windows every operation runs right
+// away.
+type maintenanceGate struct {
+	live *liveConfig
+
+	mu    sync.Mutex
+	queue []*maintenanceOp
+}
+
+func newMaintenanceGate(live *liveConfig) *maintenanceGate {
+	return &maintenanceGate{live: live}
+}
+
+func (g *maintenanceGate) windows() []maintenanceWindow {
+	config, _ := g.live.get()
+	windows, err := parseMaintenanceWindows(config.MaintenanceWindows)
+	if err != nil {
+		// Rejected when the config was loaded; never reached.
+		log.Printf("failed to parse maintenance windows. error: %v", err)
+	}
+	return windows
+}
+
+// isOpen reports whether destructive operations may run at t.
+func (g *maintenanceGate) isOpen(t time.Time) bool {
+	windows := g.windows()
+	if len(windows) == 0 {
+		return true
+	}
+
+	for _, window := range windows {
+		if window.open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// submit runs op now if a window is open and returns its error. Otherwise op
+// is queued for the next window and submit returns queued true. Nothing runs
+// in read-only mode.
+func (g *maintenanceGate) submit(ctx context.Context, name string, op func(ctx context.Context) error) (queued bool, err error) {
+	if config, _ := g.live.get(); config.ReadOnly {
+		return false, errReadOnly
+	}
+
+	if g.isOpen(time.Now()) {
+		return false, op(ctx)
+	}
+
+	g.mu.Lock()
+	g.queue = append(g.queue, &maintenanceOp{Name: name, Requested: time.Now(), run: op})
+	g.mu.Unlock()
+
+	log.Printf("queued %s until the next maintenance window", name)
+	return true, nil
+}
+
+// run drains the queue whenever a window is open, checking every interval.
+func (g *maintenanceGate) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Operations queued before a reload turned on read-only mode wait
+		// until it's turned off again.
+		if config, _ := g.live.get(); config.ReadOnly || !g.isOpen(time.Now()) {
+			continue
+		}
+
+		g.mu.Lock()
+		queue := g.queue
+		g.queue = nil
+		g.mu.Unlock()
+
+		for _, op := range queue {
+			if err := op.run(ctx); err != nil {
+				log.Printf("failed to run %s. error: %v", op.Name, err)
+				continue
+			}
+			log.Printf("ran %s, queued since %s", op.Name, op.Requested.Format(time.RFC3339))
+		}
+	}
+}
+
+// nextOpening returns when the next window opens after t, or the zero time
+// without windows.
+func (g *maintenanceGate) nextOpening(t time.Time) time.Time {
+	var next time.Time
+	for _, window := range g.windows() {
+		if n := window.schedule.Next(t); next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+	return next
+}
+
+type maintenanceStatus struct {
+	Windows     []maintenanceWindow `json:"windows"`
+	Open        bool                `json:"open"`
+	NextOpening *time.Time          `json:"next_opening,omitempty"`
+	Queued      []*maintenanceOp    `json:"queued"`
+}
+
+func (g *maintenanceGate) handleStatus(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	status := maintenanceStatus{
+		Windows: g.windows(),
+		Open:    g.isOpen(now),
+		Queued:  []*maintenanceOp{},
+	}
+	if next := g.nextOpening(now); !next.IsZero() {
+		status.NextOpening = &next
+	}
+
+	g.mu.Lock()
+	status.Queued = append(status.Queued, g.queue...)
+	g.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	tests := []struct {
+		value string
+		specs []string
+		err   bool
+	}{
+		{value: "", specs: nil},
+		{value: "0 2 * * SAT 4h", specs: []string{"0 2 * * SAT 4h"}},
+		{value: " 0 2 * * SAT 4h ; 30 1 1 * * 90m ;", specs: []string{"0 2 * * SAT 4h", "30 1 1 * * 90m"}},
+		{value: "0 2 * * SAT", err: true},
+		{value: "0 2 * * SAT -1h", err: true},
+		{value: "0 2 * * SAT 0s", err: true},
+		{value: "0 2 * SAT 4h", err: true},
+		{value: "4h", err: true},
+	}
+
+	for _, test := range tests {
+		windows, err := parseMaintenanceWindows(test.value)
+		if (err != nil) != test.err {
+			t.Errorf("parseMaintenanceWindows(%q) error = %v, want error %v", test.value, err, test.err)
+			continue
+		}
+
+		var specs []string
+		for _, window := range windows {
+			specs = append(specs, window.Spec)
+		}
+		if fmt.Sprint(specs) != fmt.Sprint(test.specs) {
+			t.Errorf("parseMaintenanceWindows(%q) = %v, want %v", test.value, specs, test.specs)
+		}
+	}
+}
+
+func TestMaintenanceWindowOpen(t *testing.T) {
+	windows, err := parseMaintenanceWindows("0 2 * * SAT 4h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saturday := time.Date(2024, time.March, 2, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		at   time.Time
+		open bool
+	}{
+		{saturday.Add(time.Hour), false},
+		{saturday.Add(2 * time.Hour), true},
+		{saturday.Add(5*time.Hour + 59*time.Minute), true},
+		{saturday.Add(6 * time.Hour), false},
+		{saturday.Add(24*time.Hour + 3*time.Hour), false},
+	}
+	for _, test := range tests {
+		if got := windows[0].open(test.at); got != test.open {
+			t.Errorf("open(%s) = %v, want %v", test.at.Format(time.RFC3339), got, test.open)
+		}
+	}
+}
+
+func TestMaintenanceGate(t *testing.T) {
+	// A one minute window half an hour away is closed for the whole test.
+	closed := fmt.Sprintf("%d * * * * 1m", (time.Now().Minute()+30)%60)
+	live := &liveConfig{config: &Config{MaintenanceWindows: closed}}
+	g := newMaintenanceGate(live)
+	ctx := context.Background()
+
+	ran := make(chan string, 2)
+	op := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			ran <- name
+			return nil
+		}
+	}
+
+	queued, err := g.submit(ctx, "gc", op("gc"))
+	if !queued || err != nil {
+		t.Fatalf("submit() outside a window = %v, %v, want queued", queued, err)
+	}
+	select {
+	case name := <-ran:
+		t.Fatalf("%s ran outside a window", name)
+	default:
+	}
+
+	w := httptest.NewRecorder()
+	g.handleStatus(w, httptest.NewRequest(http.MethodGet, "/api/v1/maintenance", nil))
+	var status maintenanceStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Open || status.NextOpening == nil || len(status.Queued) != 1 || status.Queued[0].Name != "gc" {
+		t.Errorf("status = %+v, want closed with gc queued", status)
+	}
+
+	// Read-only mode refuses operations and holds the queue.
+	live.mu.Lock()
+	live.config = &Config{ReadOnly: true}
+	live.mu.Unlock()
+	if queued, err := g.submit(ctx, "delete", op("delete")); queued || !errors.Is(err, errReadOnly) {
+		t.Errorf("submit() in read-only mode = %v, %v, want %v", queued, err, errReadOnly)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go g.run(runCtx, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case name := <-ran:
+		t.Fatalf("%s ran in read-only mode", name)
+	default:
+	}
+
+	// Without windows the queue drains and operations run right away.
+	live.mu.Lock()
+	live.config = &Config{}
+	live.mu.Unlock()
+	select {
+	case name := <-ran:
+		if name != "gc" {
+			t.Errorf("ran %s, want gc", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued gc never ran")
+	}
+
+	if queued, err := g.submit(ctx, "delete", op("delete")); queued || err != nil {
+		t.Errorf("submit() without windows = %v, %v, want run", queued, err)
+	}
+	if name := <-ran; name != "delete" {
+		t.Errorf("ran %s, want delete", name)
+	}
+
+	failure := errors.New("boom")
+	if _, err := g.submit(ctx, "retention", func(ctx context.Context) error { return failure }); err != failure {
+		t.Errorf("submit() = %v, want the operation's error", err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	// manifestScanQueue is how many pulled charts may wait for a scan.
+	manifestScanQueue = 64
+	// maxComplianceReports is how many reports are kept, the oldest being
+	// dropped first.
+	maxComplianceReports = 10000
+	// maxScannerOutput caps the findings kept from a scan.
+	maxScannerOutput = 1 << 20
+)
+
+// Statuses of a complianceReport.
+const (
+	compliancePending = "pending"
+	compliancePassed  = "passed"
+	complianceFailed  = "failed"
+	complianceError   = "error"
+)
+
+// complianceReport is what --manifest-scanner found in the manifests of a
+// chart version.
+type complianceReport struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Digest  string `json:"digest"`
+	Status  string `json:"status"`
+	Scanner string `json:"scanner"`
+	// Findings is the scanner's output, as JSON when it printed JSON.
+	Findings interface{} `json:"findings,omitempty"`
+	ExitCode int         `json:"exit_code"`
+	Error    string      `json:"error,omitempty"`
+	Scanned  *time.Time  `json:"scanned,omitempty"`
+}
+
+// manifestScanner renders every chart version pulled through the proxy
+// with its default values and runs the manifests past --manifest-scanner,
+// a command such as kubeconform or a policy scanner reading them on stdin.
+// A zero exit status passes the version. Platform teams can then publish
+// a compliance status alongside each version.
+type manifestScanner struct {
+	live  *liveConfig
+	queue chan scanJob
+
+	mu      sync.Mutex
+	reports map[string]*complianceReport
+	order   []string
+}
+
+type scanJob struct {
+	asset *Asset
+	chart *cachedChart
+}
+
+func newManifestScanner(live *liveConfig) *manifestScanner {
+	return &manifestScanner{live: live, queue: make(chan scanJob, manifestScanQueue), reports: map[string]*complianceReport{}}
+}
+
+// pulled queues the first pull of a chart version for a scan.
+func (s *manifestScanner) pulled(asset *Asset, chart *cachedChart) {
+	if config, _ := s.live.get(); config.ManifestScanner == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reports[asset.SHA]; ok {
+		return
+	}
+	select {
+	case s.queue <- scanJob{asset: asset, chart: chart}:
+		s.store(&complianceReport{Name: asset.Name, Version: chart.Version, Digest: asset.SHA, Status: compliancePending})
+	default:
+		log.Printf("manifest scan queue full, not scanning %s", asset.RawName)
+	}
+}
+
+// store keeps report, dropping the oldest report past the limit. s.mu is
+// held.
+func (s *manifestScanner) store(report *complianceReport) {
+	if _, ok := s.reports[report.Digest]; !ok {
+		s.order = append(s.order, report.Digest)
+	}
+	s.reports[report.Digest] = report
+	if len(s.order) > maxComplianceReports {
+		delete(s.reports, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *manifestScanner) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			report := s.scan(ctx, job)
+			complianceScans.WithLabelValues(report.Status).Inc()
+
+			s.mu.Lock()
+			s.store(report)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// scan renders the chart of job and runs the scanner on its manifests.
+func (s *manifestScanner) scan(ctx context.Context, job scanJob) *complianceReport {
+	config, _ := s.live.get()
+	now := time.Now()
+	report := &complianceReport{
+		Name:    job.asset.Name,
+		Version: job.chart.Version,
+		Digest:  job.asset.SHA,
+		Scanner: config.ManifestScanner,
+		Scanned: &now,
+	}
+
+	manifests, err := render(job.chart.Data, nil, defaultReleaseName, "default")
+	if err != nil {
+		report.Status, report.Error = complianceError, fmt.Sprintf("failed to render chart: %v", err)
+		return report
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.ManifestScannerTimeout)
+	defer cancel()
+	args := strings.Fields(config.ManifestScanner)
+	if len(args) == 0 {
+		report.Status, report.Error = complianceError, "no --manifest-scanner configured"
+		return report
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(manifests)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err = cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		report.Status = compliancePassed
+	case errors.As(err, &exit) && ctx.Err() == nil:
+		report.Status, report.ExitCode = complianceFailed, exit.ExitCode()
+	default:
+		report.Status, report.Error = complianceError, strings.TrimSpace(fmt.Sprintf("%v %s", err, stderr.String()))
+		log.Printf("failed to scan %s. error: %v", job.asset.RawName, err)
+		return report
+	}
+
+	output := stdout.Bytes()
+	if len(output) > maxScannerOutput {
+		output = output[:maxScannerOutput]
+	}
+	if json.Valid(output) {
+		report.Findings = json.RawMessage(output)
+	} else if len(output) > 0 {
+		report.Findings = string(output)
+	}
+	return report
+}
+
+// report returns the report of digest, or nil when it wasn't scanned.
+func (s *manifestScanner) report(digest string) *complianceReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reports[digest]
+}
+
+// handleCompliance reports what the scanner found in a chart version. A
+// version not pulled since the proxy started hasn't been scanned yet.
+func (s *manifestScanner) handleCompliance(w http.ResponseWriter, r *http.Request) {
+	name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+	asset := findChartVersion(name, version)
+	if asset == nil {
+		config, _ := s.live.get()
+		chartNotFound(w, config, currentRepository(), name, version)
+		return
+	}
+
+	report := s.report(asset.SHA)
+	if report == nil {
+		http.Error(w, fmt.Sprintf("%s hasn't been scanned, pull it first", asset.RawName), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleReports lists the reports of every scanned version, by name and
+// digest, optionally only those with a ?status.
+func (s *manifestScanner) handleReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	s.mu.Lock()
+	reports := []*complianceReport{}
+	for _, report := range s.reports {
+		if status == "" || report.Status == status {
+			reports = append(reports, report)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Name != reports[j].Name {
+			return reports[i].Name < reports[j].Name
+		}
+		return reports[i].Digest < reports[j].Digest
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestScanner(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	writer := tar.NewWriter(gz)
+	for _, file := range []struct{ name, content string }{
+		{"app/Chart.yaml", "apiVersion: v2\nname: app\nversion: 0.1.0\n"},
+		{"app/templates/configmap.yaml", "kind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n"},
+	} {
+		writer.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content))})
+		writer.Write([]byte(file.content))
+	}
+	writer.Close()
+	gz.Close()
+	job := scanJob{asset: &Asset{Name: "app", SHA: "sha256:1"}, chart: &cachedChart{Version: "0.1.0", Data: archive.Bytes()}}
+
+	tests := []struct {
+		scanner string
+		status  string
+	}{
+		{"cat", compliancePassed},
+		{"false", complianceFailed},
+		{"/nonexistent/scanner", complianceError},
+	}
+	for _, tt := range tests {
+		s := newManifestScanner(&liveConfig{config: &Config{ManifestScanner: tt.scanner, ManifestScannerTimeout: time.Minute}})
+		report := s.scan(context.Background(), job)
+		if report.Status != tt.status {
+			t.Errorf("scan with %q = %s (%s), want %s", tt.scanner, report.Status, report.Error, tt.status)
+		}
+		if tt.scanner == "cat" {
+			if findings, _ := report.Findings.(string); !strings.Contains(findings, "kind: ConfigMap") {
+				t.Errorf("scan with cat found %q, want the rendered manifests", findings)
+			}
+		}
+	}
+}
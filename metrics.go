@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics, served at /metrics.
+var (
+	clientRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_client_downloads_total",
+		Help: "Chart downloads by client tool and major.minor version, parsed from the User-Agent.",
+	}, []string{"tool", "version"})
+
+	responseCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_response_cache_requests_total",
+		Help: "Requests to cached read APIs by result, hit or miss.",
+	}, []string{"result"})
+
+	chartDownloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_chart_downloads_total",
+		Help: "Chart downloads by chart and version.",
+	}, []string{"chart", "version"})
+
+	oversizedArtifacts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_oversized_artifacts_total",
+		Help: "Requests for artifacts over --max-artifact-bytes by action, rejected or redirected.",
+	}, []string{"action"})
+
+	staleDownloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_stale_downloads_total",
+		Help: "Downloads answered with a stale archive under --serve-stale-after by reason, error or slow.",
+	}, []string{"reason"})
+
+	lazyLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_lazy_lookups_total",
+		Help: "Charts looked up on demand with --lazy-catalog by result, found, missing or error.",
+	}, []string{"result"})
+
+	iamChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_iam_checks_total",
+		Help: "Downloads checked with --iam-check by result, allowed, denied, unauthenticated or error.",
+	}, []string{"result"})
+
+	complianceScans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_manifest_scans_total",
+		Help: "Chart versions run past --manifest-scanner by status, passed, failed or error.",
+	}, []string{"status"})
+
+	syncSkippedEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcp_oci_proxy_sync_skipped_entries",
+		Help: "Catalog entries skipped by the last sync because they failed to parse or resolve.",
+	})
+
+	syncPages = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_sync_pages_total",
+		Help: "Pages fetched while listing registries for catalog syncs.",
+	})
+
+	syncPageRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_sync_page_retries_total",
+		Help: "Pages fetched again after failing to list during catalog syncs.",
+	})
+
+	syncPageItems = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gcp_oci_proxy_sync_page_items",
+		Help:    "Items per page fetched while listing registries for catalog syncs.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 7),
+	})
+
+	syncPageSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gcp_oci_proxy_sync_page_duration_seconds",
+		Help:    "Time to fetch a page while listing registries for catalog syncs.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	syncPhaseSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gcp_oci_proxy_sync_phase_duration_seconds",
+		Help:    "Time spent in each phase of catalog syncs.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"phase"})
+
+	syncAllocatedBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcp_oci_proxy_sync_allocated_bytes",
+		Help: "Memory allocated by the last catalog sync, garbage included.",
+	})
+
+	tagConflicts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcp_oci_proxy_tag_conflicts",
+		Help: "Tags of the catalog found on more than one digest of a chart.",
+	})
+
+	catalogBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcp_oci_proxy_catalog_bytes",
+		Help: "Estimated memory held by the in-memory catalog.",
+	})
+
+	upstreamDials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_upstream_dials_total",
+		Help: "Connections dialed to registries and cloud APIs by result, ok or error.",
+	}, []string{"result"})
+
+	upstreamDialSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gcp_oci_proxy_upstream_dial_duration_seconds",
+		Help:    "Time to dial connections to registries and cloud APIs.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	upstreamTLSHandshakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_upstream_tls_handshakes_total",
+		Help: "TLS handshakes with registries and cloud APIs by result, ok or error.",
+	}, []string{"result"})
+
+	upstreamTLSHandshakeSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gcp_oci_proxy_upstream_tls_handshake_duration_seconds",
+		Help:    "Time of TLS handshakes with registries and cloud APIs.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+
+	upstreamPullsWaiting = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gcp_oci_proxy_upstream_pulls_waiting",
+		Help: "Pulls from registries waiting for a slot under --max-concurrent-pulls.",
+	})
+
+	upstreamConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_oci_proxy_upstream_connections_total",
+		Help: "Connections obtained for requests to registries and cloud APIs, by whether they were reused from the idle pool.",
+	}, []string{"reused"})
+)
+
+func init() {
+	prometheus.MustRegister(clientRequests, responseCacheRequests, chartDownloads, syncSkippedEntries)
+	prometheus.MustRegister(oversizedArtifacts, staleDownloads, lazyLookups, iamChecks, complianceScans)
+	prometheus.MustRegister(syncPages, syncPageRetries, syncPageItems, syncPageSeconds, syncPhaseSeconds, syncAllocatedBytes, tagConflicts, catalogBytes)
+	prometheus.MustRegister(upstreamDials, upstreamDialSeconds, upstreamTLSHandshakes, upstreamTLSHandshakeSeconds, upstreamConnections, upstreamPullsWaiting)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// Representations a chart URL can be served as, picked with ?format or the
+// Accept header.
+const (
+	tgzFormat  = "tgz"
+	jsonFormat = "json"
+)
+
+// ociLayoutMediaType asks for the chart artifact as an OCI image layout
+// tarball.
+const ociLayoutMediaType = "application/vnd.oci.image.layout.v1+tar"
+
+// representation is what a chart request is answered with: a format and,
+// when the client asked for one by name, the media type to label it with.
+type representation struct {
+	format    string
+	mediaType string
+}
+
+// representations maps the media types clients can ask for to a format.
+var representations = map[string]string{
+	registry.ChartLayerMediaType: tgzFormat,
+	"application/gzip":           tgzFormat,
+	"application/x-gzip":         tgzFormat,
+	"application/octet-stream":   tgzFormat,
+	ociLayoutMediaType:           ociLayoutFormat,
+	"application/json":           jsonFormat,
+}
+
+// negotiate picks the representation of a chart request. ?format wins over
+// the Accept header, and without either the chart archive is served.
+func negotiate(r *http.Request) (representation, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "":
+	case tgzFormat, ociLayoutFormat, jsonFormat:
+		return representation{format: format}, nil
+	default:
+		return representation{}, fmt.Errorf("unknown format %q, expected %s, %s or %s", format, tgzFormat, ociLayoutFormat, jsonFormat)
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return representation{format: tgzFormat}, nil
+	}
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return representation{format: tgzFormat}, nil
+		}
+		if format, ok := representations[mediaType]; ok {
+			return representation{format: format, mediaType: mediaType}, nil
+		}
+	}
+	return representation{}, errNotAcceptable
+}
+
+// errNotAcceptable is returned when no representation matches Accept.
+var errNotAcceptable = errors.New("none of the accepted media types is available, expected " +
+	registry.ChartLayerMediaType + ", " + ociLayoutMediaType + " or application/json")
+
+// acceptedMediaTypes returns the media types of an Accept header from the
+// most to the least preferred, leaving out those with q=0. Types of equal
+// quality keep the order they were listed in.
+func acceptedMediaTypes(accept string) []string {
+	type accepted struct {
+		mediaType string
+		quality   float64
+	}
+	var types []accepted
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			types = append(types, accepted{mediaType, quality})
+		}
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		return types[i].quality > types[j].quality
+	})
+
+	mediaTypes := make([]string, len(types))
+	for i, t := range types {
+		mediaTypes[i] = t.mediaType
+	}
+	return mediaTypes
+}
+
+// serveMetadata writes the catalog entry of asset as JSON. Tags move
+// without the digest changing, so the ETag is the hash of the body rather
+// than the digest.
+func serveMetadata(w http.ResponseWriter, r *http.Request, asset *Asset) {
+	data, err := json.Marshal(asset)
+	if err != nil {
+		log.Printf("failed to encode metadata of %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to encode chart metadata", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+sha256Hex(data)+`"`)
+	w.Header().Set("Cache-Control", tagCacheControl)
+	http.ServeContent(w, r, "", asset.Updated, bytes.NewReader(data))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		query     string
+		accept    string
+		format    string
+		mediaType string
+		err       error
+	}{
+		{"", "", tgzFormat, "", nil},
+		{"", "*/*", tgzFormat, "", nil},
+		{"", registry.ChartLayerMediaType, tgzFormat, registry.ChartLayerMediaType, nil},
+		{"", "application/json", jsonFormat, "application/json", nil},
+		{"", ociLayoutMediaType, ociLayoutFormat, ociLayoutMediaType, nil},
+		{"", "text/html, application/json;q=0.9, */*;q=0.8", jsonFormat, "application/json", nil},
+		{"", "application/json;q=0.5, " + ociLayoutMediaType, ociLayoutFormat, ociLayoutMediaType, nil},
+		{"", "application/json;q=0, */*", tgzFormat, "", nil},
+		{"", "text/html", "", "", errNotAcceptable},
+		{"format=oci-layout", "application/json", ociLayoutFormat, "", nil},
+		{"format=json", "", jsonFormat, "", nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/nginx:1.2.3?"+tt.query, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		got, err := negotiate(req)
+		if err != tt.err {
+			t.Errorf("negotiate(%q, %q) error = %v, want %v", tt.query, tt.accept, err, tt.err)
+			continue
+		}
+		if got.format != tt.format || got.mediaType != tt.mediaType {
+			t.Errorf("negotiate(%q, %q) = %+v, want %s %q", tt.query, tt.accept, got, tt.format, tt.mediaType)
+		}
+	}
+
+	if _, err := negotiate(httptest.NewRequest(http.MethodGet, "/nginx:1.2.3?format=zip", nil)); err == nil || err == errNotAcceptable {
+		t.Errorf("negotiate(format=zip) error = %v, want an unknown format", err)
+	}
+}
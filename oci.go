@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ociManifestAccept lists the manifest media types requested when resolving
+// tags, covering Helm charts as well as images pushed by older clients.
+const ociManifestAccept = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+
+// ociBackend lists charts from any registry implementing the OCI
+// distribution API, such as Harbor, GHCR or a self-hosted distribution
+// registry, using only the catalog, tags list and manifest endpoints.
+type ociBackend struct {
+	config *Config
+	api    *registryAPI
+
+	// discover lists the repositories to serve on registries with their own
+	// API for it, instead of the catalog.
+	discover func(ctx context.Context) ([]string, error)
+}
+
+func newOCIBackend(config *Config) (*ociBackend, error) {
+	b := &ociBackend{config: config}
+	b.api = &registryAPI{
+		host:        config.OCIRegistry,
+		staticToken: config.OCIToken,
+		credential:  b.Credential,
+	}
+	return b, nil
+}
+
+func (b *ociBackend) Name() string {
+	return strings.TrimSuffix(b.config.OCIRegistry+"/"+b.config.Repository, "/")
+}
+
+func (b *ociBackend) Host() string {
+	return b.config.OCIRegistry
+}
+
+// Credential returns the configured username and password. With token auth
+// the token doubles as the password, which registries such as GHCR accept
+// for logins.
+func (b *ociBackend) Credential(ctx context.Context) (string, string, error) {
+	if b.config.OCIToken != "" {
+		user := b.config.OCIUsername
+		if user == "" {
+			user = "token"
+		}
+		return user, b.config.OCIToken, nil
+	}
+	return b.config.OCIUsername, b.config.OCIPassword, nil
+}
+
+func (b *ociBackend) Close() error {
+	return nil
+}
+
+func (b *ociBackend) List(ctx context.Context) ([]*Asset, error) {
+	repositories := b.config.OCIRepositories
+	if len(repositories) == 0 {
+		var err error
+		repositories, err = b.repositories(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var assets []*Asset
+	var skipped skippedEntries
+	for _, repository := range repositories {
+		repositoryAssets, err := b.list(ctx, repository, &skipped)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, repositoryAssets...)
+	}
+	return assets, skipped.err()
+}
+
+// list resolves every tag of repository to its manifest digest and groups
+// the tags by digest. Tags that can't be resolved are added to skipped.
+func (b *ociBackend) list(ctx context.Context, repository string, skipped *skippedEntries) ([]*Asset, error) {
+	var tags []string
+	next := fmt.Sprintf("/v2/%s/tags/list", repository)
+	for next != "" {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+
+		var err error
+		next, err = b.api.getJSON(ctx, next, &page)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+	}
+
+	var assets []*Asset
+	byDigest := map[string]*Asset{}
+	for _, tag := range tags {
+		entry := fmt.Sprintf("%s/%s:%s", b.Host(), repository, tag)
+		header, err := b.api.head(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repository, url.PathEscape(tag)), ociManifestAccept)
+		if err != nil {
+			skipped.skip(entry, err)
+			continue
+		}
+
+		digest := header.Get("Docker-Content-Digest")
+		if digest == "" {
+			skipped.skip(entry, fmt.Errorf("registry returned no digest"))
+			continue
+		}
+
+		asset, ok := byDigest[digest]
+		if !ok {
+			rawName := fmt.Sprintf("%s/%s@%s", b.Host(), repository, digest)
+			_, sha, err := extractNameAndSha(rawName)
+			if err != nil {
+				skipped.skip(rawName, err)
+				continue
+			}
+
+			asset = &Asset{
+				Name:      chartName(repository, b.config.Repository),
+				SHA:       sha,
+				RawName:   rawName,
+				URI:       rawName,
+				MediaType: header.Get("Content-Type"),
+			}
+			byDigest[digest] = asset
+			assets = append(assets, asset)
+		}
+
+		asset.Tags = append(asset.Tags, tag)
+	}
+	return assets, nil
+}
+
+// repositories pages through the registry catalog, keeping the repositories
+// under the configured prefix. Registries that don't expose the catalog,
+// like GHCR, need --oci-repositories instead.
+func (b *ociBackend) repositories(ctx context.Context) ([]string, error) {
+	if b.discover != nil {
+		return b.discover(ctx)
+	}
+
+	var names []string
+	next := "/v2/_catalog?" + url.Values{"n": {"1000"}}.Encode()
+	for next != "" {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+
+		var err error
+		next, err = b.api.getJSON(ctx, next, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list catalog, set --oci-repositories if the registry doesn't support it: %w", err)
+		}
+
+		for _, name := range page.Repositories {
+			if strings.HasPrefix(name, b.config.Repository) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ociLayoutFormat is the ?format of downloads returning the chart artifact
+// as an OCI image layout tarball instead of the chart archive.
+const ociLayoutFormat = "oci-layout"
+
+// fetchRawManifest fetches the manifest of repository at reference exactly
+// as the registry stores it, along with its media type and digest.
+func fetchRawManifest(ctx context.Context, api *registryAPI, repository, reference string) ([]byte, string, string, error) {
+	resp, err := api.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), ociManifestAccept)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	digest := "sha256:" + sha256Hex(data)
+	if strings.HasPrefix(reference, "sha256:") && digest != reference {
+		return nil, "", "", fmt.Errorf("manifest %s has digest %s", reference, digest)
+	}
+	return data, resp.Header.Get("Content-Type"), digest, nil
+}
+
+// ociLayout returns the artifact of asset as an OCI image layout tarball:
+// its manifest, config and layers byte for byte under blobs/, so tools like
+// `oras cp --from-oci-layout` can push it verbatim elsewhere. The manifest
+// is named tag in index.json when tag is set.
+func ociLayout(ctx context.Context, backend Backend, asset *Asset, tag string) ([]byte, error) {
+	api, repository, reference, err := registryFor(backend, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, mediaType, digest, err := fetchRawManifest(ctx, api, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", digest, err)
+	}
+	if manifest.MediaType != "" {
+		mediaType = manifest.MediaType
+	}
+
+	descriptor := ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(raw))}
+	if tag != "" {
+		descriptor.Annotations = map[string]string{"org.opencontainers.image.ref.name": tag}
+	}
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociIndexMediaType,
+		"manifests":     []ociDescriptor{descriptor},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	writer := tar.NewWriter(&out)
+	write := func(name string, data []byte) error {
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+			return err
+		}
+		_, err := writer.Write(data)
+		return err
+	}
+
+	if err := write("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return nil, err
+	}
+	if err := write("index.json", index); err != nil {
+		return nil, err
+	}
+	if err := write(blobPath(digest), raw); err != nil {
+		return nil, err
+	}
+
+	written := map[string]bool{digest: true}
+	for _, blob := range append([]ociDescriptor{manifest.Config}, manifest.Layers...) {
+		if blob.Digest == "" || written[blob.Digest] {
+			continue
+		}
+		written[blob.Digest] = true
+
+		data, err := fetchBlob(ctx, api, repository, blob.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch blob %s: %w", blob.Digest, err)
+		}
+		if err := write(blobPath(blob.Digest), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// blobPath returns where the blob with digest goes in an image layout.
+func blobPath(digest string) string {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+	return path.Join("blobs", algorithm, encoded)
+}
+
+// serveOCILayout writes the image layout tarball of asset.
+func (d *chartDownloader) serveOCILayout(w http.ResponseWriter, r *http.Request, backend Backend, asset *Asset, counter *countingWriter) {
+	version := requestedVersion(r, asset)
+	tag := ""
+	for _, t := range asset.Tags {
+		if t == version {
+			tag = version
+		}
+	}
+
+	data, err := ociLayout(r.Context(), backend, asset, tag)
+	if err != nil {
+		log.Printf("failed to build oci layout of %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to fetch chart artifact", http.StatusBadGateway)
+		return
+	}
+	d.stats.recordPull(int64(len(data)))
+
+	filename := path.Base(asset.Name)
+	if version != "" {
+		filename += "-" + version
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/x-tar")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.oci.tar", filename))
+	http.ServeContent(w, r, "", asset.Updated, bytes.NewReader(data))
+	d.recordDownload(r, asset, counter.written)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// openAPISpec describes the HTTP API for clients generating SDKs. The routes
+// of its operations are generated from it into openapi_gen.go, an interface
+// of handlers and a registration function per tag: run go generate after
+// changing it. Routes added to the router by hand have to be added to it
+// too: TestUndocumentedRoutes fails on those that are missing.
+//
+//go:generate go run gen_openapi.go
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPIOperation describes an operation of openAPISpec to the middleware
+// its route is wrapped with.
+type openAPIOperation struct {
+	id string
+	// admin tells whether the operation requires the admin token.
+	admin bool
+	// idempotent tells whether the operation takes an Idempotency-Key.
+	idempotent bool
+}
+
+// openAPIMiddleware returns the middleware an operation is wrapped with.
+type openAPIMiddleware func(op openAPIOperation) chi.Middlewares
+
+// openAPIRouter returns router, with the middleware wrap returns for op if
+// wrap isn't nil.
+func openAPIRouter(router chi.Router, wrap openAPIMiddleware, op openAPIOperation) chi.Router {
+	if wrap == nil {
+		return router
+	}
+	return router.With(wrap(op)...)
+}
+
+// chiPattern matches the regexp of a chi URL parameter, as in {file:.*}.
+var chiPattern = regexp.MustCompile(`\{([^:}]+):[^}]*\}`)
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// openAPIPath returns the path the spec documents a chi route under: URL
+// parameters lose their regexps, and the catch-all /* is /{path}.
+func openAPIPath(route string) string {
+	route = chiPattern.ReplaceAllString(route, "{$1}")
+	if prefix, ok := strings.CutSuffix(route, "/*"); ok {
+		route = prefix + "/{path}"
+	}
+	return route
+}
+
+// undocumentedRoutes lists, as "METHOD path", the routes of router missing
+// from the spec. Only the tests call it.
+func undocumentedRoutes(router chi.Routes) ([]string, error) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+
+	var missing []string
+	err := chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path := openAPIPath(route)
+		if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+			missing = append(missing, method+" "+path)
+		}
+		return nil
+	})
+	sort.Strings(missing)
+	return missing, err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestUndocumentedRoutes(t *testing.T) {
+	router := testRouter(&liveConfig{config: &Config{}, backend: &listedBackend{}})
+
+	missing, err := undocumentedRoutes(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) > 0 {
+		t.Errorf("routes missing from openapi.json: %s", strings.Join(missing, ", "))
+	}
+
+	router.Get("/api/v1/undocumented", handleOpenAPI)
+	if missing, _ := undocumentedRoutes(router); strings.Join(missing, ",") != "GET /api/v1/undocumented" {
+		t.Errorf("undocumented routes = %v, want only GET /api/v1/undocumented", missing)
+	}
+}
+
+func TestUnroutedOperations(t *testing.T) {
+	router := testRouter(&liveConfig{config: &Config{}, backend: &listedBackend{}})
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	routed := map[string]bool{}
+	chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		routed[method+" "+openAPIPath(route)] = true
+		return nil
+	})
+	for path, methods := range spec.Paths {
+		for method := range methods {
+			if operation := strings.ToUpper(method) + " " + path; !routed[operation] {
+				t.Errorf("%s is in openapi.json but not routed", operation)
+			}
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi"
+)
+
+// policyInput is what the policy endpoint is asked to decide on, sent as
+// the OPA data API input document.
+type policyInput struct {
+	Identity policyIdentity `json:"identity"`
+	Chart    policyChart    `json:"chart"`
+	Request  policyRequest  `json:"request"`
+}
+
+// policyIdentity is who is downloading, as far as the proxy can tell.
+// User comes from basic auth or the headers set by an authenticating proxy
+// in front, such as IAP.
+type policyIdentity struct {
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+type policyChart struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version,omitempty"`
+	Tags    []string `json:"tags"`
+	Digest  string   `json:"digest"`
+	// Signed tells whether a cosign signature is attached to the digest.
+	Signed bool `json:"signed"`
+}
+
+type policyRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// policyDecision is the result of a policy query, either a bare boolean or
+// an object with a reason shown to the denied client.
+type policyDecision struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (d *policyDecision) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &d.Allow); err == nil {
+		return nil
+	}
+
+	type decision policyDecision
+	return json.Unmarshal(data, (*decision)(d))
+}
+
+// downloadPolicy asks an external policy endpoint, such as an OPA data API
+// rule, whether a chart download may proceed. It fails closed: a download
+// is denied when the endpoint can't be reached or has no decision.
+type downloadPolicy struct {
+	live   *liveConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	revision uint64
+	signed   map[string]bool
+}
+
+func newDownloadPolicy(live *liveConfig) *downloadPolicy {
+	return &downloadPolicy{live: live, client: &http.Client{}, signed: map[string]bool{}}
+}
+
+// authorize evaluates the policy for a download of asset and returns
+// whether it is allowed and why not. Without a configured endpoint every
+// download is allowed.
+func (p *downloadPolicy) authorize(r *http.Request, asset *Asset) (bool, string, error) {
+	config, backend := p.live.get()
+	if config.PolicyURL == "" {
+		return true, "", nil
+	}
+
+	signed, err := p.isSigned(r.Context(), backend, asset)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to look up signature: %w", err)
+	}
+
+	input := policyInput{
+		Identity: requestIdentity(r),
+		Chart: policyChart{
+			Name:    asset.Name,
+			Version: requestedVersion(r, asset),
+			Tags:    []string{},
+			Digest:  asset.SHA,
+			Signed:  signed,
+		},
+		Request: policyRequest{Method: r.Method, Path: r.URL.Path},
+	}
+	for _, tag := range asset.Tags {
+		input.Chart.Tags = append(input.Chart.Tags, tag)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return false, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), config.PolicyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.PolicyURL, bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return false, "", fmt.Errorf("policy endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	var result struct {
+		Result *policyDecision `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, "", fmt.Errorf("failed to decode policy decision: %w", err)
+	}
+
+	// OPA omits the result when the rule is undefined for the input.
+	if result.Result == nil {
+		return false, "no policy decision", nil
+	}
+	return result.Result.Allow, result.Result.Reason, nil
+}
+
+// isSigned reports whether a cosign signature is attached to asset. Answers
+// are remembered until the catalog changes.
+func (p *downloadPolicy) isSigned(ctx context.Context, backend Backend, asset *Asset) (bool, error) {
+	revision := currentRepository().Revision
+
+	p.mu.Lock()
+	if p.revision != revision {
+		p.revision, p.signed = revision, map[string]bool{}
+	}
+	signed, ok := p.signed[asset.SHA]
+	p.mu.Unlock()
+	if ok {
+		return signed, nil
+	}
+
+	source, uri := sourceFor(backend, asset)
+	host, repository, _, err := splitReference(uri)
+	if err != nil {
+		return false, err
+	}
+
+	api := &registryAPI{host: host, credential: source.Credential}
+	_, err = fetchManifest(ctx, api, repository, cosignTag(asset.SHA, "sig"))
+	if err != nil && !errors.Is(err, errNotFound) {
+		return false, err
+	}
+
+	signed = err == nil
+	p.mu.Lock()
+	p.signed[asset.SHA] = signed
+	p.mu.Unlock()
+	return signed, nil
+}
+
+// requestIdentity identifies the client from a verified IAP assertion,
+// basic auth or the user headers of an authenticating proxy.
+func requestIdentity(r *http.Request) policyIdentity {
+	identity := policyIdentity{RemoteAddr: r.RemoteAddr, UserAgent: r.UserAgent()}
+	if addr, ok := forwardedClientAddr(r.Context()); ok {
+		identity.RemoteAddr = addr.String()
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		identity.RemoteAddr = host
+	}
+
+	if email, ok := iapIdentity(r.Context()); ok {
+		identity.User = email
+		return identity
+	}
+
+	if user, _, ok := r.BasicAuth(); ok {
+		identity.User = user
+		return identity
+	}
+
+	// IAP prefixes the email with the identity provider.
+	if email := r.Header.Get("X-Goog-Authenticated-User-Email"); email != "" {
+		_, identity.User, _ = strings.Cut(email, ":")
+		return identity
+	}
+
+	for _, header := range []string{"X-Forwarded-Email", "X-Forwarded-User"} {
+		if user := r.Header.Get(header); user != "" {
+			identity.User = user
+			return identity
+		}
+	}
+	return identity
+}
+
+// requestedVersion returns the tag the request asked for, or for requests
+// by digest or version range the highest semantic version asset carries.
+func requestedVersion(r *http.Request, asset *Asset) string {
+	if tag := chi.URLParam(r, "assetTag"); tag != "" {
+		return tag
+	}
+
+	if version := highestVersion(asset); version != nil {
+		return version.Original()
+	}
+	return ""
+}
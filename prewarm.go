@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// prewarmConcurrency bounds the charts pulled at once by --prewarm.
+const prewarmConcurrency = 4
+
+// prewarmAssets returns the assets --prewarm patterns select in
+// repository. A pattern is a path.Match pattern of chart names, optionally
+// followed by a version: a tag, a semver constraint, or latest, the
+// default.
+func prewarmAssets(repository *Repository, patterns []string) []*Asset {
+	names := map[string]bool{}
+	repository.each(func(asset *Asset) {
+		names[asset.Name] = true
+	})
+
+	seen := map[string]bool{}
+	var assets []*Asset
+	for _, pattern := range patterns {
+		match, version, _ := strings.Cut(pattern, ":")
+		for name := range names {
+			if ok, _ := path.Match(match, name); !ok {
+				continue
+			}
+
+			var asset *Asset
+			switch {
+			case version == "" || version == "latest":
+				asset, _ = repository.latestVersion(name)
+			case repository.findByTag(name, version) != nil:
+				asset = repository.findByTag(name, version)
+			default:
+				asset, _, _ = repository.resolveVersion(name, version)
+			}
+			if asset != nil && !seen[asset.SHA] {
+				seen[asset.SHA] = true
+				assets = append(assets, asset)
+			}
+		}
+	}
+	return assets
+}
+
+// prewarm pulls the charts selected by --prewarm into the cache, so they
+// are hot before the first download. Failures are logged; the charts are
+// then pulled on demand.
+func (d *chartDownloader) prewarm(ctx context.Context) {
+	config, backend := d.live.get()
+	if len(config.Prewarm) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.PrewarmTimeout)
+	defer cancel()
+
+	started := time.Now()
+	assets := prewarmAssets(currentRepository(), config.Prewarm)
+	queue := make(chan *Asset)
+	var pulled atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < prewarmConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for asset := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				if _, err := pullAsset(ctx, d.client, d.logins, d.cache, d.stats, backend, asset); err != nil {
+					log.Printf("failed to prewarm %s. error: %v", asset.RawName, err)
+					continue
+				}
+				pulled.Add(1)
+			}
+		}()
+	}
+	for _, asset := range assets {
+		if !oversized(config, asset) {
+			queue <- asset
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if ctx.Err() != nil {
+		log.Printf("prewarm timed out after %s, serving anyway", config.PrewarmTimeout)
+	}
+	log.Printf("prewarmed %d of %d charts in %s", pulled.Load(), len(assets), time.Since(started).Round(time.Millisecond))
+}
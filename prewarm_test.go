@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPrewarmAssets(t *testing.T) {
+	repository := &Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.3"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.3.0", "stable"}},
+		{Name: "team/app", SHA: "sha256:3", Tags: []string{"0.1.0"}},
+		{Name: "team/app", SHA: "sha256:4", Tags: []string{"0.2.0-rc.1"}},
+		{Name: "redis", SHA: "sha256:5", Tags: []string{"7.0.0"}},
+	}}
+
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{[]string{"nginx"}, []string{"sha256:2"}},
+		{[]string{"nginx:~1.2"}, []string{"sha256:1"}},
+		{[]string{"nginx:stable", "nginx:latest"}, []string{"sha256:2"}},
+		{[]string{"team/*:>=0.2.0-0"}, []string{"sha256:4"}},
+		{[]string{"*:1.2.3", "redis"}, []string{"sha256:1", "sha256:5"}},
+		{[]string{"postgres"}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, asset := range prewarmAssets(repository, tt.patterns) {
+			got = append(got, asset.SHA)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("prewarmAssets(%q) = %v, want %v", tt.patterns, got, tt.want)
+		}
+	}
+}
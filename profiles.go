@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Profile is a named set of environment defaults packaged into charts
+// downloaded with ?profile=<name>: Values, then each of ValuesFiles in
+// order, are merged over the chart's values.yaml, and Annotations are added
+// to its Chart.yaml.
+type Profile struct {
+	Name        string                 `json:"name"`
+	Values      map[string]interface{} `json:"values"`
+	ValuesFiles []string               `json:"valuesFiles"`
+	Annotations map[string]string      `json:"annotations"`
+
+	// values is Values merged with ValuesFiles.
+	values map[string]interface{}
+	// hash identifies the content of the profile in entity tags.
+	hash string
+}
+
+// resolveProfiles parses the profiles section of the config file and reads
+// the values files it refers to.
+func resolveProfiles(value interface{}) ([]Profile, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("expected a list of {name, values, valuesFiles, annotations}: %w", err)
+	}
+
+	var errs []error
+	names := map[string]bool{}
+	for i := range profiles {
+		profile := &profiles[i]
+		if profile.Name == "" {
+			errs = append(errs, fmt.Errorf("profile %d has no name", i))
+		} else if names[profile.Name] {
+			errs = append(errs, fmt.Errorf("duplicate profile %q", profile.Name))
+		}
+		names[profile.Name] = true
+
+		profile.values = mergeValues(map[string]interface{}{}, profile.Values)
+		for _, file := range profile.ValuesFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", profile.Name, err))
+				continue
+			}
+
+			var values map[string]interface{}
+			if err := yaml.Unmarshal(data, &values); err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: invalid values file %s: %w", profile.Name, file, err))
+				continue
+			}
+			profile.values = mergeValues(profile.values, values)
+		}
+
+		content, _ := json.Marshal([]interface{}{profile.values, profile.Annotations})
+		sum := sha256.Sum256(content)
+		profile.hash = hex.EncodeToString(sum[:4])
+	}
+	return profiles, errors.Join(errs...)
+}
+
+// profile returns the profile called name, or nil.
+func (c *Config) profile(name string) *Profile {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			return &c.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// etag returns the entity tag of a chart with entity tag etag once the
+// profile is applied.
+func (p *Profile) etag(etag string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + p.Name + "-" + p.hash + `"`
+}
+
+// apply returns the chart archive data with the profile packaged in. The
+// archive keeps its compression: a plain tar layer stays a plain tar.
+func (p *Profile) apply(data []byte) ([]byte, error) {
+	var archive io.Reader = bytes.NewReader(data)
+	var out bytes.Buffer
+	var compressed io.WriteCloser = nopWriteCloser{&out}
+	if isGzip(data) {
+		decompressed, err := gzip.NewReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		archive, compressed = decompressed, gzip.NewWriter(&out)
+	}
+	writer := tar.NewWriter(compressed)
+
+	dir, sawValues := "", false
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the files of the chart itself, not of its subcharts.
+		switch chartDir, file := path.Split(header.Name); {
+		case strings.Count(header.Name, "/") != 1:
+		case file == "Chart.yaml":
+			dir = chartDir
+			if content, err = p.annotate(content); err != nil {
+				return nil, fmt.Errorf("invalid Chart.yaml: %w", err)
+			}
+		case file == "values.yaml":
+			sawValues = true
+			if content, err = p.overlay(content); err != nil {
+				return nil, fmt.Errorf("invalid values.yaml: %w", err)
+			}
+		}
+
+		header.Size = int64(len(content))
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir == "" {
+		return nil, fmt.Errorf("chart archive has no Chart.yaml")
+	}
+
+	if !sawValues && len(p.values) > 0 {
+		content, err := p.overlay(nil)
+		if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{Name: dir + "values.yaml", Mode: 0o644, Size: int64(len(content))}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := compressed.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// overlay merges the profile values over the values.yaml content.
+func (p *Profile) overlay(content []byte) ([]byte, error) {
+	if len(p.values) == 0 {
+		return content, nil
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(mergeValues(values, p.values))
+}
+
+// annotate adds the profile annotations to the Chart.yaml content.
+func (p *Profile) annotate(content []byte) ([]byte, error) {
+	if len(p.Annotations) == 0 {
+		return content, nil
+	}
+
+	metadata := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &metadata); err != nil {
+		return nil, err
+	}
+
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	for key, value := range p.Annotations {
+		annotations[key] = value
+	}
+	metadata["annotations"] = annotations
+	return yaml.Marshal(metadata)
+}
+
+// mergeValues merges src into dst the way Helm layers values files: maps
+// are merged recursively and anything else in src replaces dst.
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				dst[key] = mergeValues(dstMap, srcMap)
+				continue
+			}
+			dst[key] = mergeValues(map[string]interface{}{}, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+	return dst
+}
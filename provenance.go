@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	artifactregistrypb "cloud.google.com/go/artifactregistry/apiv1/artifactregistrypb"
+	"github.com/go-chi/chi"
+	containeranalysis "google.golang.org/api/containeranalysis/v1"
+)
+
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	dsseEnvelopeMediaType       = "application/vnd.dsse.envelope.v1+json"
+)
+
+// provenance is everything known about where a chart digest came from.
+// Each part is gathered independently; failures are listed in Errors and
+// leave the rest of the report intact.
+type provenance struct {
+	Name         string            `json:"name"`
+	Digest       string            `json:"digest"`
+	URI          string            `json:"uri"`
+	Registry     *registryInfo     `json:"registry,omitempty"`
+	Signatures   []cosignSignature `json:"signatures"`
+	Attestations []attestation     `json:"attestations"`
+	Builds       []buildInfo       `json:"builds,omitempty"`
+	Scan         *scanStatus       `json:"scan,omitempty"`
+	Errors       []string          `json:"errors,omitempty"`
+}
+
+// registryInfo is the metadata Artifact Registry keeps about an image.
+type registryInfo struct {
+	UploadTime *time.Time `json:"upload_time,omitempty"`
+	BuildTime  *time.Time `json:"build_time,omitempty"`
+	UpdateTime *time.Time `json:"update_time,omitempty"`
+	SizeBytes  int64      `json:"size_bytes"`
+	MediaType  string     `json:"media_type,omitempty"`
+}
+
+// cosignSignature is one signature layer of the cosign `.sig` artifact.
+type cosignSignature struct {
+	Digest      string `json:"digest"`
+	Signature   string `json:"signature"`
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// attestation is one DSSE envelope of the cosign `.att` artifact, such as a
+// SLSA provenance statement.
+type attestation struct {
+	Digest        string `json:"digest"`
+	PredicateType string `json:"predicate_type,omitempty"`
+}
+
+// buildInfo is a build occurrence recorded by Container Analysis.
+type buildInfo struct {
+	ID         string `json:"id,omitempty"`
+	Builder    string `json:"builder,omitempty"`
+	Creator    string `json:"creator,omitempty"`
+	CreateTime string `json:"create_time,omitempty"`
+	LogsURI    string `json:"logs_uri,omitempty"`
+}
+
+// scanStatus is the vulnerability scanning state of the image.
+type scanStatus struct {
+	Status       string `json:"status"`
+	Detail       string `json:"detail,omitempty"`
+	AnalysisTime string `json:"analysis_time,omitempty"`
+}
+
+func newScanStatus(discovery *containeranalysis.DiscoveryOccurrence) *scanStatus {
+	status := &scanStatus{
+		Status:       discovery.AnalysisStatus,
+		AnalysisTime: discovery.LastScanTime,
+	}
+	if discovery.AnalysisStatusError != nil {
+		status.Detail = discovery.AnalysisStatusError.Message
+	}
+	return status
+}
+
+func handleProvenance(w http.ResponseWriter, r *http.Request, live *liveConfig) {
+	asset := currentRepository().findByDigest(chi.URLParam(r, "assetName"), chi.URLParam(r, "assetSHA"))
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, backend := live.get()
+	report := collectProvenance(r.Context(), backend, asset)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// collectProvenance gathers the registry metadata, cosign signatures and
+// attestations, build occurrences and scan status of asset.
+func collectProvenance(ctx context.Context, backend Backend, asset *Asset) *provenance {
+	report := &provenance{
+		Name:         asset.Name,
+		Digest:       asset.SHA,
+		URI:          asset.URI,
+		Signatures:   []cosignSignature{},
+		Attestations: []attestation{},
+	}
+	fail := func(part string, err error) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", part, err))
+	}
+
+	source, uri := sourceFor(backend, asset)
+	host, repository, _, err := splitReference(uri)
+	if err != nil {
+		fail("reference", err)
+		return report
+	}
+	api := &registryAPI{host: host, credential: source.Credential}
+
+	if err := report.addSignatures(ctx, api, repository); err != nil {
+		fail("signatures", err)
+	}
+	if err := report.addAttestations(ctx, api, repository); err != nil {
+		fail("attestations", err)
+	}
+
+	gar, ok := source.(*garBackend)
+	if !ok {
+		return report
+	}
+
+	if err := report.addRegistryInfo(ctx, gar, asset); err != nil {
+		fail("registry", err)
+	}
+	if err := report.addOccurrences(ctx, gar, uri); err != nil {
+		fail("occurrences", err)
+	}
+	return report
+}
+
+// cosignTag returns the tag cosign stores artifacts of kind ("sig" or
+// "att") for digest under.
+func cosignTag(digest, kind string) string {
+	return strings.Replace(digest, ":", "-", 1) + "." + kind
+}
+
+func (p *provenance) addSignatures(ctx context.Context, api *registryAPI, repository string) error {
+	manifest, err := fetchManifest(ctx, api, repository, cosignTag(p.Digest, "sig"))
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		signature, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+		p.Signatures = append(p.Signatures, cosignSignature{
+			Digest:      layer.Digest,
+			Signature:   signature,
+			Certificate: layer.Annotations[cosignCertificateAnnotation],
+		})
+	}
+	return nil
+}
+
+func (p *provenance) addAttestations(ctx context.Context, api *registryAPI, repository string) error {
+	manifest, err := fetchManifest(ctx, api, repository, cosignTag(p.Digest, "att"))
+	if errors.Is(err, errNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != dsseEnvelopeMediaType {
+			continue
+		}
+		p.Attestations = append(p.Attestations, attestation{
+			Digest:        layer.Digest,
+			PredicateType: layer.Annotations["predicateType"],
+		})
+	}
+	return nil
+}
+
+func (p *provenance) addRegistryInfo(ctx context.Context, gar *garBackend, asset *Asset) error {
+	image, err := gar.client.GetDockerImage(ctx, &artifactregistrypb.GetDockerImageRequest{Name: asset.RawName})
+	if err != nil {
+		return err
+	}
+
+	info := &registryInfo{SizeBytes: image.ImageSizeBytes, MediaType: image.MediaType}
+	if t := image.UploadTime; t != nil {
+		v := t.AsTime()
+		info.UploadTime = &v
+	}
+	if t := image.BuildTime; t != nil {
+		v := t.AsTime()
+		info.BuildTime = &v
+	}
+	if t := image.UpdateTime; t != nil {
+		v := t.AsTime()
+		info.UpdateTime = &v
+	}
+	p.Registry = info
+	return nil
+}
+
+// addOccurrences records the build and discovery occurrences Container
+// Analysis holds for the image at uri.
+func (p *provenance) addOccurrences(ctx context.Context, gar *garBackend, uri string) error {
+	service, err := gar.containerAnalysis(ctx)
+	if err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf(`resourceUrl="https://%s" AND (kind="BUILD" OR kind="DISCOVERY")`, uri)
+	call := service.Projects.Occurrences.List("projects/" + gar.config.Project).Filter(filter)
+	return call.Pages(ctx, func(page *containeranalysis.ListOccurrencesResponse) error {
+		for _, occurrence := range page.Occurrences {
+			switch {
+			case occurrence.Build != nil && occurrence.Build.Provenance != nil:
+				build := occurrence.Build.Provenance
+				p.Builds = append(p.Builds, buildInfo{
+					ID:         build.Id,
+					Builder:    build.BuilderVersion,
+					Creator:    build.Creator,
+					CreateTime: build.CreateTime,
+					LogsURI:    build.LogsUri,
+				})
+			case occurrence.Discovery != nil:
+				p.Scan = newScanStatus(occurrence.Discovery)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// provenanceFile returns the Helm provenance (.prov) file of asset. It is
+// read from the provenance layer `helm push` adds next to the chart, or
+// failing that from a companion artifact tagged `sha256-<hex>.prov`. It
+// returns nil when the chart has none.
+func provenanceFile(ctx context.Context, backend Backend, asset *Asset) ([]byte, error) {
+	api, repository, manifest, err := manifestFor(ctx, backend, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	if layer := provLayer(manifest); layer != nil {
+		return fetchBlob(ctx, api, repository, layer.Digest)
+	}
+
+	companion, err := fetchManifest(ctx, api, repository, cosignTag(asset.SHA, "prov"))
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if layer := provLayer(companion); layer != nil {
+		return fetchBlob(ctx, api, repository, layer.Digest)
+	}
+	return nil, nil
+}
+
+func provLayer(manifest *ociManifest) *ociDescriptor {
+	for i, layer := range manifest.Layers {
+		if layer.MediaType == registry.ProvLayerMediaType {
+			return &manifest.Layers[i]
+		}
+	}
+	return nil
+}
+
+// findByArchiveName returns the asset of "<name>-<version>", the base name
+// Helm gives chart archives, and its version. Chart names may contain
+// dashes themselves, so every split is tried.
+func (r *Repository) findByArchiveName(base string) (*Asset, string) {
+	for i := 0; i < len(base); i++ {
+		if base[i] != '-' {
+			continue
+		}
+
+		if asset := r.findByTag(base[:i], base[i+1:]); asset != nil {
+			return asset, base[i+1:]
+		}
+	}
+	return nil, ""
+}
+
+// serveProvenance writes the provenance file of asset, as fetched by
+// `helm install --verify`.
+func (d *chartDownloader) serveProvenance(w http.ResponseWriter, r *http.Request, asset *Asset) {
+	_, backend := d.live.get()
+	data, err := provenanceFile(r.Context(), backend, asset)
+	if err != nil {
+		log.Printf("failed to fetch provenance of %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to fetch provenance file", http.StatusBadGateway)
+		return
+	}
+
+	if data == nil {
+		http.Error(w, fmt.Sprintf("%s has no provenance file", asset.Name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pgp-signature")
+	w.Write(data)
+}
+
+func (d *chartDownloader) handleProvenanceFile(w http.ResponseWriter, r *http.Request) {
+	base := strings.TrimSuffix(chi.URLParam(r, "file"), ".tgz.prov")
+	asset, _ := repositoryFor(r).findByArchiveName(base)
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+	d.serveProvenance(w, r, asset)
+}
+
+// handleDownloadArchive serves the chart archive Helm names
+// "<name>-<version>.tgz", the layout classic chart repositories and
+// downloader plugins fetch.
+func (d *chartDownloader) handleDownloadArchive(w http.ResponseWriter, r *http.Request) {
+	base := strings.TrimSuffix(chi.URLParam(r, "archive"), ".tgz")
+	asset, tag := repositoryFor(r).findByArchiveName(base)
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+	chi.RouteContext(r.Context()).URLParams.Add("assetTag", tag)
+	d.serve(w, r, asset, false)
+}
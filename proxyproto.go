@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a connection may take to send its
+// PROXY protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyHeaderSignature starts every PROXY protocol v2 header.
+var proxyHeaderSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolListener accepts connections starting with a PROXY protocol
+// header, v1 or v2, as L4 load balancers send to pass on the client
+// address. Trusted peers must send one; other peers, such as kubelet probes,
+// keep their address and are dropped if they send one, as anyone could then
+// pick the address they are seen with.
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []netip.Prefix
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: conn, trusted: l.trusted}, nil
+}
+
+// proxyProtocolConn reads the header on first use rather than in Accept,
+// so a slow client doesn't hold up the others.
+type proxyProtocolConn struct {
+	net.Conn
+	trusted []netip.Prefix
+
+	once   sync.Once
+	reader *bufio.Reader
+	source net.Addr
+	err    error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.reader = bufio.NewReader(c.Conn)
+		peer, err := netip.ParseAddrPort(c.Conn.RemoteAddr().String())
+		trusted := err == nil && containsAddr(c.trusted, peer.Addr().Unmap())
+
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		source, present, err := readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		switch {
+		case err != nil:
+			c.err = fmt.Errorf("invalid proxy protocol header from %s: %w", c.Conn.RemoteAddr(), err)
+		case trusted && !present:
+			c.err = fmt.Errorf("missing proxy protocol header from trusted proxy %s", c.Conn.RemoteAddr())
+		case !trusted && present:
+			c.err = fmt.Errorf("proxy protocol header from untrusted peer %s", c.Conn.RemoteAddr())
+		case trusted:
+			c.source = source
+		}
+		if c.err != nil {
+			log.Printf("dropped connection. error: %v", c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, if any. The HTTP
+// server asks for it first thing, which reads the header.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader consumes the PROXY protocol header at the start of r, if
+// present, and returns the client address it carries. Headers carrying no
+// address, such as those of load balancer health checks, give a nil one.
+func readProxyHeader(r *bufio.Reader) (source net.Addr, present bool, err error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, false, nil
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, _ := r.Peek(6); string(prefix) == "PROXY " {
+			source, err = readProxyHeaderV1(r)
+			return source, true, err
+		}
+	case '\r':
+		if prefix, _ := r.Peek(len(proxyHeaderSignature)); bytes.Equal(prefix, proxyHeaderSignature) {
+			source, err = readProxyHeaderV2(r)
+			return source, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// readProxyHeaderV1 reads a text header, e.g.
+// "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	// The longest v1 header is 107 bytes.
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("unterminated v1 header")
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", text)
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source address %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readProxyHeaderV2 reads a binary header. Only the source address of TCP
+// over IPv4 or IPv6 is used; TLVs are skipped.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 header version %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections come from the load balancer itself.
+	if header[12]&0xf == 0 {
+		return nil, nil
+	}
+	var addr netip.Addr
+	var port uint16
+	switch header[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("short v2 ipv4 addresses")
+		}
+		addr, port = netip.AddrFrom4([4]byte(body[0:4])), binary.BigEndian.Uint16(body[8:10])
+	case 0x21:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("short v2 ipv6 addresses")
+		}
+		addr, port = netip.AddrFrom16([16]byte(body[0:16])), binary.BigEndian.Uint16(body[32:34])
+	default:
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, port)), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestProxyProtocol(t *testing.T) {
+	v2 := func(command, family byte, addresses ...byte) string {
+		header := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x20|command, family, 0, byte(len(addresses)))
+		return string(append(header, addresses...))
+	}
+	ipv4 := []byte{203, 0, 113, 7, 10, 0, 0, 1, 0xc8, 0x22, 0x01, 0xbb}
+	loopback := []string{"127.0.0.0/8"}
+
+	tests := []struct {
+		name    string
+		trusted []string
+		header  string
+		remote  string
+		err     bool
+	}{
+		{"v1", loopback, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n", "203.0.113.7:51234", false},
+		{"v1 ipv6", loopback, "PROXY TCP6 2001:db8::7 2001:db8::1 51234 443\r\n", "[2001:db8::7]:51234", false},
+		{"v1 unknown", loopback, "PROXY UNKNOWN\r\n", "", false},
+		{"v1 invalid", loopback, "PROXY TCP4 nope\r\n", "", true},
+		{"v2", loopback, v2(1, 0x11, ipv4...), "203.0.113.7:51234", false},
+		{"v2 local", loopback, v2(0, 0x00), "", false},
+		{"trusted peer without header", loopback, "", "", true},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "", "", false},
+		{"untrusted peer with header", []string{"10.0.0.0/8"}, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 443\r\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			trusted, _ := parsePrefixes(tt.trusted)
+			proxied := &proxyProtocolListener{Listener: listener, trusted: trusted}
+
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			io.WriteString(client, tt.header+"GET / HTTP/1.1\r\n")
+
+			conn, err := proxied.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			remote := conn.RemoteAddr().String()
+			data := make([]byte, 16)
+			_, err = io.ReadFull(conn, data)
+			if (err != nil) != tt.err {
+				t.Fatalf("Read() error = %v, want error %v", err, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if string(data) != "GET / HTTP/1.1\r\n" {
+				t.Errorf("Read() = %q, want the request after the header", data)
+			}
+			if want := tt.remote; want == "" {
+				if remote != client.LocalAddr().String() {
+					t.Errorf("RemoteAddr() = %s, want the peer %s", remote, client.LocalAddr())
+				}
+			} else if remote != want {
+				t.Errorf("RemoteAddr() = %s, want %s", remote, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// maxPushBytes bounds an uploaded chart archive when --max-artifact-bytes
+// isn't set.
+const maxPushBytes = 16 << 20
+
+// pushLimit returns the largest chart archive a push may upload.
+func pushLimit(config *Config) int64 {
+	if config.MaxArtifactBytes > 0 {
+		return config.MaxArtifactBytes
+	}
+	return maxPushBytes
+}
+
+// chartPusher uploads chart archives to Artifact Registry.
+type chartPusher struct {
+	live   *liveConfig
+	logins *loginCache
+	// attestor, when set, attaches provenance to pushed charts.
+	attestor *attestor
+}
+
+// pushedChart is the answer to an upload.
+type pushedChart struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Digest  string `json:"digest"`
+	Ref     string `json:"ref"`
+}
+
+// pushTarget returns the Artifact Registry backend a chart called name is
+// pushed to, and its name there: the repository itself, or the route
+// serving name.
+func pushTarget(backend Backend, name string) (*garBackend, string, error) {
+	held, heldName := backend, name
+	if composite, ok := backend.(*compositeBackend); ok {
+		route := composite.route(name)
+		if route == nil {
+			return nil, "", fmt.Errorf("no route for chart %s", name)
+		}
+		held, heldName = route.backend, strings.TrimPrefix(name, route.Prefix)
+	}
+
+	gar, ok := held.(*garBackend)
+	if !ok {
+		return nil, "", fmt.Errorf("%s would be stored in %s, charts can only be pushed to artifact registry", name, held.Name())
+	}
+	return gar, heldName, nil
+}
+
+// handlePush stores the chart archive in the body under its name and
+// version, in ?dir if given, and adds it to the catalog. Existing versions
+// aren't overwritten.
+func (p *chartPusher) handlePush(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
+	config, backend := p.live.get()
+	limit := pushLimit(config)
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("chart archive over %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	chart, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid chart archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	name, version := chart.Metadata.Name, chart.Metadata.Version
+	if dir := strings.Trim(r.URL.Query().Get("dir"), "/"); dir != "" {
+		name = path.Join(dir, name)
+	}
+	if currentRepository().findByTag(name, version) != nil {
+		http.Error(w, fmt.Sprintf("%s:%s already exists", name, version), http.StatusConflict)
+		return
+	}
+
+	gar, heldName, err := pushTarget(backend, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	user, credential, err := gar.Credential(r.Context())
+	if err != nil {
+		log.Printf("failed to get credential to push %s:%s. error: %v", name, version, err)
+		http.Error(w, "failed to push chart", http.StatusBadGateway)
+		return
+	}
+	client, err := p.logins.client(gar, user, credential)
+	if err != nil {
+		log.Printf("failed to log in to push %s:%s. error: %v", name, version, err)
+		http.Error(w, "failed to push chart", http.StatusBadGateway)
+		return
+	}
+
+	ref := fmt.Sprintf("%s/%s:%s", repositoryURI(gar.config), heldName, version)
+	result, err := client.Push(data, ref)
+	if err != nil {
+		log.Printf("failed to push %s. error: %v", ref, err)
+		http.Error(w, "failed to push chart", http.StatusBadGateway)
+		return
+	}
+
+	identity := requestIdentity(r)
+	who := identity.User
+	if who == "" {
+		who = identity.RemoteAddr
+	}
+	log.Printf("%s pushed %s:%s (%s)", who, name, version, result.Manifest.Digest)
+
+	// As with imports, a missing attestation is logged rather than failing
+	// the push.
+	if p.attestor != nil {
+		parameters := map[string]string{"destination": ref, "pushedBy": who}
+		if err := p.attestor.attest(r.Context(), gar, ref, result.Manifest.Digest, "push", parameters, started); err != nil {
+			log.Printf("failed to attest push of %s. error: %v", ref, err)
+		}
+	}
+
+	// The chart is stored at this point; a failed lookup leaves it to the
+	// next sync.
+	asset, err := gar.lookup(r.Context(), heldName, result.Manifest.Digest)
+	if err == nil && asset == nil {
+		err = fmt.Errorf("%s@%s isn't listed yet", heldName, result.Manifest.Digest)
+	}
+	if err != nil {
+		log.Printf("failed to add %s:%s to the catalog after push. error: %v", name, version, err)
+	} else {
+		if composite, ok := backend.(*compositeBackend); ok {
+			asset.Name = name
+			asset.sources = []*assetSource{{route: composite.route(name), name: heldName, uri: asset.URI}}
+		}
+		addPushed(r.Context(), config, backend, asset)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/%s:%s", name, version))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pushedChart{Name: name, Version: version, Digest: result.Manifest.Digest, Ref: ref})
+}
+
+// addPushed merges a pushed chart into the catalog, as a delta sync would
+// have, rather than reloading all of it.
+func addPushed(ctx context.Context, config *Config, backend Backend, asset *Asset) {
+	current := currentRepository()
+	previous := &Repository{Revision: current.Revision, Assets: current.holding([]*Asset{asset})}
+	swapRepository(current.merged([]*Asset{asset}))
+	if catalogChanged != nil {
+		catalogChanged(previous, &Repository{Assets: []*Asset{asset}})
+	}
+	saveSnapshot(ctx, config, backend.Name(), currentRepository())
+}
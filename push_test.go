@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{{Name: "team/nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}})
+	defer setRepository(&Repository{})
+
+	chart := func(version string) []byte {
+		var tgz bytes.Buffer
+		compressed := gzip.NewWriter(&tgz)
+		archive := tar.NewWriter(compressed)
+		chartYAML := []byte("apiVersion: v2\nname: nginx\nversion: " + version + "\n")
+		archive.WriteHeader(&tar.Header{Name: "nginx/Chart.yaml", Mode: 0o644, Size: int64(len(chartYAML))})
+		archive.Write(chartYAML)
+		archive.Close()
+		compressed.Close()
+		return tgz.Bytes()
+	}
+
+	pusher := &chartPusher{live: &liveConfig{config: &Config{}, backend: &listedBackend{}}}
+	tests := []struct {
+		target string
+		body   []byte
+		code   int
+	}{
+		{"/api/charts", []byte("not a chart"), http.StatusBadRequest},
+		{"/api/charts?dir=team", chart("1.0.0"), http.StatusConflict},
+		{"/api/charts?dir=team", chart("1.1.0"), http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		pusher.handlePush(w, httptest.NewRequest(http.MethodPost, tt.target, bytes.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("POST %s = %d %s, want %d", tt.target, w.Code, w.Body, tt.code)
+		}
+	}
+}
+
+func TestAddPushed(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}},
+		{Name: "redis", SHA: "sha256:2", Tags: []string{"7.0.0"}},
+	}})
+	defer setRepository(&Repository{})
+	var changed []*Asset
+	catalogChanged = func(previous, current *Repository) { changed = current.Assets }
+	defer func() { catalogChanged = nil }()
+
+	addPushed(context.Background(), &Config{}, &listedBackend{}, &Asset{Name: "nginx", SHA: "sha256:3", Tags: []string{"1.1.0"}})
+
+	repository := currentRepository()
+	for _, tag := range []string{"nginx:1.0.0", "nginx:1.1.0", "redis:7.0.0"} {
+		name, version, _ := strings.Cut(tag, ":")
+		if repository.findByTag(name, version) == nil {
+			t.Errorf("%s missing from the catalog after the push", tag)
+		}
+	}
+	if len(changed) != 1 || changed[0].SHA != "sha256:3" {
+		t.Errorf("subscribers were told of %v, want only the pushed chart", changed)
+	}
+}
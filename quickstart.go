@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// quickstartRepo is the name the generated configs give the proxy's chart
+// repository.
+const quickstartRepo = "gcp-oci-proxy"
+
+// quickstart is ready-to-use client configuration for one chart version.
+type quickstart struct {
+	Chart   string   `json:"chart"`
+	Version string   `json:"version"`
+	URL     string   `json:"url"`
+	Helm    []string `json:"helm"`
+	Flux    string   `json:"flux"`
+	ArgoCD  string   `json:"argocd"`
+}
+
+// externalURL returns the URL clients reach the proxy at: --external-url,
+// or the scheme and host the request came in through.
+func externalURL(config *Config, r *http.Request) string {
+	if config.ExternalURL != "" {
+		return strings.TrimSuffix(config.ExternalURL, "/")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
+
+func newQuickstart(url, chart, version string) *quickstart {
+	release := path.Base(chart)
+	return &quickstart{
+		Chart:   chart,
+		Version: version,
+		URL:     url,
+		Helm: []string{
+			fmt.Sprintf("helm repo add %s %s", quickstartRepo, url),
+			"helm repo update " + quickstartRepo,
+			fmt.Sprintf("helm install %s %s/%s --version %s", release, quickstartRepo, chart, version),
+			fmt.Sprintf("helm pull %s/%s:%s", url, chart, version),
+		},
+		Flux: fmt.Sprintf(`apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: %[1]s
+  namespace: flux-system
+spec:
+  interval: 10m
+  url: %[2]s
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: %[3]s
+  namespace: flux-system
+spec:
+  interval: 10m
+  chart:
+    spec:
+      chart: %[4]s
+      version: %[5]q
+      sourceRef:
+        kind: HelmRepository
+        name: %[1]s
+`, quickstartRepo, url, release, chart, version),
+		ArgoCD: fmt.Sprintf(`apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: %[1]s
+  namespace: argocd
+spec:
+  project: default
+  source:
+    repoURL: %[2]s
+    chart: %[3]s
+    targetRevision: %[4]q
+  destination:
+    server: https://kubernetes.default.svc
+    namespace: %[1]s
+`, release, url, chart, version),
+	}
+}
+
+// handleQuickstart generates helm commands, a Flux HelmRepository and
+// HelmRelease, and an Argo CD Application for ?chart, at ?version or its
+// latest version.
+func handleQuickstart(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, _ := live.get()
+		chart, version := r.URL.Query().Get("chart"), r.URL.Query().Get("version")
+		if chart == "" {
+			http.Error(w, "missing chart parameter", http.StatusBadRequest)
+			return
+		}
+
+		repository := currentRepository()
+		if version == "" {
+			if _, version = repository.latestVersion(chart); version == "" {
+				chartNotFound(w, config, repository, chart, "")
+				return
+			}
+		} else if repository.findByTag(chart, version) == nil {
+			chartNotFound(w, config, repository, chart, version)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newQuickstart(externalURL(config, r), chart, version))
+	}
+}
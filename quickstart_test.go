@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQuickstart(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.3"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.3.0"}},
+	}})
+	defer setRepository(&Repository{})
+
+	handler := handleQuickstart(&liveConfig{config: &Config{NotFoundSuggestions: 5}})
+	tests := []struct {
+		target  string
+		code    int
+		version string
+	}{
+		{"/api/v1/quickstart?chart=nginx", http.StatusOK, "1.3.0"},
+		{"/api/v1/quickstart?chart=nginx&version=1.2.3", http.StatusOK, "1.2.3"},
+		{"/api/v1/quickstart?chart=nginx&version=9.9.9", http.StatusNotFound, ""},
+		{"/api/v1/quickstart", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.target, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+
+		var got quickstart
+		json.NewDecoder(w.Body).Decode(&got)
+		if got.Version != tt.version || got.URL != "https://example.com" || !strings.Contains(got.ArgoCD, "targetRevision: \""+tt.version+"\"") {
+			t.Errorf("GET %s = %+v, want version %s at https://example.com", tt.target, got, tt.version)
+		}
+	}
+}
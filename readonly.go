@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errReadOnly is returned by mutating operations attempted in read-only mode.
+var errReadOnly = errors.New("server is read-only")
+
+// readOnly rejects every request that could change the registry while
+// --read-only is set, whatever else is configured. Only safe methods get
+// through, plus resolution sessions, which live in memory and change nothing
+// that is served, and signing download urls and rendering charts, which
+// change nothing at all.
+func readOnly(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, _ := live.get()
+			if !config.ReadOnly || safeMethod(r.Method) || strings.HasPrefix(r.URL.Path, "/api/v1/sessions") || strings.HasSuffix(r.URL.Path, "/signed-url") || isRender(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			http.Error(w, errReadOnly.Error(), http.StatusMethodNotAllowed)
+		})
+	}
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// isRender reports whether r renders a chart, which is a POST only to carry
+// the values.
+func isRender(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/api/charts/") && strings.HasSuffix(r.URL.Path, "/render")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	handler := readOnly(&liveConfig{config: &Config{ReadOnly: true}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		method string
+		target string
+		code   int
+	}{
+		{http.MethodGet, "/admin/cache", http.StatusNoContent},
+		{http.MethodHead, "/nginx:1.0.0", http.StatusNoContent},
+		{http.MethodPost, "/api/v1/sessions", http.StatusNoContent},
+		{http.MethodPost, "/api/charts/nginx/1.0.0/signed-url", http.StatusNoContent},
+		{http.MethodPost, "/api/charts/nginx/1.0.0/render", http.StatusNoContent},
+		{http.MethodPost, "/api/charts", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/charts/nginx/1.0.0", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/admin/cache", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/admin/cache/sha256:1", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+		if w.Code != test.code {
+			t.Errorf("%s %s = %d, want %d", test.method, test.target, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+// ociManifest is the subset of an OCI image manifest needed to find the
+// chart layer of a Helm artifact and the content of supply-chain artifacts.
+type ociManifest struct {
+	MediaType    string          `json:"mediaType"`
+	ArtifactType string          `json:"artifactType,omitempty"`
+	Config       ociDescriptor   `json:"config"`
+	Layers       []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType    string            `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// splitReference splits "host/repository@digest" or "host/repository:tag"
+// into its parts.
+func splitReference(uri string) (host, repository, reference string, err error) {
+	host, rest, ok := strings.Cut(uri, "/")
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid reference %q", uri)
+	}
+
+	if repository, reference, ok = strings.Cut(rest, "@"); !ok {
+		i := strings.LastIndex(rest, ":")
+		if i < 0 {
+			return "", "", "", fmt.Errorf("invalid reference %q", uri)
+		}
+		repository, reference = rest[:i], rest[i+1:]
+	}
+
+	if host == "" || repository == "" || reference == "" {
+		return "", "", "", fmt.Errorf("invalid reference %q", uri)
+	}
+	return host, repository, reference, nil
+}
+
+// sourceFor returns the backend holding asset and the reference to use with
+// it, resolving composite backends to the asset's preferred source.
+func sourceFor(backend Backend, asset *Asset) (Backend, string) {
+	if asset.origin != nil {
+		return asset.origin, asset.URI
+	}
+
+	if _, ok := backend.(*compositeBackend); ok {
+		if sources := rankSources(asset.sources, time.Now()); len(sources) > 0 {
+			return sources[0].route.backend, sources[0].uri
+		}
+	}
+	return backend, asset.URI
+}
+
+// registryFor returns the registry API, repository and reference asset is
+// fetched from.
+func registryFor(backend Backend, asset *Asset) (*registryAPI, string, string, error) {
+	backend, uri := sourceFor(backend, asset)
+	host, repository, reference, err := splitReference(uri)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return &registryAPI{host: host, credential: backend.Credential}, repository, reference, nil
+}
+
+// manifestFor fetches the OCI manifest of asset from its backend.
+func manifestFor(ctx context.Context, backend Backend, asset *Asset) (*registryAPI, string, *ociManifest, error) {
+	api, repository, reference, err := registryFor(backend, asset)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	manifest, err := fetchManifest(ctx, api, repository, reference)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return api, repository, manifest, nil
+}
+
+// fetchManifest fetches the OCI manifest of repository at reference, a tag
+// or a digest.
+func fetchManifest(ctx context.Context, api *registryAPI, repository, reference string) (*ociManifest, error) {
+	resp, err := api.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), ociManifestAccept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var manifest ociManifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// fetchBlob downloads the blob digest of repository and checks its content
+// against the digest.
+func fetchBlob(ctx context.Context, api *registryAPI, repository, digest string) ([]byte, error) {
+	resp, err := api.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repository, digest), "*/*")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if got := "sha256:" + sha256Hex(data); got != digest {
+		return nil, fmt.Errorf("blob %s has digest %s", digest, got)
+	}
+	return data, nil
+}
+
+// chartBlobLocation returns the short-lived URL the registry redirects
+// downloads of the chart layer of asset to. It returns an empty string when
+// the registry serves blobs itself, in which case the chart has to be
+// proxied.
+func chartBlobLocation(ctx context.Context, backend Backend, asset *Asset) (string, error) {
+	api, repository, manifest, err := manifestFor(ctx, backend, asset)
+	if err != nil {
+		return "", err
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == registry.ChartLayerMediaType {
+			return api.location(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repository, layer.Digest))
+		}
+	}
+	return "", fmt.Errorf("%s has no chart layer", asset.RawName)
+}
+
+// redirectAsset answers with a redirect to the upstream location of the
+// chart instead of proxying its bytes. It returns false, without writing a
+// response, when no redirect is possible and the chart should be proxied.
+func redirectAsset(w http.ResponseWriter, r *http.Request, backend Backend, asset *Asset) bool {
+	location, err := chartBlobLocation(r.Context(), backend, asset)
+	if err != nil {
+		log.Printf("failed to resolve redirect for %s, proxying instead. error: %v", asset.RawName, err)
+		return false
+	}
+
+	if location == "" {
+		return false
+	}
+
+	http.Redirect(w, r, location, http.StatusFound)
+	return true
+}
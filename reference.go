@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// digestAlgorithm is the algorithm component of an OCI digest.
+	digestAlgorithm = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*$`)
+
+	// digestLengths is the hex length of the encoded part of the digests of
+	// the registered algorithms. Others only need to be hex.
+	digestLengths = map[string]int{"sha256": 64, "sha512": 128}
+
+	hexDigits = regexp.MustCompile(`^[a-f0-9]+$`)
+)
+
+// extractNameAndSha splits an image reference by digest, such as
+// us-docker.pkg.dev/project/repository/chart@sha256:<hex> or the GAR
+// resource name projects/p/locations/l/repositories/r/dockerImages/chart@sha256:<hex>,
+// into the chart name and digest. The chart name is the last path
+// component of the image after decoding, except in GAR resource names where
+// it is the whole image path below dockerImages/, which GAR URL-encodes,
+// e.g. team/chart for dockerImages/team%2Fchart. The digest follows the
+// last '@'.
+func extractNameAndSha(input string) (name, sha string, err error) {
+	at := strings.LastIndex(input, "@")
+	if at < 0 {
+		return "", "", fmt.Errorf("invalid reference %q: missing digest", input)
+	}
+
+	image, sha := input[:at], input[at+1:]
+	if err := validateDigest(sha); err != nil {
+		return "", "", fmt.Errorf("invalid reference %q: %w", input, err)
+	}
+
+	if !strings.Contains(image, "/") {
+		return "", "", fmt.Errorf("invalid reference %q: missing repository path", input)
+	}
+
+	decoded, err := url.PathUnescape(image)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid reference %q: %w", input, err)
+	}
+
+	if _, nested, ok := strings.Cut(image, "/dockerImages/"); ok {
+		if name, err = url.PathUnescape(nested); err != nil {
+			return "", "", fmt.Errorf("invalid reference %q: %w", input, err)
+		}
+	} else {
+		name = decoded[strings.LastIndex(decoded, "/")+1:]
+	}
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		return "", "", fmt.Errorf("invalid reference %q: empty name", input)
+	}
+	if strings.ContainsAny(name, "@:") || strings.TrimSpace(name) != name {
+		return "", "", fmt.Errorf("invalid reference %q: invalid name %q", input, name)
+	}
+	return name, sha, nil
+}
+
+// chartName returns the catalog name of the chart stored in repository: its
+// path below root, the repository prefix the backend was configured with,
+// so charts can be nested like team/app. A root ending in the middle of a
+// path segment is taken back to the last whole one.
+func chartName(repository, root string) string {
+	root = strings.Trim(root, "/")
+	if root != "" && strings.HasPrefix(repository, root+"/") {
+		return repository[len(root)+1:]
+	}
+	if i := strings.LastIndex(root, "/"); i >= 0 && strings.HasPrefix(repository, root[:i+1]) {
+		return repository[i+1:]
+	}
+	return repository
+}
+
+// validateDigest checks that digest is an OCI digest, algorithm:encoded.
+func validateDigest(digest string) error {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok {
+		return fmt.Errorf("invalid digest %q: missing algorithm", digest)
+	}
+
+	if !digestAlgorithm.MatchString(algorithm) {
+		return fmt.Errorf("invalid digest %q: invalid algorithm %q", digest, algorithm)
+	}
+
+	if !hexDigits.MatchString(encoded) {
+		return fmt.Errorf("invalid digest %q: encoded part is not lowercase hex", digest)
+	}
+
+	if length, ok := digestLengths[algorithm]; ok && len(encoded) != length {
+		return fmt.Errorf("invalid digest %q: %s needs %d hex digits, got %d", digest, algorithm, length, len(encoded))
+	}
+	return nil
+}
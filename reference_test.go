@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testSHA256 = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	testSHA512 = "sha512:cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"
+)
+
+func TestExtractNameAndSha(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantSHA string
+		wantErr string
+	}{
+		{
+			name:    "registry reference",
+			input:   "us-docker.pkg.dev/project/repository/nginx@" + testSHA256,
+			want:    "nginx",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "gar resource name",
+			input:   "projects/p/locations/us/repositories/charts/dockerImages/nginx@" + testSHA256,
+			want:    "nginx",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "gar url-encoded nested image",
+			input:   "projects/p/locations/us/repositories/charts/dockerImages/team%2Fplatform%2Fnginx@" + testSHA256,
+			want:    "team/platform/nginx",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "nested path",
+			input:   "ghcr.io/org/team/charts/nginx@" + testSHA256,
+			want:    "nginx",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "host with port",
+			input:   "localhost:5000/charts/nginx@" + testSHA256,
+			want:    "nginx",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "url-encoded name",
+			input:   "registry.example.com/charts/my%2Bchart@" + testSHA256,
+			want:    "my+chart",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "unusual characters",
+			input:   "registry.example.com/charts/my_chart.v2-beta@" + testSHA256,
+			want:    "my_chart.v2-beta",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "multiple at signs",
+			input:   "registry.example.com/scope@org/nginx@" + testSHA256,
+			want:    "nginx",
+			wantSHA: testSHA256,
+		},
+		{
+			name:    "sha512",
+			input:   "registry.example.com/charts/nginx@" + testSHA512,
+			want:    "nginx",
+			wantSHA: testSHA512,
+		},
+		{
+			name:    "unregistered algorithm",
+			input:   "registry.example.com/charts/nginx@blake3:abc123",
+			want:    "nginx",
+			wantSHA: "blake3:abc123",
+		},
+		{
+			name:    "missing digest",
+			input:   "registry.example.com/charts/nginx",
+			wantErr: "missing digest",
+		},
+		{
+			name:    "missing repository path",
+			input:   "nginx@" + testSHA256,
+			wantErr: "missing repository path",
+		},
+		{
+			name:    "empty name",
+			input:   "registry.example.com/charts/@" + testSHA256,
+			wantErr: "empty name",
+		},
+		{
+			name:    "encoded trailing slash",
+			input:   "registry.example.com/charts/team%2F@" + testSHA256,
+			wantErr: "empty name",
+		},
+		{
+			name:    "gar nested image with empty segment",
+			input:   "projects/p/locations/us/repositories/charts/dockerImages/team%2F%2Fnginx@" + testSHA256,
+			wantErr: "empty name",
+		},
+		{
+			name:    "digest without algorithm",
+			input:   "registry.example.com/charts/nginx@2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+			wantErr: "missing algorithm",
+		},
+		{
+			name:    "short sha256",
+			input:   "registry.example.com/charts/nginx@sha256:2c26b4",
+			wantErr: "needs 64 hex digits",
+		},
+		{
+			name:    "uppercase hex",
+			input:   "registry.example.com/charts/nginx@sha256:2C26B46B68FFC68FF99B453C1D30413413422D706483BFA0F98A5E886266E7AE",
+			wantErr: "not lowercase hex",
+		},
+		{
+			name:    "invalid algorithm",
+			input:   "registry.example.com/charts/nginx@SHA-256:abc",
+			wantErr: "invalid algorithm",
+		},
+		{
+			name:    "digest after tag",
+			input:   "registry.example.com/charts/nginx:1.0@" + testSHA256,
+			wantErr: "invalid name",
+		},
+		{
+			name:    "bad escape",
+			input:   "registry.example.com/charts/nginx%zz@" + testSHA256,
+			wantErr: "invalid URL escape",
+		},
+		{
+			name:    "empty",
+			input:   "",
+			wantErr: "missing digest",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, sha, err := extractNameAndSha(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("extractNameAndSha(%q) error = %v, want one containing %q", tt.input, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("extractNameAndSha(%q) error = %v", tt.input, err)
+			}
+			if name != tt.want || sha != tt.wantSHA {
+				t.Errorf("extractNameAndSha(%q) = %q, %q, want %q, %q", tt.input, name, sha, tt.want, tt.wantSHA)
+			}
+		})
+	}
+}
+
+func TestChartName(t *testing.T) {
+	tests := []struct {
+		repository string
+		root       string
+		want       string
+	}{
+		{repository: "charts/nginx", root: "charts", want: "nginx"},
+		{repository: "charts/team/app", root: "charts/", want: "team/app"},
+		{repository: "charts/nginx", root: "", want: "charts/nginx"},
+		{repository: "charts/nginx", root: "charts/ng", want: "nginx"},
+		{repository: "nginx", root: "nginx", want: "nginx"},
+		{repository: "project/team/app", root: "project", want: "team/app"},
+	}
+
+	for _, tt := range tests {
+		if got := chartName(tt.repository, tt.root); got != tt.want {
+			t.Errorf("chartName(%q, %q) = %q, want %q", tt.repository, tt.root, got, tt.want)
+		}
+	}
+}
+
+func FuzzExtractNameAndSha(f *testing.F) {
+	for _, seed := range []string{
+		"us-docker.pkg.dev/project/repository/nginx@" + testSHA256,
+		"projects/p/locations/us/repositories/charts/dockerImages/team%2Fnginx@" + testSHA256,
+		"registry.example.com/scope@org/nginx@" + testSHA512,
+		"registry.example.com/charts/nginx%zz@sha256:abc",
+		"@",
+		"/@:",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		name, sha, err := extractNameAndSha(input)
+		if err != nil {
+			return
+		}
+
+		if name == "" || strings.ContainsAny(name, "@:") || strings.Contains("/"+name+"/", "//") {
+			t.Fatalf("extractNameAndSha(%q) returned invalid name %q", input, name)
+		}
+		if !strings.HasSuffix(input, "@"+sha) {
+			t.Fatalf("extractNameAndSha(%q) returned digest %q not at the end of the input", input, sha)
+		}
+		if err := validateDigest(sha); err != nil {
+			t.Fatalf("extractNameAndSha(%q) returned invalid digest: %v", input, err)
+		}
+	})
+}
+
+func FuzzSplitReference(f *testing.F) {
+	for _, seed := range []string{
+		"us-docker.pkg.dev/project/repository/nginx@" + testSHA256,
+		"ghcr.io/org/charts/nginx:1.2.3",
+		"localhost:5000/nginx:1.0",
+		"registry.example.com/nginx",
+		"nginx",
+		"/:@",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, uri string) {
+		host, repository, reference, err := splitReference(uri)
+		if err != nil {
+			return
+		}
+
+		if host == "" || repository == "" || reference == "" {
+			t.Fatalf("splitReference(%q) = %q, %q, %q, want every part", uri, host, repository, reference)
+		}
+	})
+}
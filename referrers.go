@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+const ociIndexMediaType = "application/vnd.oci.image.index.v1+json"
+
+// sbomMediaTypes are the artifact and layer media types recognized as
+// software bills of materials, by format.
+var sbomMediaTypes = map[string]string{
+	"application/spdx+json":          "spdx",
+	"text/spdx+json":                 "spdx",
+	"text/spdx":                      "spdx",
+	"application/vnd.cyclonedx+json": "cyclonedx",
+	"application/vnd.cyclonedx+xml":  "cyclonedx",
+	"application/vnd.syft+json":      "syft",
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// referrers lists the artifacts referring to digest in repository, using
+// the OCI referrers API and falling back to the referrers tag schema
+// (`sha256-<hex>`) for registries that don't implement it.
+func referrers(ctx context.Context, api *registryAPI, repository, digest string) ([]ociDescriptor, error) {
+	index, err := fetchIndex(ctx, api, fmt.Sprintf("/v2/%s/referrers/%s", repository, digest))
+	if errors.Is(err, errNotFound) {
+		index, err = fetchIndex(ctx, api, fmt.Sprintf("/v2/%s/manifests/%s", repository, strings.Replace(digest, ":", "-", 1)))
+		if errors.Is(err, errNotFound) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
+
+func fetchIndex(ctx context.Context, api *registryAPI, path string) (*ociIndex, error) {
+	resp, err := api.do(ctx, http.MethodGet, path, ociIndexMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var index ociIndex
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// supplyChainArtifact is an SBOM or attestation attached to a chart.
+type supplyChainArtifact struct {
+	Digest        string          `json:"digest"`
+	MediaType     string          `json:"media_type"`
+	PredicateType string          `json:"predicate_type,omitempty"`
+	Source        string          `json:"source"`
+	Content       json.RawMessage `json:"content,omitempty"`
+
+	data []byte
+}
+
+// supplyChain collects the artifacts attached to asset whose layers match,
+// from referrers and from the cosign tag of the given kind ("sbom" or
+// "att").
+func supplyChain(ctx context.Context, backend Backend, asset *Asset, kind string, match func(mediaType string) bool) ([]supplyChainArtifact, error) {
+	source, uri := sourceFor(backend, asset)
+	host, repository, _, err := splitReference(uri)
+	if err != nil {
+		return nil, err
+	}
+	api := &registryAPI{host: host, credential: source.Credential}
+
+	var manifests []string
+	descriptors, err := referrers(ctx, api, repository, asset.SHA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers: %w", err)
+	}
+	for _, descriptor := range descriptors {
+		if descriptor.ArtifactType == "" || match(descriptor.ArtifactType) {
+			manifests = append(manifests, descriptor.Digest)
+		}
+	}
+	manifests = append(manifests, cosignTag(asset.SHA, kind))
+
+	var artifacts []supplyChainArtifact
+	for _, reference := range manifests {
+		manifest, err := fetchManifest(ctx, api, repository, reference)
+		if errors.Is(err, errNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		origin := "referrers"
+		if strings.HasPrefix(reference, "sha256-") {
+			origin = "cosign"
+		}
+
+		for _, layer := range manifest.Layers {
+			if !match(layer.MediaType) && !match(manifest.ArtifactType) {
+				continue
+			}
+
+			data, err := fetchBlob(ctx, api, repository, layer.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			artifact := supplyChainArtifact{
+				Digest:        layer.Digest,
+				MediaType:     layer.MediaType,
+				PredicateType: layer.Annotations["predicateType"],
+				Source:        origin,
+				data:          data,
+			}
+			if json.Valid(data) {
+				artifact.Content = data
+			}
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+func isSBOM(mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	_, ok := sbomMediaTypes[strings.TrimSpace(mediaType)]
+	return ok
+}
+
+func isAttestation(mediaType string) bool {
+	return mediaType == dsseEnvelopeMediaType || strings.Contains(mediaType, "in-toto")
+}
+
+// findChartVersion returns the asset of chart name at version, a tag or a
+// digest.
+func findChartVersion(name, version string) *Asset {
+	if strings.HasPrefix(version, "sha256:") {
+		return currentRepository().findByDigest(name, version)
+	}
+	return currentRepository().findByTag(name, version)
+}
+
+// handleSBOM serves the first SBOM attached to a chart version, optionally
+// restricted to a ?format (spdx, cyclonedx or syft), as the document itself.
+func handleSBOM(w http.ResponseWriter, r *http.Request, live *liveConfig) {
+	name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+	asset := findChartVersion(name, version)
+	if asset == nil {
+		config, _ := live.get()
+		chartNotFound(w, config, currentRepository(), name, version)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	match := func(mediaType string) bool {
+		if !isSBOM(mediaType) {
+			return false
+		}
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		return format == "" || sbomMediaTypes[strings.TrimSpace(mediaType)] == format
+	}
+
+	_, backend := live.get()
+	artifacts, err := supplyChain(r.Context(), backend, asset, "sbom", match)
+	if err != nil {
+		log.Printf("failed to fetch sbom of %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to fetch sbom", http.StatusBadGateway)
+		return
+	}
+
+	if len(artifacts) == 0 {
+		http.Error(w, fmt.Sprintf("%s has no sbom", asset.RawName), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", artifacts[0].MediaType)
+	w.Write(artifacts[0].data)
+}
+
+// handleAttestations lists the in-toto attestations attached to a chart
+// version, with their DSSE envelopes.
+func handleAttestations(w http.ResponseWriter, r *http.Request, live *liveConfig) {
+	name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+	asset := findChartVersion(name, version)
+	if asset == nil {
+		config, _ := live.get()
+		chartNotFound(w, config, currentRepository(), name, version)
+		return
+	}
+
+	_, backend := live.get()
+	artifacts, err := supplyChain(r.Context(), backend, asset, "att", isAttestation)
+	if err != nil {
+		log.Printf("failed to fetch attestations of %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to fetch attestations", http.StatusBadGateway)
+		return
+	}
+
+	if artifacts == nil {
+		artifacts = []supplyChainArtifact{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifacts)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// errNotFound is wrapped by registryAPI calls answered with 404.
+	errNotFound = errors.New("not found")
+
+	// errUnauthorized is wrapped by registryAPI calls the registry refused
+	// the credentials of, with 401 or 403.
+	errUnauthorized = errors.New("unauthorized")
+)
+
+// registryAPI calls the OCI distribution API of a registry. Requests carry
+// the static token when one is configured; otherwise the standard bearer
+// token challenge is answered with the basic credential returned by
+// credential.
+type registryAPI struct {
+	host        string
+	client      *http.Client
+	staticToken string
+	credential  func(ctx context.Context) (user, password string, err error)
+	// basic sends the credential up front as basic auth, for APIs that
+	// don't answer with a challenge.
+	basic bool
+}
+
+// getJSON fetches path from the registry and decodes the JSON response into v.
+// It returns the next page URL advertised by the Link header, if any.
+func (a *registryAPI) getJSON(ctx context.Context, path string, v interface{}) (string, error) {
+	resp, err := a.do(ctx, http.MethodGet, path, "application/json")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return nextLink(a.host, resp.Header.Get("Link")), nil
+}
+
+// head returns the response headers of a HEAD request for path.
+func (a *registryAPI) head(ctx context.Context, path, accept string) (http.Header, error) {
+	resp, err := a.do(ctx, http.MethodHead, path, accept)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+func (a *registryAPI) do(ctx context.Context, method, path, accept string) (*http.Response, error) {
+	return a.upload(ctx, method, path, accept, "", nil)
+}
+
+// upload is do with a request body of the given content type.
+func (a *registryAPI) upload(ctx context.Context, method, path, accept, contentType string, body []byte) (*http.Response, error) {
+	endpoint := path
+	if !strings.HasPrefix(endpoint, "https://") {
+		endpoint = fmt.Sprintf("https://%s%s", a.host, path)
+	}
+
+	authorization := ""
+	if a.staticToken != "" {
+		authorization = "Bearer " + a.staticToken
+	} else if a.basic && a.credential != nil {
+		user, password, err := a.credential(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if user != "" || password != "" {
+			authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+		}
+	}
+
+	resp, err := a.send(ctx, method, endpoint, accept, authorization, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && a.staticToken == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		authorization, err := a.answer(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = a.send(ctx, method, endpoint, accept, authorization, contentType, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %w", method, endpoint, errNotFound)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %w (%s)", method, endpoint, errUnauthorized, resp.Status)
+	}
+
+	// Redirects only make it here when the client was told not to follow
+	// them, in which case the caller wants the Location.
+	if resp.StatusCode/100 != 2 && !isRedirect(resp.StatusCode) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, endpoint, resp.Status)
+	}
+	return resp, nil
+}
+
+// location returns where the registry redirects a GET for path, typically a
+// short-lived signed storage URL for a blob. It returns an empty string if
+// the registry serves the content itself.
+func (a *registryAPI) location(ctx context.Context, path string) (string, error) {
+	noFollow := *a
+	client := a.client
+	if client == nil {
+		client = upstreamClient
+	}
+	noFollow.client = &http.Client{
+		Transport: client.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := noFollow.do(ctx, http.MethodGet, path, "*/*")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if !isRedirect(resp.StatusCode) {
+		return "", nil
+	}
+	return resp.Header.Get("Location"), nil
+}
+
+// pushBlob uploads data to repository in a single monolithic upload and
+// returns its digest.
+func (a *registryAPI) pushBlob(ctx context.Context, repository string, data []byte) (string, error) {
+	digest := "sha256:" + sha256Hex(data)
+
+	resp, err := a.do(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", repository), "*/*")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry %s returned no upload location", a.host)
+	}
+
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+
+	resp, err = a.upload(ctx, http.MethodPut, location+separator+"digest="+url.QueryEscape(digest), "*/*", "application/octet-stream", data)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return digest, nil
+}
+
+// pushManifest stores manifest in repository under reference.
+func (a *registryAPI) pushManifest(ctx context.Context, repository, reference, mediaType string, manifest []byte) error {
+	resp, err := a.upload(ctx, http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), "*/*", mediaType, manifest)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func (a *registryAPI) send(ctx context.Context, method, endpoint, accept, authorization, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	client := a.client
+	if client == nil {
+		client = upstreamClient
+	}
+	return client.Do(req)
+}
+
+// answer returns the Authorization header satisfying challenge. Registries
+// without a token service, such as a plain distribution registry behind
+// htpasswd, ask for basic auth directly.
+func (a *registryAPI) answer(ctx context.Context, challenge string) (string, error) {
+	if scheme, _, _ := strings.Cut(challenge, " "); strings.EqualFold(scheme, "basic") {
+		if a.credential == nil {
+			return "", fmt.Errorf("registry %s requires a credential", a.host)
+		}
+
+		user, password, err := a.credential(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)), nil
+	}
+
+	token, err := a.token(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// token exchanges the registry credential for a bearer token as described by
+// a `Bearer realm="...",service="...",scope="..."` challenge.
+func (a *registryAPI) token(ctx context.Context, challenge string) (string, error) {
+	params := parseChallenge(challenge)
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if a.credential != nil {
+		user, password, err := a.credential(ctx)
+		if err != nil {
+			return "", err
+		}
+		if user != "" || password != "" {
+			req.SetBasicAuth(user, password)
+		}
+	}
+
+	client := a.client
+	if client == nil {
+		client = upstreamClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s: unexpected status %s", realm, resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	scheme, rest, ok := strings.Cut(challenge, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return params
+	}
+
+	for rest != "" {
+		var pair string
+		pair, rest = cutParam(rest)
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		params[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return params
+}
+
+// cutParam splits the first comma separated parameter off s, ignoring
+// commas inside quoted values such as multi-action scopes.
+func cutParam(s string) (param, rest string) {
+	quoted := false
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// nextLink extracts the target of a `<...>; rel="next"` Link header.
+func nextLink(host, link string) string {
+	// APIs such as GitHub's list several relations, e.g.
+	// `<...>; rel="prev", <...>; rel="next"`.
+	for _, value := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(value, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+
+		target = strings.Trim(strings.TrimSpace(target), "<>")
+		if strings.HasPrefix(target, "/") {
+			target = fmt.Sprintf("https://%s%s", host, target)
+		}
+		return target
+	}
+	return ""
+}
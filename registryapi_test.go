@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func FuzzParseChallenge(f *testing.F) {
+	for _, seed := range []string{
+		`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:charts/nginx:pull"`,
+		`Bearer realm="https://auth.example.com/token",scope="repository:a:pull,push"`,
+		`Basic realm="registry"`,
+		`Bearer `,
+		`Bearer ,,=,"`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, challenge string) {
+		params := parseChallenge(challenge)
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") && len(params) > 0 {
+			t.Fatalf("parseChallenge(%q) = %v, want no params for a non-bearer challenge", challenge, params)
+		}
+	})
+}
+
+func FuzzNextLink(f *testing.F) {
+	for _, seed := range []string{
+		`</v2/_catalog?last=b&n=100>; rel="next"`,
+		`<https://registry.example.com/v2/charts/tags/list?last=1.0>; rel="next"`,
+		`</v2/_catalog>; rel="prev"`,
+		`<>; rel="next"`,
+		`;`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, link string) {
+		next := nextLink("registry.example.com", link)
+		if next != "" && !strings.Contains(link, `rel="next"`) {
+			t.Fatalf("nextLink(%q) = %q without a next relation", link, next)
+		}
+	})
+}
+
+// FuzzFetchManifest feeds arbitrary registry responses to the manifest
+// handling the proxy does for redirects, provenance files and supply-chain
+// lookups.
+func FuzzFetchManifest(f *testing.F) {
+	for _, seed := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip","digest":"sha256:abc","size":10}]}`},
+		{http.StatusOK, `{"layers":[{"mediaType":"application/vnd.cncf.helm.chart.provenance.v1.prov","digest":"sha256:def","size":1,"annotations":{"a":"b"}}]}`},
+		{http.StatusOK, `{"manifests":[{"digest":"sha256:abc","artifactType":"application/spdx+json"}]}`},
+		{http.StatusOK, `{"layers":null}`},
+		{http.StatusOK, `[]`},
+		{http.StatusNotFound, `{"errors":[{"code":"MANIFEST_UNKNOWN"}]}`},
+		{http.StatusInternalServerError, ``},
+	} {
+		f.Add(seed.status, seed.body)
+	}
+
+	f.Fuzz(func(t *testing.T, status int, body string) {
+		if status < 200 || status > 599 || status == http.StatusUnauthorized {
+			return
+		}
+
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+
+		api := &registryAPI{host: server.Listener.Addr().String(), client: server.Client()}
+		ctx := context.Background()
+
+		manifest, err := fetchManifest(ctx, api, "charts/nginx", "1.0.0")
+		if err == nil {
+			provLayer(manifest)
+		}
+
+		if index, err := fetchIndex(ctx, api, "/v2/charts/nginx/referrers/sha256:abc"); err == nil {
+			for _, descriptor := range index.Manifests {
+				isSBOM(descriptor.ArtifactType)
+				isAttestation(descriptor.ArtifactType)
+			}
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backendDrainTimeout bounds how long a replaced backend stays open for the
+// requests still using it.
+const backendDrainTimeout = 5 * time.Minute
+
+// liveConfig holds the configuration and backend currently in effect.
+// Handlers take a snapshot at the start of a request, so a reload never
+// changes settings underneath an in-flight download.
+type liveConfig struct {
+	mu      sync.RWMutex
+	config  *Config
+	backend Backend
+	// inFlight counts the requests started since backend was swapped in.
+	inFlight *sync.WaitGroup
+}
+
+func (l *liveConfig) get() (*Config, Backend) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.config, l.backend
+}
+
+// acquire counts a request against the current backend, which a reload
+// then keeps open until release is called.
+func (l *liveConfig) acquire() (release func()) {
+	l.mu.RLock()
+	if inFlight := l.inFlight; inFlight != nil {
+		inFlight.Add(1)
+		l.mu.RUnlock()
+		return inFlight.Done
+	}
+	l.mu.RUnlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight == nil {
+		l.inFlight = &sync.WaitGroup{}
+	}
+	l.inFlight.Add(1)
+	return l.inFlight.Done
+}
+
+// holdBackend keeps the backend a request started with open until the
+// response is written, across reloads.
+func holdBackend(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer live.acquire()()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// closeDrained closes backend once the requests counted in inFlight are
+// done, or after timeout, whichever comes first.
+func closeDrained(backend Backend, inFlight *sync.WaitGroup, timeout time.Duration) {
+	drained := make(chan struct{})
+	if inFlight != nil {
+		go func() {
+			inFlight.Wait()
+			close(drained)
+		}()
+	} else {
+		close(drained)
+	}
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		log.Printf("closing %s with requests still in flight", backend.Name())
+	}
+	backend.Close()
+}
+
+// reload resolves the configuration again, builds a new backend from it and
+// swaps both in once the new catalog has loaded. The previous backend is
+// closed once the requests using it are done. On failure the current
+// configuration stays in effect.
+func (l *liveConfig) reload(ctx context.Context, load func() (*Config, error)) error {
+	config, err := load()
+	if err != nil {
+		return err
+	}
+
+	backend, err := newBackend(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	repository, err := loadRepository(ctx, config, backend)
+	if err != nil {
+		backend.Close()
+		return err
+	}
+
+	l.mu.Lock()
+	previous, previousBackend, previousInFlight := l.config, l.backend, l.inFlight
+	l.config, l.backend, l.inFlight = config, backend, &sync.WaitGroup{}
+	l.mu.Unlock()
+
+	setRepository(repository)
+	go closeDrained(previousBackend, previousInFlight, backendDrainTimeout)
+
+	if config.Port != previous.Port || config.BindHost != previous.BindHost || config.GRPCPort != previous.GRPCPort || config.Listen != previous.Listen {
+		log.Printf("listen addresses changed, restart to apply")
+	}
+	log.Printf("reloaded %d assets from %s", repository.len(), backend.Name())
+	return nil
+}
+
+// watchReload reloads l every time the process receives SIGHUP.
+func (l *liveConfig) watchReload(ctx context.Context, load func() (*Config, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := l.reload(ctx, load); err != nil {
+				log.Printf("failed to reload config. error: %v", err)
+			}
+		}
+	}()
+}
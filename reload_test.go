@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type closingBackend struct {
+	listedBackend
+	closed chan struct{}
+}
+
+func (b *closingBackend) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestBackendDrain(t *testing.T) {
+	previous := &closingBackend{closed: make(chan struct{})}
+	live := &liveConfig{config: &Config{}, backend: previous}
+
+	// A request started before the swap holds the previous backend open.
+	started, finish := make(chan struct{}), make(chan struct{})
+	handler := holdBackend(live)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+	}))
+	served := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(served)
+	}()
+	<-started
+
+	live.mu.Lock()
+	inFlight := live.inFlight
+	live.backend, live.inFlight = &listedBackend{}, &sync.WaitGroup{}
+	live.mu.Unlock()
+	go closeDrained(previous, inFlight, time.Minute)
+
+	// Requests started after the swap don't.
+	release := live.acquire()
+	select {
+	case <-previous.closed:
+		t.Fatal("previous backend closed with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(finish)
+	<-served
+	select {
+	case <-previous.closed:
+	case <-time.After(time.Second):
+		t.Fatal("previous backend not closed once its requests were done")
+	}
+	release()
+
+	// A request that never ends doesn't keep it open past the timeout.
+	stuck := &closingBackend{closed: make(chan struct{})}
+	live.acquire()
+	go closeDrained(stuck, live.inFlight, 50*time.Millisecond)
+	select {
+	case <-stuck.closed:
+	case <-time.After(time.Second):
+		t.Fatal("backend not closed after the drain timeout")
+	}
+}
+
+func TestReload(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/charts/redis/tags/list":
+			fmt.Fprint(w, `{"tags":["7.0.0"]}`)
+		case "/v2/charts/redis/manifests/7.0.0":
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	previous := upstreamClient
+	upstreamClient = server.Client()
+	defer func() { upstreamClient = previous }()
+	defer setRepository(&Repository{})
+
+	registry := func(repositories ...string) func() (*Config, error) {
+		return func() (*Config, error) {
+			return &Config{Backend: "oci", OCIRegistry: server.Listener.Addr().String(), Repository: "charts", OCIRepositories: repositories, Anonymous: true}, nil
+		}
+	}
+	tests := []struct {
+		name     string
+		load     func() (*Config, error)
+		reloaded bool
+	}{
+		{"new repository", registry("charts/redis"), true},
+		{"invalid config", func() (*Config, error) { return nil, errors.New("invalid config") }, false},
+		{"unknown backend", func() (*Config, error) { return &Config{Backend: "unknown"}, nil }, false},
+		{"registry failure", registry("charts/broken"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := &closingBackend{closed: make(chan struct{})}
+			config := &Config{Backend: "oci"}
+			live := &liveConfig{config: config, backend: current}
+			setRepository(&Repository{Assets: []*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}})
+
+			err := live.reload(context.Background(), tt.load)
+			if (err == nil) != tt.reloaded {
+				t.Fatalf("reload() = %v, want reloaded %v", err, tt.reloaded)
+			}
+			got, backend := live.get()
+			if (got != config) != tt.reloaded || (backend != Backend(current)) != tt.reloaded {
+				t.Errorf("reload() left config %+v and backend %s", got, backend.Name())
+			}
+			if (currentRepository().findByTag("redis", "7.0.0") != nil) != tt.reloaded || (currentRepository().findByTag("nginx", "1.0.0") != nil) == tt.reloaded {
+				t.Errorf("catalog after reload() = %v", currentRepository().Assets)
+			}
+
+			select {
+			case <-current.closed:
+				if !tt.reloaded {
+					t.Error("failed reload() closed the backend in use")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.reloaded {
+					t.Error("reload() didn't close the replaced backend")
+				}
+			}
+		})
+	}
+}
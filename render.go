@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+const (
+	// maxRenderValues bounds the values.yaml a render request may send.
+	maxRenderValues = 1 << 20
+
+	// defaultReleaseName is the release name `helm template` uses.
+	defaultReleaseName = "release-name"
+)
+
+// errInvalidValues is returned when the values sent to render don't parse.
+type errInvalidValues struct{ err error }
+
+func (e errInvalidValues) Error() string { return "invalid values: " + e.err.Error() }
+
+// render returns the manifests chart produces with values, as `helm
+// template` prints them: sorted by template, each preceded by its source.
+func render(data []byte, values []byte, release, namespace string) ([]byte, error) {
+	if !isGzip(data) {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(data)
+		writer.Close()
+		data = compressed.Bytes()
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chart: %w", err)
+	}
+
+	overrides, err := chartutil.ReadValues(values)
+	if err != nil {
+		return nil, errInvalidValues{err}
+	}
+	if err := chartutil.ProcessDependenciesWithMerge(ch, overrides); err != nil {
+		return nil, err
+	}
+
+	options := chartutil.ReleaseOptions{Name: release, Namespace: namespace, Revision: 1, IsInstall: true}
+	renderValues, err := chartutil.ToRenderValues(ch, overrides, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := engine.Render(ch, renderValues)
+	if err != nil {
+		return nil, err
+	}
+	return joinManifests(manifests), nil
+}
+
+// joinManifests concatenates the rendered templates, leaving out notes and
+// templates that rendered to nothing.
+func joinManifests(manifests map[string]string) []byte {
+	names := make([]string, 0, len(manifests))
+	for name, content := range manifests {
+		if strings.HasSuffix(name, "NOTES.txt") || strings.TrimSpace(content) == "" {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&out, "---\n# Source: %s\n%s\n", name, strings.TrimSpace(manifests[name]))
+	}
+	return out.Bytes()
+}
+
+// handleRender renders a chart version with the values.yaml overrides in the
+// request body. ?release and ?namespace set the release the manifests are
+// rendered for.
+func (d *chartDownloader) handleRender(w http.ResponseWriter, r *http.Request) {
+	asset := findChartVersion(chi.URLParam(r, "name"), chi.URLParam(r, "version"))
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	allowed, reason, err := d.policy.authorize(r, asset)
+	if err != nil {
+		log.Printf("failed to evaluate download policy for %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to evaluate download policy", http.StatusServiceUnavailable)
+		return
+	}
+	if !allowed {
+		http.Error(w, strings.TrimSpace("download denied by policy. "+reason), http.StatusForbidden)
+		return
+	}
+
+	values, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRenderValues))
+	if err != nil {
+		http.Error(w, "failed to read values", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	release := r.URL.Query().Get("release")
+	if release == "" {
+		release = defaultReleaseName
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	config, backend := d.live.get()
+	if oversized(config, asset) {
+		refuseOversized(w, config, asset)
+		return
+	}
+	chart, err := pullAsset(r.Context(), d.client, d.logins, d.cache, d.stats, backend, asset)
+	if err != nil {
+		log.Printf("failed to pull %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to pull chart", http.StatusBadGateway)
+		return
+	}
+
+	manifests, err := render(chart.Data, values, release, namespace)
+	var invalid errInvalidValues
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		// Template and schema errors come from the chart and the values
+		// given, not from the proxy.
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(manifests)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	writer := tar.NewWriter(gz)
+	for _, file := range []struct{ name, content string }{
+		{"nginx/Chart.yaml", "apiVersion: v2\nname: nginx\nversion: 1.2.3\n"},
+		{"nginx/values.yaml", "replicas: 1\n"},
+		{"nginx/templates/deployment.yaml", "kind: Deployment\nmetadata:\n  name: {{ .Release.Name }}\n  namespace: {{ .Release.Namespace }}\nspec:\n  replicas: {{ .Values.replicas }}\n"},
+		{"nginx/templates/empty.yaml", "{{- if .Values.missing }}kind: Secret{{ end }}\n"},
+		{"nginx/templates/NOTES.txt", "thanks\n"},
+	} {
+		writer.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content))})
+		writer.Write([]byte(file.content))
+	}
+	writer.Close()
+	gz.Close()
+
+	got, err := render(archive.Bytes(), []byte("replicas: 3\n"), "web", "prod")
+	if err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+	want := "---\n# Source: nginx/templates/deployment.yaml\nkind: Deployment\nmetadata:\n  name: web\n  namespace: prod\nspec:\n  replicas: 3\n"
+	if string(got) != want {
+		t.Errorf("render() = %q, want %q", got, want)
+	}
+
+	var invalid errInvalidValues
+	if _, err := render(archive.Bytes(), []byte("replicas: [\n"), "web", "prod"); !errors.As(err, &invalid) {
+		t.Errorf("render(invalid values) error = %v, want errInvalidValues", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// maxCachedResponses bounds the response cache; it starts over when full.
+const maxCachedResponses = 1024
+
+// responseCache keeps rendered responses of read APIs that only depend on
+// the catalog, keyed by path and normalized query, and drops them all when
+// a new catalog revision is loaded.
+type responseCache struct {
+	mu       sync.Mutex
+	revision uint64
+	entries  map[string]*cachedResponse
+}
+
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: map[string]*cachedResponse{}}
+}
+
+// cacheKey identifies a request regardless of the order of its query
+// parameters.
+func cacheKey(r *http.Request) string {
+	query := r.URL.Query()
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
+
+func (c *responseCache) get(key string, revision uint64) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.revision != revision {
+		c.revision = revision
+		c.entries = map[string]*cachedResponse{}
+		return nil, false
+	}
+
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *responseCache) put(key string, revision uint64, entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.revision != revision {
+		return
+	}
+	if len(c.entries) >= maxCachedResponses {
+		c.entries = map[string]*cachedResponse{}
+	}
+	c.entries[key] = entry
+}
+
+// middleware serves cached responses and caches successful ones.
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := cacheKey(r)
+		revision := currentRepository().Revision
+
+		if entry, ok := c.get(key, revision); ok {
+			responseCacheRequests.WithLabelValues("hit").Inc()
+			for name, values := range entry.header {
+				if _, ok := w.Header()[name]; !ok {
+					w.Header()[name] = values
+				}
+			}
+			w.Write(entry.body)
+			return
+		}
+		responseCacheRequests.WithLabelValues("miss").Inc()
+
+		capture := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(capture, r)
+		if capture.status == http.StatusOK {
+			c.put(key, revision, &cachedResponse{header: w.Header().Clone(), body: capture.body.Bytes()})
+		}
+	})
+}
+
+// captureWriter copies the response it writes.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
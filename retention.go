@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// retentionHistory is how many enforcement runs the retention worker
+// reports.
+const retentionHistory = 20
+
+// retentionWorker enforces the retention policy against Artifact Registry
+// every --retention-interval, deleting what planRetention selects. Runs go
+// through the maintenance gate and re-plan when they actually start, so a
+// run queued for a window never deletes from a stale plan.
+type retentionWorker struct {
+	live        *liveConfig
+	stats       *downloadStats
+	maintenance *maintenanceGate
+	leader      *leaderElection
+
+	mu      sync.Mutex
+	pending bool
+	runs    []*retentionRun
+}
+
+// retentionRun reports what one enforcement run deleted.
+type retentionRun struct {
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	Deleted    []gcCandidate     `json:"deleted"`
+	Failed     []retentionFailed `json:"failed,omitempty"`
+	FreedBytes int64             `json:"freed_bytes"`
+}
+
+type retentionFailed struct {
+	gcCandidate
+	Error string `json:"error"`
+}
+
+func newRetentionWorker(live *liveConfig, stats *downloadStats, maintenance *maintenanceGate, leader *leaderElection) *retentionWorker {
+	return &retentionWorker{live: live, stats: stats, maintenance: maintenance, leader: leader}
+}
+
+// run schedules an enforcement run every interval until ctx is done.
+func (w *retentionWorker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		config, _ := w.live.get()
+		if !retentionPolicyFrom(config).enabled() || !w.leader.isLeader() {
+			continue
+		}
+
+		// One run waiting for a window is enough.
+		w.mu.Lock()
+		pending := w.pending
+		w.pending = true
+		w.mu.Unlock()
+		if pending {
+			continue
+		}
+
+		queued, err := w.maintenance.submit(ctx, "retention", w.enforce)
+		if err != nil {
+			log.Printf("failed to enforce retention. error: %v", err)
+		}
+		if !queued {
+			w.mu.Lock()
+			w.pending = false
+			w.mu.Unlock()
+		}
+	}
+}
+
+// enforce deletes every candidate of the current retention plan. A failed
+// deletion is reported and doesn't stop the others.
+func (w *retentionWorker) enforce(ctx context.Context) error {
+	defer func() {
+		w.mu.Lock()
+		w.pending = false
+		w.mu.Unlock()
+	}()
+
+	config, backend := w.live.get()
+	plan := planRetention(currentRepository(), retentionPolicyFrom(config), w.stats, time.Now())
+	run := &retentionRun{Started: time.Now(), Deleted: []gcCandidate{}}
+
+	for _, candidate := range plan.Candidates {
+		if err := deleteEverywhere(ctx, backend, candidate.asset); err != nil {
+			log.Printf("failed to delete %s@%s under retention. error: %v", candidate.Name, candidate.Digest, err)
+			run.Failed = append(run.Failed, retentionFailed{gcCandidate: candidate, Error: err.Error()})
+			continue
+		}
+
+		log.Printf("retention deleted %s@%s: %s", candidate.Name, candidate.Digest, candidate.Rule)
+		run.Deleted = append(run.Deleted, candidate)
+		run.FreedBytes += candidate.SizeBytes
+	}
+	run.Finished = time.Now()
+
+	w.mu.Lock()
+	w.runs = append(w.runs, run)
+	if len(w.runs) > retentionHistory {
+		w.runs = w.runs[len(w.runs)-retentionHistory:]
+	}
+	w.mu.Unlock()
+	return nil
+}
+
+// deleteEverywhere deletes asset with all its tags from every Artifact
+// Registry repository holding it, then from the catalog.
+func deleteEverywhere(ctx context.Context, backend Backend, asset *Asset) error {
+	targets, err := garSources(backend, asset)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if err := target.gar.deleteVersion(ctx, target.name, asset.SHA, ""); err != nil {
+			return err
+		}
+	}
+	setRepository(currentRepository().without(asset, ""))
+	return nil
+}
+
+// handleRuns reports the latest enforcement runs, newest first.
+func (w *retentionWorker) handleRuns(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	runs := make([]*retentionRun, 0, len(w.runs))
+	for i := len(w.runs) - 1; i >= 0; i-- {
+		runs = append(runs, w.runs[i])
+	}
+	pending := w.pending
+	w.mu.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(struct {
+		Pending bool            `json:"pending"`
+		Runs    []*retentionRun `json:"runs"`
+	}{pending, runs})
+}
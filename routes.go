@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// apiServices are the components main starts that the HTTP API serves. It
+// implements the handler interfaces generated from openapi.json, but for the
+// downloads, which the chartDownloader serves.
+type apiServices struct {
+	live *liveConfig
+	// desiredState is nil unless --desired-state is synced.
+	desiredState *desiredStateSyncer
+	scanner      *manifestScanner
+	maintenance  *maintenanceGate
+	stats        *downloadStats
+	retention    *retentionWorker
+	leader       *leaderElection
+	webhooks     *webhookNotifier
+	clients      *clientStats
+	events       *eventStream
+	startup      *startupGate
+	downloads    *chartDownloader
+	cache        chartCache
+	logins       *loginCache
+	// attestor is nil unless --attestation-kms-key is set.
+	attestor *attestor
+
+	// Set by routes.
+	deleter    *chartDeleter
+	pusher     *chartPusher
+	cacheAdmin *cacheAdmin
+	sessions   *sessionStore
+}
+
+// routes registers every operation of openapi.json on router.
+func (s *apiServices) routes(router chi.Router) {
+	live := s.live
+	s.deleter = &chartDeleter{live: live, maintenance: s.maintenance}
+	s.pusher = &chartPusher{live: live, logins: s.logins, attestor: s.attestor}
+	s.cacheAdmin = &cacheAdmin{cache: s.cache}
+	s.sessions = newSessionStore(live)
+
+	// Retries of mutating requests sent with an Idempotency-Key are
+	// answered with the first response.
+	idempotency := newIdempotencyStore(live)
+	responses := newResponseCache()
+	wrap := func(op openAPIOperation) chi.Middlewares {
+		var middlewares chi.Middlewares
+		if op.admin {
+			middlewares = append(middlewares, requireAdmin(live))
+		}
+		if op.idempotent {
+			middlewares = append(middlewares, idempotency.middleware)
+		}
+		if op.id == "search" || op.id == "index" {
+			middlewares = append(middlewares, responses.middleware)
+		}
+		return middlewares
+	}
+
+	registerHealthAPI(router, s, wrap)
+	registerCatalogAPI(router, s, wrap)
+	registerChartsAPI(router, s, wrap)
+	registerStatsAPI(router, s, wrap)
+	registerAdminAPI(router, s, wrap)
+	s.downloads.routes(router.With(s.sessions.middleware))
+}
+
+func (s *apiServices) handleHealth(w http.ResponseWriter, r *http.Request) {
+	defaultHealthCheck(w, r)
+}
+
+func (s *apiServices) handleBackendHealth(w http.ResponseWriter, r *http.Request) {
+	_, backend := s.live.get()
+	statuses := []backendStatus{{Name: "default", Backend: backend.Name(), Match: "*", Healthy: true, Assets: currentRepository().len()}}
+	if composite, ok := backend.(*compositeBackend); ok {
+		statuses = composite.statuses()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+func (s *apiServices) handleStartup(w http.ResponseWriter, r *http.Request) {
+	s.startup.handleStartup(w, r)
+}
+
+func (s *apiServices) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	promhttp.Handler().ServeHTTP(w, r)
+}
+
+func (s *apiServices) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	handleOpenAPI(w, r)
+}
+
+func (s *apiServices) handleUI(w http.ResponseWriter, r *http.Request) {
+	handleUI(w, r)
+}
+
+func (s *apiServices) handleUISlash(w http.ResponseWriter, r *http.Request) {
+	handleUI(w, r)
+}
+
+func (s *apiServices) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "apiVersion: v2")
+	fmt.Fprintln(w, "entries:")
+	currentRepository().each(func(asset *Asset) {
+		if len(asset.Tags) > 0 {
+			fmt.Fprintf(w, "  %s:\n", asset.Name)
+			fmt.Fprintf(w, "  - created: %s\n", chartCreated(asset).Format(time.RFC3339))
+			fmt.Fprintf(w, "    description: A Helm chart for Kubernetes\n")
+			fmt.Fprintf(w, "    digest: %s\n", strings.Split(asset.SHA, ":")[1])
+			fmt.Fprintf(w, "    name: %s\n", asset.Name)
+			fmt.Fprintf(w, "    type: application\n")
+			fmt.Fprintf(w, "    urls:\n")
+			fmt.Fprintf(w, "    - http://gcp-oci-proxy.gcp-oci-proxy.svc.cluster.local/%s:%s\n", asset.Name, asset.Tags[0])
+			// fmt.Fprintf(w, "      - %s\n", asset.URI)
+			fmt.Fprintf(w, "    version: %s\n", asset.Tags[0])
+		}
+	})
+}
+
+func (s *apiServices) handleSearch(w http.ResponseWriter, r *http.Request) {
+	handleSearch(w, r)
+}
+
+func (s *apiServices) handleEvents(w http.ResponseWriter, r *http.Request) {
+	s.events.handleEvents(w, r)
+}
+
+func (s *apiServices) handleQuickstart(w http.ResponseWriter, r *http.Request) {
+	handleQuickstart(s.live)(w, r)
+}
+
+func (s *apiServices) handleTimezone(w http.ResponseWriter, r *http.Request) {
+	handleTimezone(s.live)(w, r)
+}
+
+func (s *apiServices) handleSyncErrors(w http.ResponseWriter, r *http.Request) {
+	handleSyncErrors(w, r)
+}
+
+func (s *apiServices) handleSyncConflicts(w http.ResponseWriter, r *http.Request) {
+	handleSyncConflicts(w, r)
+}
+
+func (s *apiServices) handleOpenSession(w http.ResponseWriter, r *http.Request) {
+	s.sessions.handleOpen(w, r)
+}
+
+func (s *apiServices) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	s.sessions.handleGet(w, r)
+}
+
+func (s *apiServices) handleCloseSession(w http.ResponseWriter, r *http.Request) {
+	s.sessions.handleClose(w, r)
+}
+
+func (s *apiServices) handleChartMetadata(w http.ResponseWriter, r *http.Request) {
+	s.downloads.handleChartFile(chartYAMLFile)(w, r)
+}
+
+func (s *apiServices) handleChartValues(w http.ResponseWriter, r *http.Request) {
+	s.downloads.handleChartFile(valuesYAMLFile)(w, r)
+}
+
+func (s *apiServices) handleChartReadme(w http.ResponseWriter, r *http.Request) {
+	s.downloads.handleChartFile(readmeFile)(w, r)
+}
+
+func (s *apiServices) handleChartFile(w http.ResponseWriter, r *http.Request) {
+	s.downloads.handleFile(w, r)
+}
+
+func (s *apiServices) handleRenderChart(w http.ResponseWriter, r *http.Request) {
+	s.downloads.handleRender(w, r)
+}
+
+func (s *apiServices) handleSignURL(w http.ResponseWriter, r *http.Request) {
+	s.downloads.handleSignURL(w, r)
+}
+
+func (s *apiServices) handleChartSBOM(w http.ResponseWriter, r *http.Request) {
+	handleSBOM(w, r, s.live)
+}
+
+func (s *apiServices) handleChartAttestations(w http.ResponseWriter, r *http.Request) {
+	handleAttestations(w, r, s.live)
+}
+
+func (s *apiServices) handleChartCompliance(w http.ResponseWriter, r *http.Request) {
+	s.scanner.handleCompliance(w, r)
+}
+
+func (s *apiServices) handleChartProvenance(w http.ResponseWriter, r *http.Request) {
+	handleProvenance(w, r, s.live)
+}
+
+func (s *apiServices) handleChartVulnerabilities(w http.ResponseWriter, r *http.Request) {
+	handleVulnerabilities(w, r, s.live)
+}
+
+func (s *apiServices) handleCompliance(w http.ResponseWriter, r *http.Request) {
+	s.scanner.handleReports(w, r)
+}
+
+func (s *apiServices) handleStats(w http.ResponseWriter, r *http.Request) {
+	s.stats.handleStats(w, r)
+}
+
+func (s *apiServices) handleClients(w http.ResponseWriter, r *http.Request) {
+	s.clients.handleClients(w, r)
+}
+
+func (s *apiServices) handleCosts(w http.ResponseWriter, r *http.Request) {
+	handleCosts(w, r, s.live, s.stats)
+}
+
+func (s *apiServices) handlePushChart(w http.ResponseWriter, r *http.Request) {
+	s.pusher.handlePush(w, r)
+}
+
+func (s *apiServices) handleDeleteChart(w http.ResponseWriter, r *http.Request) {
+	s.deleter.handleDelete(w, r)
+}
+
+func (s *apiServices) handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	handleSyncStatus(w, r)
+}
+
+func (s *apiServices) handleSyncTraces(w http.ResponseWriter, r *http.Request) {
+	handleSyncTraces(w, r)
+}
+
+func (s *apiServices) handleGCPlan(w http.ResponseWriter, r *http.Request) {
+	handleGCPlan(w, r, s.live, s.stats)
+}
+
+func (s *apiServices) handleGCRuns(w http.ResponseWriter, r *http.Request) {
+	s.retention.handleRuns(w, r)
+}
+
+func (s *apiServices) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	s.cacheAdmin.handleList(w, r)
+}
+
+func (s *apiServices) handlePurgeCache(w http.ResponseWriter, r *http.Request) {
+	s.cacheAdmin.handlePurge(w, r)
+}
+
+func (s *apiServices) handleEvictCache(w http.ResponseWriter, r *http.Request) {
+	s.cacheAdmin.handleRemove(w, r)
+}
+
+func (s *apiServices) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.maintenance.handleStatus(w, r)
+}
+
+func (s *apiServices) handleLeader(w http.ResponseWriter, r *http.Request) {
+	s.leader.handleStatus(w, r)
+}
+
+// handleDesiredState serves the drift report, when the desired state is
+// synced.
+func (s *apiServices) handleDesiredState(w http.ResponseWriter, r *http.Request) {
+	if s.desiredState == nil {
+		http.Error(w, "desired state sync is off, set --desired-state to turn it on", http.StatusNotFound)
+		return
+	}
+	s.desiredState.handleReport(w, r)
+}
+
+func (s *apiServices) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	s.webhooks.handleDeadLetters(w, r)
+}
+
+func (s *apiServices) handleRedeliver(w http.ResponseWriter, r *http.Request) {
+	s.webhooks.handleRedeliver(w, r)
+}
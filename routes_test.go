@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// testRouter returns a router with every route of the HTTP API, backed by
+// components that aren't started.
+func testRouter(live *liveConfig) *chi.Mux {
+	router := defaultRouter(nil)
+	api := &apiServices{
+		live:         live,
+		desiredState: &desiredStateSyncer{},
+		scanner:      &manifestScanner{},
+		maintenance:  &maintenanceGate{},
+		stats:        newDownloadStats(),
+		retention:    &retentionWorker{},
+		webhooks:     &webhookNotifier{},
+		clients:      newClientStats(),
+		events:       newEventStream(),
+		startup:      newStartupGate(),
+		downloads:    &chartDownloader{},
+		cache:        noCache{},
+	}
+	api.routes(router)
+	return router
+}
+
+func TestAdminReads(t *testing.T) {
+	live := &liveConfig{config: &Config{AdminToken: "secret"}, backend: &listedBackend{}}
+	router := testRouter(live)
+
+	for _, target := range []string{"/admin/cache", "/admin/gc/plan", "/admin/gc/runs", "/admin/sync/status", "/debug/sync", "/api/v1/webhooks/dead-letters"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without the admin token = %d, want 401", target, w.Code)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+			t.Errorf("GET %s with the admin token = %d", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// searchQuery filters and orders the catalog for /api/search.
+type searchQuery struct {
+	// text is matched case-insensitively anywhere in the chart name.
+	text      string
+	mediaType string
+	tag       string
+	version   *semver.Constraints
+	sort      string
+	offset    int
+	limit     int
+}
+
+// searchSorts orders assets by the ?sort values they are named after. A
+// leading - reverses them.
+var searchSorts = map[string]func(a, b *Asset) bool{
+	"name":     func(a, b *Asset) bool { return a.Name < b.Name },
+	"updated":  func(a, b *Asset) bool { return a.Updated.Before(b.Updated) },
+	"uploaded": func(a, b *Asset) bool { return a.Uploaded.Before(b.Uploaded) },
+	"built":    func(a, b *Asset) bool { return a.Built.Before(b.Built) },
+	"size":     func(a, b *Asset) bool { return a.Size < b.Size },
+}
+
+// parseSearchQuery reads the query parameters of a search request.
+func parseSearchQuery(r *http.Request) (*searchQuery, error) {
+	params := r.URL.Query()
+	query := &searchQuery{
+		text:      strings.ToLower(params.Get("q")),
+		mediaType: params.Get("mediaType"),
+		tag:       params.Get("tag"),
+		sort:      params.Get("sort"),
+		limit:     defaultSearchLimit,
+	}
+
+	if version := params.Get("version"); version != "" {
+		constraints, err := semver.NewConstraint(version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %w", version, err)
+		}
+		query.version = constraints
+	}
+
+	if query.sort == "" {
+		query.sort = "name"
+	}
+	if _, ok := searchSorts[strings.TrimPrefix(query.sort, "-")]; !ok {
+		return nil, fmt.Errorf("invalid sort %q, expected name, updated, uploaded, built or size, optionally prefixed with -", query.sort)
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return nil, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxSearchLimit)
+		}
+		query.limit = limit
+	}
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset %q", value)
+		}
+		query.offset = offset
+	}
+	return query, nil
+}
+
+// matches reports whether asset passes every filter of the query.
+func (q *searchQuery) matches(asset *Asset) bool {
+	if q.text != "" && !strings.Contains(strings.ToLower(asset.Name), q.text) {
+		return false
+	}
+	if q.mediaType != "" && asset.MediaType != q.mediaType {
+		return false
+	}
+	if q.tag == "" && q.version == nil {
+		return true
+	}
+
+	for _, tag := range asset.Tags {
+		if q.tag != "" && tag != q.tag {
+			continue
+		}
+		if q.version != nil {
+			version, err := semver.NewVersion(tag)
+			if err != nil || !q.version.Check(version) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// search returns the page of assets of repository the query asks for,
+// along with how many matched in total.
+func (r *Repository) search(q *searchQuery) ([]*Asset, int) {
+	var matched []*Asset
+	r.each(func(asset *Asset) {
+		if q.matches(asset) {
+			matched = append(matched, asset)
+		}
+	})
+
+	less := searchSorts[strings.TrimPrefix(q.sort, "-")]
+	descending := strings.HasPrefix(q.sort, "-")
+	sort.SliceStable(matched, func(i, j int) bool {
+		if descending {
+			return less(matched[j], matched[i])
+		}
+		return less(matched[i], matched[j])
+	})
+
+	total := len(matched)
+	if q.offset >= total {
+		return []*Asset{}, total
+	}
+	end := q.offset + q.limit
+	if end > total {
+		end = total
+	}
+	return matched[q.offset:end], total
+}
+
+// handleSearch serves a page of the catalog filtered by ?q (a substring of
+// the chart name), ?mediaType, ?tag (an exact tag) and ?version (a semver
+// constraint), ordered by ?sort and paginated with ?offset and ?limit.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	query, err := parseSearchQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, total := currentRepository().search(query)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Total   int      `json:"total"`
+		Offset  int      `json:"offset"`
+		Limit   int      `json:"limit"`
+		Results []*Asset `json:"results"`
+	}{total, query.offset, query.limit, results})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	now := time.Now()
+	repository := &Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", MediaType: "application/vnd.oci.image.manifest.v1+json", Tags: []string{"1.2.3"}, Updated: now, Uploaded: now.Add(-2 * time.Hour), Size: 30},
+		{Name: "nginx", SHA: "sha256:2", MediaType: "application/vnd.oci.image.manifest.v1+json", Tags: []string{"2.0.0", "latest"}, Updated: now.Add(time.Hour), Size: 10},
+		{Name: "ingress-nginx", SHA: "sha256:3", MediaType: "application/vnd.docker.distribution.manifest.v2+json", Tags: []string{"4.0.0"}, Updated: now.Add(-time.Hour), Uploaded: now.Add(-time.Hour), Size: 20},
+		{Name: "redis", SHA: "sha256:4", Tags: []string{"7.0.0"}},
+	}}
+
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"q=NGINX", []string{"sha256:3", "sha256:1", "sha256:2"}, 3},
+		{"q=nginx&mediaType=application/vnd.oci.image.manifest.v1%2Bjson", []string{"sha256:1", "sha256:2"}, 2},
+		{"tag=latest", []string{"sha256:2"}, 1},
+		{"version=>=2.0.0", []string{"sha256:3", "sha256:2", "sha256:4"}, 3},
+		{"q=nginx&sort=-updated", []string{"sha256:2", "sha256:1", "sha256:3"}, 3},
+		{"q=nginx&sort=size&offset=1&limit=1", []string{"sha256:3"}, 3},
+		{"q=nginx&sort=-uploaded", []string{"sha256:3", "sha256:1", "sha256:2"}, 3},
+		{"offset=10", []string{}, 4},
+	}
+	for _, tt := range tests {
+		query, err := parseSearchQuery(httptest.NewRequest(http.MethodGet, "/api/search?"+tt.query, nil))
+		if err != nil {
+			t.Fatalf("parseSearchQuery(%s) error = %v", tt.query, err)
+		}
+
+		results, total := repository.search(query)
+		got := []string{}
+		for _, asset := range results {
+			got = append(got, asset.SHA)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") || total != tt.total {
+			t.Errorf("search(%s) = %v of %d, want %v of %d", tt.query, got, total, tt.want, tt.total)
+		}
+	}
+
+	for _, query := range []string{"sort=tags", "limit=0", "limit=1000", "offset=-1", "version=not-a-range"} {
+		if _, err := parseSearchQuery(httptest.NewRequest(http.MethodGet, "/api/search?"+query, nil)); err == nil {
+			t.Errorf("parseSearchQuery(%s) succeeded, want an error", query)
+		}
+	}
+}
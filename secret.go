@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"sync"
+	"time"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// secretCredential keeps the latest payload of a Secret Manager secret
+// version in memory and polls it so key rotations are picked up without a
+// restart.
+type secretCredential struct {
+	name    string
+	service *secretmanager.Service
+
+	mu    sync.RWMutex
+	value string
+}
+
+var (
+	secretCredentials   = map[string]*secretCredential{}
+	secretCredentialsMu sync.Mutex
+)
+
+// watchSecret returns the credential stored in the secret version name,
+// fetching it on first use and refreshing it every refresh interval after
+// that. Watches are shared, so reloading the config doesn't start another.
+func watchSecret(ctx context.Context, name string, refresh time.Duration) (*secretCredential, error) {
+	secretCredentialsMu.Lock()
+	defer secretCredentialsMu.Unlock()
+
+	if secret, ok := secretCredentials[name]; ok {
+		return secret, nil
+	}
+
+	service, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &secretCredential{name: name, service: service}
+	if err := secret.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if refresh > 0 {
+		go func() {
+			ticker := time.NewTicker(refresh)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := secret.refresh(ctx); err != nil {
+					log.Printf("failed to refresh credential secret %s. error: %v", name, err)
+				}
+			}
+		}()
+	}
+
+	secretCredentials[name] = secret
+	return secret, nil
+}
+
+func (s *secretCredential) refresh(ctx context.Context) error {
+	resp, err := s.service.Projects.Secrets.Versions.Access(s.name).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value != "" && s.value != string(data) {
+		log.Printf("credential secret %s rotated to %s", s.name, resp.Name)
+	}
+	s.value = string(data)
+	return nil
+}
+
+func (s *secretCredential) get() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// identifyingHeaders are stripped from responses by --security-headers.
+var identifyingHeaders = []string{"Server", "X-Powered-By"}
+
+// securityHeaders hardens responses with --security-headers set: HSTS for
+// --hsts-max-age, no content sniffing, framing or referrers, and no server
+// identification. Directory-style paths, ending in a slash, get 404 rather
+// than resolving to a chart, except for the UI.
+func securityHeaders(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			config, _ := live.get()
+			if !config.SecurityHeaders {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			if config.HSTSMaxAge > 0 {
+				header.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int64(config.HSTSMaxAge.Seconds())))
+			}
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Content-Security-Policy", "frame-ancestors 'none'")
+			header.Set("Referrer-Policy", "no-referrer")
+
+			if strings.HasSuffix(r.URL.Path, "/") && r.URL.Path != "/ui/" {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(&anonymousWriter{ResponseWriter: w}, r)
+		})
+	}
+}
+
+// anonymousWriter strips the identifyingHeaders handlers set before the
+// response goes out.
+type anonymousWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *anonymousWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		for _, name := range identifyingHeaders {
+			w.Header().Del(name)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *anonymousWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps server-sent events streaming.
+func (w *anonymousWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *anonymousWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
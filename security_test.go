@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	live := &liveConfig{config: &Config{SecurityHeaders: true, HSTSMaxAge: 24 * time.Hour}}
+	handler := securityHeaders(live)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Powered-By", "go")
+		io.WriteString(w, "ok")
+	}))
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/nginx", http.StatusOK},
+		{"/ui/", http.StatusOK},
+		{"/", http.StatusNotFound},
+		{"/team/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+		for name, want := range map[string]string{
+			"Strict-Transport-Security": "max-age=86400; includeSubDomains",
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "no-referrer",
+			"Server":                    "",
+			"X-Powered-By":              "",
+		} {
+			if got := w.Header().Get(name); got != want {
+				t.Errorf("GET %s %s = %q, want %q", tt.path, name, got, want)
+			}
+		}
+	}
+
+	live.config = &Config{}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/team/", nil))
+	if w.Code != http.StatusOK || w.Header().Get("X-Frame-Options") != "" || w.Header().Get("Server") != "nginx" {
+		t.Errorf("GET /team/ without --security-headers = %d %v, want it untouched", w.Code, w.Header())
+	}
+}
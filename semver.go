@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-chi/chi"
+)
+
+// resolveVersion returns the asset of the chart called name tagged with the
+// highest semantic version satisfying constraint, along with that tag. An
+// empty constraint matches any stable version. Tags that aren't semantic
+// versions are ignored.
+func (r *Repository) resolveVersion(name, constraint string) (*Asset, string, error) {
+	if constraint == "" {
+		constraint = "*"
+	}
+
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+	}
+
+	var best *Asset
+	var bestTag string
+	var bestVersion *semver.Version
+	r.eachNamed(name, func(asset *Asset) {
+		for _, tag := range asset.Tags {
+			version, err := semver.NewVersion(tag)
+			if err != nil || !constraints.Check(version) {
+				continue
+			}
+
+			if bestVersion == nil || version.GreaterThan(bestVersion) {
+				best, bestTag, bestVersion = asset, tag, version
+			}
+		}
+	})
+	return best, bestTag, nil
+}
+
+// latestVersion returns the asset of the highest stable version of the
+// chart called name, falling back to pre-releases for charts that have
+// none, along with its tag.
+func (r *Repository) latestVersion(name string) (*Asset, string) {
+	for _, constraint := range []string{"*", ">=0.0.0-0"} {
+		if asset, tag, _ := r.resolveVersion(name, constraint); asset != nil {
+			return asset, tag
+		}
+	}
+	return nil, ""
+}
+
+// handleDownloadByRange serves the chart version best matching the
+// `version` query parameter, pointing Content-Location at the exact tag it
+// resolved to.
+func (d *chartDownloader) handleDownloadByRange(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "assetName")
+	asset, tag, err := repositoryFor(r).resolveVersion(name, r.URL.Query().Get("version"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if asset == nil {
+		if repository := repositoryFor(r); !repository.hasChart(name) {
+			config, _ := d.live.get()
+			chartNotFound(w, config, repository, name, "")
+			return
+		}
+		http.Error(w, fmt.Sprintf("no version of %s matches", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Location", fmt.Sprintf("/%s:%s", name, tag))
+	d.serve(w, r, asset, false)
+}
+
+// handleDownloadLatest serves the newest version of a chart.
+func (d *chartDownloader) handleDownloadLatest(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "assetName")
+	asset, tag := repositoryFor(r).latestVersion(name)
+	if asset == nil {
+		if repository := repositoryFor(r); !repository.hasChart(name) {
+			config, _ := d.live.get()
+			chartNotFound(w, config, repository, name, "")
+			return
+		}
+		http.Error(w, fmt.Sprintf("no version of %s found", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Location", fmt.Sprintf("/%s:%s", name, tag))
+	d.serve(w, r, asset, false)
+}
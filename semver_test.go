@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestResolveVersion(t *testing.T) {
+	repository := &Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.2.0", "stable"}},
+		{Name: "nginx", SHA: "sha256:3", Tags: []string{"2.0.0-rc.1"}},
+		{Name: "nginx", SHA: "sha256:4", Tags: []string{"1.10.1"}},
+		{Name: "redis", SHA: "sha256:5", Tags: []string{"9.0.0"}},
+	}}
+
+	tests := []struct {
+		constraint string
+		sha        string
+		tag        string
+		err        bool
+	}{
+		{constraint: "", sha: "sha256:4", tag: "1.10.1"},
+		{constraint: "~1.2", sha: "sha256:2", tag: "1.2.0"},
+		{constraint: "<1.2.0", sha: "sha256:1", tag: "1.0.0"},
+		{constraint: ">=2.0.0-0", sha: "sha256:3", tag: "2.0.0-rc.1"},
+		{constraint: "^3"},
+		{constraint: "not a version", err: true},
+	}
+
+	for _, test := range tests {
+		asset, tag, err := repository.resolveVersion("nginx", test.constraint)
+		if (err != nil) != test.err {
+			t.Errorf("resolveVersion(%q) error = %v, want error %v", test.constraint, err, test.err)
+			continue
+		}
+
+		sha := ""
+		if asset != nil {
+			sha = asset.SHA
+		}
+		if sha != test.sha || tag != test.tag {
+			t.Errorf("resolveVersion(%q) = %s %s, want %s %s", test.constraint, sha, tag, test.sha, test.tag)
+		}
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.0"}, Size: 1},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.10.0"}, Size: 2},
+		{Name: "nginx", SHA: "sha256:3", Tags: []string{"2.0.0-rc.1"}, Size: 3},
+		{Name: "team/redis", SHA: "sha256:4", Tags: []string{"7.0.0-beta.1"}, Size: 4},
+		{Name: "team/redis", SHA: "sha256:5", Tags: []string{"7.0.0-beta.2"}, Size: 5},
+		{Name: "tools", SHA: "sha256:6", Tags: []string{"main"}, Size: 6},
+	}})
+	defer setRepository(&Repository{})
+
+	live := &liveConfig{config: &Config{}}
+	d := &chartDownloader{
+		live:    live,
+		cache:   newMemoryCache(1 << 20),
+		stats:   newDownloadStats(),
+		clients: newClientStats(),
+		policy:  newDownloadPolicy(live),
+		iam:     newIAMChecker(live),
+	}
+	router := chi.NewRouter()
+	d.routes(router)
+
+	tests := []struct {
+		target   string
+		code     int
+		location string
+		digest   string
+	}{
+		// Stable versions win over newer pre-releases.
+		{"/nginx/latest", http.StatusOK, "/nginx:1.10.0", "sha256:2"},
+		// Charts with only pre-releases fall back to the newest of them.
+		{"/team/redis/latest", http.StatusOK, "/team/redis:7.0.0-beta.2", "sha256:5"},
+		// Tags that aren't versions are never latest.
+		{"/tools/latest", http.StatusNotFound, "", ""},
+		{"/postgres/latest", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodHead, tt.target, nil))
+		if w.Code != tt.code {
+			t.Errorf("HEAD %s = %d, want %d", tt.target, w.Code, tt.code)
+			continue
+		}
+		if got := w.Header().Get("Content-Location"); got != tt.location {
+			t.Errorf("HEAD %s Content-Location = %q, want %q", tt.target, got, tt.location)
+		}
+		if got := w.Header().Get("Docker-Content-Digest"); got != tt.digest {
+			t.Errorf("HEAD %s Docker-Content-Digest = %q, want %q", tt.target, got, tt.digest)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// sessionHeader carries a resolution session token; the session query
+// parameter does the same for clients that can't set headers.
+const sessionHeader = "X-Resolution-Session"
+
+type sessionContextKey struct{}
+
+// resolutionSession pins the catalog as it was when the session opened, so
+// every tag lookup made with its token resolves to the same digests for the
+// whole CI run even if tags move meanwhile.
+type resolutionSession struct {
+	Token    string    `json:"token"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+	Revision uint64    `json:"revision"`
+
+	repository *Repository
+}
+
+// sessionStore holds the open resolution sessions.
+type sessionStore struct {
+	live *liveConfig
+
+	mu       sync.Mutex
+	sessions map[string]*resolutionSession
+}
+
+func newSessionStore(live *liveConfig) *sessionStore {
+	return &sessionStore{live: live, sessions: map[string]*resolutionSession{}}
+}
+
+// open starts a session over the current catalog lasting ttl.
+func (s *sessionStore) open(ttl time.Duration) (*resolutionSession, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	repository := currentRepository()
+	session := &resolutionSession{
+		Token:      hex.EncodeToString(token),
+		Created:    now,
+		Expires:    now.Add(ttl),
+		Revision:   repository.Revision,
+		repository: repository,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, existing := range s.sessions {
+		if now.After(existing.Expires) {
+			delete(s.sessions, token)
+		}
+	}
+	s.sessions[session.Token] = session
+	return session, nil
+}
+
+// get returns the open session with token, or nil.
+func (s *sessionStore) get(token string) *resolutionSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		delete(s.sessions, token)
+		return nil
+	}
+	return session
+}
+
+func (s *sessionStore) close(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.sessions[token]
+	delete(s.sessions, token)
+	return ok
+}
+
+// middleware resolves requests carrying a session token against the
+// session's catalog. An unknown or expired token is an error rather than a
+// silent fallback to the moving tags.
+func (s *sessionStore) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(sessionHeader)
+		if token == "" {
+			token = r.URL.Query().Get("session")
+		}
+		if token == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session := s.get(token)
+		if session == nil {
+			http.Error(w, "unknown or expired resolution session", http.StatusGone)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session)))
+	})
+}
+
+// repositoryFor returns the catalog tag lookups of r resolve against: the
+// one pinned by its resolution session, or the current one.
+func repositoryFor(r *http.Request) *Repository {
+	if session, ok := r.Context().Value(sessionContextKey{}).(*resolutionSession); ok {
+		return session.repository
+	}
+	return currentRepository()
+}
+
+// handleOpen opens a session lasting ?ttl=<duration>, capped at and
+// defaulting to the configured session TTL.
+func (s *sessionStore) handleOpen(w http.ResponseWriter, r *http.Request) {
+	config, _ := s.live.get()
+	ttl := config.SessionTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		requested, err := time.ParseDuration(value)
+		if err != nil || requested <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl %q", value), http.StatusBadRequest)
+			return
+		}
+		ttl = min(requested, config.SessionTTL)
+	}
+
+	session, err := s.open(ttl)
+	if err != nil {
+		http.Error(w, "failed to open session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+func (s *sessionStore) handleGet(w http.ResponseWriter, r *http.Request) {
+	session := s.get(chi.URLParam(r, "token"))
+	if session == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session)
+}
+
+func (s *sessionStore) handleClose(w http.ResponseWriter, r *http.Request) {
+	if !s.close(chi.URLParam(r, "token")) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
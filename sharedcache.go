@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// sharedCacheTimeout bounds a read or write of the shared chart cache.
+const sharedCacheTimeout = 30 * time.Second
+
+// parseGCSBucket splits a gs://bucket or gs://bucket/prefix location.
+func parseGCSBucket(location string) (bucket, prefix string, err error) {
+	rest, ok := strings.CutPrefix(location, "gs://")
+	bucket, prefix, _ = strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return "", "", fmt.Errorf("invalid bucket %q, expected gs://<bucket> or gs://<bucket>/<prefix>", location)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// gcsCache is a chartCache shared by replicas through a Cloud Storage
+// bucket, behind a local one. Charts are stored under their digest, so
+// objects never change once written.
+type gcsCache struct {
+	local   chartCache
+	service *storage.Service
+	bucket  string
+	prefix  string
+
+	mu           sync.Mutex
+	hits, misses int64
+
+	// uploads tracks the charts being written to the bucket.
+	uploads sync.WaitGroup
+}
+
+func newGCSCache(ctx context.Context, config *Config, local chartCache) (*gcsCache, error) {
+	bucket, prefix, err := parseGCSBucket(config.CacheBucket)
+	if err != nil {
+		return nil, err
+	}
+	service, err := newStorageService(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsCache{local: local, service: service, bucket: bucket, prefix: prefix}, nil
+}
+
+func (c *gcsCache) object(digest string) string {
+	return path.Join(c.prefix, strings.Replace(digest, ":", "/", 1))
+}
+
+func (c *gcsCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// get looks the chart up locally, then in the bucket. Charts found in the
+// bucket are kept locally too. Errors reading the bucket count as misses,
+// the chart then being pulled from the registry.
+func (c *gcsCache) get(digest string) (*cachedChart, bool) {
+	if chart, ok := c.local.get(digest); ok {
+		return chart, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+	defer cancel()
+	chart, err := c.read(ctx, digest)
+	if err != nil {
+		log.Printf("failed to read %s from the shared cache. error: %v", digest, err)
+	}
+	c.count(chart != nil)
+	if chart == nil {
+		return nil, false
+	}
+	c.local.put(digest, chart)
+	return chart, true
+}
+
+// read returns the chart stored under digest, or nil when there is none.
+func (c *gcsCache) read(ctx context.Context, digest string) (*cachedChart, error) {
+	object, err := c.service.Objects.Get(c.bucket, c.object(digest)).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.service.Objects.Get(c.bucket, object.Name).Generation(object.Generation).Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedChart{Name: object.Metadata["chart"], Version: object.Metadata["version"], Data: data}, nil
+}
+
+// put keeps the chart locally and writes it to the bucket in the
+// background, so downloads don't wait on the upload.
+func (c *gcsCache) put(digest string, chart *cachedChart) {
+	c.local.put(digest, chart)
+
+	c.uploads.Add(1)
+	go func() {
+		defer c.uploads.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), sharedCacheTimeout)
+		defer cancel()
+		if err := c.write(ctx, digest, chart); err != nil {
+			log.Printf("failed to write %s to the shared cache. error: %v", digest, err)
+		}
+	}()
+}
+
+// write stores chart under digest, unless another replica already did.
+func (c *gcsCache) write(ctx context.Context, digest string, chart *cachedChart) error {
+	object := &storage.Object{
+		Name:        c.object(digest),
+		ContentType: chartContentType(chart.Data),
+		Metadata:    map[string]string{"chart": chart.Name, "version": chart.Version},
+	}
+	_, err := c.service.Objects.Insert(c.bucket, object).IfGenerationMatch(0).Media(bytes.NewReader(chart.Data)).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return nil
+	}
+	return err
+}
+
+// digest returns the digest of the chart stored in object, or "" when
+// object isn't one.
+func (c *gcsCache) digest(object string) string {
+	if c.prefix != "" {
+		var ok bool
+		if object, ok = strings.CutPrefix(object, c.prefix+"/"); !ok {
+			return ""
+		}
+	}
+	digest := strings.Replace(object, "/", ":", 1)
+	if validateDigest(digest) != nil {
+		return ""
+	}
+	return digest
+}
+
+// each calls fn with the digest of every chart stored in the bucket.
+func (c *gcsCache) each(ctx context.Context, fn func(digest string, object *storage.Object) error) error {
+	call := c.service.Objects.List(c.bucket)
+	if c.prefix != "" {
+		call = call.Prefix(c.prefix + "/")
+	}
+	return call.Pages(ctx, func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			if digest := c.digest(object.Name); digest != "" {
+				if err := fn(digest, object); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (c *gcsCache) list(ctx context.Context) ([]cacheEntry, error) {
+	entries, err := c.local.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = c.each(ctx, func(digest string, object *storage.Object) error {
+		added, _ := time.Parse(time.RFC3339, object.TimeCreated)
+		entries = append(entries, cacheEntry{
+			Digest:  digest,
+			Chart:   object.Metadata["chart"],
+			Version: object.Metadata["version"],
+			Tier:    "bucket",
+			Bytes:   int64(object.Size),
+			Added:   added,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the shared cache: %w", err)
+	}
+	return entries, nil
+}
+
+func (c *gcsCache) remove(ctx context.Context, digest string) (bool, error) {
+	removed, err := c.local.remove(ctx, digest)
+	if err != nil {
+		return false, err
+	}
+	err = c.service.Objects.Delete(c.bucket, c.object(digest)).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return removed, nil
+	}
+	if err != nil {
+		return removed, fmt.Errorf("failed to delete %s from the shared cache: %w", digest, err)
+	}
+	return true, nil
+}
+
+func (c *gcsCache) purge(ctx context.Context) (int, error) {
+	removed, err := c.local.purge(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = c.each(ctx, func(digest string, object *storage.Object) error {
+		err := c.service.Objects.Delete(c.bucket, object.Name).Context(ctx).Do()
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil
+		}
+		if err == nil {
+			removed++
+		}
+		return err
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to purge the shared cache: %w", err)
+	}
+	return removed, nil
+}
+
+func (c *gcsCache) stats() cacheStats {
+	stats := c.local.stats()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats.SharedHits, stats.SharedMisses = c.hits, c.misses
+	return stats
+}
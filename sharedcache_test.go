@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestGCSCache(t *testing.T) {
+	type object struct {
+		metadata map[string]string
+		data     []byte
+	}
+	var mu sync.Mutex
+	objects := map[string]*object{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodPost {
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			parts := multipart.NewReader(r.Body, params["boundary"])
+			var meta struct {
+				Name     string            `json:"name"`
+				Metadata map[string]string `json:"metadata"`
+			}
+			part, _ := parts.NextPart()
+			json.NewDecoder(part).Decode(&meta)
+			part, _ = parts.NextPart()
+			data, _ := io.ReadAll(part)
+			objects[meta.Name] = &object{metadata: meta.Metadata, data: data}
+			json.NewEncoder(w).Encode(map[string]string{"name": meta.Name})
+			return
+		}
+
+		name, _ := strings.CutPrefix(r.URL.Path, "/storage/v1/b/charts/o/")
+		found, ok := objects[name]
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "not found"}})
+		case r.URL.Query().Get("alt") == "media":
+			w.Write(found.data)
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "generation": "1", "metadata": found.metadata})
+		}
+	}))
+	defer server.Close()
+
+	service, err := storage.NewService(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chart := &cachedChart{Name: "nginx", Version: "1.2.3", Data: []byte{0x1f, 0x8b, 0, 0, 0}}
+	writer := &gcsCache{local: newMemoryCache(1 << 20), service: service, bucket: "charts", prefix: "cache"}
+	writer.put("sha256:1", chart)
+	writer.uploads.Wait()
+	if _, ok := objects["cache/sha256/1"]; !ok {
+		t.Fatalf("put(sha256:1) stored %v, want cache/sha256/1", objects)
+	}
+
+	// Another replica finds the chart in the bucket.
+	reader := &gcsCache{local: newMemoryCache(1 << 20), service: service, bucket: "charts", prefix: "cache"}
+	got, ok := reader.get("sha256:1")
+	if !ok || got.Name != chart.Name || got.Version != chart.Version || !bytes.Equal(got.Data, chart.Data) {
+		t.Errorf("get(sha256:1) = %+v, %v, want %+v", got, ok, chart)
+	}
+	if _, ok := reader.get("sha256:2"); ok {
+		t.Errorf("get(sha256:2) found a chart never stored")
+	}
+	if stats := reader.stats(); stats.SharedHits != 1 || stats.SharedMisses != 1 {
+		t.Errorf("stats() = %+v, want 1 shared hit and 1 shared miss", stats)
+	}
+}
+
+func TestParseGCSBucket(t *testing.T) {
+	tests := []struct {
+		location string
+		bucket   string
+		prefix   string
+		err      bool
+	}{
+		{"gs://charts", "charts", "", false},
+		{"gs://charts/cache/", "charts", "cache", false},
+		{"charts", "", "", true},
+		{"gs://", "", "", true},
+	}
+	for _, tt := range tests {
+		bucket, prefix, err := parseGCSBucket(tt.location)
+		if bucket != tt.bucket || prefix != tt.prefix || (err != nil) != tt.err {
+			t.Errorf("parseGCSBucket(%q) = %q, %q, %v", tt.location, bucket, prefix, err)
+		}
+	}
+}
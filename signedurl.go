@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// signedURL answers POST /api/charts/{name}/{version}/signed-url.
+type signedURL struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+type signedDownloadKey struct{}
+
+// urlSignature signs a download path valid until expires with key.
+func urlSignature(key, path string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedURL lets through requests bearing a valid signature for
+// their path, marking them as signed downloads, which skip IAP, the IAM
+// check and the download policy. Requests with an invalid or expired
+// signature are refused, those without one go through untouched. The
+// signature only covers the path, so signed requests carrying any other
+// parameter, which could select another artifact, are refused too.
+func verifySignedURL(live *liveConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			if !query.Has("signature") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			config, _ := live.get()
+			expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+			switch {
+			case config.SignedURLKey == "":
+				http.Error(w, "signed urls are disabled", http.StatusForbidden)
+			case len(query) != 2 || len(query["expires"]) != 1 || len(query["signature"]) != 1:
+				http.Error(w, "signed urls only take expires and signature parameters", http.StatusForbidden)
+			case err != nil:
+				http.Error(w, "invalid signed url expiry", http.StatusForbidden)
+			case !hmac.Equal([]byte(query.Get("signature")), []byte(urlSignature(config.SignedURLKey, r.URL.Path, expires))):
+				http.Error(w, "invalid signed url", http.StatusForbidden)
+			case time.Now().Unix() > expires:
+				http.Error(w, "signed url expired", http.StatusForbidden)
+			default:
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedDownloadKey{}, true)))
+			}
+		})
+	}
+}
+
+// signedDownload reports whether r came in through a valid signed URL.
+func signedDownload(r *http.Request) bool {
+	signed, _ := r.Context().Value(signedDownloadKey{}).(bool)
+	return signed
+}
+
+// handleSignURL returns a URL downloading a chart version by digest without
+// credentials until it expires, after --signed-url-ttl or the shorter ?ttl.
+// The caller has to be allowed to download the chart.
+func (d *chartDownloader) handleSignURL(w http.ResponseWriter, r *http.Request) {
+	config, backend := d.live.get()
+	if config.SignedURLKey == "" {
+		http.Error(w, "signed urls are disabled, set --signed-url-key to enable them", http.StatusForbidden)
+		return
+	}
+
+	ttl := config.SignedURLTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		requested, err := time.ParseDuration(value)
+		if err != nil || requested <= 0 {
+			http.Error(w, fmt.Sprintf("invalid ttl %q", value), http.StatusBadRequest)
+			return
+		}
+		ttl = min(requested, config.SignedURLTTL)
+	}
+
+	name, version := chi.URLParam(r, "name"), chi.URLParam(r, "version")
+	asset := findChartVersion(name, version)
+	if asset == nil {
+		chartNotFound(w, config, currentRepository(), name, version)
+		return
+	}
+	if !d.authorize(w, r, backend, asset) {
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	path := "/" + asset.Name + "@" + asset.SHA
+	query := url.Values{
+		"expires":   {strconv.FormatInt(expires.Unix(), 10)},
+		"signature": {urlSignature(config.SignedURLKey, path, expires.Unix())},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signedURL{URL: externalURL(config, r) + path + "?" + query.Encode(), Expires: expires})
+}
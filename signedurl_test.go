@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestSignedURL(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("1", 64)
+	setRepository(&Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: digest, Tags: []string{"1.2.3"}},
+		{Name: "redis", SHA: "sha256:" + strings.Repeat("2", 64), Tags: []string{"7.0.0"}},
+	}})
+	defer setRepository(&Repository{})
+
+	live := &liveConfig{config: &Config{IAPAudience: "audience", SignedURLKey: strings.Repeat("k", 32), SignedURLTTL: time.Hour}}
+	d := &chartDownloader{
+		live:    live,
+		cache:   newMemoryCache(1 << 20),
+		stats:   newDownloadStats(),
+		clients: newClientStats(),
+		policy:  newDownloadPolicy(live),
+		iam:     newIAMChecker(live),
+	}
+	d.cache.put(digest, &cachedChart{Name: "nginx", Version: "1.2.3", Data: []byte{0x1f, 0x8b, 0, 0, 0}})
+	signer := chi.NewRouter()
+	signer.Post("/api/charts/{name}/{version}/signed-url", d.handleSignURL)
+	router := chi.NewRouter()
+	router.Use(verifySignedURL(live), verifyIAP(live))
+	d.routes(router)
+
+	w := httptest.NewRecorder()
+	signer.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/charts/nginx/1.2.3/signed-url?ttl=10m", nil))
+	var signed signedURL
+	if err := json.NewDecoder(w.Body).Decode(&signed); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("POST signed-url = %d, %v", w.Code, err)
+	}
+	if until := time.Until(signed.Expires); until > 10*time.Minute || until < 9*time.Minute {
+		t.Errorf("signed url expires in %s, want 10m", until)
+	}
+	u, _ := url.Parse(signed.URL)
+	query := u.Query()
+	expired := url.Values{"expires": {"1"}, "signature": {urlSignature(live.config.SignedURLKey, u.Path, 1)}}
+
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{u.RequestURI(), http.StatusOK},
+		{u.Path, http.StatusUnauthorized},
+		{strings.Replace(u.RequestURI(), "signature=", "signature=x", 1), http.StatusForbidden},
+		{"/redis@sha256:" + strings.Repeat("2", 64) + "?" + query.Encode(), http.StatusForbidden},
+		{u.Path + "?" + expired.Encode(), http.StatusForbidden},
+		{u.RequestURI() + "&profile=minimal", http.StatusForbidden},
+		{u.RequestURI() + "&format=tar", http.StatusForbidden},
+		{u.RequestURI() + "&expires=" + query.Get("expires"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d %s, want %d", tt.target, w.Code, w.Body, tt.code)
+		}
+	}
+}
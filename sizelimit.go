@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Values of --oversized-artifacts.
+const (
+	rejectOversized   = "reject"
+	redirectOversized = "redirect"
+)
+
+// oversized reports whether asset exceeds --max-artifact-bytes. Assets of
+// unknown size are let through.
+func oversized(config *Config, asset *Asset) bool {
+	return config.MaxArtifactBytes > 0 && asset.Size > config.MaxArtifactBytes
+}
+
+// refuseOversized answers a request for an oversized asset with 413,
+// telling the client where to pull it from instead.
+func refuseOversized(w http.ResponseWriter, config *Config, asset *Asset) {
+	log.Printf("refused %s of %d bytes, over the limit of %d", asset.RawName, asset.Size, config.MaxArtifactBytes)
+	oversizedArtifacts.WithLabelValues("rejected").Inc()
+	http.Error(w, fmt.Sprintf("%s is %d bytes, over this proxy's limit of %d bytes. Pull it from %s directly, or ask the operator to raise --max-artifact-bytes.",
+		asset.Name, asset.Size, config.MaxArtifactBytes, asset.URI), http.StatusRequestEntityTooLarge)
+}
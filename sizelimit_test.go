@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOversized(t *testing.T) {
+	config := &Config{MaxArtifactBytes: 100}
+	tests := []struct {
+		size int64
+		want bool
+	}{
+		{0, false},
+		{100, false},
+		{101, true},
+	}
+	for _, tt := range tests {
+		if got := oversized(config, &Asset{Size: tt.size}); got != tt.want {
+			t.Errorf("oversized(%d bytes) = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+	if oversized(&Config{}, &Asset{Size: 1 << 40}) {
+		t.Error("oversized() without a limit = true")
+	}
+
+	w := httptest.NewRecorder()
+	refuseOversized(w, config, &Asset{Name: "big", Size: 101, URI: "example.com/big@sha256:1"})
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "example.com/big@sha256:1") {
+		t.Errorf("refuseOversized() = %d %q, want 413 naming the registry URI", w.Code, w.Body)
+	}
+}
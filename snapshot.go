@@ -0,0 +1,241 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// snapshotHeader opens a catalog snapshot: a gzipped stream of JSON values,
+// the header followed by one asset per line, so snapshots of large
+// catalogs are written and read without holding their JSON in memory.
+type snapshotHeader struct {
+	Backend string      `json:"backend"`
+	Synced  time.Time   `json:"synced"`
+	Errors  []syncError `json:"errors,omitempty"`
+
+	Conflicts []tagConflict `json:"conflicts,omitempty"`
+}
+
+// parseGCSObject splits a gs://bucket/object location.
+func parseGCSObject(location string) (bucket, object string, err error) {
+	rest, _ := strings.CutPrefix(location, "gs://")
+	bucket, object, _ = strings.Cut(rest, "/")
+	if bucket == "" || object == "" {
+		return "", "", fmt.Errorf("invalid object %q, expected gs://<bucket>/<object>", location)
+	}
+	return bucket, object, nil
+}
+
+func writeSnapshot(w io.Writer, backend string, repository *Repository) error {
+	compressed := gzip.NewWriter(w)
+	encoder := json.NewEncoder(compressed)
+	if err := encoder.Encode(snapshotHeader{Backend: backend, Synced: time.Now(), Errors: repository.Errors, Conflicts: repository.Conflicts}); err != nil {
+		return err
+	}
+
+	var err error
+	repository.each(func(asset *Asset) {
+		if err == nil {
+			err = encoder.Encode(asset)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return compressed.Close()
+}
+
+func readSnapshot(r io.Reader) (*snapshotHeader, []*Asset, error) {
+	decompressed, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	decoder := json.NewDecoder(decompressed)
+
+	header := &snapshotHeader{}
+	if err := decoder.Decode(header); err != nil {
+		return nil, nil, err
+	}
+	var assets []*Asset
+	for {
+		asset := &Asset{}
+		if err := decoder.Decode(asset); err == io.EOF {
+			return header, assets, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		assets = append(assets, asset)
+	}
+}
+
+// saveSnapshot persists repository to --catalog-snapshot, a file or a
+// gs://bucket/object. The snapshot only speeds up the next start, so
+// failures are logged rather than failing the sync.
+func saveSnapshot(ctx context.Context, config *Config, backend string, repository *Repository) {
+	if config.CatalogSnapshot == "" {
+		return
+	}
+
+	var err error
+	if strings.HasPrefix(config.CatalogSnapshot, "gs://") {
+		err = saveGCSSnapshot(ctx, config, backend, repository)
+	} else {
+		err = saveFileSnapshot(config.CatalogSnapshot, backend, repository)
+	}
+	if err != nil {
+		log.Printf("failed to save catalog snapshot to %s. error: %v", config.CatalogSnapshot, err)
+	}
+}
+
+// saveFileSnapshot writes the snapshot next to path and renames it over
+// path, so a crash never leaves a truncated snapshot behind.
+func saveFileSnapshot(path, backend string, repository *Repository) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".catalog-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if err := writeSnapshot(file, backend, repository); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func saveGCSSnapshot(ctx context.Context, config *Config, backend string, repository *Repository) error {
+	bucket, object, err := parseGCSObject(config.CatalogSnapshot)
+	if err != nil {
+		return err
+	}
+	service, err := newStorageService(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeSnapshot(writer, backend, repository))
+	}()
+	_, err = service.Objects.Insert(bucket, &storage.Object{Name: object, ContentType: "application/gzip"}).Media(reader).Context(ctx).Do()
+	reader.CloseWithError(err)
+	return err
+}
+
+func newStorageService(ctx context.Context, config *Config) (*storage.Service, error) {
+	opts, err := googleClientOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	service, err := storage.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud storage client. error: %w", err)
+	}
+	return service, nil
+}
+
+// snapshotModified returns when --catalog-snapshot was last written, or
+// the zero time when there is none.
+func snapshotModified(ctx context.Context, config *Config) (time.Time, error) {
+	if !strings.HasPrefix(config.CatalogSnapshot, "gs://") {
+		info, err := os.Stat(config.CatalogSnapshot)
+		if os.IsNotExist(err) {
+			return time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		return info.ModTime(), nil
+	}
+
+	bucket, object, err := parseGCSObject(config.CatalogSnapshot)
+	if err != nil {
+		return time.Time{}, err
+	}
+	service, err := newStorageService(ctx, config)
+	if err != nil {
+		return time.Time{}, err
+	}
+	attrs, err := service.Objects.Get(bucket, object).Fields("updated").Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, attrs.Updated)
+}
+
+// loadSnapshot loads the catalog from --catalog-snapshot. It returns nil
+// when there is no snapshot, or when it was taken of another backend.
+func loadSnapshot(ctx context.Context, config *Config, backend string) (*Repository, error) {
+	if config.CatalogSnapshot == "" {
+		return nil, nil
+	}
+
+	var body io.ReadCloser
+	if strings.HasPrefix(config.CatalogSnapshot, "gs://") {
+		bucket, object, err := parseGCSObject(config.CatalogSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		service, err := newStorageService(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := service.Objects.Get(bucket, object).Context(ctx).Download()
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body = resp.Body
+	} else {
+		file, err := os.Open(config.CatalogSnapshot)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body = file
+	}
+	defer body.Close()
+
+	header, assets, err := readSnapshot(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid catalog snapshot: %w", err)
+	}
+	if header.Backend != backend {
+		log.Printf("ignoring catalog snapshot of %s, the backend is %s", header.Backend, backend)
+		return nil, nil
+	}
+
+	log.Printf("loaded catalog snapshot of %d assets taken at %s", len(assets), header.Synced.Format(time.RFC3339))
+	repository, err := newCatalog(config, assets, header.Errors, nil)
+	if err != nil {
+		return nil, err
+	}
+	repository.Conflicts = withConflicts(header.Conflicts, repository.Conflicts)
+	repository.synced = header.Synced
+	return repository, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	config := &Config{CatalogSnapshot: t.TempDir() + "/catalog.json.gz"}
+	if repository, err := loadSnapshot(context.Background(), config, "example"); repository != nil || err != nil {
+		t.Fatalf("loadSnapshot() without a snapshot = %v, %v", repository, err)
+	}
+
+	saveSnapshot(context.Background(), config, "example", &Repository{
+		Assets: []*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"latest"}, Size: 42}},
+		Errors: []syncError{{Entry: "bad", Error: "invalid"}},
+	})
+
+	repository, err := loadSnapshot(context.Background(), config, "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asset := repository.findByTag("nginx", "latest"); asset == nil || asset.Size != 42 {
+		t.Errorf("findByTag(nginx, latest) = %v, want the saved asset", asset)
+	}
+	if len(repository.Errors) != 1 {
+		t.Errorf("loaded %d sync errors, want 1", len(repository.Errors))
+	}
+
+	if repository, err := loadSnapshot(context.Background(), config, "other"); repository != nil || err != nil {
+		t.Errorf("loadSnapshot() of another backend = %v, %v, want nil", repository, err)
+	}
+}
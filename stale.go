@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// staleWarning marks an archive served under --serve-stale-after, as in
+// RFC 7234.
+const staleWarning = `110 - "Response is Stale"`
+
+// staleCharts remembers the digest each tag was last downloaded with, so a
+// download of a tag that has since moved can fall back on the archive it
+// pointed to before while the new one is pulled.
+type staleCharts struct {
+	mu      sync.Mutex
+	digests map[string]string
+}
+
+func newStaleCharts() *staleCharts {
+	return &staleCharts{digests: map[string]string{}}
+}
+
+// served records that asset was downloaded under its tags.
+func (s *staleCharts) served(asset *Asset) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range asset.Tags {
+		s.digests[asset.Name+":"+tag] = asset.SHA
+	}
+}
+
+// previous returns the digest and cached archive one of the tags of asset
+// was last downloaded with, if the tag pointed elsewhere then and the
+// archive is still in cache.
+func (s *staleCharts) previous(cache chartCache, asset *Asset) (string, *cachedChart) {
+	if s == nil {
+		return "", nil
+	}
+	s.mu.Lock()
+	var digests []string
+	for _, tag := range asset.Tags {
+		if digest, ok := s.digests[asset.Name+":"+tag]; ok && digest != asset.SHA {
+			digests = append(digests, digest)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, digest := range digests {
+		if chart, ok := cache.get(digest); ok {
+			return digest, chart
+		}
+	}
+	return "", nil
+}
+
+// pull pulls the archive of asset for a download. With --serve-stale-after
+// set, a download by tag whose pull fails or takes longer than that gets
+// the cached archive the tag was last downloaded with instead, whose
+// digest is returned as stale. The pull goes on in the background and
+// refreshes the cache for the next download.
+func (d *chartDownloader) pull(ctx context.Context, config *Config, backend Backend, asset *Asset, byDigest bool) (chart *cachedChart, stale string, err error) {
+	var fallback *cachedChart
+	if config.ServeStaleAfter > 0 && !byDigest {
+		stale, fallback = d.stale.previous(d.cache, asset)
+	}
+	if fallback == nil {
+		chart, err = pullAsset(ctx, d.client, d.logins, d.cache, d.stats, backend, asset)
+		if err == nil {
+			d.stale.served(asset)
+		}
+		return chart, "", err
+	}
+
+	pullCtx, cancel := context.WithTimeout(ctx, config.ServeStaleAfter)
+	defer cancel()
+	chart, err = pullAsset(pullCtx, d.client, d.logins, d.cache, d.stats, backend, asset)
+	if err == nil {
+		d.stale.served(asset)
+		return chart, "", nil
+	}
+	if ctx.Err() != nil {
+		return nil, "", err
+	}
+
+	reason := "error"
+	if errors.Is(err, context.DeadlineExceeded) {
+		reason = "slow"
+	}
+	staleDownloads.WithLabelValues(reason).Inc()
+	log.Printf("serving %s with the stale digest %s. error: %v", asset.RawName, stale, err)
+	return fallback, stale, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+type failingBackend struct {
+	*listedBackend
+}
+
+func (b failingBackend) Credential(ctx context.Context) (string, string, error) {
+	return "", "", errors.New("registry unavailable")
+}
+
+func TestServeStale(t *testing.T) {
+	moved := &Asset{Name: "nginx", SHA: "sha256:2", RawName: "nginx@sha256:2", Tags: []string{"1.2.3"}}
+	setRepository(&Repository{Assets: []*Asset{moved}})
+	defer setRepository(&Repository{})
+
+	archive := []byte{0x1f, 0x8b, 0, 0, 0}
+	live := &liveConfig{config: &Config{ServeStaleAfter: time.Second}, backend: failingBackend{&listedBackend{}}}
+	d := &chartDownloader{
+		live:    live,
+		cache:   newMemoryCache(1 << 20),
+		stats:   newDownloadStats(),
+		clients: newClientStats(),
+		policy:  newDownloadPolicy(live),
+		iam:     newIAMChecker(live),
+		stale:   newStaleCharts(),
+	}
+	d.cache.put("sha256:1", &cachedChart{Name: "nginx", Version: "1.2.3", Data: archive})
+	d.stale.served(&Asset{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.3"}})
+	router := chi.NewRouter()
+	d.routes(router)
+
+	tests := []struct {
+		target  string
+		code    int
+		warning string
+	}{
+		{"/nginx:1.2.3", http.StatusOK, staleWarning},
+		{"/nginx@sha256:2", http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.target, w.Code, tt.code)
+		}
+		if got := w.Header().Get("Warning"); got != tt.warning {
+			t.Errorf("GET %s Warning = %q, want %q", tt.target, got, tt.warning)
+		}
+		if tt.warning != "" && w.Header().Get("ETag") != assetETag(&Asset{SHA: "sha256:1"}) {
+			t.Errorf("GET %s ETag = %q, want the stale digest", tt.target, w.Header().Get("ETag"))
+		}
+	}
+
+	live.config = &Config{}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nginx:1.2.3", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("GET /nginx:1.2.3 without --serve-stale-after = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// startupGate is the handler of the server while the proxy starts. The
+// port opens before the backend is set up and the first sync runs, so
+// platforms like Cloud Run see the container listening, but everything
+// besides the startup probe is answered with 503 until open hands requests
+// over to the router.
+type startupGate struct {
+	started time.Time
+
+	mu     sync.RWMutex
+	router http.Handler
+}
+
+// startupStatus is what /health/startup reports.
+type startupStatus struct {
+	Ready   bool           `json:"ready"`
+	Seconds float64        `json:"seconds"`
+	Assets  int            `json:"assets"`
+	Syncs   []syncProgress `json:"syncs"`
+}
+
+func newStartupGate() *startupGate {
+	return &startupGate{started: time.Now()}
+}
+
+// open serves every request with router from now on.
+func (g *startupGate) open(router http.Handler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.router = router
+}
+
+func (g *startupGate) ready() http.Handler {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.router
+}
+
+func (g *startupGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if router := g.ready(); router != nil {
+		router.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == "/health/startup" {
+		g.handleStartup(w, r)
+		return
+	}
+
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "starting", http.StatusServiceUnavailable)
+}
+
+// handleStartup answers 200 once the proxy started and 503 before, with
+// the progress of the syncs running.
+func (g *startupGate) handleStartup(w http.ResponseWriter, r *http.Request) {
+	status := startupStatus{
+		Ready:   g.ready() != nil,
+		Seconds: time.Since(g.started).Seconds(),
+		Assets:  currentRepository().len(),
+		Syncs:   runningSyncProgress(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
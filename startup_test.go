@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartupGate(t *testing.T) {
+	gate := newStartupGate()
+	tests := []struct {
+		path string
+		open bool
+		code int
+	}{
+		{"/health/startup", false, http.StatusServiceUnavailable},
+		{"/index.yaml", false, http.StatusServiceUnavailable},
+		{"/index.yaml", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if tt.open {
+			gate.open(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		}
+		w := httptest.NewRecorder()
+		gate.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	gate.handleStartup(w, httptest.NewRequest(http.MethodGet, "/health/startup", nil))
+	var status startupStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	if w.Code != http.StatusOK || !status.Ready {
+		t.Errorf("GET /health/startup after open = %d, %+v, want ready", w.Code, status)
+	}
+}
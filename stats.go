@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// assetDownloads is how often and how recently an asset was downloaded.
+type assetDownloads struct {
+	Count int64     `json:"count"`
+	Bytes int64     `json:"bytes"`
+	Last  time.Time `json:"last"`
+}
+
+// downloadStats counts downloads per digest, and the bytes pulled from
+// upstream to serve them, since the proxy started.
+type downloadStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	byDigest  map[string]*assetDownloads
+	upstream  int64
+	served    int64
+	downloads int64
+}
+
+func newDownloadStats() *downloadStats {
+	return &downloadStats{started: time.Now(), byDigest: map[string]*assetDownloads{}}
+}
+
+// recordPull counts a chart of the given size pulled from the registry.
+func (s *downloadStats) recordPull(bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upstream += bytes
+}
+
+// totals returns when counting started, the bytes pulled from upstream, the
+// bytes served to clients and the number of downloads.
+func (s *downloadStats) totals() (started time.Time, upstream, served, downloads int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started, s.upstream, s.served, s.downloads
+}
+
+// record counts a download of digest that sent bytes to the client.
+func (s *downloadStats) record(digest string, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.byDigest[digest]
+	if !ok {
+		entry = &assetDownloads{}
+		s.byDigest[digest] = entry
+	}
+	entry.Count++
+	entry.Bytes += bytes
+	entry.Last = time.Now()
+	s.served += bytes
+	s.downloads++
+}
+
+// get returns a copy of the stats of digest.
+func (s *downloadStats) get(digest string) assetDownloads {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.byDigest[digest]; ok {
+		return *entry
+	}
+	return assetDownloads{}
+}
+
+// chartStats is the download count of a chart and of each of its versions
+// in the catalog.
+type chartStats struct {
+	Name      string         `json:"name"`
+	Downloads int64          `json:"downloads"`
+	Bytes     int64          `json:"bytes"`
+	Last      *time.Time     `json:"last,omitempty"`
+	Versions  []versionStats `json:"versions"`
+}
+
+type versionStats struct {
+	Version   string     `json:"version,omitempty"`
+	Tags      []string   `json:"tags"`
+	Digest    string     `json:"digest"`
+	Downloads int64      `json:"downloads"`
+	Bytes     int64      `json:"bytes"`
+	Last      *time.Time `json:"last,omitempty"`
+}
+
+type statsReport struct {
+	Since     time.Time    `json:"since"`
+	Downloads int64        `json:"downloads"`
+	Bytes     int64        `json:"bytes"`
+	Charts    []chartStats `json:"charts"`
+}
+
+// chartStats groups the download counts of the catalog by chart and
+// version, most downloaded first. An empty name reports every chart.
+func (s *downloadStats) chartStats(repository *Repository, name string) []chartStats {
+	byName := map[string]*chartStats{}
+	repository.each(func(asset *Asset) {
+		if name != "" && asset.Name != name {
+			return
+		}
+
+		chart, ok := byName[asset.Name]
+		if !ok {
+			chart = &chartStats{Name: asset.Name, Versions: []versionStats{}}
+			byName[asset.Name] = chart
+		}
+
+		downloads := s.get(asset.SHA)
+		version := versionStats{
+			Tags:      []string{},
+			Digest:    asset.SHA,
+			Downloads: downloads.Count,
+			Bytes:     downloads.Bytes,
+		}
+		if v := highestVersion(asset); v != nil {
+			version.Version = v.Original()
+		} else if len(asset.Tags) > 0 {
+			version.Version = asset.Tags[0]
+		}
+		for _, tag := range asset.Tags {
+			version.Tags = append(version.Tags, tag)
+		}
+		if last := downloads.Last; !last.IsZero() {
+			version.Last = &last
+			if chart.Last == nil || last.After(*chart.Last) {
+				chart.Last = &last
+			}
+		}
+
+		chart.Versions = append(chart.Versions, version)
+		chart.Downloads += version.Downloads
+		chart.Bytes += version.Bytes
+	})
+
+	charts := []chartStats{}
+	for _, chart := range byName {
+		sort.SliceStable(chart.Versions, func(i, j int) bool {
+			a, b := chart.Versions[i], chart.Versions[j]
+			if a.Downloads != b.Downloads {
+				return a.Downloads > b.Downloads
+			}
+			return a.Version > b.Version
+		})
+		charts = append(charts, *chart)
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		if charts[i].Downloads != charts[j].Downloads {
+			return charts[i].Downloads > charts[j].Downloads
+		}
+		return charts[i].Name < charts[j].Name
+	})
+	return charts
+}
+
+// handleStats reports downloads per chart and version since start, for
+// every chart or, with ?chart=<name>, for one.
+func (s *downloadStats) handleStats(w http.ResponseWriter, r *http.Request) {
+	started, _, served, downloads := s.totals()
+	report := statsReport{
+		Since:     started,
+		Downloads: downloads,
+		Bytes:     served,
+		Charts:    s.chartStats(currentRepository(), r.URL.Query().Get("chart")),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
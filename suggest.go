@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// notFoundBody is the answer to a chart lookup that found nothing.
+type notFoundBody struct {
+	Error       string   `json:"error"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// chartNotFound answers a lookup of the chart called name at reference, a
+// tag or digest if any, with 404 and a JSON body suggesting up to
+// --not-found-suggestions near matches, so a typo doesn't need a support
+// ticket.
+func chartNotFound(w http.ResponseWriter, config *Config, repository *Repository, name, reference string) {
+	body := notFoundBody{Error: fmt.Sprintf("chart %s not found", name)}
+	if reference != "" {
+		body.Error = fmt.Sprintf("chart %s:%s not found", name, reference)
+	}
+	if config != nil && config.NotFoundSuggestions > 0 {
+		body.Suggestions = repository.suggestions(name, reference, config.NotFoundSuggestions)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(body)
+}
+
+// suggestions returns up to limit catalog entries close to name and
+// reference, nearest first: other versions of the chart when it exists,
+// otherwise charts with a similar name. Digests are never suggested.
+func (r *Repository) suggestions(name, reference string, limit int) []string {
+	var names, tags []string
+	seen := map[string]bool{}
+	r.each(func(asset *Asset) {
+		if asset.Name == name {
+			tags = append(tags, asset.Tags...)
+		} else if !seen[asset.Name] {
+			seen[asset.Name] = true
+			names = append(names, asset.Name)
+		}
+	})
+
+	if tags != nil {
+		if reference == "" || validateDigest(reference) == nil {
+			return nil
+		}
+		var suggested []string
+		for _, tag := range nearest(reference, tags, limit) {
+			suggested = append(suggested, name+":"+tag)
+		}
+		return suggested
+	}
+	return nearest(name, names, limit)
+}
+
+// nearest returns up to limit candidates within a few edits of target,
+// nearest first.
+func nearest(target string, candidates []string, limit int) []string {
+	// Allow about one typo per three characters, and two for short names.
+	threshold := len(target) / 3
+	if threshold < 2 {
+		threshold = 2
+	}
+
+	type match struct {
+		candidate string
+		distance  int
+	}
+	var matches []match
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		if distance := editDistance(strings.ToLower(target), strings.ToLower(candidate)); distance <= threshold {
+			matches = append(matches, match{candidate, distance})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].candidate < matches[j].candidate
+	})
+	var nearest []string
+	for i := 0; i < len(matches) && i < limit; i++ {
+		nearest = append(nearest, matches[i].candidate)
+	}
+	return nearest
+}
+
+// editDistance is the Levenshtein distance between a and b, in bytes.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSuggestions(t *testing.T) {
+	r := &Repository{Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.2.3", "latest"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.3.0"}},
+		{Name: "nginx-ingress", SHA: "sha256:3", Tags: []string{"4.0.0"}},
+		{Name: "redis", SHA: "sha256:4", Tags: []string{"7.0.0"}},
+	}}
+
+	tests := []struct {
+		name, reference string
+		want            []string
+	}{
+		{"ngnix", "", []string{"nginx"}},
+		{"nginx-ingres", "4.0.0", []string{"nginx-ingress"}},
+		{"nginx", "1.2.4", []string{"nginx:1.2.3", "nginx:1.3.0"}},
+		{"nginx", "sha256:9", nil},
+		{"postgres", "", nil},
+	}
+	for _, tt := range tests {
+		got := r.suggestions(tt.name, tt.reference, 5)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("suggestions(%q, %q) = %v, want %v", tt.name, tt.reference, got, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	chartNotFound(w, &Config{NotFoundSuggestions: 1}, r, "nginx", "1.2.4")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"suggestions":["nginx:1.2.3"]`) {
+		t.Errorf("chartNotFound() = %d %s, want 404 suggesting nginx:1.2.3", w.Code, w.Body)
+	}
+}
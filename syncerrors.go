@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// syncError is a catalog entry skipped during a sync because it couldn't be
+// parsed or looked up.
+type syncError struct {
+	Route string    `json:"route,omitempty"`
+	Entry string    `json:"entry"`
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+// skippedEntries is returned by Backend.List along with the assets it did
+// list when some entries had to be skipped. Callers keep those assets.
+type skippedEntries []syncError
+
+func (s skippedEntries) Error() string {
+	return fmt.Sprintf("skipped %d catalog entries", len(s))
+}
+
+// skip records that entry was left out of the catalog because of err.
+func (s *skippedEntries) skip(entry string, err error) {
+	log.Printf("failed to sync %s, skipping. error: %v", entry, err)
+	*s = append(*s, syncError{Entry: entry, Error: err.Error(), Time: time.Now()})
+}
+
+// err returns the skipped entries as an error, or nil when there are none.
+func (s skippedEntries) err() error {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+type syncErrors struct {
+	Revision uint64      `json:"revision"`
+	Errors   []syncError `json:"errors"`
+}
+
+// handleSyncErrors lists the entries skipped by the last catalog sync.
+func handleSyncErrors(w http.ResponseWriter, r *http.Request) {
+	repository := currentRepository()
+	response := syncErrors{Revision: repository.Revision, Errors: []syncError{}}
+	response.Errors = append(response.Errors, repository.Errors...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
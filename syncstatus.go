@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// syncRun is a finished catalog sync as /admin/sync/status reports it.
+type syncRun struct {
+	Backend  string    `json:"backend"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Seconds  float64   `json:"seconds"`
+	Assets   int       `json:"assets"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// syncStatus tells how fresh the catalog is: the last sync, the last one
+// that succeeded and how long ago it finished, and the syncs running now.
+type syncStatus struct {
+	Revision    uint64         `json:"revision"`
+	Assets      int            `json:"assets"`
+	Errors      int            `json:"errors"`
+	Conflicts   int            `json:"conflicts"`
+	LastSync    *syncRun       `json:"last_sync"`
+	LastSuccess *syncRun       `json:"last_success"`
+	AgeSeconds  float64        `json:"age_seconds,omitempty"`
+	Running     []syncProgress `json:"running"`
+}
+
+func (t *syncTrace) run() *syncRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seconds := time.Duration(t.Seconds * float64(time.Second))
+	return &syncRun{
+		Backend:  t.Backend,
+		Started:  t.Started,
+		Finished: t.Started.Add(seconds),
+		Seconds:  t.Seconds,
+		Assets:   t.Assets,
+		Error:    t.Error,
+	}
+}
+
+// currentSyncStatus returns the status of the catalog and its syncs. The
+// last syncs are those /debug/sync remembers, so a catalog loaded from a
+// snapshot has none until it syncs.
+func currentSyncStatus() syncStatus {
+	repository := currentRepository()
+	status := syncStatus{
+		Revision:  repository.Revision,
+		Assets:    repository.len(),
+		Errors:    len(repository.Errors),
+		Conflicts: len(repository.Conflicts),
+		Running:   runningSyncProgress(),
+	}
+
+	syncTracesMu.Lock()
+	traces := append([]*syncTrace(nil), syncTraces...)
+	syncTracesMu.Unlock()
+
+	for i := len(traces) - 1; i >= 0; i-- {
+		run := traces[i].run()
+		if status.LastSync == nil {
+			status.LastSync = run
+		}
+		if run.Error == "" {
+			status.LastSuccess = run
+			status.AgeSeconds = time.Since(run.Finished).Seconds()
+			break
+		}
+	}
+	return status
+}
+
+// handleSyncStatus reports the last catalog sync, the last successful one
+// and the progress of the syncs in flight, to tell whether the catalog is
+// stale.
+func handleSyncStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentSyncStatus())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSyncStatus(t *testing.T) {
+	syncTracesMu.Lock()
+	previous := syncTraces
+	syncTraces = nil
+	syncTracesMu.Unlock()
+	defer func() {
+		syncTracesMu.Lock()
+		syncTraces = previous
+		syncTracesMu.Unlock()
+	}()
+
+	backend := &listedBackend{}
+	_, succeeded := startSyncTrace(context.Background(), backend)
+	succeeded.finish(3, nil)
+	_, failed := startSyncTrace(context.Background(), backend)
+	failed.finish(0, errors.New("listing failed"))
+	_, running := startSyncTrace(context.Background(), backend)
+	running.page(10, time.Second)
+	defer running.finish(0, nil)
+
+	got := currentSyncStatus()
+	if got.LastSync == nil || got.LastSync.Error != "listing failed" {
+		t.Errorf("last sync = %+v, want the failed one", got.LastSync)
+	}
+	if got.LastSuccess == nil || got.LastSuccess.Assets != 3 {
+		t.Errorf("last success = %+v, want the one with 3 assets", got.LastSuccess)
+	}
+	if len(got.Running) != 1 || got.Running[0].Items != 10 {
+		t.Errorf("running = %+v, want one sync with 10 items", got.Running)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// syncTraceHistory is how many catalog syncs /debug/sync remembers.
+const syncTraceHistory = 10
+
+// syncTrace records how a catalog sync went: the pages it fetched, how long
+// each phase took and how much memory it allocated, to tell why syncs of
+// very large repositories are slow or run out of memory.
+type syncTrace struct {
+	Backend  string      `json:"backend"`
+	Started  time.Time   `json:"started"`
+	Seconds  float64     `json:"seconds"`
+	Assets   int         `json:"assets"`
+	Error    string      `json:"error,omitempty"`
+	Phases   []syncPhase `json:"phases"`
+	Pages    int         `json:"pages"`
+	Items    int         `json:"items"`
+	MaxItems int         `json:"max_page_items"`
+	Retries  int         `json:"page_retries"`
+	// SlowestPageSeconds is the longest a single page took to fetch.
+	SlowestPageSeconds float64 `json:"slowest_page_seconds"`
+
+	// HeapBefore and HeapAfter are the live heap around the sync, and
+	// Allocated what the sync allocated in total, garbage included.
+	HeapBefore uint64 `json:"heap_bytes_before"`
+	HeapAfter  uint64 `json:"heap_bytes_after"`
+	Allocated  uint64 `json:"allocated_bytes"`
+	GCCycles   uint32 `json:"gc_cycles"`
+
+	mu    sync.Mutex
+	start runtime.MemStats
+}
+
+type syncPhase struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+type syncTraceContextKey struct{}
+
+var (
+	syncTracesMu sync.Mutex
+	syncTraces   []*syncTrace
+	// runningSyncs are the syncs started and not finished yet.
+	runningSyncs = map[*syncTrace]bool{}
+)
+
+// startSyncTrace starts tracing a sync of backend and returns a context
+// carrying the trace to the backend.
+func startSyncTrace(ctx context.Context, backend Backend) (context.Context, *syncTrace) {
+	t := &syncTrace{Backend: backend.Name(), Started: time.Now()}
+	runtime.ReadMemStats(&t.start)
+	t.HeapBefore = t.start.HeapAlloc
+
+	syncTracesMu.Lock()
+	runningSyncs[t] = true
+	syncTracesMu.Unlock()
+	return context.WithValue(ctx, syncTraceContextKey{}, t), t
+}
+
+// syncTraceFrom returns the trace of the sync ctx belongs to, or nil.
+func syncTraceFrom(ctx context.Context) *syncTrace {
+	t, _ := ctx.Value(syncTraceContextKey{}).(*syncTrace)
+	return t
+}
+
+// phase starts timing the phase called name; call the returned function
+// when it ends.
+func (t *syncTrace) phase(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		syncPhaseSeconds.WithLabelValues(name).Observe(elapsed.Seconds())
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.Phases = append(t.Phases, syncPhase{Name: name, Seconds: elapsed.Seconds()})
+	}
+}
+
+// page records a page of items fetched in elapsed.
+func (t *syncTrace) page(items int, elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	syncPages.Inc()
+	syncPageItems.Observe(float64(items))
+	syncPageSeconds.Observe(elapsed.Seconds())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Pages++
+	t.Items += items
+	if items > t.MaxItems {
+		t.MaxItems = items
+	}
+	if elapsed.Seconds() > t.SlowestPageSeconds {
+		t.SlowestPageSeconds = elapsed.Seconds()
+	}
+}
+
+// retry records that a failed page is fetched again.
+func (t *syncTrace) retry() {
+	if t == nil {
+		return
+	}
+	syncPageRetries.Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Retries++
+}
+
+// pages returns how many pages were fetched so far.
+func (t *syncTrace) pages() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Pages
+}
+
+// finish completes the trace and adds it to the history.
+func (t *syncTrace) finish(assets int, err error) {
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+
+	t.mu.Lock()
+	t.Seconds = time.Since(t.Started).Seconds()
+	t.Assets = assets
+	if err != nil {
+		t.Error = err.Error()
+	}
+	t.HeapAfter = end.HeapAlloc
+	t.Allocated = end.TotalAlloc - t.start.TotalAlloc
+	t.GCCycles = end.NumGC - t.start.NumGC
+	t.mu.Unlock()
+	syncAllocatedBytes.Set(float64(t.Allocated))
+
+	syncTracesMu.Lock()
+	defer syncTracesMu.Unlock()
+	delete(runningSyncs, t)
+	syncTraces = append(syncTraces, t)
+	if len(syncTraces) > syncTraceHistory {
+		syncTraces = syncTraces[len(syncTraces)-syncTraceHistory:]
+	}
+}
+
+// syncProgress is how far a running sync got.
+type syncProgress struct {
+	Backend string    `json:"backend"`
+	Started time.Time `json:"started"`
+	Seconds float64   `json:"seconds"`
+	Pages   int       `json:"pages"`
+	Items   int       `json:"items"`
+	Retries int       `json:"page_retries"`
+}
+
+// runningSyncProgress returns the progress of the syncs running now,
+// oldest first.
+func runningSyncProgress() []syncProgress {
+	syncTracesMu.Lock()
+	defer syncTracesMu.Unlock()
+
+	progress := []syncProgress{}
+	for t := range runningSyncs {
+		t.mu.Lock()
+		progress = append(progress, syncProgress{
+			Backend: t.Backend,
+			Started: t.Started,
+			Seconds: time.Since(t.Started).Seconds(),
+			Pages:   t.Pages,
+			Items:   t.Items,
+			Retries: t.Retries,
+		})
+		t.mu.Unlock()
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].Started.Before(progress[j].Started) })
+	return progress
+}
+
+// handleSyncTraces lists the traces of the last catalog syncs, newest
+// first.
+func handleSyncTraces(w http.ResponseWriter, r *http.Request) {
+	syncTracesMu.Lock()
+	traces := make([]*syncTrace, 0, len(syncTraces))
+	for i := len(syncTraces) - 1; i >= 0; i-- {
+		traces = append(traces, syncTraces[i])
+	}
+	syncTracesMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(traces)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// tagConflict is a tag claimed by more than one digest of a chart in the
+// same listing, as happens while concurrent pushes of a version land. The
+// tag is kept on Digest, the newest upload, and taken off Others.
+type tagConflict struct {
+	Name   string    `json:"name"`
+	Tag    string    `json:"tag"`
+	Digest string    `json:"digest"`
+	Others []string  `json:"others"`
+	Time   time.Time `json:"time"`
+}
+
+// newerUpload reports whether a was uploaded after b. Ties are broken by
+// update time, then by digest, so the outcome doesn't depend on the order
+// the registry listed them in.
+func newerUpload(a, b *Asset) bool {
+	if !a.Uploaded.Equal(b.Uploaded) {
+		return a.Uploaded.After(b.Uploaded)
+	}
+	if !a.Updated.Equal(b.Updated) {
+		return a.Updated.After(b.Updated)
+	}
+	return a.SHA > b.SHA
+}
+
+// resolveTagConflicts leaves every tag of assets on a single digest of its
+// chart, the newest upload, and returns the conflicts it resolved.
+func resolveTagConflicts(assets []*Asset) []tagConflict {
+	owners := map[string]*Asset{}
+	claimed := map[string][]*Asset{}
+	for _, asset := range assets {
+		for _, tag := range asset.Tags {
+			key := asset.Name + "\x00" + tag
+			claimed[key] = append(claimed[key], asset)
+			if owner, ok := owners[key]; !ok || newerUpload(asset, owner) {
+				owners[key] = asset
+			}
+		}
+	}
+
+	var conflicts []tagConflict
+	for _, asset := range assets {
+		kept := asset.Tags[:0]
+		for _, tag := range asset.Tags {
+			if owners[asset.Name+"\x00"+tag] == asset {
+				kept = append(kept, tag)
+			}
+		}
+		asset.Tags = kept
+	}
+
+	now := time.Now()
+	for key, claimants := range claimed {
+		if len(claimants) < 2 {
+			continue
+		}
+
+		owner := owners[key]
+		conflict := tagConflict{Name: owner.Name, Digest: owner.SHA, Time: now}
+		for _, claimant := range claimants {
+			if claimant != owner {
+				conflict.Others = append(conflict.Others, claimant.SHA)
+			}
+		}
+		conflict.Tag = key[len(owner.Name)+1:]
+		log.Printf("tag %s:%s is claimed by %d digests, keeping it on the newest upload %s", conflict.Name, conflict.Tag, len(claimants), conflict.Digest)
+		conflicts = append(conflicts, conflict)
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		if conflicts[i].Name != conflicts[j].Name {
+			return conflicts[i].Name < conflicts[j].Name
+		}
+		return conflicts[i].Tag < conflicts[j].Tag
+	})
+	return conflicts
+}
+
+// withConflicts returns previous with the conflicts of a later listing
+// added, replacing those of the same tags.
+func withConflicts(previous, later []tagConflict) []tagConflict {
+	if len(later) == 0 {
+		return previous
+	}
+
+	replaced := map[string]bool{}
+	for _, conflict := range later {
+		replaced[conflict.Name+"\x00"+conflict.Tag] = true
+	}
+
+	var conflicts []tagConflict
+	for _, conflict := range previous {
+		if !replaced[conflict.Name+"\x00"+conflict.Tag] {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return append(conflicts, later...)
+}
+
+type syncConflicts struct {
+	Revision  uint64        `json:"revision"`
+	Conflicts []tagConflict `json:"conflicts"`
+}
+
+// handleSyncConflicts lists the tags the catalog found claimed by several
+// digests, and the digest each was kept on.
+func handleSyncConflicts(w http.ResponseWriter, r *http.Request) {
+	repository := currentRepository()
+	response := syncConflicts{Revision: repository.Revision, Conflicts: []tagConflict{}}
+	response.Conflicts = append(response.Conflicts, repository.Conflicts...)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveTagConflicts(t *testing.T) {
+	older := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Minute)
+
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}} {
+		assets := []*Asset{
+			{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0", "latest"}, Uploaded: older},
+			{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.0.0"}, Uploaded: newer},
+			{Name: "redis", SHA: "sha256:3", Tags: []string{"1.0.0"}, Uploaded: older},
+		}
+		listed := make([]*Asset, len(assets))
+		for i, j := range order {
+			listed[i] = assets[j]
+		}
+
+		conflicts := resolveTagConflicts(listed)
+		if len(conflicts) != 1 || conflicts[0].Tag != "1.0.0" || conflicts[0].Digest != "sha256:2" || len(conflicts[0].Others) != 1 {
+			t.Fatalf("order %v: conflicts = %+v, want 1.0.0 kept on sha256:2", order, conflicts)
+		}
+
+		r := &Repository{Assets: listed}
+		if asset := r.findByTag("nginx", "1.0.0"); asset == nil || asset.SHA != "sha256:2" {
+			t.Errorf("order %v: findByTag(nginx, 1.0.0) = %v, want sha256:2", order, asset)
+		}
+		if asset := r.findByTag("nginx", "latest"); asset == nil || asset.SHA != "sha256:1" {
+			t.Errorf("order %v: findByTag(nginx, latest) = %v, want sha256:1", order, asset)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// throttledWriter caps the rate a response body is written at, for
+// --download-bytes-per-second. A second's worth of bytes may go out at
+// once.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func newThrottledWriter(w http.ResponseWriter, ctx context.Context, bytesPerSecond int64) *throttledWriter {
+	burst := int(bytesPerSecond)
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), burst)}
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), w.limiter.Burst())
+		if err := w.limiter.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
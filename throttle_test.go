@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	started := time.Now()
+	throttled := newThrottledWriter(w, context.Background(), 10000)
+	if n, err := throttled.Write(make([]byte, 15000)); n != 15000 || err != nil {
+		t.Fatalf("Write() = %d, %v, want 15000 bytes", n, err)
+	}
+	// The first second's worth goes out at once, the rest at 10000 B/s.
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("writing 15000 bytes at 10000 B/s took %s, want about 500ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	throttled = newThrottledWriter(httptest.NewRecorder(), ctx, 10000)
+	if _, err := throttled.Write(make([]byte, 100)); err == nil {
+		t.Errorf("Write() after the client went away succeeded")
+	}
+}
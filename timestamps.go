@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// utcLogWriter prefixes every log line with its time in RFC3339 UTC.
+type utcLogWriter struct {
+	w io.Writer
+}
+
+func (l utcLogWriter) Write(p []byte) (int, error) {
+	line := append([]byte(time.Now().UTC().Format(time.RFC3339)+" "), p...)
+	if _, err := l.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// useUTC makes every timestamp the proxy writes, in API responses, index.yaml
+// and logs, RFC3339 in UTC whatever the timezone of the host, so clients
+// never have to guess the offset of a date.
+func useUTC() {
+	time.Local = time.UTC
+	log.SetFlags(0)
+	log.SetOutput(utcLogWriter{w: os.Stderr})
+}
+
+// chartCreated returns when the chart version of asset was created: its
+// upload time, or its build or update time for registries that don't record
+// uploads.
+func chartCreated(asset *Asset) time.Time {
+	for _, t := range []time.Time{asset.Uploaded, asset.Built, asset.Updated} {
+		if !t.IsZero() {
+			return t.UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
+// handleTimezone tells user interfaces the timezone to display timestamps
+// in. The APIs themselves always answer in UTC.
+func handleTimezone(live *liveConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, _ := live.get()
+		location, err := time.LoadLocation(config.DisplayTimezone)
+		if err != nil {
+			location = time.UTC
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Timezone  string `json:"timezone"`
+			UTCOffset string `json:"utc_offset"`
+		}{location.String(), time.Now().In(location).Format("-07:00")})
+	}
+}
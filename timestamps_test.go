@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChartCreated(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	uploaded := time.Date(2024, 5, 1, 12, 0, 0, 0, berlin)
+	built := time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		asset *Asset
+		want  string
+	}{
+		{&Asset{Uploaded: uploaded, Built: built}, "2024-05-01T10:00:00Z"},
+		{&Asset{Built: built, Updated: uploaded}, "2024-04-30T08:00:00Z"},
+		{&Asset{Updated: uploaded}, "2024-05-01T10:00:00Z"},
+	}
+	for _, tt := range tests {
+		if got := chartCreated(tt.asset).Format(time.RFC3339); got != tt.want {
+			t.Errorf("chartCreated(%+v) = %s, want %s", tt.asset, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	layout := "2006-01-02T15:04:05Z "
+	utcLogWriter{w: &buf}.Write([]byte("synced\n"))
+	if _, err := time.Parse(layout, buf.String()[:len(layout)]); err != nil || !strings.HasSuffix(buf.String(), " synced\n") {
+		t.Errorf("log line %q doesn't start with an RFC3339 UTC time", buf.String())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// upstreamClient is the HTTP client of every registry and cloud API call.
+// serve replaces it with one tuned by the --transport-* flags.
+var upstreamClient = http.DefaultClient
+
+// newUpstreamClient returns an HTTP client whose transport is tuned by the
+// config and reports dial, TLS handshake and connection reuse metrics.
+func newUpstreamClient(config *Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.TransportMaxIdleConnsPerHost
+	transport.MaxIdleConns = max(transport.MaxIdleConns, config.TransportMaxIdleConnsPerHost)
+	transport.TLSHandshakeTimeout = config.TransportTLSHandshakeTimeout
+	// Chart layers are already compressed and verified against their
+	// digest: asking for gzip would only let a registry or CDN that
+	// mislabels them get them decoded on the way.
+	transport.DisableCompression = true
+
+	// Pings detect HTTP/2 connections that went dead without being closed,
+	// instead of leaving requests hanging on them.
+	h2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure http/2. error: %w", err)
+	}
+	h2.ReadIdleTimeout = config.TransportHTTP2PingInterval
+	h2.PingTimeout = config.TransportHTTP2PingTimeout
+
+	return &http.Client{Transport: tracedTransport{transport}}, nil
+}
+
+// tracedTransport records connection metrics of the requests it sends.
+type tracedTransport struct {
+	next http.RoundTripper
+}
+
+func (t tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var dialStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(network, addr string) {
+			dialStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			upstreamDials.WithLabelValues(outcome(err)).Inc()
+			upstreamDialSeconds.Observe(time.Since(dialStart).Seconds())
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			upstreamTLSHandshakes.WithLabelValues(outcome(err)).Inc()
+			upstreamTLSHandshakeSeconds.Observe(time.Since(tlsStart).Seconds())
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnections.WithLabelValues(strconv.FormatBool(info.Reused)).Inc()
+		},
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUI(t *testing.T) {
+	router := defaultRouter(nil)
+	router.Get("/ui", handleUI)
+	router.Get("/ui/", handleUI)
+	(&chartDownloader{}).routes(router)
+
+	for _, target := range []string{"/ui", "/ui/"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(w.Body.String(), "api/search") {
+			t.Errorf("GET %s = %d %s, want the chart browser", target, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+}
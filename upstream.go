@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pullThrough resolves charts that aren't in the catalog live against the
+// configured upstream OCI repository. Resolved charts are pulled and kept in
+// the chart cache like any other; tag resolutions are remembered for
+// UpstreamTagTTL.
+type pullThrough struct {
+	live *liveConfig
+
+	mu       sync.Mutex
+	upstream string
+	backend  *ociBackend
+	resolved map[string]upstreamResolution
+}
+
+type upstreamResolution struct {
+	asset *Asset
+	at    time.Time
+}
+
+func newPullThrough(live *liveConfig) *pullThrough {
+	return &pullThrough{live: live, resolved: map[string]upstreamResolution{}}
+}
+
+// parseUpstream splits an oci://host/path upstream into its host and
+// repository path.
+func parseUpstream(upstream string) (host, path string, err error) {
+	rest, ok := strings.CutPrefix(upstream, "oci://")
+	if !ok {
+		return "", "", fmt.Errorf("invalid upstream %q, expected oci://<host>[/<path>]", upstream)
+	}
+
+	host, path, _ = strings.Cut(strings.TrimSuffix(rest, "/"), "/")
+	if host == "" {
+		return "", "", fmt.Errorf("invalid upstream %q, missing host", upstream)
+	}
+	return host, path, nil
+}
+
+// upstreamBackend returns the backend of the configured upstream, or nil
+// when pull-through is disabled. It is rebuilt when the config changes.
+func (p *pullThrough) upstreamBackend(config *Config) (*ociBackend, error) {
+	if config.Upstream == "" {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := strings.Join([]string{config.Upstream, config.UpstreamUsername, config.UpstreamPassword}, "\x00")
+	if p.backend != nil && p.upstream == key {
+		return p.backend, nil
+	}
+
+	host, path, err := parseUpstream(config.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := newOCIBackend(&Config{
+		Backend:     "oci",
+		OCIRegistry: host,
+		Repository:  path,
+		OCIUsername: config.UpstreamUsername,
+		OCIPassword: config.UpstreamPassword,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.upstream, p.backend = key, backend
+	p.resolved = map[string]upstreamResolution{}
+	return backend, nil
+}
+
+// resolve returns the upstream asset of the chart called name at reference,
+// a tag or a digest, or nil when pull-through is disabled or the upstream
+// doesn't have it.
+func (p *pullThrough) resolve(ctx context.Context, name, reference string) (*Asset, error) {
+	config, _ := p.live.get()
+	backend, err := p.upstreamBackend(config)
+	if backend == nil || err != nil {
+		return nil, err
+	}
+
+	key := name + "\x00" + reference
+	p.mu.Lock()
+	resolution, ok := p.resolved[key]
+	p.mu.Unlock()
+	if ok && time.Since(resolution.at) < config.UpstreamTagTTL {
+		return resolution.asset, nil
+	}
+
+	repository := strings.TrimPrefix(backend.config.Repository+"/"+name, "/")
+	header, err := backend.api.head(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repository, url.PathEscape(reference)), ociManifestAccept)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digest := header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return nil, fmt.Errorf("upstream returned no digest for %s:%s", repository, reference)
+	}
+
+	rawName := fmt.Sprintf("%s/%s@%s", backend.Host(), repository, digest)
+	asset := &Asset{
+		Name:      name,
+		SHA:       digest,
+		RawName:   rawName,
+		URI:       rawName,
+		MediaType: header.Get("Content-Type"),
+		origin:    backend,
+	}
+	if !strings.Contains(reference, ":") {
+		asset.Tags = []string{reference}
+	}
+
+	p.mu.Lock()
+	p.resolved[key] = upstreamResolution{asset: asset, at: time.Now()}
+	p.mu.Unlock()
+	return asset, nil
+}
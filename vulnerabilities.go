@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/go-chi/chi"
+	containeranalysis "google.golang.org/api/containeranalysis/v1"
+)
+
+// severityOrder ranks Container Analysis severities, most severe first.
+var severityOrder = map[string]int{
+	"CRITICAL":             0,
+	"HIGH":                 1,
+	"MEDIUM":               2,
+	"LOW":                  3,
+	"MINIMAL":              4,
+	"SEVERITY_UNSPECIFIED": 5,
+}
+
+// vulnerabilityReport is the scan result of a chart digest, as recorded by
+// Container Analysis.
+type vulnerabilityReport struct {
+	Name            string               `json:"name"`
+	Digest          string               `json:"digest"`
+	URI             string               `json:"uri"`
+	Scan            *scanStatus          `json:"scan,omitempty"`
+	Summary         vulnerabilitySummary `json:"summary"`
+	Vulnerabilities []vulnerability      `json:"vulnerabilities"`
+}
+
+// vulnerabilitySummary counts the vulnerabilities by effective severity.
+type vulnerabilitySummary struct {
+	Total      int            `json:"total"`
+	Fixable    int            `json:"fixable"`
+	BySeverity map[string]int `json:"by_severity"`
+}
+
+type vulnerability struct {
+	ID           string            `json:"id"`
+	Severity     string            `json:"severity"`
+	CVSSScore    float64           `json:"cvss_score,omitempty"`
+	FixAvailable bool              `json:"fix_available"`
+	Description  string            `json:"description,omitempty"`
+	Packages     []affectedPackage `json:"packages,omitempty"`
+}
+
+type affectedPackage struct {
+	Name         string `json:"name"`
+	Type         string `json:"type,omitempty"`
+	Version      string `json:"version,omitempty"`
+	FixedVersion string `json:"fixed_version,omitempty"`
+}
+
+// handleVulnerabilities reports the vulnerability occurrences Container
+// Analysis holds for a chart digest. Only Artifact Registry charts are
+// scanned.
+func handleVulnerabilities(w http.ResponseWriter, r *http.Request, live *liveConfig) {
+	asset := currentRepository().findByDigest(chi.URLParam(r, "assetName"), chi.URLParam(r, "assetSHA"))
+	if asset == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	_, backend := live.get()
+	source, uri := sourceFor(backend, asset)
+	gar, ok := source.(*garBackend)
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s is not stored in artifact registry, which has no scan results", asset.RawName), http.StatusNotImplemented)
+		return
+	}
+
+	report, err := collectVulnerabilities(r.Context(), gar, asset, uri)
+	if err != nil {
+		log.Printf("failed to fetch vulnerabilities of %s. error: %v", asset.RawName, err)
+		http.Error(w, "failed to fetch vulnerabilities", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// collectVulnerabilities lists the vulnerability and discovery occurrences
+// of the image at uri, most severe first.
+func collectVulnerabilities(ctx context.Context, gar *garBackend, asset *Asset, uri string) (*vulnerabilityReport, error) {
+	service, err := gar.containerAnalysis(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &vulnerabilityReport{
+		Name:            asset.Name,
+		Digest:          asset.SHA,
+		URI:             asset.URI,
+		Summary:         vulnerabilitySummary{BySeverity: map[string]int{}},
+		Vulnerabilities: []vulnerability{},
+	}
+
+	filter := fmt.Sprintf(`resourceUrl="https://%s" AND (kind="VULNERABILITY" OR kind="DISCOVERY")`, uri)
+	call := service.Projects.Occurrences.List("projects/" + gar.config.Project).Filter(filter)
+	err = call.Pages(ctx, func(page *containeranalysis.ListOccurrencesResponse) error {
+		for _, occurrence := range page.Occurrences {
+			switch {
+			case occurrence.Discovery != nil:
+				report.Scan = newScanStatus(occurrence.Discovery)
+			case occurrence.Vulnerability != nil:
+				report.add(occurrence)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(report.Vulnerabilities, func(i, j int) bool {
+		a, b := report.Vulnerabilities[i], report.Vulnerabilities[j]
+		if a.Severity != b.Severity {
+			return severityRank(a.Severity) < severityRank(b.Severity)
+		}
+		return a.CVSSScore > b.CVSSScore
+	})
+	return report, nil
+}
+
+func (r *vulnerabilityReport) add(occurrence *containeranalysis.Occurrence) {
+	v := occurrence.Vulnerability
+	severity := v.EffectiveSeverity
+	if severity == "" {
+		severity = v.Severity
+	}
+	if severity == "" {
+		severity = "SEVERITY_UNSPECIFIED"
+	}
+
+	entry := vulnerability{
+		// Note names end in the vulnerability ID, e.g.
+		// projects/goog-vulnz/notes/CVE-2023-1234.
+		ID:           path.Base(occurrence.NoteName),
+		Severity:     severity,
+		CVSSScore:    v.CvssScore,
+		FixAvailable: v.FixAvailable,
+		Description:  v.ShortDescription,
+	}
+	for _, issue := range v.PackageIssue {
+		pkg := affectedPackage{Name: issue.AffectedPackage, Type: issue.PackageType}
+		if issue.AffectedVersion != nil {
+			pkg.Version = issue.AffectedVersion.FullName
+		}
+		if issue.FixedVersion != nil {
+			pkg.FixedVersion = issue.FixedVersion.FullName
+		}
+		entry.Packages = append(entry.Packages, pkg)
+	}
+
+	r.Vulnerabilities = append(r.Vulnerabilities, entry)
+	r.Summary.Total++
+	r.Summary.BySeverity[severity]++
+	if entry.FixAvailable {
+		r.Summary.Fixable++
+	}
+}
+
+func severityRank(severity string) int {
+	if rank, ok := severityOrder[severity]; ok {
+		return rank
+	}
+	return len(severityOrder)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	webhookBuffer      = 256
+	webhookTimeout     = 10 * time.Second
+	webhookMaxBackoff  = 5 * time.Minute
+	webhookDeadLetters = 100
+
+	// webhookSignatureHeader carries the HMAC-SHA256 of the payload, in the
+	// format GitHub uses, so existing receivers can verify it.
+	webhookSignatureHeader = "X-Hub-Signature-256"
+)
+
+// chartEvent announces a chart version that appeared in the catalog.
+type chartEvent struct {
+	Event   string    `json:"event"`
+	Chart   string    `json:"chart"`
+	Version string    `json:"version"`
+	Digest  string    `json:"digest"`
+	URI     string    `json:"uri"`
+	Time    time.Time `json:"time"`
+}
+
+// webhookNotifier posts events to every --webhooks URL when a catalog
+// load finds new chart versions. Events are batched and delivered by a
+// fixed number of workers off the sync path, with retries and jittered
+// exponential backoff. When the buffer is full, events are dropped and
+// counted rather than holding up the sync; deliveries that still fail
+// after their retries are kept as dead letters for inspection.
+type webhookNotifier struct {
+	live       *liveConfig
+	leader     *leaderElection
+	client     *http.Client
+	events     chan chartEvent
+	deliveries chan *webhookDelivery
+	dropped    atomic.Int64
+
+	mu          sync.Mutex
+	deadLetters []*deadLetter
+	lastID      int64
+}
+
+// webhookDelivery is a batch of events for one webhook.
+type webhookDelivery struct {
+	url    string
+	events []chartEvent
+}
+
+// deadLetter is a delivery that failed for good.
+type deadLetter struct {
+	ID       int64        `json:"id"`
+	URL      string       `json:"url"`
+	Events   []chartEvent `json:"events"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	Time     time.Time    `json:"time"`
+}
+
+func newWebhookNotifier(live *liveConfig, leader *leaderElection) *webhookNotifier {
+	return &webhookNotifier{
+		live:       live,
+		leader:     leader,
+		client:     &http.Client{Timeout: webhookTimeout},
+		events:     make(chan chartEvent, webhookBuffer),
+		deliveries: make(chan *webhookDelivery, webhookBuffer),
+	}
+}
+
+// run batches queued events and hands them to the delivery workers until
+// ctx is done. A batch is sent once it holds --webhook-batch-size events or
+// --webhook-batch-wait after its first event, whichever comes first.
+func (n *webhookNotifier) run(ctx context.Context) {
+	config, _ := n.live.get()
+	for i := 0; i < config.WebhookConcurrency; i++ {
+		go n.work(ctx)
+	}
+
+	var batch []chartEvent
+	var flush <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.events:
+			batch = append(batch, event)
+			config, _ := n.live.get()
+			if len(batch) < config.WebhookBatchSize {
+				if flush == nil {
+					flush = time.After(config.WebhookBatchWait)
+				}
+				continue
+			}
+		case <-flush:
+		}
+
+		n.dispatch(ctx, batch)
+		batch, flush = nil, nil
+	}
+}
+
+// dispatch queues batch for every webhook.
+func (n *webhookNotifier) dispatch(ctx context.Context, batch []chartEvent) {
+	config, _ := n.live.get()
+	for _, url := range config.Webhooks {
+		select {
+		case <-ctx.Done():
+			return
+		case n.deliveries <- &webhookDelivery{url: url, events: batch}:
+		}
+	}
+}
+
+// work delivers queued batches until ctx is done.
+func (n *webhookNotifier) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-n.deliveries:
+			n.deliver(ctx, delivery)
+		}
+	}
+}
+
+// catalogChanged queues an event for every version in current that wasn't
+// in previous. The first load at startup announces nothing, and only the
+// leader announces anything, so receivers don't hear from every replica.
+func (n *webhookNotifier) catalogChanged(previous, current *Repository) {
+	if previous.Revision == 0 || !n.leader.isLeader() {
+		return
+	}
+	if config, _ := n.live.get(); len(config.Webhooks) == 0 {
+		return
+	}
+
+	for _, event := range newVersions(previous, current, time.Now()) {
+		select {
+		case n.events <- event:
+		default:
+			if dropped := n.dropped.Add(1); dropped == 1 || dropped%100 == 0 {
+				log.Printf("webhook buffer full, dropped %d events", dropped)
+			}
+		}
+	}
+}
+
+// newVersions lists the tags of current missing from previous.
+func newVersions(previous, current *Repository, now time.Time) []chartEvent {
+	known := map[string]bool{}
+	previous.each(func(asset *Asset) {
+		for _, tag := range asset.Tags {
+			known[asset.Name+"\x00"+tag] = true
+		}
+	})
+
+	var events []chartEvent
+	current.each(func(asset *Asset) {
+		for _, tag := range asset.Tags {
+			if known[asset.Name+"\x00"+tag] {
+				continue
+			}
+			events = append(events, chartEvent{
+				Event:   "chart.version.published",
+				Chart:   asset.Name,
+				Version: tag,
+				Digest:  asset.SHA,
+				URI:     asset.URI,
+				Time:    now,
+			})
+		}
+	})
+	return events
+}
+
+// webhookPayload returns the body announcing events: the event itself when
+// there is one, so receivers written before batching keep working, or
+// {"events": [...]} otherwise.
+func webhookPayload(events []chartEvent) ([]byte, error) {
+	if len(events) == 1 {
+		return json.Marshal(events[0])
+	}
+	return json.Marshal(struct {
+		Events []chartEvent `json:"events"`
+	}{events})
+}
+
+// deliver posts a batch to its webhook and keeps it as a dead letter if
+// that fails for good.
+func (n *webhookNotifier) deliver(ctx context.Context, delivery *webhookDelivery) {
+	config, _ := n.live.get()
+	body, err := webhookPayload(delivery.events)
+	if err != nil {
+		log.Printf("failed to encode webhook event. error: %v", err)
+		return
+	}
+
+	attempts, err := n.post(ctx, delivery.url, body, config.WebhookSecret, config.WebhookRetries)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	log.Printf("failed to notify %s of %d events. error: %v", delivery.url, len(delivery.events), err)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.lastID++
+	n.deadLetters = append(n.deadLetters, &deadLetter{
+		ID:       n.lastID,
+		URL:      delivery.url,
+		Events:   delivery.events,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Time:     time.Now(),
+	})
+	if len(n.deadLetters) > webhookDeadLetters {
+		n.deadLetters = n.deadLetters[len(n.deadLetters)-webhookDeadLetters:]
+	}
+}
+
+// post sends body to url, retrying up to retries times on network errors,
+// 429 and 5xx answers. It returns how many attempts were made.
+func (n *webhookNotifier) post(ctx context.Context, url string, body []byte, secret string, retries int) (int, error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := n.send(ctx, url, body, secret)
+		if err == nil || !retry || attempt > retries {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-time.After(jitter(backoff)):
+		}
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// jitter returns a random duration between half of d and d, so receivers
+// recovering from an outage aren't hit by every retry at once.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// handleDeadLetters lists the deliveries that failed for good, newest first.
+func (n *webhookNotifier) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	letters := make([]*deadLetter, 0, len(n.deadLetters))
+	for i := len(n.deadLetters) - 1; i >= 0; i-- {
+		letters = append(letters, n.deadLetters[i])
+	}
+	n.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Dropped     int64         `json:"dropped"`
+		DeadLetters []*deadLetter `json:"dead_letters"`
+	}{n.dropped.Load(), letters})
+}
+
+// handleRedeliver queues a dead letter for delivery again.
+func (n *webhookNotifier) handleRedeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid dead letter id", http.StatusBadRequest)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, letter := range n.deadLetters {
+		if letter.ID != id {
+			continue
+		}
+
+		select {
+		case n.deliveries <- &webhookDelivery{url: letter.URL, events: letter.Events}:
+		default:
+			http.Error(w, "webhook delivery queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		n.deadLetters = append(n.deadLetters[:i], n.deadLetters[i+1:]...)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (n *webhookNotifier) send(ctx context.Context, url string, body []byte, secret string) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhookSignatureHeader, signPayload(secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+// signPayload returns the signature header value of body: sha256= followed
+// by the hex HMAC-SHA256 of body keyed with secret.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// testReceiver is a webhook receiver answering with statuses in turn, and
+// 200 once they run out.
+type testReceiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	statuses   []int
+	bodies     []string
+	signatures []string
+}
+
+func newTestReceiver(t *testing.T, statuses ...int) *testReceiver {
+	receiver := &testReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.bodies = append(receiver.bodies, string(body))
+		receiver.signatures = append(receiver.signatures, r.Header.Get(webhookSignatureHeader))
+		if len(receiver.statuses) > 0 {
+			w.WriteHeader(receiver.statuses[0])
+			receiver.statuses = receiver.statuses[1:]
+		}
+	}))
+	t.Cleanup(receiver.Close)
+	return receiver
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	events := []chartEvent{{Event: "chart.version.published", Chart: "nginx", Version: "1.0.0", Digest: "sha256:1"}}
+	body, err := webhookPayload(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		attempts int
+		failed   bool
+	}{
+		{name: "delivered", statuses: nil, retries: 1, attempts: 1},
+		{name: "retried server error", statuses: []int{http.StatusServiceUnavailable}, retries: 1, attempts: 2},
+		{name: "retried rate limit", statuses: []int{http.StatusTooManyRequests}, retries: 1, attempts: 2},
+		{name: "out of retries", statuses: []int{http.StatusBadGateway, http.StatusBadGateway}, retries: 1, attempts: 2, failed: true},
+		{name: "no retries", statuses: []int{http.StatusInternalServerError}, retries: 0, attempts: 1, failed: true},
+		{name: "client error", statuses: []int{http.StatusBadRequest}, retries: 3, attempts: 1, failed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := newTestReceiver(t, test.statuses...)
+			live := &liveConfig{config: &Config{WebhookSecret: "secret", WebhookRetries: test.retries}}
+			n := newWebhookNotifier(live, nil)
+
+			n.deliver(context.Background(), &webhookDelivery{url: receiver.URL, events: events})
+
+			receiver.mu.Lock()
+			defer receiver.mu.Unlock()
+			if len(receiver.bodies) != test.attempts {
+				t.Fatalf("receiver got %d requests, want %d", len(receiver.bodies), test.attempts)
+			}
+			for i := range receiver.bodies {
+				if receiver.bodies[i] != string(body) || receiver.signatures[i] != signPayload("secret", body) {
+					t.Errorf("request %d = %s signed %q, want %s signed %q", i, receiver.bodies[i], receiver.signatures[i], body, signPayload("secret", body))
+				}
+			}
+
+			if !test.failed {
+				if len(n.deadLetters) != 0 {
+					t.Errorf("dead letters = %v, want none", n.deadLetters)
+				}
+				return
+			}
+			if len(n.deadLetters) != 1 {
+				t.Fatalf("dead letters = %v, want one", n.deadLetters)
+			}
+			if letter := n.deadLetters[0]; letter.URL != receiver.URL || letter.Attempts != test.attempts || len(letter.Events) != 1 || letter.Error == "" {
+				t.Errorf("dead letter = %+v, want %d attempts at %s", letter, test.attempts, receiver.URL)
+			}
+		})
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	n := newWebhookNotifier(&liveConfig{config: &Config{}}, nil)
+	ctx := context.Background()
+	for i := 0; i < webhookDeadLetters+2; i++ {
+		n.deliver(ctx, &webhookDelivery{url: "http://127.0.0.1:0/hook", events: []chartEvent{{Chart: "nginx", Version: strconv.Itoa(i)}}})
+	}
+
+	list := func() (letters []*deadLetter) {
+		w := httptest.NewRecorder()
+		n.handleDeadLetters(w, httptest.NewRequest(http.MethodGet, "/api/v1/webhooks/dead-letters", nil))
+		var body struct {
+			DeadLetters []*deadLetter `json:"dead_letters"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.DeadLetters
+	}
+
+	// Only the newest are kept, listed newest first.
+	letters := list()
+	if len(letters) != webhookDeadLetters || letters[0].ID != webhookDeadLetters+2 || letters[len(letters)-1].ID != 3 {
+		t.Fatalf("listed %d dead letters from %d to %d, want %d from %d to 3", len(letters), letters[0].ID, letters[len(letters)-1].ID, webhookDeadLetters, webhookDeadLetters+2)
+	}
+
+	redeliver := func(id string) int {
+		router := chi.NewRouter()
+		router.Post("/api/v1/webhooks/dead-letters/{id}/redeliver", n.handleRedeliver)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/webhooks/dead-letters/"+id+"/redeliver", nil))
+		return w.Code
+	}
+
+	tests := []struct {
+		id   string
+		code int
+	}{
+		{"10", http.StatusAccepted},
+		{"10", http.StatusNotFound},
+		{"1", http.StatusNotFound},
+		{"ten", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if code := redeliver(test.id); code != test.code {
+			t.Errorf("redeliver %s = %d, want %d", test.id, code, test.code)
+		}
+	}
+
+	if delivery := <-n.deliveries; delivery.events[0].Version != "9" {
+		t.Errorf("redelivered version %s, want 9", delivery.events[0].Version)
+	}
+	if letters := list(); len(letters) != webhookDeadLetters-1 {
+		t.Errorf("listed %d dead letters after redelivery, want %d", len(letters), webhookDeadLetters-1)
+	}
+}
+
+func TestWebhookBatching(t *testing.T) {
+	receiver := newTestReceiver(t)
+	live := &liveConfig{config: &Config{
+		Webhooks:           []string{receiver.URL},
+		WebhookConcurrency: 1,
+		WebhookBatchSize:   2,
+		WebhookBatchWait:   time.Hour,
+	}}
+	n := newWebhookNotifier(live, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.run(ctx)
+
+	previous := &Repository{Revision: 1, Assets: []*Asset{{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}}}}
+	current := &Repository{Revision: 2, Assets: []*Asset{
+		{Name: "nginx", SHA: "sha256:1", Tags: []string{"1.0.0"}},
+		{Name: "nginx", SHA: "sha256:2", Tags: []string{"1.1.0", "stable"}},
+	}}
+	n.catalogChanged(previous, current)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		receiver.mu.Lock()
+		bodies := append([]string(nil), receiver.bodies...)
+		receiver.mu.Unlock()
+		if len(bodies) == 1 {
+			var batch struct {
+				Events []chartEvent `json:"events"`
+			}
+			if err := json.Unmarshal([]byte(bodies[0]), &batch); err != nil || len(batch.Events) != 2 || batch.Events[0].Version != "1.1.0" || batch.Events[1].Version != "stable" {
+				t.Errorf("delivered %s, want a batch of 1.1.0 and stable", bodies[0])
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("receiver got %d requests, want one batch", len(bodies))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}